@@ -16,52 +16,102 @@ package main
 
 import (
 	"flag"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+
+	"github.com/google/alertmanager-irc-relay/relay"
 )
 
 func main() {
 
 	configFile := flag.String("config", "", "Config file path.")
+	renderFile := flag.String("render", "",
+		"Print the IRC lines for the webhook JSON in this file (- for stdin) and exit.")
+	renderChannel := flag.String("render-channel", "",
+		"Channel to render for, the first configured one by default.")
+	checkIRC := flag.Bool("check-irc", false,
+		"Connect to IRC, join the configured channels, disconnect and exit, without starting the HTTP server.")
+	checkIRCMessage := flag.String("check-irc-message", "",
+		"Send this line to the configured channels when checking IRC.")
+	checkIRCTimeout := flag.Duration("check-irc-timeout", 30*time.Second,
+		"Fail the IRC check of each connection after this long.")
+	dumpState := flag.Bool("dump-state-on-sigusr1", true,
+		"Log the internal state of the relay when receiving SIGUSR1.")
 
 	flag.Parse()
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
-	config, err := LoadConfig(*configFile)
+	config, err := relay.LoadConfig(*configFile)
 	if err != nil {
 		log.Printf("Could not load config: %s", err)
 		return
 	}
 
-	alertMsgs := make(chan AlertMsg, 10)
+	if *renderFile != "" {
+		if err := render(config, *renderFile, *renderChannel); err != nil {
+			log.Printf("Could not render: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	ircNotifier, err := NewIRCNotifier(config, alertMsgs)
-	if err != nil {
-		log.Printf("Could not create IRC notifier: %s", err)
+	if *checkIRC {
+		if err := relay.CheckIRC(config, *checkIRCMessage, *checkIRCTimeout); err != nil {
+			log.Printf("IRC check failed: %s", err)
+			os.Exit(1)
+		}
 		return
 	}
-	go ircNotifier.Run()
 
-	httpServer, err := NewHTTPServer(config, alertMsgs)
+	r, err := relay.New(config)
 	if err != nil {
-		log.Printf("Could not create HTTP server: %s", err)
+		log.Printf("Could not create relay: %s", err)
 		return
 	}
-	go httpServer.Run()
 
-	select {
-	case <-httpServer.StoppedRunning:
-		log.Printf("Http server terminated, exiting")
-	case <-ircNotifier.StoppedRunning:
-		log.Printf("IRC notifier stopped running, exiting")
-	case s := <-signals:
+	go func() {
+		s := <-signals
 		log.Printf("Received %s, exiting", s)
-		ircNotifier.StopRunning <- true
-		log.Printf("Waiting for IRC to quit")
-		<-ircNotifier.StoppedRunning
+		r.Shutdown()
+	}()
+
+	if *dumpState {
+		dumps := make(chan os.Signal, 1)
+		signal.Notify(dumps, syscall.SIGUSR1)
+		go func() {
+			for range dumps {
+				log.Printf("Received SIGUSR1, dumping state")
+				r.DumpState()
+			}
+		}()
+	}
+
+	if err := r.Run(); err != nil {
+		log.Printf("Relay stopped: %s", err)
+		if err == relay.ErrIRCGaveUp {
+			os.Exit(1)
+		}
+	}
+}
+
+func render(config *relay.Config, file string, channel string) error {
+	var input io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+	if channel == "" && len(config.IRCChannels) > 0 {
+		channel = config.IRCChannels[0].Name
 	}
+	return relay.Render(config, channel, input, os.Stdout)
 }
@@ -0,0 +1,64 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	ackQueueSize           = 100
+	defaultAckTimeout      = 5 * time.Second
+	defaultAckMaxRetries   = 3
+	defaultAckRetryBackoff = time.Second
+)
+
+type ackRecord struct {
+	Channel string    `json:"channel"`
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+}
+
+// AckSender posts an acknowledgement to a callback URL for each message
+// delivered to IRC, from its own goroutine so that IRC sends never wait on
+// the callback.
+type AckSender struct {
+	*postQueue
+	url string
+}
+
+// NewAckSender starts posting acknowledgements to url, giving up on each
+// after maxRetries retries.
+func NewAckSender(url string, timeout time.Duration, maxRetries int) *AckSender {
+	queue := newPostQueue("send ack", ackQueueSize, timeout, maxRetries,
+		defaultAckRetryBackoff)
+	return &AckSender{postQueue: queue, url: url}
+}
+
+// Ack queues an acknowledgement without blocking. Acknowledgements are
+// dropped if the callback cannot keep up.
+func (s *AckSender) Ack(channel string, text string, sentAt time.Time) {
+	body, err := json.Marshal(
+		ackRecord{Channel: channel, Text: text, Time: sentAt})
+	if err != nil {
+		log.Printf("Could not encode ack: %s", err)
+		return
+	}
+	if !s.enqueue(postRequest{url: s.url, channel: channel, body: body}) {
+		log.Printf("Ack queue full, dropping ack for %s", channel)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAckSenderRetries(t *testing.T) {
+	received := make(chan ackRecord, 10)
+	failures := 2
+	callback := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			record := ackRecord{}
+			if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+				t.Errorf("Could not decode ack: %s", err)
+			}
+			received <- record
+		}))
+	defer callback.Close()
+
+	sender := NewAckSender(callback.URL, time.Second, 2)
+	sender.retryBackoff = time.Millisecond
+	defer sender.Close()
+
+	sentAt := time.Date(2017, 5, 15, 12, 0, 0, 0, time.UTC)
+	sender.Ack("#foo", "airDown is firing", sentAt)
+
+	select {
+	case record := <-received:
+		expected := ackRecord{
+			Channel: "#foo", Text: "airDown is firing", Time: sentAt}
+		if record != expected {
+			t.Errorf("Unexpected ack: %+v", record)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Ack not received after retries")
+	}
+}
+
+func TestAckSenderGivesUp(t *testing.T) {
+	attempts := make(chan bool, 10)
+	callback := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			attempts <- true
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+	defer callback.Close()
+
+	sender := NewAckSender(callback.URL, time.Second, 1)
+	sender.retryBackoff = time.Millisecond
+
+	sender.Ack("#foo", "first", time.Now())
+	sender.Ack("#foo", "second", time.Now())
+	// One attempt and one retry per ack.
+	for i := 0; i < 4; i++ {
+		select {
+		case <-attempts:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 4 attempts, got %d", i)
+		}
+	}
+	sender.Close()
+	if len(attempts) != 0 {
+		t.Errorf("Expected no attempts beyond the retry cap")
+	}
+}
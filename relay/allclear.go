@@ -0,0 +1,106 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+	"text/template"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// AllClearData is passed to the all clear template.
+type AllClearData struct {
+	Channel string
+	// Most alerts firing at once since the channel was last clear.
+	Alerts int
+}
+
+func parseAllClearTemplate(text string,
+	parser templateParser) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return parser.parse("all_clear", text)
+}
+
+// channelFiring tracks the fingerprints of the alerts firing in a channel,
+// as last sent to it.
+type channelFiring struct {
+	firing map[string]bool
+	peak   int
+}
+
+// allClearTracker counts the alerts firing in each channel, by fingerprint,
+// up to maxFiringFingerprints of them per channel.
+type allClearTracker map[string]*channelFiring
+
+// Sent records the alerts of alertMsg as sent, returning the data of the
+// all clear to send if none is left firing in its channel while some were.
+// Alerts without fingerprint cannot be tracked.
+func (t allClearTracker) Sent(alertMsg *AlertMsg) *AllClearData {
+	var alerts []promtmpl.Alert
+	switch {
+	case alertMsg.AlertData != nil:
+		alerts = []promtmpl.Alert{*alertMsg.AlertData}
+	case alertMsg.GroupData != nil:
+		alerts = alertMsg.GroupData.Alerts
+	default:
+		return nil
+	}
+	channel, ok := t[alertMsg.Channel]
+	if !ok {
+		channel = &channelFiring{firing: make(map[string]bool)}
+		t[alertMsg.Channel] = channel
+	}
+	for _, alert := range alerts {
+		switch {
+		case alert.Fingerprint == "":
+		case alert.Status == "firing":
+			if len(channel.firing) < maxFiringFingerprints {
+				channel.firing[alert.Fingerprint] = true
+			}
+		default:
+			delete(channel.firing, alert.Fingerprint)
+		}
+	}
+	if len(channel.firing) > channel.peak {
+		channel.peak = len(channel.firing)
+	}
+	if len(channel.firing) > 0 || channel.peak == 0 {
+		return nil
+	}
+	data := &AllClearData{Channel: alertMsg.Channel, Alerts: channel.peak}
+	channel.peak = 0
+	return data
+}
+
+// maybeSendAllClear sends the all clear message to the channel of
+// alertMsg, once sent, if that resolved its last firing alerts.
+func (notifier *IRCNotifier) maybeSendAllClear(alertMsg *AlertMsg) {
+	if notifier.allClearTmpl == nil {
+		return
+	}
+	data := notifier.allClear.Sent(alertMsg)
+	if data == nil {
+		return
+	}
+	log.Printf("No alert left firing in %s, sending all clear", data.Channel)
+	msg := notifier.Formatter.execute(notifier.allClearTmpl, data)
+	if msg == "" {
+		return
+	}
+	notifier.sendLines(data.Channel, notifier.Formatter.splitLines([]string{msg}))
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	irc "github.com/fluffle/goirc/client"
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func makeAllClearAlertMsg(status string, fingerprints ...string) AlertMsg {
+	group := &WebhookData{}
+	group.Status = status
+	for _, fingerprint := range fingerprints {
+		group.Alerts = append(group.Alerts,
+			promtmpl.Alert{Status: status, Fingerprint: fingerprint})
+	}
+	return AlertMsg{Channel: "#foo", GroupData: group}
+}
+
+func TestAllClearTracker(t *testing.T) {
+	tracker := make(allClearTracker)
+	firing := makeAllClearAlertMsg("firing", "a", "b", "c")
+	if data := tracker.Sent(&firing); data != nil {
+		t.Errorf("Expected no all clear while firing, got %v", data)
+	}
+	resolved := makeAllClearAlertMsg("resolved", "a", "b")
+	if data := tracker.Sent(&resolved); data != nil {
+		t.Errorf("Expected no all clear while c is firing, got %v", data)
+	}
+	resolved = makeAllClearAlertMsg("resolved", "c")
+	data := tracker.Sent(&resolved)
+	expected := &AllClearData{Channel: "#foo", Alerts: 3}
+	if !reflect.DeepEqual(expected, data) {
+		t.Errorf("Unexpected all clear: %v", data)
+	}
+	// Once clear, resolved alerts are not an all clear again.
+	if data := tracker.Sent(&resolved); data != nil {
+		t.Errorf("Expected no all clear again, got %v", data)
+	}
+}
+
+func TestAllClearMessage(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.MsgTemplate = "{{ .Status }} {{ len .Alerts }}"
+	config.AllClearMessage = "All clear: {{ .Alerts }} alerts resolved"
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(4)
+	for _, alertMsg := range []AlertMsg{
+		makeAllClearAlertMsg("firing", "a", "b", "c"),
+		makeAllClearAlertMsg("resolved", "a", "b"),
+		makeAllClearAlertMsg("resolved", "c"),
+	} {
+		alertMsgs <- alertMsg
+	}
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :firing 3",
+		"NOTICE #foo :resolved 2",
+		"NOTICE #foo :resolved 1",
+		"NOTICE #foo :All clear: 3 alerts resolved",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("All clear not sent. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
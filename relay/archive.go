@@ -0,0 +1,95 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	webhookArchiveQueueSize = 100
+)
+
+type archiveRecord struct {
+	Time    time.Time       `json:"time"`
+	Channel string          `json:"channel"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// WebhookArchiver appends received webhook bodies to a file, one JSON record
+// per line. The file is never truncated or rotated.
+type WebhookArchiver struct {
+	file    *os.File
+	records chan archiveRecord
+	done    chan bool
+}
+
+// NewWebhookArchiver opens path for appending archive records.
+func NewWebhookArchiver(path string) (*WebhookArchiver, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	archiver := &WebhookArchiver{
+		file:    file,
+		records: make(chan archiveRecord, webhookArchiveQueueSize),
+		done:    make(chan bool),
+	}
+	go archiver.run()
+	return archiver, nil
+}
+
+// Archive queues body for writing without blocking. Records are dropped if
+// the writer cannot keep up.
+func (a *WebhookArchiver) Archive(channel string, body []byte) {
+	compacted := bytes.Buffer{}
+	if err := json.Compact(&compacted, body); err != nil {
+		log.Printf("Could not archive webhook body: %s", err)
+		return
+	}
+	record := archiveRecord{
+		Time:    time.Now(),
+		Channel: channel,
+		Body:    compacted.Bytes(),
+	}
+	select {
+	case a.records <- record:
+	default:
+		log.Printf("Webhook archive queue full, dropping record for %s", channel)
+	}
+}
+
+func (a *WebhookArchiver) run() {
+	encoder := json.NewEncoder(a.file)
+	for record := range a.records {
+		if err := encoder.Encode(record); err != nil {
+			log.Printf("Could not write webhook archive record: %s", err)
+		}
+	}
+	if err := a.file.Close(); err != nil {
+		log.Printf("Could not close webhook archive: %s", err)
+	}
+	a.done <- true
+}
+
+// Close writes the queued records and closes the archive file.
+func (a *WebhookArchiver) Close() {
+	close(a.records)
+	<-a.done
+}
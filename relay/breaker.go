@@ -0,0 +1,109 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultCircuitBreakerWindow   = time.Minute
+	defaultCircuitBreakerCooldown = 5 * time.Minute
+	breakerCheckSecs              = 5
+	maxBreakerHeldAlertMsgs       = 1000
+)
+
+var (
+	circuitBreakerOpen = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "irc_circuit_breaker_open",
+			Help: "Whether sending is paused after repeated IRC errors"},
+		[]string{"connection"},
+	)
+	circuitBreakerHeldAlerts = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "irc_circuit_breaker_held_alerts",
+			Help: "Number of alerts held while sending is paused"},
+		[]string{"connection"},
+	)
+)
+
+// CircuitBreaker opens after threshold failures within window, and closes
+// again after cooldown, on the IRC connection it is named after. Failures
+// are reported from the IRC client handlers, so it is safe to use from any
+// goroutine.
+type CircuitBreaker struct {
+	connection string
+	threshold  int
+	window     time.Duration
+	cooldown   time.Duration
+
+	mu       sync.Mutex
+	failures []time.Time
+	open     bool
+	openedAt time.Time
+}
+
+func NewCircuitBreaker(connection string, threshold int, window time.Duration,
+	cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		connection: connection,
+		threshold:  threshold,
+		window:     window,
+		cooldown:   cooldown,
+	}
+}
+
+// Failure records a failure, opening the breaker if there were too many.
+func (b *CircuitBreaker) Failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return
+	}
+	recent := b.failures[:0]
+	for _, t := range b.failures {
+		if now.Sub(t) < b.window {
+			recent = append(recent, t)
+		}
+	}
+	b.failures = append(recent, now)
+	if len(b.failures) >= b.threshold {
+		log.Printf("%d IRC errors within %s, pausing sending for %s",
+			len(b.failures), b.window, b.cooldown)
+		b.open = true
+		b.openedAt = now
+		b.failures = nil
+		circuitBreakerOpen.WithLabelValues(b.connection).Set(1)
+	}
+}
+
+// Allow returns whether sending is allowed, closing the breaker once the
+// cooldown has elapsed.
+func (b *CircuitBreaker) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open && now.Sub(b.openedAt) >= b.cooldown {
+		log.Printf("IRC errors cooldown elapsed, resuming sending")
+		b.open = false
+		circuitBreakerOpen.WithLabelValues(b.connection).Set(0)
+	}
+	return !b.open
+}
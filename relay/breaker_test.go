@@ -0,0 +1,68 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker(defaultConnection, 3, time.Minute, 5*time.Minute)
+
+	breaker.Failure(now)
+	breaker.Failure(now.Add(10 * time.Second))
+	if !breaker.Allow(now.Add(10 * time.Second)) {
+		t.Error("Expected the breaker to stay closed below the threshold")
+	}
+
+	// The first failure is out of the window.
+	breaker.Failure(now.Add(70 * time.Second))
+	if !breaker.Allow(now.Add(70 * time.Second)) {
+		t.Error("Expected failures out of the window not to count")
+	}
+
+	breaker.Failure(now.Add(75 * time.Second))
+	breaker.Failure(now.Add(80 * time.Second))
+	if breaker.Allow(now.Add(80 * time.Second)) {
+		t.Error("Expected the breaker to open at the threshold")
+	}
+	if breaker.Allow(now.Add(80*time.Second + 4*time.Minute)) {
+		t.Error("Expected the breaker to stay open during the cooldown")
+	}
+	if !breaker.Allow(now.Add(80*time.Second + 5*time.Minute)) {
+		t.Error("Expected the breaker to close after the cooldown")
+	}
+}
+
+func TestCircuitBreakerMetricsPerConnection(t *testing.T) {
+	now := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker("breakerone", 1, time.Minute, time.Minute)
+	other := NewCircuitBreaker("breakertwo", 1, time.Minute, time.Minute)
+
+	breaker.Failure(now)
+	other.Failure(now)
+	// The other breaker closing leaves this one reported open.
+	other.Allow(now.Add(time.Minute))
+	if open := testutil.ToFloat64(circuitBreakerOpen.WithLabelValues("breakerone")); open != 1 {
+		t.Errorf("Expected the breaker to be reported open, got %v", open)
+	}
+	if open := testutil.ToFloat64(circuitBreakerOpen.WithLabelValues("breakertwo")); open != 0 {
+		t.Errorf("Expected the other breaker to be reported closed, got %v", open)
+	}
+}
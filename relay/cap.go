@@ -0,0 +1,104 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	overCapDrop      = "drop"
+	overCapSummarize = "summarize"
+
+	hourlyCapWindow = time.Hour
+)
+
+// HourlyCap bounds the alert messages sent to a channel to Messages per
+// hour, counted from the first message of each hour long window. Once
+// reached, a notice says further alerts are suppressed until the window
+// ends, when they are counted in a summary if Policy is summarize rather
+// than drop.
+type HourlyCap struct {
+	Messages int    `yaml:"messages"`
+	Policy   string `yaml:"policy"`
+}
+
+// Init validates the hourly cap configuration and applies defaults.
+func (c *HourlyCap) Init() error {
+	if c.Messages <= 0 {
+		return errors.New("hourly cap messages must be positive")
+	}
+	if c.Policy == "" {
+		c.Policy = overCapDrop
+	}
+	if c.Policy != overCapDrop && c.Policy != overCapSummarize {
+		return fmt.Errorf("invalid hourly cap policy: %s", c.Policy)
+	}
+	return nil
+}
+
+// capWindow counts the alert messages sent to a channel in the current
+// window of its hourly cap.
+type capWindow struct {
+	limit      *HourlyCap
+	since      time.Time
+	sent       int
+	suppressed int
+}
+
+// Allow counts a message to send at now, returning whether it is within the
+// cap, and if not whether it is the first over the cap, for the notice to
+// be sent instead. The window must have been expired first.
+func (w *capWindow) Allow(now time.Time) (allowed bool, first bool) {
+	if w.since.IsZero() {
+		w.since = now
+	}
+	if w.sent < w.limit.Messages {
+		w.sent++
+		return true, false
+	}
+	w.suppressed++
+	return false, w.suppressed == 1
+}
+
+// Expire ends the window if over at now, returning the alert messages it
+// suppressed when they are to be summarized.
+func (w *capWindow) Expire(now time.Time) int {
+	if w.since.IsZero() || now.Sub(w.since) < hourlyCapWindow {
+		return 0
+	}
+	suppressed := w.suppressed
+	w.since, w.sent, w.suppressed = time.Time{}, 0, 0
+	if w.limit.Policy != overCapSummarize {
+		return 0
+	}
+	return suppressed
+}
+
+// capNotice is sent in place of the first alert message over limit.
+func capNotice(limit *HourlyCap) string {
+	return fmt.Sprintf(
+		"Hourly cap of %d messages reached, suppressing further alerts this hour",
+		limit.Messages)
+}
+
+// capSummary is sent once the window of limit ended, for the alert messages
+// it suppressed.
+func capSummary(limit *HourlyCap, suppressed int) string {
+	return fmt.Sprintf("%d alerts suppressed over the hourly cap of %d messages",
+		suppressed, limit.Messages)
+}
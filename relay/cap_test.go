@@ -0,0 +1,81 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCapWindow(t *testing.T) {
+	for _, test := range []struct {
+		policy     string
+		summarized int
+	}{
+		{overCapDrop, 0},
+		{overCapSummarize, 2},
+	} {
+		limit := &HourlyCap{Messages: 2, Policy: test.policy}
+		if err := limit.Init(); err != nil {
+			t.Fatalf("Could not init hourly cap: %s", err)
+		}
+		window := &capWindow{limit: limit}
+		start := time.Unix(0, 0)
+
+		for i, expected := range []struct{ allowed, first bool }{
+			{true, false},
+			{true, false},
+			{false, true},
+			{false, false},
+		} {
+			now := start.Add(time.Duration(i) * time.Minute)
+			window.Expire(now)
+			allowed, first := window.Allow(now)
+			if allowed != expected.allowed || first != expected.first {
+				t.Errorf("%s: message %d: expected %+v, got %t and %t",
+					test.policy, i, expected, allowed, first)
+			}
+		}
+
+		if suppressed := window.Expire(start.Add(59 * time.Minute)); suppressed != 0 {
+			t.Errorf("%s: unexpected summary before the hour is over",
+				test.policy)
+		}
+		if suppressed := window.Expire(start.Add(time.Hour)); suppressed != test.summarized {
+			t.Errorf("%s: expected %d alerts summarized, got %d",
+				test.policy, test.summarized, suppressed)
+		}
+		if allowed, _ := window.Allow(start.Add(time.Hour)); !allowed {
+			t.Errorf("%s: expected messages allowed in the next hour",
+				test.policy)
+		}
+	}
+}
+
+func TestHourlyCapInit(t *testing.T) {
+	limit := &HourlyCap{Messages: 20}
+	if err := limit.Init(); err != nil || limit.Policy != overCapDrop {
+		t.Errorf("Expected the drop policy by default, got %q (%v)",
+			limit.Policy, err)
+	}
+	for _, limit := range []*HourlyCap{
+		{Messages: 0},
+		{Messages: 20, Policy: "queue"},
+	} {
+		if err := limit.Init(); err == nil {
+			t.Errorf("Expected error for hourly cap %+v", limit)
+		}
+	}
+}
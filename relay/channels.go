@@ -0,0 +1,260 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	joinedChannels = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "irc_joined_channels",
+			Help: "Number of IRC channels currently joined"},
+		[]string{"connection"},
+	)
+	channelMembers = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "irc_channel_members",
+			Help: "Number of members of joined channels"},
+		[]string{"connection", "ircchannel"},
+	)
+	channelJoinBlocked = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "irc_channel_join_blocked",
+			Help: "Whether joining an IRC channel failed and is not retried"},
+		[]string{"connection", "ircchannel"},
+	)
+)
+
+// ircCaseMapping folds the characters servers compare as equal in names
+// by default (rfc1459 casemapping), on top of ASCII case.
+var ircCaseMapping = strings.NewReplacer("[", "{", "]", "}", "\\", "|", "~", "^")
+
+// channelKey returns the name channel is tracked by, as the server compares
+// it whatever the case it is written in.
+func channelKey(channel string) string {
+	return ircCaseMapping.Replace(strings.ToLower(channel))
+}
+
+// ChannelInfo describes a joined channel.
+type ChannelInfo struct {
+	Name    string   `json:"name"`
+	Members int      `json:"members"`
+	Nicks   []string `json:"nicks"`
+}
+
+// BlockedChannel describes a channel that could not be joined.
+type BlockedChannel struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ChannelTracker keeps track of the joined channels and their members, from
+// the NAMES replies received when joining and the JOIN, PART, QUIT, KICK and
+// NICK messages received since, and of the channels whose join was refused,
+// on the IRC connection it is named after. It is updated from the IRC client
+// handlers and safe to read from any goroutine.
+type ChannelTracker struct {
+	connection string
+
+	mu sync.Mutex
+	// Members of each channel, by nick.
+	joined  map[string]map[string]bool
+	pending map[string]map[string]bool
+	// Kept across reconnects, until explicitly unblocked.
+	blocked map[string]string
+}
+
+func NewChannelTracker(connection string) *ChannelTracker {
+	return &ChannelTracker{
+		connection: connection,
+		joined:     make(map[string]map[string]bool),
+		pending:    make(map[string]map[string]bool),
+		blocked:    make(map[string]string),
+	}
+}
+
+// Nicks in NAMES replies are prefixed by their channel status, e.g. @ for
+// operators.
+const namesPrefixes = "~&@%+"
+
+// Names records members listed in a NAMES reply (353) for channel.
+func (c *ChannelTracker) Names(channel string, nicks []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	members, ok := c.pending[channel]
+	if !ok {
+		members = make(map[string]bool)
+		c.pending[channel] = members
+	}
+	for _, nick := range nicks {
+		if nick = strings.TrimLeft(nick, namesPrefixes); nick != "" {
+			members[nick] = true
+		}
+	}
+}
+
+// EndOfNames marks channel as joined once its NAMES replies ended (366).
+func (c *ChannelTracker) EndOfNames(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	members, ok := c.pending[channel]
+	if !ok {
+		members = make(map[string]bool)
+	}
+	delete(c.pending, channel)
+	c.joined[channel] = members
+	joinedChannels.WithLabelValues(c.connection).Set(float64(len(c.joined)))
+	c.updateMembers(channel)
+}
+
+func (c *ChannelTracker) updateMembers(channel string) {
+	channelMembers.WithLabelValues(c.connection, channel).Set(
+		float64(len(c.joined[channel])))
+}
+
+// MemberJoined records nick joining channel.
+func (c *ChannelTracker) MemberJoined(channel string, nick string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if members, ok := c.joined[channel]; ok {
+		members[nick] = true
+		c.updateMembers(channel)
+	}
+}
+
+// MemberLeft records nick leaving channel, parting or kicked.
+func (c *ChannelTracker) MemberLeft(channel string, nick string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if members, ok := c.joined[channel]; ok {
+		delete(members, nick)
+		c.updateMembers(channel)
+	}
+}
+
+// MemberQuit records nick leaving all channels.
+func (c *ChannelTracker) MemberQuit(nick string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for channel, members := range c.joined {
+		if members[nick] {
+			delete(members, nick)
+			c.updateMembers(channel)
+		}
+	}
+}
+
+// MemberRenamed records nick changing to newNick in all channels.
+func (c *ChannelTracker) MemberRenamed(nick string, newNick string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, members := range c.joined {
+		if members[nick] {
+			delete(members, nick)
+			members[newNick] = true
+		}
+	}
+}
+
+// Left marks channel as no longer joined.
+func (c *ChannelTracker) Left(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.joined, channel)
+	joinedChannels.WithLabelValues(c.connection).Set(float64(len(c.joined)))
+	channelMembers.DeleteLabelValues(c.connection, channel)
+}
+
+// Reset forgets all channels, e.g. when disconnected.
+func (c *ChannelTracker) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for channel := range c.joined {
+		channelMembers.DeleteLabelValues(c.connection, channel)
+	}
+	c.joined = make(map[string]map[string]bool)
+	c.pending = make(map[string]map[string]bool)
+	joinedChannels.WithLabelValues(c.connection).Set(0)
+}
+
+// Channels returns the joined channels, sorted by name, with their members
+// sorted by nick.
+func (c *ChannelTracker) Channels() []ChannelInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	channels := []ChannelInfo{}
+	for name, members := range c.joined {
+		nicks := []string{}
+		for nick := range members {
+			nicks = append(nicks, nick)
+		}
+		sort.Strings(nicks)
+		channels = append(channels,
+			ChannelInfo{Name: name, Members: len(nicks), Nicks: nicks})
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+	return channels
+}
+
+// Block marks channel as not to be joined again, for reason.
+func (c *ChannelTracker) Block(channel string, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocked[channel] = reason
+	channelJoinBlocked.WithLabelValues(c.connection, channel).Set(1)
+}
+
+// Unblock allows joining channel again, returning whether it was blocked.
+func (c *ChannelTracker) Unblock(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.blocked[channel]; !ok {
+		return false
+	}
+	delete(c.blocked, channel)
+	channelJoinBlocked.DeleteLabelValues(c.connection, channel)
+	return true
+}
+
+// Blocked returns why channel is blocked, if it is.
+func (c *ChannelTracker) Blocked(channel string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reason, ok := c.blocked[channel]
+	return reason, ok
+}
+
+// BlockedChannels returns the blocked channels, sorted by name.
+func (c *ChannelTracker) BlockedChannels() []BlockedChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	channels := []BlockedChannel{}
+	for name, reason := range c.blocked {
+		channels = append(channels, BlockedChannel{Name: name, Reason: reason})
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+	return channels
+}
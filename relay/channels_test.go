@@ -0,0 +1,127 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestChannelTracker(t *testing.T) {
+	tracker := NewChannelTracker(defaultConnection)
+	tracker.Names("#foo", []string{"foo", "@bar"})
+	tracker.Names("#foo", []string{"+baz"})
+	tracker.Names("#bar", []string{"foo"})
+	// Only listed once the NAMES list is complete.
+	if channels := tracker.Channels(); len(channels) != 0 {
+		t.Errorf("Expected no joined channels, got %v", channels)
+	}
+	tracker.EndOfNames("#foo")
+	tracker.EndOfNames("#bar")
+
+	expected := []ChannelInfo{
+		{"#bar", 1, []string{"foo"}},
+		{"#foo", 3, []string{"bar", "baz", "foo"}},
+	}
+	if channels := tracker.Channels(); !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected %v, got %v", expected, channels)
+	}
+
+	tracker.Left("#bar")
+	expected = []ChannelInfo{{"#foo", 3, []string{"bar", "baz", "foo"}}}
+	if channels := tracker.Channels(); !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected %v after leaving, got %v", expected, channels)
+	}
+
+	tracker.Reset()
+	if channels := tracker.Channels(); len(channels) != 0 {
+		t.Errorf("Expected no joined channels after reset, got %v", channels)
+	}
+}
+
+func TestChannelTrackerMembers(t *testing.T) {
+	tracker := NewChannelTracker(defaultConnection)
+	tracker.Names("#foo", []string{"foo", "bar"})
+	tracker.EndOfNames("#foo")
+	tracker.Names("#bar", []string{"foo", "bar"})
+	tracker.EndOfNames("#bar")
+
+	tracker.MemberJoined("#foo", "baz")
+	// Not joined, ignored.
+	tracker.MemberJoined("#baz", "baz")
+	tracker.MemberLeft("#bar", "foo")
+	tracker.MemberRenamed("baz", "qux")
+	tracker.MemberQuit("bar")
+
+	expected := []ChannelInfo{
+		{"#bar", 0, []string{}},
+		{"#foo", 2, []string{"foo", "qux"}},
+	}
+	if channels := tracker.Channels(); !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected %v, got %v", expected, channels)
+	}
+	if members := testutil.ToFloat64(channelMembers.WithLabelValues(defaultConnection, "#foo")); members != 2 {
+		t.Errorf("Expected 2 members of #foo, got %v", members)
+	}
+	tracker.Reset()
+}
+
+func TestChannelTrackerBlocks(t *testing.T) {
+	tracker := NewChannelTracker(defaultConnection)
+	tracker.Block("#foo", "474: banned")
+	tracker.Reset()
+
+	// Blocks survive reconnects.
+	if reason, blocked := tracker.Blocked("#foo"); !blocked || reason != "474: banned" {
+		t.Errorf("Expected #foo to be blocked, got %v (%s)", blocked, reason)
+	}
+	expected := []BlockedChannel{{"#foo", "474: banned"}}
+	if channels := tracker.BlockedChannels(); !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected %v, got %v", expected, channels)
+	}
+
+	if !tracker.Unblock("#foo") {
+		t.Error("Expected #foo to be unblocked")
+	}
+	if tracker.Unblock("#foo") {
+		t.Error("Expected #foo to be unblocked only once")
+	}
+	if _, blocked := tracker.Blocked("#foo"); blocked {
+		t.Error("Expected #foo not to be blocked anymore")
+	}
+}
+
+func TestChannelTrackerMetricsPerConnection(t *testing.T) {
+	tracker := NewChannelTracker("metricsone")
+	other := NewChannelTracker("metricstwo")
+	tracker.Names("#foo", []string{"foo", "bar"})
+	tracker.EndOfNames("#foo")
+	other.Names("#foo", []string{"foo", "bar", "baz"})
+	other.EndOfNames("#foo")
+
+	tracker.Reset()
+	if joined := testutil.ToFloat64(joinedChannels.WithLabelValues("metricsone")); joined != 0 {
+		t.Errorf("Expected no joined channels after reset, got %v", joined)
+	}
+	if joined := testutil.ToFloat64(joinedChannels.WithLabelValues("metricstwo")); joined != 1 {
+		t.Errorf("Expected the other connection to be left alone, got %v joined", joined)
+	}
+	if members := testutil.ToFloat64(channelMembers.WithLabelValues("metricstwo", "#foo")); members != 3 {
+		t.Errorf("Expected 3 members on the other connection, got %v", members)
+	}
+	other.Reset()
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// unsupportedCharReplacement replaces characters that cannot be encoded in
+// the IRC charset, rather than dropping the message.
+const unsupportedCharReplacement = "?"
+
+// newCharsetEncoder returns a function transcoding UTF-8 text to charset, an
+// IANA name such as "ISO-8859-1". It returns nil for UTF-8, which needs no
+// transcoding.
+func newCharsetEncoder(charset string) (func(string) string, error) {
+	if charset == "" {
+		return nil, nil
+	}
+	enc, err := ianaindex.IANA.Encoding(charset)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("unsupported irc_charset: %s", charset)
+	}
+	if enc == unicode.UTF8 {
+		return nil, nil
+	}
+	return func(s string) string {
+		return encodeString(enc, s)
+	}, nil
+}
+
+func encodeString(enc encoding.Encoding, s string) string {
+	encoder := enc.NewEncoder()
+	if encoded, err := encoder.String(s); err == nil {
+		return encoded
+	}
+	// Only go rune by rune when some cannot be encoded.
+	var b bytes.Buffer
+	for _, r := range s {
+		encoded, err := encoder.String(string(r))
+		if err != nil {
+			encoded = unsupportedCharReplacement
+		}
+		b.WriteString(encoded)
+	}
+	return b.String()
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+)
+
+func TestCharsetEncoder(t *testing.T) {
+	encode, err := newCharsetEncoder("ISO-8859-1")
+	if err != nil {
+		t.Fatalf("Could not create encoder: %s", err)
+	}
+	for _, test := range []struct {
+		in, expected string
+	}{
+		{"plain", "plain"},
+		{"café", "caf\xe9"},
+		// Not in Latin-1, replaced.
+		{"air ☁ down €", "air ? down ?"},
+	} {
+		if encoded := encode(test.in); encoded != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.in, encoded)
+		}
+	}
+}
+
+func TestCharsetEncoderUTF8(t *testing.T) {
+	for _, charset := range []string{"", "UTF-8", "utf-8"} {
+		encode, err := newCharsetEncoder(charset)
+		if err != nil || encode != nil {
+			t.Errorf("%q: expected no transcoding, got error %v", charset, err)
+		}
+	}
+}
+
+func TestCharsetEncoderUnknown(t *testing.T) {
+	if _, err := newCharsetEncoder("bogus"); err == nil {
+		t.Error("Expected an error for an unknown charset")
+	}
+}
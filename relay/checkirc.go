@@ -0,0 +1,131 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const checkIRCPollInterval = 100 * time.Millisecond
+
+// CheckIRC connects to each configured IRC connection, registers, joins its
+// channels and optionally sends message to them, then disconnects. It
+// returns why the first connection failing to do so within timeout failed,
+// without starting the HTTP server, to smoke test deployments.
+func CheckIRC(config *Config, message string, timeout time.Duration) error {
+	if config.ForwardOnly {
+		return errors.New("no IRC connection when only forwarding webhooks")
+	}
+	configs := []*Config{config}
+	for i := range config.IRCConnections {
+		configs = append(configs,
+			config.connectionConfig(&config.IRCConnections[i]))
+	}
+	for _, c := range configs {
+		notifier, err := NewIRCNotifier(c, make(chan AlertMsg),
+			make(chan string))
+		if err != nil {
+			return err
+		}
+		if err := notifier.check(message, timeout); err != nil {
+			return fmt.Errorf("%s: %s", c.connection(), err)
+		}
+		log.Printf("IRC connection %s works", c.connection())
+	}
+	return nil
+}
+
+// check runs the connection steps of Run once, without retrying.
+func (notifier *IRCNotifier) check(message string, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	if notifier.Client.Config().Timeout > timeout {
+		notifier.Client.Config().Timeout = timeout
+	}
+
+	notifier.resolveServer()
+	log.Printf("Connecting to %s", notifier.Client.Config().Server)
+	if err := notifier.Client.Connect(); err != nil {
+		return fmt.Errorf("could not connect: %s", err)
+	}
+	defer notifier.disconnectCheck()
+
+	select {
+	case <-notifier.sessionUpSignal:
+		notifier.sessionUp = true
+	case <-notifier.sessionDownSignal:
+		return errors.New("disconnected before registration completed")
+	case <-deadline:
+		return fmt.Errorf("registration did not complete within %s", timeout)
+	}
+	log.Printf("Registered as %s", notifier.Client.Me().Nick)
+	notifier.MaybeIdentifyNick()
+
+	for i := range notifier.PreJoinChannels {
+		notifier.JoinChannel(&notifier.PreJoinChannels[i])
+	}
+	poll := time.NewTicker(checkIRCPollInterval)
+	defer poll.Stop()
+	for _, channel := range notifier.PreJoinChannels {
+		for !notifier.ChannelTracker.Joined(channel.Name) {
+			if reason, blocked := notifier.ChannelTracker.Blocked(
+				channel.Name); blocked {
+				return fmt.Errorf("could not join %s: %s", channel.Name, reason)
+			}
+			select {
+			case <-poll.C:
+			case <-notifier.sessionDownSignal:
+				notifier.sessionUp = false
+				return fmt.Errorf("disconnected while joining %s", channel.Name)
+			case <-deadline:
+				return fmt.Errorf("joining %s was not confirmed within %s",
+					channel.Name, timeout)
+			}
+		}
+		log.Printf("Joined %s", channel.Name)
+	}
+
+	if message != "" {
+		for _, channel := range notifier.PreJoinChannels {
+			notifier.sendLines(channel.Name, []string{message})
+		}
+	}
+	return nil
+}
+
+// disconnectCheck quits, waiting for the disconnection for at most the
+// client timeout.
+func (notifier *IRCNotifier) disconnectCheck() {
+	if !notifier.Client.Connected() {
+		return
+	}
+	if !notifier.sessionUp {
+		// Close dispatches the disconnection to the handler signalling
+		// sessionDownSignal.
+		go func() { <-notifier.sessionDownSignal }()
+		notifier.Client.Close()
+		return
+	}
+	notifier.Client.Quit(notifier.quitMessage())
+	select {
+	case <-notifier.sessionDownSignal:
+	case <-time.After(notifier.Client.Config().Timeout):
+		log.Printf("Timeout while waiting for IRC disconnect to complete")
+		go func() { <-notifier.sessionDownSignal }()
+		notifier.Client.Close()
+	}
+}
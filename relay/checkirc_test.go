@@ -0,0 +1,93 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+func confirmJoins(conn *bufio.ReadWriter, line *irc.Line) error {
+	r := fmt.Sprintf(":example.com 366 foo %s :End of /NAMES list.\n",
+		line.Args[0])
+	_, err := conn.WriteString(r)
+	return err
+}
+
+func TestCheckIRC(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, _ := makeTestNotifier(t, config)
+	server.SetHandler("JOIN", confirmJoins)
+
+	if err := notifier.check("relay check", time.Second); err != nil {
+		t.Fatalf("Expected the check to pass, got %s", err)
+	}
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :relay check",
+		"NOTICE #bar :relay check",
+		"NOTICE #baz :relay check",
+		"QUIT :see ya",
+	}
+	if strings.Join(server.Log, "\n") != strings.Join(expectedCommands, "\n") {
+		t.Errorf("Expected commands %q, got %q", expectedCommands, server.Log)
+	}
+}
+
+func TestCheckIRCBlockedChannel(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	server.SetHandler("JOIN", func(conn *bufio.ReadWriter, line *irc.Line) error {
+		if line.Args[0] == "#bar" {
+			_, err := conn.WriteString(
+				":example.com 474 foo #bar :Cannot join channel (+b)\n")
+			return err
+		}
+		return confirmJoins(conn, line)
+	})
+
+	err := CheckIRC(config, "", time.Second)
+	server.Stop()
+	if err == nil || !strings.Contains(err.Error(), "could not join #bar") {
+		t.Errorf("Expected #bar not to be joined, got %v", err)
+	}
+}
+
+func TestCheckIRCTimeout(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	// Never confirm any join.
+	server.SetHandler("JOIN", func(*bufio.ReadWriter, *irc.Line) error {
+		return nil
+	})
+
+	err := CheckIRC(config, "", 200*time.Millisecond)
+	server.Stop()
+	if err == nil || !strings.Contains(err.Error(), "not confirmed within") {
+		t.Errorf("Expected the check to time out, got %v", err)
+	}
+}
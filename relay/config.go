@@ -0,0 +1,856 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMsgOnceTemplate = "Alert {{ .GroupLabels.alertname }} for {{ .GroupLabels.job }} is {{ .Status }}"
+	defaultMsgTemplate     = "Alert {{ .Labels.alertname }} on {{ .Labels.instance }} is {{ .Status }}"
+
+	defaultCollapseHeaderTemplate = "Alerts {{ range .SharedLabels.SortedPairs }}{{ .Name }}={{ .Value }} {{ end }}are {{ .Status }}"
+	defaultCollapseLineTemplate   = "- {{ range .UniqueLabels.SortedPairs }}{{ .Name }}={{ .Value }} {{ end }}is {{ .Status }}"
+
+	defaultMsgLineDelimiter = "\n"
+
+	defaultHTTPReadTimeout  = 10 * time.Second
+	defaultHTTPWriteTimeout = 30 * time.Second
+	defaultHTTPIdleTimeout  = 2 * time.Minute
+
+	unjoinedChannelJoin     = "join"
+	unjoinedChannelReject   = "reject"
+	unjoinedChannelFallback = "fallback"
+
+	giveUpExit    = "exit"
+	giveUpUnready = "unready"
+
+	templateErrorRaw    = "raw"
+	templateErrorDrop   = "drop"
+	templateErrorStatic = "static"
+
+	rawFallbackJSON    = "json"
+	rawFallbackCompact = "compact"
+
+	defaultTemplateErrorMessage = "Could not render alert, see the relay logs"
+)
+
+// IRCChannel is a channel the relay joins on connect.
+type IRCChannel struct {
+	Name       string      `yaml:"name"`
+	Password   string      `yaml:"password"`
+	QuietHours *QuietHours `yaml:"quiet_hours"`
+	Digest     *Digest     `yaml:"digest"`
+	HourlyCap  *HourlyCap  `yaml:"hourly_cap"`
+	// Overrides Config.SendResolved for the channel when set.
+	SendResolved *bool `yaml:"send_resolved"`
+	// Raw IRC lines sent once the channel is joined, templated with the
+	// channel name and current nick.
+	OnJoinCommands []string `yaml:"on_join_commands"`
+	// Sent after the messages of each webhook to the channel, empty for none.
+	FooterTemplate string `yaml:"footer_template"`
+	// Prefix each line sent to the channel with its sequence number, as in
+	// "[#1234] ", for observers to notice dropped lines.
+	IncludeSequence bool `yaml:"include_sequence"`
+	// Strip IRC formatting codes from all lines sent to the channel when
+	// set to false, e.g. for plain logging channels. Kept when unset.
+	SupportsFormatting *bool `yaml:"supports_formatting"`
+}
+
+// Config is the relay configuration, usually loaded with LoadConfig.
+type Config struct {
+	HTTPHost    string       `yaml:"http_host"`
+	HTTPPort    int          `yaml:"http_port"`
+	IRCNick     string       `yaml:"irc_nickname"`
+	IRCNickPass string       `yaml:"irc_nickname_password"`
+	IRCRealName string       `yaml:"irc_realname"`
+	IRCHost     string       `yaml:"irc_host"`
+	IRCPort     int          `yaml:"irc_port"`
+	IRCUseSSL   bool         `yaml:"irc_use_ssl"`
+	IRCChannels []IRCChannel `yaml:"irc_channels"`
+	MsgTemplate string       `yaml:"msg_template"`
+	MsgOnce     bool         `yaml:"msg_once_per_alert_group"`
+	UsePrivmsg  bool         `yaml:"use_privmsg"`
+
+	// Template of the QUIT reason sent when stopping, "see ya" when unset.
+	IRCQuitMessage string `yaml:"irc_quit_message"`
+
+	// Split the alerts of each webhook into groups by the values of these
+	// labels, each sent in a message of its own as with MsgOnce.
+	RegroupBy []string `yaml:"regroup_by"`
+
+	// Message templates used instead of MsgTemplate for the webhooks of
+	// these Alertmanager receivers, by name.
+	MsgTemplatesByReceiver map[string]string `yaml:"msg_templates_by_receiver"`
+
+	// Files of template definitions msg_template can use. With
+	// WatchTemplates they are parsed again when changed, without reloading
+	// the rest of the configuration, as found by polling their modification
+	// times every templateCheckSecs.
+	MsgTemplateFiles []string `yaml:"msg_template_files"`
+	WatchTemplates   bool     `yaml:"watch_templates"`
+
+	// Other IRC networks to relay to, channels they list being relayed
+	// through them rather than the connection configured above.
+	IRCConnections []IRCConnection `yaml:"irc_connections"`
+	// Optionally relay alerts through the connection selected by the value
+	// of one of their labels instead.
+	ConnectionByLabel *ConnectionSelector `yaml:"connection_by_label"`
+	// Name of the connection this configuration is for, set by
+	// connectionConfig.
+	connectionName string
+
+	// Labels and annotations templates may use, all when unset, minus the
+	// denied ones. Others are stripped from alerts before rendering.
+	AllowedLabels      []string `yaml:"allowed_labels"`
+	DeniedLabels       []string `yaml:"denied_labels"`
+	AllowedAnnotations []string `yaml:"allowed_annotations"`
+	DeniedAnnotations  []string `yaml:"denied_annotations"`
+	// Prefixes stripped from the values of these labels in messages, the
+	// first matching one for each value. Alerts are still matched, e.g. by
+	// maintenance windows, on the full values.
+	LabelValueTrimPrefixes map[string][]string `yaml:"label_value_trim_prefixes"`
+	// Labels whose whole name matches this regular expression are hidden
+	// from messages, for display only like trimmed prefixes.
+	OmitLabelsRegex string `yaml:"omit_labels_regex"`
+	// Pairs rendered by the renderLabels template function, all when 0.
+	MaxRenderedLabels int `yaml:"max_rendered_labels"`
+	// Strip IRC formatting codes from label and annotation values, leaving
+	// those emitted by templates.
+	StripValueFormatting bool `yaml:"strip_value_formatting"`
+	// Cut annotation values to this many characters at a word boundary,
+	// ending with an ellipsis, whatever the template. All of them when 0.
+	MaxAnnotationLength int `yaml:"max_annotation_length"`
+
+	// Delimiters of all templates, {{ and }} when unset.
+	TemplateDelimiters TemplateDelimiters `yaml:"template_delimiters"`
+
+	// Unset timeouts get a default, HTTP clients are not trusted to be
+	// well behaved.
+	HTTPReadTimeout  time.Duration `yaml:"http_read_timeout"`
+	HTTPWriteTimeout time.Duration `yaml:"http_write_timeout"`
+	HTTPIdleTimeout  time.Duration `yaml:"http_idle_timeout"`
+
+	// Serve HTTP/2 over cleartext (h2c), or TLS when serving HTTPS,
+	// besides HTTP/1.1. A zero
+	// HTTP2MaxConcurrentStreams uses the library default.
+	EnableHTTP2               bool   `yaml:"enable_http2"`
+	HTTP2MaxConcurrentStreams uint32 `yaml:"http2_max_concurrent_streams"`
+	HTTPDisableKeepAlives     bool   `yaml:"http_disable_keep_alives"`
+
+	// Serve HTTPS with this certificate and key when both are set,
+	// accepting TLS versions and cipher suites as for IRC.
+	HTTPTLSCertFile     string   `yaml:"http_tls_cert_file"`
+	HTTPTLSKeyFile      string   `yaml:"http_tls_key_file"`
+	HTTPTLSMinVersion   string   `yaml:"http_tls_min_version"`
+	HTTPTLSCipherSuites []string `yaml:"http_tls_cipher_suites"`
+
+	// Proxies (CIDRs or addresses) whose X-Forwarded-For is trusted to
+	// identify clients in logs and for rate limiting.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// Reply 429 to clients sending more than HTTPPerIPRateLimit webhooks
+	// per second, in bursts of up to HTTPPerIPBurst (the rate rounded up
+	// when unset). 0 disables it.
+	HTTPPerIPRateLimit float64 `yaml:"http_per_ip_rate_limit"`
+	HTTPPerIPBurst     int     `yaml:"http_per_ip_burst"`
+	// Rate limits replacing HTTPPerIPRateLimit for webhooks of these
+	// severities, so that e.g. criticals are not throttled behind a flood
+	// of warnings.
+	HTTPSeverityRateLimits map[string]SeverityRateLimit `yaml:"http_severity_rate_limits"`
+
+	// Status replied to webhooks with alerts dropped as the queue to the
+	// IRC routine is full: 200 (dropped silently), 429 (failed without
+	// retries by Alertmanager) or 503 (retried).
+	QueueFullStatus int `yaml:"queue_full_status"`
+	// Body of replies to webhooks whose messages were all queued, rendered
+	// with SuccessResponseData. Empty by default.
+	SuccessResponseTemplate string `yaml:"success_response_template"`
+
+	// Reply 503 to webhooks beyond MaxConcurrentRequests being processed,
+	// 0 means unlimited.
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests"`
+
+	// Look up the _ircs._tcp (or _irc._tcp without SSL) SRV records of
+	// IRCHost on each connect, falling back to IRCHost and IRCPort.
+	IRCUseSRV bool `yaml:"irc_use_srv"`
+
+	// Delay before also trying the other address family of dual-stack IRC
+	// servers, the Go default (300ms) when unset. Negative disables it.
+	IRCDialFallbackDelay time.Duration `yaml:"irc_dial_fallback_delay"`
+
+	// Local IP address, optionally with a port, to connect to IRC from.
+	IRCLocalAddr string `yaml:"irc_local_addr"`
+
+	// Oldest TLS version ("1.0" to "1.3") and cipher suites, by their
+	// standard names, accepted connecting to IRC over TLS. The Go default
+	// cipher suites are kept when unset.
+	IRCTLSMinVersion   string   `yaml:"irc_tls_min_version"`
+	IRCTLSCipherSuites []string `yaml:"irc_tls_cipher_suites"`
+
+	// Charset messages are sent in, e.g. "ISO-8859-1", UTF-8 when unset.
+	IRCCharset string `yaml:"irc_charset"`
+
+	// Server password, sent as PASS on connect. Behind a ZNC style bouncer
+	// this is "user/network:password", and IRCBouncerMode leaves NickServ
+	// identification to the bouncer.
+	IRCPassword    string `yaml:"irc_password"`
+	IRCBouncerMode bool   `yaml:"irc_bouncer_mode"`
+	// Secrets can instead be read from these files, e.g. mounted ones, a
+	// trailing newline being dropped. Setting both is an error.
+	IRCPasswordFile    string `yaml:"irc_password_file"`
+	IRCNickPassFile    string `yaml:"irc_nickname_password_file"`
+	LifecycleTokenFile string `yaml:"lifecycle_token_file"`
+
+	// How to pick another nick when IRCNick is in use: "caret", "underscore",
+	// "counter" or "random". IRCNick is tried again on each reconnect.
+	IRCNickCollisionStrategy string `yaml:"irc_nick_collision_strategy"`
+	// Nicks picked on collision are cut to fit IRCNickMaxLength, the
+	// server's NICKLEN.
+	IRCNickMaxLength int `yaml:"irc_nick_max_length"`
+
+	// Wait IRCJoinDelay after registering before joining channels, and
+	// IRCJoinStagger between channels, against anti-spam measures.
+	IRCJoinDelay   time.Duration `yaml:"irc_join_delay"`
+	IRCJoinStagger time.Duration `yaml:"irc_join_stagger"`
+	// Join channels again when the server did not confirm joining them
+	// within IRCJoinConfirmTimeout, if set.
+	IRCJoinConfirmTimeout time.Duration `yaml:"irc_join_confirm_timeout"`
+
+	// IRCv3 capabilities to request, if advertised by the server.
+	IRCCapabilities []string `yaml:"irc_capabilities"`
+	// Attach +alertname, +severity and +fingerprint client tags to alert
+	// messages, requesting message-tags, when the server enables it.
+	IRCMessageTags bool `yaml:"irc_message_tags"`
+	// Send the messages following the first one of an alert group as
+	// replies to it, requesting message-tags and echo-message, when the
+	// server enables both.
+	IRCThreadGroups bool `yaml:"irc_thread_groups"`
+
+	// Webhooks to channels not in IRCChannels (or those of their
+	// connection) are sent there, joining it ("join"), rejected with a 403
+	// ("reject") or sent to FallbackChannel instead ("fallback").
+	UnjoinedChannelPolicy string `yaml:"unjoined_channel_policy"`
+	FallbackChannel       string `yaml:"fallback_channel"`
+
+	// Alerts still queued when shutting down are abandoned ("none"), sent
+	// for up to ShutdownTimeout (10s when unset, "best_effort") or all sent
+	// ("strict").
+	DrainMode       string        `yaml:"drain_mode"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Send HeartbeatTemplate to HeartbeatChannel every HeartbeatInterval,
+	// for external monitoring to notice when the relay is down.
+	HeartbeatChannel  string        `yaml:"heartbeat_channel"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	HeartbeatTemplate string        `yaml:"heartbeat_template"`
+
+	// Tell AdminChannel about reconnections with ReconnectNoticeTemplate,
+	// at most once per ReconnectNoticeInterval (5m when unset).
+	AdminChannel            string        `yaml:"admin_channel"`
+	ReconnectNoticeTemplate string        `yaml:"reconnect_notice_template"`
+	ReconnectNoticeInterval time.Duration `yaml:"reconnect_notice_interval"`
+
+	// Pause sending for CircuitBreakerCooldown after CircuitBreakerThreshold
+	// IRC errors (failed sends, kicks) within CircuitBreakerWindow, holding
+	// alerts meanwhile. 0 disables it.
+	CircuitBreakerThreshold int           `yaml:"circuit_breaker_threshold"`
+	CircuitBreakerWindow    time.Duration `yaml:"circuit_breaker_window"`
+	CircuitBreakerCooldown  time.Duration `yaml:"circuit_breaker_cooldown"`
+
+	// Give up after this many consecutive failed connection attempts, 0
+	// means retrying forever. OnGiveUp is either "exit" or "unready".
+	IRCMaxReconnectAttempts int    `yaml:"irc_max_reconnect_attempts"`
+	OnGiveUp                string `yaml:"on_give_up"`
+	// Reconnect when the server did not complete registration (001) within
+	// IRCRegisterTimeout of connecting, if set.
+	IRCRegisterTimeout time.Duration `yaml:"irc_register_timeout"`
+	// Queue up to this many alerts per channel while disconnected, instead
+	// of dropping them, to send in order once reconnected.
+	IRCReconnectQueueSize int `yaml:"irc_reconnect_queue_size"`
+
+	// Lifecycle endpoints live under /-/ and require LifecycleToken as a
+	// bearer token when set.
+	EnableLifecycleEndpoints bool   `yaml:"enable_lifecycle_endpoints"`
+	LifecycleToken           string `yaml:"lifecycle_token"`
+	EnableIRCRawEndpoint     bool   `yaml:"enable_irc_raw_endpoint"`
+
+	WebhookArchiveFile string `yaml:"webhook_archive_file"`
+	WarnOnEmptyAlerts  bool   `yaml:"warn_on_empty_alerts"`
+	// Append a record of each attempt to deliver an alert to IRC, sent or
+	// not, to this file.
+	DeliveryLogFile string `yaml:"delivery_log_file"`
+
+	// Alert fields (e.g. "labels.alertname") to form fields, used to
+	// decode form-encoded webhooks.
+	FormFieldMapping map[string]string `yaml:"form_field_mapping"`
+	// Source of the JSON webhooks: "alertmanager" or "opsgenie".
+	PayloadFormat string `yaml:"payload_format"`
+	// Acknowledge webhooks received again within IdempotencyWindow without
+	// relaying them, the same when their "payload" is, or their group key
+	// and status are ("group").
+	IdempotencyWindow time.Duration `yaml:"idempotency_window"`
+	IdempotencyKey    string        `yaml:"idempotency_key"`
+
+	// What to send when a template fails: "raw" alert JSON, nothing
+	// ("drop") or TemplateErrorMessage ("static").
+	OnTemplateError      string `yaml:"on_template_error"`
+	TemplateErrorMessage string `yaml:"template_error_message"`
+	// The raw alert is sent as single line JSON ("json") or as its status
+	// and labels ("compact").
+	RawFallbackFormat string `yaml:"raw_fallback_format"`
+	// Log template renders taking longer than this, when set.
+	SlowTemplateThreshold time.Duration `yaml:"slow_template_threshold"`
+	// What to send when a message renders to nothing but whitespace:
+	// nothing ("skip"), EmptyMessagePlaceholder ("placeholder") or the
+	// "raw" alert.
+	OnEmptyMessage          string `yaml:"on_empty_message"`
+	EmptyMessagePlaceholder string `yaml:"empty_message_placeholder"`
+
+	// Rendered messages are sent as one line per delimited part.
+	MsgLineDelimiter string `yaml:"msg_line_delimiter"`
+	KeepEmptyLines   bool   `yaml:"keep_empty_lines"`
+	// Trim spaces and tabs around rendered lines, and collapse their runs
+	// within lines into single spaces.
+	CollapseWhitespace bool `yaml:"collapse_whitespace"`
+	// Neutralize @everyone, @here and user or role mentions in rendered
+	// lines, for IRC channels bridged to Discord not to ping anyone.
+	EscapeMentions bool `yaml:"escape_mentions"`
+
+	// Send a header with the labels shared by all alerts of a group, then
+	// one line per alert with its remaining labels.
+	CollapseLabels         bool   `yaml:"collapse_labels"`
+	CollapseHeaderTemplate string `yaml:"collapse_header_template"`
+	CollapseLineTemplate   string `yaml:"collapse_line_template"`
+
+	// Colors used by the themed template function, "classic" or "solarized".
+	ThemeName string `yaml:"theme"`
+	// Severities from the least to the most severe, ranked from 1 by the
+	// severityRank template function, others getting UnknownSeverityRank.
+	SeverityOrder       []string `yaml:"severity_order"`
+	UnknownSeverityRank int      `yaml:"unknown_severity_rank"`
+	// How the link template function wraps URLs: "plain", "spaced",
+	// "angle" or "reset".
+	LinkStyle string `yaml:"link_style"`
+
+	// Allow templates to call {{ exec "command" "args"... }}, restricted to
+	// the listed commands.
+	AllowExecTemplateFunc bool          `yaml:"allow_exec_template_func"`
+	ExecTemplateCommands  []string      `yaml:"exec_template_commands"`
+	ExecTemplateTimeout   time.Duration `yaml:"exec_template_timeout"`
+
+	// Only the listed template functions of the relay are available to
+	// templates when set, e.g. to keep those supplied by other teams to
+	// formatting helpers.
+	AllowedTemplateFuncs []string `yaml:"allowed_template_funcs"`
+
+	// POST an acknowledgement of each message delivered to IRC to this URL,
+	// retrying failed ones up to AckCallbackMaxRetries times.
+	AckCallbackURL        string        `yaml:"ack_callback_url"`
+	AckCallbackTimeout    time.Duration `yaml:"ack_callback_timeout"`
+	AckCallbackMaxRetries int           `yaml:"ack_callback_max_retries"`
+
+	// POST webhooks to the relay at ForwardURL as well, or only when
+	// ForwardOnly, retrying failed ones up to ForwardMaxRetries times.
+	ForwardURL        string        `yaml:"forward_url"`
+	ForwardOnly       bool          `yaml:"forward_only"`
+	ForwardTimeout    time.Duration `yaml:"forward_timeout"`
+	ForwardMaxRetries int           `yaml:"forward_max_retries"`
+
+	DedupWindow     time.Duration `yaml:"dedup_window"`
+	DedupByGroupKey bool          `yaml:"dedup_by_group_key"`
+
+	// Resolved alerts are relayed unless set to false, in which case only
+	// channels setting their own SendResolved are sent them.
+	SendResolved *bool `yaml:"send_resolved"`
+
+	// Drop resolved alerts whose fingerprint was not seen firing in the last
+	// SuppressUnknownResolvedTTL (a day when unset).
+	SuppressUnknownResolved    bool          `yaml:"suppress_unknown_resolved"`
+	SuppressUnknownResolvedTTL time.Duration `yaml:"suppress_unknown_resolved_ttl"`
+
+	// Only relay alerts whose status changed since last seen, remembering
+	// statuses for NotifyOnlyTransitionsTTL (a day when unset) since.
+	NotifyOnlyTransitions    bool          `yaml:"notify_only_transitions"`
+	NotifyOnlyTransitionsTTL time.Duration `yaml:"notify_only_transitions_ttl"`
+
+	// Template of the message sent to a channel once the last alerts
+	// firing in it are sent resolved, if set.
+	AllClearMessage string `yaml:"all_clear_message"`
+
+	// Alerts matching an active maintenance window are not sent.
+	MaintenanceWindows []MaintenanceWindow `yaml:"maintenance_windows"`
+
+	// Drop alerts queued for longer than MaxQueueAge, e.g. during an IRC
+	// outage, resolved alerts excepted if MaxQueueAgeExemptResolved.
+	MaxQueueAge               time.Duration `yaml:"max_queue_age"`
+	MaxQueueAgeExemptResolved bool          `yaml:"max_queue_age_exempt_resolved"`
+
+	StaleAlertThreshold time.Duration `yaml:"stale_alert_threshold"`
+	PrefixStaleAlerts   bool          `yaml:"prefix_stale_alerts"`
+}
+
+// uniqueChannels returns channels without those listed again, by the name
+// the server knows them by, keeping the settings of the first listed.
+func uniqueChannels(channels []IRCChannel) []IRCChannel {
+	seen := make(map[string]bool)
+	unique := []IRCChannel{}
+	for _, channel := range channels {
+		if key := channelKey(channel.Name); !seen[key] {
+			seen[key] = true
+			unique = append(unique, channel)
+		}
+	}
+	return unique
+}
+
+// initChannels validates the settings of channels, applying defaults.
+func initChannels(channels []IRCChannel, parser templateParser) error {
+	for _, channel := range channels {
+		if channel.QuietHours != nil {
+			if err := channel.QuietHours.Init(); err != nil {
+				return fmt.Errorf("%s: %s", channel.Name, err)
+			}
+		}
+		if channel.Digest != nil {
+			if err := channel.Digest.Init(parser); err != nil {
+				return fmt.Errorf("%s: %s", channel.Name, err)
+			}
+		}
+		if channel.HourlyCap != nil {
+			if err := channel.HourlyCap.Init(); err != nil {
+				return fmt.Errorf("%s: %s", channel.Name, err)
+			}
+		}
+		if _, err := parseFooterTemplate(
+			channel.FooterTemplate, parser); err != nil {
+			return fmt.Errorf("%s: %s", channel.Name, err)
+		}
+	}
+	return nil
+}
+
+// readSecretFile sets *secret to the content of file, without trailing
+// newline, unless file is empty. name is the setting of the secret.
+func readSecretFile(name string, secret *string, file string) error {
+	if file == "" {
+		return nil
+	}
+	if *secret != "" {
+		return fmt.Errorf("%s and %s_file are mutually exclusive", name, name)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("%s_file: %s", name, err)
+	}
+	*secret = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+// readSecretFiles reads the secrets of config set from files.
+func readSecretFiles(config *Config) error {
+	for _, s := range []struct {
+		name   string
+		secret *string
+		file   string
+	}{
+		{"irc_password", &config.IRCPassword, config.IRCPasswordFile},
+		{"irc_nickname_password", &config.IRCNickPass, config.IRCNickPassFile},
+		{"lifecycle_token", &config.LifecycleToken, config.LifecycleTokenFile},
+	} {
+		if err := readSecretFile(s.name, s.secret, s.file); err != nil {
+			return err
+		}
+	}
+	for i := range config.IRCConnections {
+		conn := &config.IRCConnections[i]
+		if err := readSecretFile("irc_password", &conn.IRCPassword,
+			conn.IRCPasswordFile); err != nil {
+			return fmt.Errorf("%s: %s", conn.Name, err)
+		}
+		if err := readSecretFile("irc_nickname_password", &conn.IRCNickPass,
+			conn.IRCNickPassFile); err != nil {
+			return fmt.Errorf("%s: %s", conn.Name, err)
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads and validates configFile, applying defaults. An empty
+// configFile gives the default configuration.
+func LoadConfig(configFile string) (*Config, error) {
+	config := &Config{
+		HTTPHost:    "localhost",
+		HTTPPort:    8000,
+		IRCNick:     "alertmanager-irc-relay",
+		IRCNickPass: "",
+		IRCRealName: "Alertmanager IRC Relay",
+		IRCHost:     "irc.freenode.net",
+		IRCPort:     7000,
+		IRCUseSSL:   true,
+		IRCChannels: []IRCChannel{IRCChannel{Name: "#airtest"}},
+		MsgOnce:     false,
+		UsePrivmsg:  false,
+		OnGiveUp:    giveUpExit,
+
+		IRCNickCollisionStrategy: nickCollisionCaret,
+		IRCNickMaxLength:         defaultNickMaxLength,
+		ThemeName:                defaultTheme,
+		LinkStyle:                defaultLinkStyle,
+
+		OnTemplateError:      templateErrorRaw,
+		TemplateErrorMessage: defaultTemplateErrorMessage,
+		RawFallbackFormat:    rawFallbackJSON,
+
+		UnjoinedChannelPolicy: unjoinedChannelJoin,
+		QueueFullStatus:       http.StatusOK,
+
+		DrainMode: drainNone,
+
+		PayloadFormat: payloadFormatAlertmanager,
+
+		OnEmptyMessage: emptyMessageSkip,
+		IdempotencyKey: idempotencyKeyPayload,
+
+		IRCTLSMinVersion:  defaultTLSMinVersion,
+		HTTPTLSMinVersion: defaultTLSMinVersion,
+
+		AckCallbackTimeout:    defaultAckTimeout,
+		AckCallbackMaxRetries: defaultAckMaxRetries,
+
+		ForwardTimeout:    defaultForwardTimeout,
+		ForwardMaxRetries: defaultForwardMaxRetries,
+	}
+
+	if configFile != "" {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+	}
+	if err := readSecretFiles(config); err != nil {
+		return nil, err
+	}
+
+	if config.HTTPReadTimeout == 0 {
+		config.HTTPReadTimeout = defaultHTTPReadTimeout
+	}
+	if config.HTTPWriteTimeout == 0 {
+		config.HTTPWriteTimeout = defaultHTTPWriteTimeout
+	}
+	if config.HTTPIdleTimeout == 0 {
+		config.HTTPIdleTimeout = defaultHTTPIdleTimeout
+	}
+
+	if config.MsgLineDelimiter == "" {
+		config.MsgLineDelimiter = defaultMsgLineDelimiter
+	}
+	if config.CircuitBreakerWindow == 0 {
+		config.CircuitBreakerWindow = defaultCircuitBreakerWindow
+	}
+	if config.CircuitBreakerCooldown == 0 {
+		config.CircuitBreakerCooldown = defaultCircuitBreakerCooldown
+	}
+	if config.SuppressUnknownResolvedTTL == 0 {
+		config.SuppressUnknownResolvedTTL = defaultFiringTTL
+	}
+	if config.NotifyOnlyTransitionsTTL == 0 {
+		config.NotifyOnlyTransitionsTTL = defaultTransitionTTL
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if config.ExecTemplateTimeout == 0 {
+		config.ExecTemplateTimeout = defaultExecTemplateTimeout
+	}
+	if config.ReconnectNoticeInterval == 0 {
+		config.ReconnectNoticeInterval = defaultReconnectNoticeInterval
+	}
+	if _, err := themeColors(config.ThemeName); err != nil {
+		return nil, err
+	}
+	if _, err := newLabelOmitter(config.OmitLabelsRegex); err != nil {
+		return nil, err
+	}
+	if config.SlowTemplateThreshold < 0 {
+		return nil, errors.New("slow_template_threshold must not be negative")
+	}
+	if config.MaxRenderedLabels < 0 {
+		return nil, errors.New("max_rendered_labels must not be negative")
+	}
+	if config.WatchTemplates && len(config.MsgTemplateFiles) == 0 {
+		return nil, errors.New("watch_templates requires msg_template_files")
+	}
+	if config.AllowExecTemplateFunc && len(config.ExecTemplateCommands) == 0 {
+		return nil, errors.New("allow_exec_template_func requires exec_template_commands")
+	}
+
+	if config.OnGiveUp != giveUpExit && config.OnGiveUp != giveUpUnready {
+		return nil, fmt.Errorf("invalid on_give_up value: %s", config.OnGiveUp)
+	}
+
+	switch config.OnTemplateError {
+	case templateErrorRaw, templateErrorDrop, templateErrorStatic:
+	default:
+		return nil, fmt.Errorf("invalid on_template_error value: %s",
+			config.OnTemplateError)
+	}
+	switch config.OnEmptyMessage {
+	case emptyMessageSkip, emptyMessageRaw:
+	case emptyMessagePlaceholder:
+		if strings.TrimSpace(config.EmptyMessagePlaceholder) == "" {
+			return nil, errors.New("on_empty_message placeholder requires an empty_message_placeholder")
+		}
+	default:
+		return nil, fmt.Errorf("invalid on_empty_message value: %s",
+			config.OnEmptyMessage)
+	}
+	if config.ShutdownTimeout < 0 {
+		return nil, fmt.Errorf("shutdown_timeout cannot be negative")
+	}
+	switch config.UnjoinedChannelPolicy {
+	case unjoinedChannelJoin, unjoinedChannelReject:
+	case unjoinedChannelFallback:
+		if config.FallbackChannel == "" {
+			return nil, errors.New("unjoined_channel_policy fallback requires a fallback_channel")
+		}
+	default:
+		return nil, fmt.Errorf("invalid unjoined_channel_policy value: %s",
+			config.UnjoinedChannelPolicy)
+	}
+	switch config.DrainMode {
+	case drainNone, drainBestEffort, drainStrict:
+	default:
+		return nil, fmt.Errorf("invalid drain_mode value: %s", config.DrainMode)
+	}
+	switch config.RawFallbackFormat {
+	case rawFallbackJSON, rawFallbackCompact:
+	default:
+		return nil, fmt.Errorf("invalid raw_fallback_format value: %s",
+			config.RawFallbackFormat)
+	}
+
+	if config.IdempotencyWindow < 0 {
+		return nil, errors.New("idempotency_window must not be negative")
+	}
+	switch config.IdempotencyKey {
+	case idempotencyKeyPayload, idempotencyKeyGroup:
+	default:
+		return nil, fmt.Errorf("invalid idempotency_key value: %s",
+			config.IdempotencyKey)
+	}
+	if _, ok := payloadDecoders[config.PayloadFormat]; !ok {
+		return nil, fmt.Errorf("invalid payload_format value: %s",
+			config.PayloadFormat)
+	}
+	if err := validateFormFieldMapping(config.FormFieldMapping); err != nil {
+		return nil, err
+	}
+
+	if err := config.TemplateDelimiters.validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseTrustedProxies(config.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if config.IRCJoinDelay < 0 || config.IRCJoinStagger < 0 {
+		return nil, errors.New("irc_join_delay and irc_join_stagger must not be negative")
+	}
+	if config.IRCRegisterTimeout < 0 {
+		return nil, errors.New("irc_register_timeout must not be negative")
+	}
+	if config.ReconnectNoticeInterval < 0 {
+		return nil, errors.New("reconnect_notice_interval must not be negative")
+	}
+	if config.MaxAnnotationLength < 0 {
+		return nil, errors.New("max_annotation_length must not be negative")
+	}
+	if config.IRCReconnectQueueSize < 0 {
+		return nil, errors.New("irc_reconnect_queue_size must not be negative")
+	}
+	if config.IRCJoinConfirmTimeout < 0 {
+		return nil, errors.New("irc_join_confirm_timeout must not be negative")
+	}
+	switch config.QueueFullStatus {
+	case http.StatusOK, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return nil, fmt.Errorf("invalid queue_full_status value: %d",
+			config.QueueFullStatus)
+	}
+	if config.MaxConcurrentRequests < 0 {
+		return nil, errors.New("max_concurrent_requests must not be negative")
+	}
+	if config.HTTPPerIPRateLimit < 0 || config.HTTPPerIPBurst < 0 {
+		return nil, errors.New("http_per_ip_rate_limit and http_per_ip_burst must not be negative")
+	}
+	for severity, limit := range config.HTTPSeverityRateLimits {
+		if limit.Rate < 0 || limit.Burst < 0 {
+			return nil, fmt.Errorf("http_severity_rate_limits: %s: rate and burst must not be negative", severity)
+		}
+	}
+
+	parser, err := newTemplateParser(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.HeartbeatChannel != "" {
+		if config.HeartbeatInterval <= 0 {
+			return nil, errors.New("heartbeat_channel requires a positive heartbeat_interval")
+		}
+		if config.HeartbeatTemplate == "" {
+			config.HeartbeatTemplate = config.TemplateDelimiters.rewrite(
+				defaultHeartbeatTemplate)
+		}
+		if _, err := parseHeartbeatTemplate(
+			config.HeartbeatTemplate, parser); err != nil {
+			return nil, err
+		}
+	}
+	if config.AdminChannel != "" {
+		if config.ReconnectNoticeTemplate == "" {
+			config.ReconnectNoticeTemplate = config.TemplateDelimiters.rewrite(
+				defaultReconnectNoticeTemplate)
+		}
+		if _, err := parseReconnectNoticeTemplate(
+			config.ReconnectNoticeTemplate, parser); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := parseQuitTemplate(config.IRCQuitMessage, parser); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseAllClearTemplate(
+		config.AllClearMessage, parser); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseSuccessResponseTemplate(
+		config.SuccessResponseTemplate, parser); err != nil {
+		return nil, err
+	}
+
+	// Channels listed several times are joined once.
+	config.IRCChannels = uniqueChannels(config.IRCChannels)
+	for i := range config.IRCConnections {
+		config.IRCConnections[i].IRCChannels = uniqueChannels(
+			config.IRCConnections[i].IRCChannels)
+	}
+
+	if err := initChannels(config.IRCChannels, parser); err != nil {
+		return nil, err
+	}
+	if err := validateConnections(config.IRCConnections); err != nil {
+		return nil, err
+	}
+	if config.ConnectionByLabel != nil {
+		if err := config.ConnectionByLabel.validate(config.IRCConnections); err != nil {
+			return nil, err
+		}
+	}
+	for _, conn := range config.IRCConnections {
+		if err := initChannels(conn.IRCChannels, parser); err != nil {
+			return nil, fmt.Errorf("%s: %s", conn.Name, err)
+		}
+		if _, err := newCharsetEncoder(conn.IRCCharset); err != nil {
+			return nil, fmt.Errorf("%s: %s", conn.Name, err)
+		}
+	}
+
+	if config.IRCNickMaxLength <= 0 {
+		return nil, errors.New("irc_nick_max_length must be positive")
+	}
+	if _, err := newNickFunc(config.IRCNickCollisionStrategy, config.IRCNick,
+		config.IRCNickMaxLength); err != nil {
+		return nil, err
+	}
+
+	if config.IRCLocalAddr != "" {
+		if err := validateLocalAddr(config.IRCLocalAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := newCharsetEncoder(config.IRCCharset); err != nil {
+		return nil, err
+	}
+
+	if _, err := newTLSConfig(config.IRCTLSMinVersion,
+		config.IRCTLSCipherSuites); err != nil {
+		return nil, fmt.Errorf("invalid irc_tls settings: %s", err)
+	}
+	if (config.HTTPTLSCertFile == "") != (config.HTTPTLSKeyFile == "") {
+		return nil, errors.New(
+			"http_tls_cert_file and http_tls_key_file must be set together")
+	}
+	if _, err := newTLSConfig(config.HTTPTLSMinVersion,
+		config.HTTPTLSCipherSuites); err != nil {
+		return nil, fmt.Errorf("invalid http_tls settings: %s", err)
+	}
+
+	if config.IRCBouncerMode && config.IRCPassword == "" {
+		return nil, errors.New("irc_bouncer_mode requires an irc_password")
+	}
+
+	for i := range config.MaintenanceWindows {
+		if err := config.MaintenanceWindows[i].Init(); err != nil {
+			return nil, fmt.Errorf("%s: %s",
+				config.MaintenanceWindows[i].Name, err)
+		}
+	}
+
+	if config.ForwardOnly && config.ForwardURL == "" {
+		return nil, errors.New("forward_only requires a forward_url")
+	}
+
+	// Sending arbitrary IRC commands must never be possible anonymously.
+	if config.EnableIRCRawEndpoint && config.LifecycleToken == "" {
+		return nil, errors.New("enable_irc_raw_endpoint requires a lifecycle_token")
+	}
+
+	// Set default template if config does not have one.
+	delims := config.TemplateDelimiters
+	if config.MsgTemplate == "" {
+		if config.MsgOnce || len(config.RegroupBy) > 0 {
+			config.MsgTemplate = delims.rewrite(defaultMsgOnceTemplate)
+		} else {
+			config.MsgTemplate = delims.rewrite(defaultMsgTemplate)
+		}
+	}
+
+	if config.CollapseLabels {
+		if config.CollapseHeaderTemplate == "" {
+			config.CollapseHeaderTemplate = delims.rewrite(defaultCollapseHeaderTemplate)
+		}
+		if config.CollapseLineTemplate == "" {
+			config.CollapseLineTemplate = delims.rewrite(defaultCollapseLineTemplate)
+		}
+	}
+
+	return config, nil
+}
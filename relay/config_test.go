@@ -0,0 +1,911 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNoConfig(t *testing.T) {
+	noConfigFile := ""
+
+	config, err := LoadConfig(noConfigFile)
+	if config == nil {
+		t.Errorf("Expected a default config, got: %s", err)
+	}
+
+}
+
+func TestLoadGoodConfig(t *testing.T) {
+	expectedConfig := &Config{
+		HTTPHost:    "test.web",
+		HTTPPort:    8888,
+		IRCNick:     "foo",
+		IRCHost:     "irc.example.com",
+		IRCPort:     1234,
+		IRCUseSSL:   true,
+		IRCChannels: []IRCChannel{IRCChannel{Name: "#foobar"}},
+		MsgTemplate: defaultMsgTemplate,
+		MsgOnce:     false,
+		UsePrivmsg:  false,
+		OnGiveUp:    "exit",
+
+		IRCNickCollisionStrategy: "caret",
+		IRCNickMaxLength:         30,
+		ThemeName:                "classic",
+		LinkStyle:                "spaced",
+
+		OnTemplateError:      "raw",
+		TemplateErrorMessage: defaultTemplateErrorMessage,
+		RawFallbackFormat:    rawFallbackJSON,
+
+		UnjoinedChannelPolicy: unjoinedChannelJoin,
+		QueueFullStatus:       200,
+
+		DrainMode:       drainNone,
+		ShutdownTimeout: defaultShutdownTimeout,
+
+		PayloadFormat: payloadFormatAlertmanager,
+
+		OnEmptyMessage: emptyMessageSkip,
+		IdempotencyKey: idempotencyKeyPayload,
+
+		IRCTLSMinVersion:  defaultTLSMinVersion,
+		HTTPTLSMinVersion: defaultTLSMinVersion,
+
+		ReconnectNoticeInterval: defaultReconnectNoticeInterval,
+
+		HTTPReadTimeout:  defaultHTTPReadTimeout,
+		HTTPWriteTimeout: defaultHTTPWriteTimeout,
+		HTTPIdleTimeout:  defaultHTTPIdleTimeout,
+
+		ExecTemplateTimeout: defaultExecTemplateTimeout,
+		MsgLineDelimiter:    defaultMsgLineDelimiter,
+
+		SuppressUnknownResolvedTTL: defaultFiringTTL,
+		NotifyOnlyTransitionsTTL:   defaultTransitionTTL,
+
+		CircuitBreakerWindow:   defaultCircuitBreakerWindow,
+		CircuitBreakerCooldown: defaultCircuitBreakerCooldown,
+
+		AckCallbackTimeout:    defaultAckTimeout,
+		AckCallbackMaxRetries: defaultAckMaxRetries,
+
+		ForwardTimeout:    defaultForwardTimeout,
+		ForwardMaxRetries: defaultForwardMaxRetries,
+	}
+	expectedData, err := yaml.Marshal(expectedConfig)
+	if err != nil {
+		t.Errorf("Could not serialize test data: %s", err)
+	}
+
+	tmpfile, err := ioutil.TempFile("", "airtestconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write(expectedData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Errorf("Could not close tmpfile: %s", err)
+	}
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config == nil {
+		t.Errorf("Expected a config, got: %s", err)
+	}
+
+	configData, err := yaml.Marshal(config)
+	if err != nil {
+		t.Errorf("Could not serialize loaded config")
+	}
+
+	if string(expectedData) != string(configData) {
+		t.Errorf("Loaded config does not match expected config: %s", configData)
+	}
+}
+
+func TestLoadBadFile(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestbadfile")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	tmpfile.Close()
+	os.Remove(tmpfile.Name())
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon non-existent file.")
+	}
+}
+
+func TestLoadBadConfig(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestbadconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	badConfigData := []byte("footest\nbarbaz\n")
+	if _, err := tmpfile.Write(badConfigData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon bad config.")
+	}
+}
+
+func TestMsgOnceDefaultTemplate(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtesttemmplateonceconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	msgOnceConfigData := []byte("msg_once_per_alert_group: yes")
+	if _, err := tmpfile.Write(msgOnceConfigData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config == nil {
+		t.Errorf("Expected a config, got: %s", err)
+	}
+
+	if config.MsgTemplate != defaultMsgOnceTemplate {
+		t.Errorf("Expecting defaultMsgOnceTemplate when MsgOnce is true")
+	}
+}
+
+func TestMsgDefaultTemplate(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtesttemmplateconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte("")); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config == nil {
+		t.Errorf("Expected a config, got: %s", err)
+	}
+
+	if config.MsgTemplate != defaultMsgTemplate {
+		t.Errorf("Expecting defaultMsgTemplate when MsgOnce is false")
+	}
+}
+
+func TestGivenTemplateNotOverwritten(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestexpectedtemmplate")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	expectedTemplate := "Alert {{ .Status }}: {{ .Annotations.SUMMARY }}"
+	configData := []byte(fmt.Sprintf("msg_template: \"%s\"", expectedTemplate))
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config == nil {
+		t.Errorf("Expected a config, got: %s", err)
+	}
+
+	if config.MsgTemplate != expectedTemplate {
+		t.Errorf("Template does not match configuration")
+	}
+}
+
+func TestIRCRawEndpointRequiresToken(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestrawendpointconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("enable_lifecycle_endpoints: yes\nenable_irc_raw_endpoint: yes")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config when the raw endpoint has no token")
+	}
+}
+
+func TestLoadBadFormFieldMapping(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestformmappingconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("form_field_mapping:\n  severity: level")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid form field mapping")
+	}
+}
+
+func TestBouncerModeRequiresPassword(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestbouncerconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("irc_bouncer_mode: yes")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config when bouncer mode has no password")
+	}
+}
+
+func TestHTTPTimeouts(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtesthttptimeoutsconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("http_read_timeout: 5s\nhttp_idle_timeout: 0s")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config == nil {
+		t.Fatalf("Expected a config, got: %s", err)
+	}
+	if config.HTTPReadTimeout != 5*time.Second {
+		t.Errorf("Unexpected read timeout: %s", config.HTTPReadTimeout)
+	}
+	if config.HTTPWriteTimeout != defaultHTTPWriteTimeout {
+		t.Errorf("Expected default write timeout, got %s", config.HTTPWriteTimeout)
+	}
+	if config.HTTPIdleTimeout != defaultHTTPIdleTimeout {
+		t.Errorf("Expected default idle timeout, got %s", config.HTTPIdleTimeout)
+	}
+}
+
+func TestLoadBadOnTemplateError(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtesttemplateerrorconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("on_template_error: ignore")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid on_template_error")
+	}
+}
+
+func TestLoadBadTheme(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestthemeconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("theme: neon")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid theme")
+	}
+}
+
+func TestLoadBadFooterTemplate(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestfooterconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte(`irc_channels:
+  - name: "#foo"
+    footer_template: "{{ .Count "`)
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid footer template")
+	}
+}
+
+func TestLoadBadHourlyCap(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtesthourlycapconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte(`irc_channels:
+  - name: "#foo"
+    hourly_cap:
+      messages: 20
+      policy: queue`)
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid hourly cap policy")
+	}
+}
+
+func TestLoadBadConnectionByLabel(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestconnectionbylabelconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte(`irc_connections:
+  - name: eunet
+connection_by_label:
+  label: region
+  connections:
+    eu-west: eunet
+    us-east: usnet`)
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon unknown selected connection")
+	}
+}
+
+func TestLoadBadOmitLabelsRegex(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestomitlabelsconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte(`omit_labels_regex: "kubernetes_(.*"`)
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid omit_labels_regex")
+	}
+}
+
+func TestLoadBadTemplateDelimiters(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestdelimsconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("template_delimiters:\n  left: '[['")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon delimiters not provided together")
+	}
+}
+
+func TestLoadHeartbeatWithoutInterval(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestheartbeatconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("heartbeat_channel: '#status'")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon heartbeat without interval")
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	secretFile, err := ioutil.TempFile("", "airtestsecret")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(secretFile.Name())
+	if _, err := secretFile.Write([]byte("mynickserv_key\n")); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	secretFile.Close()
+
+	for _, test := range []struct {
+		configData string
+		expectErr  bool
+	}{
+		{"irc_nickname_password_file: " + secretFile.Name(), false},
+		{"irc_connections: [{name: other, irc_nickname_password_file: " +
+			secretFile.Name() + "}]", false},
+		{"irc_nickname_password: inline\nirc_nickname_password_file: " +
+			secretFile.Name(), true},
+		{"irc_nickname_password_file: /nonexistent/airtestsecret", true},
+	} {
+		tmpfile, err := ioutil.TempFile("", "airtestsecretconfig")
+		if err != nil {
+			t.Errorf("Could not create tmpfile for testing: %s", err)
+		}
+		defer os.Remove(tmpfile.Name())
+		if _, err := tmpfile.Write([]byte(test.configData)); err != nil {
+			t.Errorf("Could not write test data in tmpfile: %s", err)
+		}
+		tmpfile.Close()
+
+		config, err := LoadConfig(tmpfile.Name())
+		if test.expectErr {
+			if config != nil {
+				t.Errorf("Expected no config upon %q", test.configData)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Could not load %q: %s", test.configData, err)
+			continue
+		}
+		nickPass := config.IRCNickPass
+		if len(config.IRCConnections) > 0 {
+			nickPass = config.IRCConnections[0].IRCNickPass
+		}
+		if nickPass != "mynickserv_key" {
+			t.Errorf("%q: expected the password without newline, got %q",
+				test.configData, nickPass)
+		}
+	}
+}
+
+func TestLoadBadTrustedProxies(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestproxiesconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("trusted_proxies: ['10.0.0.0/33']")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid trusted proxies")
+	}
+}
+
+func TestLoadBadSeverityRateLimits(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestseverityratelimitsconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("http_severity_rate_limits: {critical: {rate: -1}}")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon negative severity rate limits")
+	}
+}
+
+func TestLoadBadRawFallbackFormat(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestfallbackconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("raw_fallback_format: yaml")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid raw fallback format")
+	}
+}
+
+func TestLoadBadPayloadFormat(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestpayloadconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("payload_format: pagerduty")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid payload format")
+	}
+}
+
+func TestLoadBadIdempotencyKey(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestidempotencyconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("idempotency_key: fingerprint")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid idempotency key")
+	}
+}
+
+func TestLoadBadTLSSettings(t *testing.T) {
+	for _, configData := range []string{
+		"irc_tls_min_version: \"1.4\"",
+		"irc_tls_cipher_suites: [TLS_RSA_WITH_RC4_128_SHA]",
+		"http_tls_min_version: tls12",
+		"http_tls_cipher_suites: [AES128-SHA]",
+		"http_tls_cert_file: /etc/tls.crt",
+	} {
+		tmpfile, err := ioutil.TempFile("", "airtesttlsconfig")
+		if err != nil {
+			t.Errorf("Could not create tmpfile for testing: %s", err)
+		}
+		defer os.Remove(tmpfile.Name())
+
+		if _, err := tmpfile.Write([]byte(configData)); err != nil {
+			t.Errorf("Could not write test data in tmpfile: %s", err)
+		}
+		tmpfile.Close()
+
+		config, err := LoadConfig(tmpfile.Name())
+		if config != nil {
+			t.Errorf("Expected no config upon invalid TLS settings: %s",
+				configData)
+		}
+	}
+}
+
+func TestLoadBadUnjoinedChannelPolicy(t *testing.T) {
+	for _, configData := range []string{
+		"unjoined_channel_policy: drop",
+		"unjoined_channel_policy: fallback",
+	} {
+		tmpfile, err := ioutil.TempFile("", "airtestunjoinedconfig")
+		if err != nil {
+			t.Errorf("Could not create tmpfile for testing: %s", err)
+		}
+		defer os.Remove(tmpfile.Name())
+
+		if _, err := tmpfile.Write([]byte(configData)); err != nil {
+			t.Errorf("Could not write test data in tmpfile: %s", err)
+		}
+		tmpfile.Close()
+
+		config, err := LoadConfig(tmpfile.Name())
+		if config != nil {
+			t.Errorf("Expected no config upon %q", configData)
+		}
+	}
+}
+
+func TestLoadBadQueueFullStatus(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestqueuefullconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("queue_full_status: 500")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid queue full status")
+	}
+}
+
+func TestLoadBadSuccessResponseTemplate(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestsuccessresponseconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("success_response_template: '{{ .Enqueued'")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid success response template")
+	}
+}
+
+func TestLoadBadDrainMode(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestdrainconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("drain_mode: eventually")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid drain mode")
+	}
+}
+
+func TestLoadWatchTemplatesWithoutFiles(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestwatchconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte(`watch_templates: yes`)
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config when watching no template files")
+	}
+}
+
+func TestLoadBadNickMaxLength(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestnicklenconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte(`irc_nick_max_length: -1`)
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon negative irc_nick_max_length")
+	}
+}
+
+func TestLoadDuplicateConnections(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestconnectionsconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte(`irc_connections:
+  - name: other
+  - name: other`)
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon duplicate connections")
+	}
+}
+
+func TestLoadDuplicateChannels(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestchannelsconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte(`irc_channels:
+  - name: "#foo"
+    password: foopass
+  - name: "#bar"
+  - name: "#FOO"
+irc_connections:
+  - name: other
+    irc_channels:
+      - name: "#baz"
+      - name: "#baz"`)
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config == nil {
+		t.Fatalf("Expected a config, got: %s", err)
+	}
+	expectedChannels := []IRCChannel{
+		{Name: "#foo", Password: "foopass"},
+		{Name: "#bar"},
+	}
+	if !reflect.DeepEqual(expectedChannels, config.IRCChannels) {
+		t.Errorf("Unexpected channels: %+v", config.IRCChannels)
+	}
+	if channels := config.IRCConnections[0].IRCChannels; len(channels) != 1 {
+		t.Errorf("Unexpected connection channels: %+v", channels)
+	}
+}
+
+func TestLoadBadLocalAddr(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestlocaladdrconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte("irc_local_addr: eth0")
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config != nil {
+		t.Errorf("Expected no config upon invalid irc_local_addr")
+	}
+}
+
+func TestLoadTemplateFuncsInAllTemplates(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestfuncsconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte(`allow_exec_template_func: yes
+exec_template_commands: ["/bin/echo"]
+heartbeat_channel: "#foo"
+heartbeat_interval: 1m
+heartbeat_template: "{{ themed \"ok\" \"alive\" }}"
+irc_channels:
+  - name: "#foo"
+    footer_template: "{{ exec \"/bin/echo\" .Channel }}"
+    digest:
+      interval: 1h
+      template: "{{ themed \"warn\" .Channel }}"`)
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config == nil {
+		t.Fatalf("Expected a config, got: %s", err)
+	}
+	if _, err := NewIRCNotifier(config, make(chan AlertMsg),
+		make(chan string)); err != nil {
+		t.Errorf("Could not create notifier: %s", err)
+	}
+}
+
+func TestLoadMaintenanceWindows(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestmaintenanceconfig")
+	if err != nil {
+		t.Errorf("Could not create tmpfile for testing: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	configData := []byte(`maintenance_windows:
+  - name: db upgrade
+    start: 2017-05-15T22:00:00Z
+    end: 2017-05-16T02:00:00+02:00
+    matchers:
+      service: db`)
+	if _, err := tmpfile.Write(configData); err != nil {
+		t.Errorf("Could not write test data in tmpfile: %s", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if config == nil {
+		t.Fatalf("Expected a config, got: %s", err)
+	}
+	window := config.MaintenanceWindows[0]
+	if !window.End.Equal(time.Date(2017, 5, 16, 0, 0, 0, 0, time.UTC)) ||
+		window.Matchers["service"] != "db" {
+		t.Errorf("Unexpected maintenance window: %+v", window)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"errors"
+	"fmt"
+)
+
+// defaultConnection names the IRC connection configured at the top level.
+const defaultConnection = "default"
+
+// IRCConnection is an additional IRC network to relay to. Unset settings
+// are taken from the top level configuration, except channels.
+type IRCConnection struct {
+	Name        string       `yaml:"name"`
+	IRCHost     string       `yaml:"irc_host"`
+	IRCPort     int          `yaml:"irc_port"`
+	IRCUseSSL   *bool        `yaml:"irc_use_ssl"`
+	IRCPassword string       `yaml:"irc_password"`
+	IRCNick     string       `yaml:"irc_nickname"`
+	IRCNickPass string       `yaml:"irc_nickname_password"`
+	IRCRealName string       `yaml:"irc_realname"`
+	IRCCharset  string       `yaml:"irc_charset"`
+	IRCChannels []IRCChannel `yaml:"irc_channels"`
+
+	IRCPasswordFile string `yaml:"irc_password_file"`
+	IRCNickPassFile string `yaml:"irc_nickname_password_file"`
+}
+
+// connectionConfig returns the configuration of the notifier for conn, a
+// copy of config with the settings of conn.
+func (config *Config) connectionConfig(conn *IRCConnection) *Config {
+	connConfig := *config
+	connConfig.IRCConnections = nil
+	connConfig.connectionName = conn.Name
+	connConfig.IRCChannels = conn.IRCChannels
+	// Heartbeats are only sent on the default connection.
+	connConfig.HeartbeatChannel = ""
+	if conn.IRCHost != "" {
+		connConfig.IRCHost = conn.IRCHost
+	}
+	if conn.IRCPort != 0 {
+		connConfig.IRCPort = conn.IRCPort
+	}
+	if conn.IRCUseSSL != nil {
+		connConfig.IRCUseSSL = *conn.IRCUseSSL
+	}
+	if conn.IRCPassword != "" {
+		connConfig.IRCPassword = conn.IRCPassword
+	}
+	// The top level password is not sent for a nick of its own.
+	if conn.IRCNick != "" {
+		connConfig.IRCNick = conn.IRCNick
+		connConfig.IRCNickPass = conn.IRCNickPass
+	} else if conn.IRCNickPass != "" {
+		connConfig.IRCNickPass = conn.IRCNickPass
+	}
+	if conn.IRCRealName != "" {
+		connConfig.IRCRealName = conn.IRCRealName
+	}
+	if conn.IRCCharset != "" {
+		connConfig.IRCCharset = conn.IRCCharset
+	}
+	return &connConfig
+}
+
+// connection returns the name of the connection config is for.
+func (config *Config) connection() string {
+	if config.connectionName == "" {
+		return defaultConnection
+	}
+	return config.connectionName
+}
+
+// validateConnections checks connections have distinct names, and that
+// channels are routed to a single one.
+func validateConnections(connections []IRCConnection) error {
+	names := make(map[string]bool)
+	channels := make(map[string]string)
+	for _, conn := range connections {
+		switch {
+		case conn.Name == "":
+			return errors.New("irc_connections require a name")
+		case conn.Name == defaultConnection || names[conn.Name]:
+			return fmt.Errorf("duplicate irc connection name: %s", conn.Name)
+		}
+		names[conn.Name] = true
+		for _, channel := range conn.IRCChannels {
+			key := channelKey(channel.Name)
+			if other, ok := channels[key]; ok {
+				return fmt.Errorf("channel %s listed by connections %s and %s",
+					channel.Name, other, conn.Name)
+			}
+			channels[key] = conn.Name
+		}
+	}
+	return nil
+}
+
+// channelConnections maps the channels of connections, by channelKey, to the
+// name of the connection they are relayed to, others going to the default
+// connection.
+func channelConnections(connections []IRCConnection) map[string]string {
+	routes := make(map[string]string)
+	for _, conn := range connections {
+		for _, channel := range conn.IRCChannels {
+			routes[channelKey(channel.Name)] = conn.Name
+		}
+	}
+	return routes
+}
+
+// sendResolvedOverrides returns the SendResolved setting of the channels of
+// all connections that have one, by channelKey.
+func sendResolvedOverrides(config *Config) map[string]bool {
+	channels := append([]IRCChannel{}, config.IRCChannels...)
+	for _, conn := range config.IRCConnections {
+		channels = append(channels, conn.IRCChannels...)
+	}
+	overrides := make(map[string]bool)
+	for _, channel := range channels {
+		if channel.SendResolved != nil {
+			overrides[channelKey(channel.Name)] = *channel.SendResolved
+		}
+	}
+	return overrides
+}
+
+// configuredChannels returns the channels of each connection by channelKey,
+// the top level ones under defaultConnection.
+func configuredChannels(config *Config) map[string]map[string]bool {
+	connections := append([]IRCConnection{
+		{Name: defaultConnection, IRCChannels: config.IRCChannels},
+	}, config.IRCConnections...)
+	channels := make(map[string]map[string]bool)
+	for _, conn := range connections {
+		channels[conn.Name] = make(map[string]bool)
+		for _, channel := range conn.IRCChannels {
+			channels[conn.Name][channelKey(channel.Name)] = true
+		}
+	}
+	return channels
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConnectionConfigInheritsUnsetSettings(t *testing.T) {
+	useSSL := false
+	config := &Config{
+		IRCHost:          "irc.example.com",
+		IRCPort:          7000,
+		IRCUseSSL:        true,
+		IRCNick:          "relay",
+		IRCNickPass:      "secret",
+		IRCRealName:      "Relay",
+		IRCChannels:      []IRCChannel{IRCChannel{Name: "#foo"}},
+		HeartbeatChannel: "#foo",
+		MsgTemplate:      "{{ .Status }}",
+	}
+	conn := &IRCConnection{
+		Name:        "other",
+		IRCHost:     "irc.example.org",
+		IRCUseSSL:   &useSSL,
+		IRCNick:     "otherrelay",
+		IRCChannels: []IRCChannel{IRCChannel{Name: "#bar"}},
+	}
+	connConfig := config.connectionConfig(conn)
+	expected := &Config{
+		IRCHost:     "irc.example.org",
+		IRCPort:     7000,
+		IRCUseSSL:   false,
+		IRCNick:     "otherrelay",
+		IRCRealName: "Relay",
+		IRCChannels: []IRCChannel{IRCChannel{Name: "#bar"}},
+		MsgTemplate: "{{ .Status }}",
+
+		connectionName: "other",
+	}
+	if !reflect.DeepEqual(expected, connConfig) {
+		t.Errorf("Unexpected connection config.\nExpected: %+v\nActual: %+v",
+			expected, connConfig)
+	}
+	if config.IRCHost != "irc.example.com" {
+		t.Error("Expected the top level config to be left alone")
+	}
+}
+
+func TestConnectionConfigNickPasswordOnly(t *testing.T) {
+	config := &Config{IRCNick: "relay", IRCNickPass: "secret"}
+	connConfig := config.connectionConfig(&IRCConnection{
+		Name: "other", IRCNickPass: "othersecret"})
+	if connConfig.IRCNick != "relay" || connConfig.IRCNickPass != "othersecret" {
+		t.Errorf("Expected the top level nick with the connection password, got %s/%s",
+			connConfig.IRCNick, connConfig.IRCNickPass)
+	}
+}
+
+func TestValidateConnections(t *testing.T) {
+	for _, connections := range [][]IRCConnection{
+		{{IRCHost: "irc.example.org"}},
+		{{Name: "default"}},
+		{{Name: "other"}, {Name: "other"}},
+		{
+			{Name: "other", IRCChannels: []IRCChannel{{Name: "#foo"}}},
+			{Name: "another", IRCChannels: []IRCChannel{{Name: "#foo"}}},
+		},
+		{
+			{Name: "other", IRCChannels: []IRCChannel{{Name: "#foo[1]"}}},
+			{Name: "another", IRCChannels: []IRCChannel{{Name: "#FOO{1}"}}},
+		},
+	} {
+		if err := validateConnections(connections); err == nil {
+			t.Errorf("Expected error for connections %+v", connections)
+		}
+	}
+}
+
+func TestSendResolvedOverrides(t *testing.T) {
+	no, yes := false, true
+	overrides := sendResolvedOverrides(&Config{
+		IRCChannels: []IRCChannel{{Name: "#foo", SendResolved: &no}, {Name: "#bar"}},
+		IRCConnections: []IRCConnection{
+			{Name: "other", IRCChannels: []IRCChannel{{Name: "#Baz", SendResolved: &yes}}},
+		},
+	})
+	expected := map[string]bool{"#foo": false, "#baz": true}
+	if !reflect.DeepEqual(expected, overrides) {
+		t.Errorf("Unexpected overrides: %+v", overrides)
+	}
+}
+
+func TestChannelConnections(t *testing.T) {
+	routes := channelConnections([]IRCConnection{
+		{Name: "other", IRCChannels: []IRCChannel{{Name: "#foo"}, {Name: "#bar"}}},
+		{Name: "another", IRCChannels: []IRCChannel{{Name: "#Baz"}}},
+	})
+	expected := map[string]string{
+		"#foo": "other",
+		"#bar": "other",
+		"#baz": "another",
+	}
+	if !reflect.DeepEqual(expected, routes) {
+		t.Errorf("Unexpected routes: %+v", routes)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// WebhookData is the payload of Alertmanager webhooks.
+type WebhookData struct {
+	promtmpl.Data
+
+	Version  string `json:"version"`
+	GroupKey string `json:"groupKey"`
+
+	// unfilteredGroupKey is the group key once redacted from GroupKey, as
+	// it holds the group label values.
+	unfilteredGroupKey string
+}
+
+// groupKey returns the group key of the data, even if redacted.
+func (data *WebhookData) groupKey() string {
+	if data.unfilteredGroupKey != "" {
+		return data.unfilteredGroupKey
+	}
+	return data.GroupKey
+}
+
+// AlertTemplateData is what templates are applied on when sending a message
+// per alert: the alert, along with details of the group it belongs to.
+type AlertTemplateData struct {
+	promtmpl.Alert
+
+	GroupKey string `json:"groupKey,omitempty"`
+	// Left out of the raw fallback on template errors, as they are the same
+	// for every alert. GroupStatus is firing while any alert of the group is.
+	GroupStatus string `json:"-"`
+	ExternalURL string `json:"-"`
+}
+
+// AlertMsg is a message queued for an IRC channel.
+type AlertMsg struct {
+	Channel string
+	// Alert is the text sent as-is when no structured data is attached.
+	Alert string
+
+	// GroupData is the webhook data the message was built from. AlertData
+	// is the single alert to render, or nil when rendering once per group.
+	GroupData *WebhookData
+	AlertData *promtmpl.Alert
+
+	// StartsAt is when the alert (or the earliest alert of the group, when
+	// sending once per group) started firing.
+	StartsAt time.Time
+	// EnqueuedAt is when the message was queued for the IRC routine.
+	EnqueuedAt time.Time
+	// EndsBatch is set on the last message built from a webhook.
+	EndsBatch bool
+	// webhook is the whole webhook when GroupData is only part of it, once
+	// regrouped.
+	webhook *WebhookData
+}
+
+// batch returns the webhook alertMsg was built from.
+func (alertMsg *AlertMsg) batch() *WebhookData {
+	if alertMsg.webhook != nil {
+		return alertMsg.webhook
+	}
+	return alertMsg.GroupData
+}
+
+// groupStatus returns the status of a group of alerts, as Alertmanager sets
+// it: firing while any of them is.
+func groupStatus(alerts promtmpl.Alerts) string {
+	if len(alerts.Firing()) > 0 {
+		return "firing"
+	}
+	return "resolved"
+}
+
+// alertMsgStatus returns the status of the alert(s) in alertMsg.
+func alertMsgStatus(alertMsg *AlertMsg) string {
+	switch {
+	case alertMsg.AlertData != nil:
+		return alertMsg.AlertData.Status
+	case alertMsg.GroupData != nil:
+		return alertMsg.GroupData.Status
+	default:
+		return ""
+	}
+}
+
+// alertMsgLabels returns the labels of the alert in alertMsg, or the common
+// labels of its group, and its fingerprint, only known for single alerts.
+func alertMsgLabels(alertMsg *AlertMsg) (promtmpl.KV, string) {
+	switch {
+	case alertMsg.AlertData != nil:
+		return alertMsg.AlertData.Labels, alertMsg.AlertData.Fingerprint
+	case alertMsg.GroupData != nil:
+		fingerprint := ""
+		if len(alertMsg.GroupData.Alerts) == 1 {
+			fingerprint = alertMsg.GroupData.Alerts[0].Fingerprint
+		}
+		return alertMsg.GroupData.CommonLabels, fingerprint
+	default:
+		return nil, ""
+	}
+}
+
+// CollapsedGroupData is passed to the collapse header template, SharedLabels
+// holds the labels common to all alerts of the group.
+type CollapsedGroupData struct {
+	WebhookData
+	SharedLabels promtmpl.KV `json:"sharedLabels"`
+}
+
+// CollapsedAlertData is passed to the collapse line template, UniqueLabels
+// holds the labels of the alert not shared with the rest of its group.
+type CollapsedAlertData struct {
+	AlertTemplateData
+	UniqueLabels promtmpl.KV `json:"uniqueLabels"`
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeduplicatorWindow(t *testing.T) {
+	dedup := NewDeduplicator(time.Minute)
+	start := time.Unix(0, 0)
+
+	for i, test := range []struct {
+		key      string
+		elapsed  time.Duration
+		expected bool
+	}{
+		{"a", 0, false},
+		{"a", 10 * time.Second, true},
+		{"b", 20 * time.Second, false},
+		// "a" expired, it gets recorded again.
+		{"a", time.Minute, false},
+		{"b", 70 * time.Second, true},
+		{"b", 80 * time.Second, false},
+	} {
+		if seen := dedup.Seen(test.key, start.Add(test.elapsed)); seen != test.expected {
+			t.Errorf("Call #%d for key %s: expected seen=%t", i, test.key, test.expected)
+		}
+	}
+	if dedup.Size() != 2 {
+		t.Errorf("Expected 2 remembered keys, got %d", dedup.Size())
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"errors"
+	"strings"
+	"text/template"
+)
+
+const (
+	defaultLeftDelim  = "{{"
+	defaultRightDelim = "}}"
+)
+
+// TemplateDelimiters replace the {{ and }} action delimiters of templates,
+// e.g. when they conflict with the tool generating the configuration.
+type TemplateDelimiters struct {
+	Left  string `yaml:"left"`
+	Right string `yaml:"right"`
+}
+
+func (d TemplateDelimiters) validate() error {
+	if (d.Left == "") != (d.Right == "") {
+		return errors.New("template_delimiters requires both left and right")
+	}
+	return nil
+}
+
+// newTemplate returns a template using the delimiters, the default ones
+// when unset.
+func (d TemplateDelimiters) newTemplate(name string) *template.Template {
+	return template.New(name).Delims(d.Left, d.Right)
+}
+
+// rewrite replaces the default delimiters of the built-in template text, so
+// that it parses with the configured ones.
+func (d TemplateDelimiters) rewrite(text string) string {
+	if d.Left == "" {
+		return text
+	}
+	text = strings.Replace(text, defaultLeftDelim, d.Left, -1)
+	return strings.Replace(text, defaultRightDelim, d.Right, -1)
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestCustomTemplateDelimiters(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate:        "Alert [[ .Labels.alertname ]] is {{ [[ .Status ]] }}",
+		TemplateDelimiters: TemplateDelimiters{Left: "[[", Right: "]]"},
+	})
+	alert := promtmpl.Alert{
+		Status: "firing",
+		Labels: promtmpl.KV{"alertname": "airDown"},
+	}
+	alertMsg := AlertMsg{Channel: "#foo", AlertData: &alert,
+		GroupData: &WebhookData{}}
+
+	expected := "Alert airDown is {{ firing }}"
+	if msg := formatter.RenderMsg(&alertMsg); msg != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, msg)
+	}
+}
+
+func TestRewriteDefaultTemplateDelimiters(t *testing.T) {
+	delims := TemplateDelimiters{Left: "[[", Right: "]]"}
+	expected := "Alert [[ .Labels.alertname ]] on [[ .Labels.instance ]] is [[ .Status ]]"
+	if text := delims.rewrite(defaultMsgTemplate); text != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, text)
+	}
+	if text := (TemplateDelimiters{}).rewrite(defaultMsgTemplate); text != defaultMsgTemplate {
+		t.Errorf("Expected default delimiters to be kept, got '%s'", text)
+	}
+}
+
+func TestTemplateDelimitersProvidedTogether(t *testing.T) {
+	for _, delims := range []TemplateDelimiters{
+		{Left: "[["},
+		{Right: "]]"},
+	} {
+		if err := delims.validate(); err == nil {
+			t.Errorf("Expected error for delimiters %+v", delims)
+		}
+	}
+	if err := (TemplateDelimiters{}).validate(); err != nil {
+		t.Errorf("Expected unset delimiters to be valid: %s", err)
+	}
+}
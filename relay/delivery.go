@@ -0,0 +1,102 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	deliveryLogQueueSize = 1000
+)
+
+type deliveryRecord struct {
+	Time        time.Time `json:"time"`
+	Connection  string    `json:"connection"`
+	Channel     string    `json:"channel"`
+	Alertname   string    `json:"alertname,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Delivered   bool      `json:"delivered"`
+}
+
+// DeliveryLogger appends a record of each attempt to deliver an alert, sent
+// or not, to a file, one JSON record per line. The file is never truncated
+// or rotated. Records are written in a single write each, so that the
+// notifiers of all connections can append to the same file.
+type DeliveryLogger struct {
+	connection string
+	file       *os.File
+	records    chan deliveryRecord
+	done       chan bool
+}
+
+// NewDeliveryLogger opens path for appending the delivery records of
+// connection.
+func NewDeliveryLogger(path string, connection string) (*DeliveryLogger, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	logger := &DeliveryLogger{
+		connection: connection,
+		file:       file,
+		records:    make(chan deliveryRecord, deliveryLogQueueSize),
+		done:       make(chan bool),
+	}
+	go logger.run()
+	return logger, nil
+}
+
+// Log queues the record of an attempt to deliver alertMsg without blocking.
+// Records are dropped if the writer cannot keep up.
+func (l *DeliveryLogger) Log(alertMsg *AlertMsg, delivered bool, at time.Time) {
+	labels, fingerprint := alertMsgLabels(alertMsg)
+	record := deliveryRecord{
+		Time:        at,
+		Connection:  l.connection,
+		Channel:     alertMsg.Channel,
+		Alertname:   labels["alertname"],
+		Fingerprint: fingerprint,
+		Delivered:   delivered,
+	}
+	select {
+	case l.records <- record:
+	default:
+		log.Printf("Delivery log queue full, dropping record for %s",
+			alertMsg.Channel)
+	}
+}
+
+func (l *DeliveryLogger) run() {
+	encoder := json.NewEncoder(l.file)
+	for record := range l.records {
+		if err := encoder.Encode(record); err != nil {
+			log.Printf("Could not write delivery log record: %s", err)
+		}
+	}
+	if err := l.file.Close(); err != nil {
+		log.Printf("Could not close delivery log: %s", err)
+	}
+	l.done <- true
+}
+
+// Close writes the queued records and closes the delivery log file.
+func (l *DeliveryLogger) Close() {
+	close(l.records)
+	<-l.done
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestDeliveryLogger(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestdeliveries")
+	if err != nil {
+		t.Fatalf("Could not create tmpfile for testing: %s", err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	logger, err := NewDeliveryLogger(tmpfile.Name(), "default")
+	if err != nil {
+		t.Fatalf("Could not create delivery logger: %s", err)
+	}
+	at := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	alert := promtmpl.Alert{
+		Labels: promtmpl.KV{"alertname": "airDown"}, Fingerprint: "a1b2"}
+	logger.Log(&AlertMsg{Channel: "#foo", AlertData: &alert}, true, at)
+	logger.Log(&AlertMsg{Channel: "#bar", Alert: "test message"}, false, at)
+	logger.Close()
+
+	data, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Could not read delivery log: %s", err)
+	}
+	expected := []string{
+		`{"time":"2017-05-15T23:00:00Z","connection":"default","channel":"#foo","alertname":"airDown","fingerprint":"a1b2","delivered":true}`,
+		`{"time":"2017-05-15T23:00:00Z","connection":"default","channel":"#bar","delivered":false}`,
+	}
+	if strings.TrimSpace(string(data)) != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected delivery log:\n%s", data)
+	}
+}
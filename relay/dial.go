@@ -0,0 +1,173 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// goirc does not let us tune its dialer, but dials through any dialer
+// registered as a proxy scheme. The fallback dialer is registered this way
+// to connect with our own Happy Eyeballs settings.
+const fallbackDialerScheme = "airfallback"
+
+// goirc negotiates capabilities with a bare CAP LS, sent along NICK and USER.
+// The CAP LS dialer sends CAP LS 302 itself once connected, before goirc
+// registers, goirc then requests the capabilities and ends the negotiation.
+const capLSDialerScheme = "aircapls"
+
+func init() {
+	proxy.RegisterDialerType(fallbackDialerScheme, newFallbackDialer)
+	proxy.RegisterDialerType(capLSDialerScheme, newCapLSDialer)
+}
+
+// fallbackDialerURL returns the proxy URL of a dual-stack dialer starting a
+// connection on the other address family after fallbackDelay.
+func fallbackDialerURL(fallbackDelay time.Duration, timeout time.Duration) string {
+	return dialerURL(fallbackDialerScheme, fallbackDialerQuery(fallbackDelay, timeout))
+}
+
+// capLSDialerURL returns the proxy URL of a dialer like the fallback one,
+// sending CAP LS 302 once connected. The connection is made over TLS to
+// tlsServerName unless empty, as goirc would otherwise wrap the CAP LS
+// dialer connection in TLS only after it sent CAP LS, accepting at least
+// tlsMinVersion and only tlsCipherSuites as with newTLSConfig.
+func capLSDialerURL(fallbackDelay time.Duration, timeout time.Duration,
+	tlsServerName string, tlsMinVersion string, tlsCipherSuites []string) string {
+	query := fallbackDialerQuery(fallbackDelay, timeout)
+	if tlsServerName != "" {
+		query.Set("tls_server_name", tlsServerName)
+		query.Set("tls_min_version", tlsMinVersion)
+		query["tls_cipher_suite"] = tlsCipherSuites
+	}
+	return dialerURL(capLSDialerScheme, query)
+}
+
+func fallbackDialerQuery(fallbackDelay time.Duration, timeout time.Duration) url.Values {
+	query := url.Values{}
+	query.Set("fallback_delay", fallbackDelay.String())
+	query.Set("timeout", timeout.String())
+	return query
+}
+
+func dialerURL(scheme string, query url.Values) string {
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     "direct",
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// validateLocalAddr checks that addr is an IP address to connect from,
+// optionally with a port.
+func validateLocalAddr(addr string) error {
+	host := addr
+	if h, port, err := net.SplitHostPort(addr); err == nil {
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return fmt.Errorf("invalid irc_local_addr port: %s", port)
+		}
+		host = h
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid irc_local_addr, expected an IP address: %s",
+			addr)
+	}
+	return nil
+}
+
+// newFallbackDialer is passed the goirc dialer as forward dialer, and keeps
+// its local address.
+func newFallbackDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	query := u.Query()
+	fallbackDelay, err := time.ParseDuration(query.Get("fallback_delay"))
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := time.ParseDuration(query.Get("timeout"))
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:       timeout,
+		FallbackDelay: fallbackDelay,
+	}
+	if forward, ok := forward.(*net.Dialer); ok {
+		dialer.LocalAddr = forward.LocalAddr
+	}
+	return dialer, nil
+}
+
+type capLSDialer struct {
+	dialer *net.Dialer
+	// Only set when connecting over TLS.
+	tlsConfig *tls.Config
+}
+
+func newCapLSDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	dialer, err := newFallbackDialer(u, forward)
+	if err != nil {
+		return nil, err
+	}
+	capLS := &capLSDialer{dialer: dialer.(*net.Dialer)}
+	query := u.Query()
+	if serverName := query.Get("tls_server_name"); serverName != "" {
+		capLS.tlsConfig, err = newTLSConfig(query.Get("tls_min_version"),
+			query["tls_cipher_suite"])
+		if err != nil {
+			return nil, err
+		}
+		capLS.tlsConfig.ServerName = serverName
+	}
+	return capLS, nil
+}
+
+func (d *capLSDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *capLSDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// Bound the handshake and write by the dial timeout too, as a stalled
+	// server would otherwise hang connecting.
+	if d.dialer.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.dialer.Timeout))
+	}
+	if d.tlsConfig != nil {
+		tlsConn := tls.Client(conn, d.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	if _, err := io.WriteString(conn, "CAP LS 302\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
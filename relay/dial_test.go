@@ -0,0 +1,170 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+func TestFallbackDialer(t *testing.T) {
+	u, err := url.Parse(fallbackDialerURL(50*time.Millisecond, time.Second))
+	if err != nil {
+		t.Fatalf("Could not parse dialer URL: %s", err)
+	}
+	dialer, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		t.Fatalf("Could not get dialer: %s", err)
+	}
+	netDialer, ok := dialer.(*net.Dialer)
+	if !ok {
+		t.Fatalf("Expected a net.Dialer, got %T", dialer)
+	}
+	if netDialer.FallbackDelay != 50*time.Millisecond ||
+		netDialer.Timeout != time.Second {
+		t.Errorf("Unexpected dialer settings: %+v", netDialer)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	defer listener.Close()
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not dial: %s", err)
+	}
+	conn.Close()
+}
+
+func TestFallbackDialerKeepsLocalAddr(t *testing.T) {
+	u, err := url.Parse(fallbackDialerURL(50*time.Millisecond, time.Second))
+	if err != nil {
+		t.Fatalf("Could not parse dialer URL: %s", err)
+	}
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}
+	dialer, err := proxy.FromURL(u, &net.Dialer{LocalAddr: local})
+	if err != nil {
+		t.Fatalf("Could not get dialer: %s", err)
+	}
+	if netDialer := dialer.(*net.Dialer); netDialer.LocalAddr != local {
+		t.Errorf("Expected local address %s, got %s", local, netDialer.LocalAddr)
+	}
+}
+
+func TestCapLSDialer(t *testing.T) {
+	u, err := url.Parse(capLSDialerURL(0, time.Second, "", "", nil))
+	if err != nil {
+		t.Fatalf("Could not parse dialer URL: %s", err)
+	}
+	dialer, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		t.Fatalf("Could not get dialer: %s", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	defer listener.Close()
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not dial: %s", err)
+	}
+	defer conn.Close()
+
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Could not accept: %s", err)
+	}
+	defer server.Close()
+	line, err := bufio.NewReader(server).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Could not read: %s", err)
+	}
+	if line != "CAP LS 302\r\n" {
+		t.Errorf("Expected CAP LS 302 first, got: %q", line)
+	}
+}
+
+func TestCapLSDialerHandshakeTimeout(t *testing.T) {
+	u, err := url.Parse(capLSDialerURL(0, 100*time.Millisecond, "example.com", "1.2", nil))
+	if err != nil {
+		t.Fatalf("Could not parse dialer URL: %s", err)
+	}
+	dialer, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		t.Fatalf("Could not get dialer: %s", err)
+	}
+
+	// Accepts connections but never answers the TLS handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	defer listener.Close()
+
+	dialed := make(chan error, 1)
+	go func() {
+		conn, err := dialer.Dial("tcp", listener.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+		dialed <- err
+	}()
+	select {
+	case err := <-dialed:
+		if err == nil {
+			t.Errorf("Expected the stalled handshake to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Dialing hung on the stalled handshake")
+	}
+}
+
+func TestCapLSDialerInvalidTLSSettings(t *testing.T) {
+	u, err := url.Parse(capLSDialerURL(0, time.Second, "example.com", "1.2",
+		[]string{"TLS_RSA_WITH_RC4_128_SHA"}))
+	if err != nil {
+		t.Fatalf("Could not parse dialer URL: %s", err)
+	}
+	if _, err := proxy.FromURL(u, proxy.Direct); err == nil {
+		t.Error("Expected an error for an unsupported cipher suite")
+	}
+}
+
+func TestValidateLocalAddr(t *testing.T) {
+	for _, test := range []struct {
+		addr  string
+		valid bool
+	}{
+		{"192.0.2.1", true},
+		{"192.0.2.1:6000", true},
+		{"2001:db8::1", true},
+		{"[2001:db8::1]:6000", true},
+		{"relay.example.com", false},
+		{"192.0.2.1:bogus", false},
+		{"192.0.2.300", false},
+	} {
+		if err := validateLocalAddr(test.addr); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid=%v, got error %v", test.addr, test.valid, err)
+		}
+	}
+}
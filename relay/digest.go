@@ -0,0 +1,131 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"errors"
+	"sort"
+	"text/template"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+const (
+	defaultDigestTemplate = "{{ len .Alerts }} alerts in the last {{ .Interval }}"
+	maxDigestAlerts       = 1000
+)
+
+// Digest replaces individual messages to a channel with one message every
+// Interval, rendered with Template from the alerts received meanwhile.
+type Digest struct {
+	Interval time.Duration `yaml:"interval"`
+	Template string        `yaml:"template"`
+
+	tmpl *template.Template
+}
+
+// Init validates the digest configuration and applies defaults, parsing the
+// template with parser.
+func (d *Digest) Init(parser templateParser) error {
+	if d.Interval <= 0 {
+		return errors.New("digest interval must be positive")
+	}
+	if d.Template == "" {
+		d.Template = parser.delims.rewrite(defaultDigestTemplate)
+	}
+	tmpl, err := parser.parse("digest", d.Template)
+	if err != nil {
+		return err
+	}
+	d.tmpl = tmpl
+	return nil
+}
+
+// DigestData is passed to digest templates.
+type DigestData struct {
+	Channel  string
+	Interval time.Duration
+	Alerts   promtmpl.Alerts
+	// Alerts dropped because the digest buffer was full.
+	Dropped int
+}
+
+// LabelValues returns the sorted distinct values of label name in the
+// digest alerts, e.g. to count the services involved.
+func (d DigestData) LabelValues(name string) []string {
+	seen := make(map[string]bool)
+	values := []string{}
+	for _, alert := range d.Alerts {
+		value, ok := alert.Labels[name]
+		if !ok || seen[value] {
+			continue
+		}
+		seen[value] = true
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// digestBuffer accumulates the alerts of a channel until its digest is due.
+type digestBuffer struct {
+	digest  *Digest
+	alerts  promtmpl.Alerts
+	dropped int
+	since   time.Time
+}
+
+// Add buffers the alerts of alertMsg received at now, dropping the oldest
+// ones beyond maxDigestAlerts.
+func (b *digestBuffer) Add(alertMsg *AlertMsg, now time.Time) {
+	if b.since.IsZero() {
+		b.since = now
+	}
+	switch {
+	case alertMsg.AlertData != nil:
+		b.alerts = append(b.alerts, *alertMsg.AlertData)
+	case alertMsg.GroupData != nil:
+		b.alerts = append(b.alerts, alertMsg.GroupData.Alerts...)
+	}
+	if excess := len(b.alerts) - maxDigestAlerts; excess > 0 {
+		b.alerts = b.alerts[excess:]
+		b.dropped += excess
+	}
+}
+
+// Flush returns the data of the digest due at now, if any, and resets the
+// buffer.
+func (b *digestBuffer) Flush(channel string, now time.Time) (*DigestData, bool) {
+	if b.since.IsZero() {
+		b.since = now
+	}
+	if now.Sub(b.since) < b.digest.Interval {
+		return nil, false
+	}
+	b.since = now
+	if len(b.alerts) == 0 {
+		return nil, false
+	}
+	data := &DigestData{
+		Channel:  channel,
+		Interval: b.digest.Interval,
+		Alerts:   b.alerts,
+		Dropped:  b.dropped,
+	}
+	b.alerts = nil
+	b.dropped = 0
+	return data, true
+}
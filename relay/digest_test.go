@@ -0,0 +1,92 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func makeDigestAlertMsg(service string) *AlertMsg {
+	return &AlertMsg{
+		Channel: "#foo",
+		AlertData: &promtmpl.Alert{
+			Labels: promtmpl.KV{"alertname": "airDown", "service": service}},
+	}
+}
+
+func TestDigestBufferFlush(t *testing.T) {
+	digest := &Digest{Interval: 5 * time.Minute}
+	if err := digest.Init(templateParser{funcs: templateFuncs}); err != nil {
+		t.Fatalf("Could not init digest: %s", err)
+	}
+	buffer := &digestBuffer{digest: digest}
+	start := time.Unix(0, 0)
+
+	if _, due := buffer.Flush("#foo", start); due {
+		t.Errorf("Expected no digest before any interval elapsed")
+	}
+	buffer.Add(makeDigestAlertMsg("air"), start)
+	buffer.Add(makeDigestAlertMsg("water"), start)
+	buffer.Add(makeDigestAlertMsg("air"), start)
+	if _, due := buffer.Flush("#foo", start.Add(time.Minute)); due {
+		t.Errorf("Expected no digest before the interval elapsed")
+	}
+
+	data, due := buffer.Flush("#foo", start.Add(5*time.Minute))
+	if !due {
+		t.Fatalf("Expected a digest once the interval elapsed")
+	}
+	if len(data.Alerts) != 3 {
+		t.Errorf("Expected 3 alerts in digest, got %d", len(data.Alerts))
+	}
+	if services := data.LabelValues("service"); !reflect.DeepEqual(
+		[]string{"air", "water"}, services) {
+		t.Errorf("Unexpected services: %q", services)
+	}
+	if msg := (&Formatter{}).execute(digest.tmpl, data); msg != "3 alerts in the last 5m0s" {
+		t.Errorf("Unexpected digest: %s", msg)
+	}
+
+	// Nothing is sent for an empty digest.
+	if _, due := buffer.Flush("#foo", start.Add(10*time.Minute)); due {
+		t.Errorf("Expected no digest without alerts")
+	}
+}
+
+func TestDigestBufferBounded(t *testing.T) {
+	buffer := &digestBuffer{digest: &Digest{Interval: time.Minute}}
+	for i := 0; i < maxDigestAlerts+5; i++ {
+		buffer.Add(makeDigestAlertMsg("air"), time.Unix(0, 0))
+	}
+	if len(buffer.alerts) != maxDigestAlerts || buffer.dropped != 5 {
+		t.Errorf("Expected %d buffered and 5 dropped alerts, got %d and %d",
+			maxDigestAlerts, len(buffer.alerts), buffer.dropped)
+	}
+}
+
+func TestDigestInvalid(t *testing.T) {
+	for _, digest := range []*Digest{
+		&Digest{},
+		&Digest{Interval: time.Minute, Template: "{{ .Alerts"},
+	} {
+		if err := digest.Init(templateParser{funcs: templateFuncs}); err == nil {
+			t.Errorf("Expected an error for digest %+v", digest)
+		}
+	}
+}
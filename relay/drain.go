@@ -0,0 +1,115 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	drainNone       = "none"
+	drainBestEffort = "best_effort"
+	drainStrict     = "strict"
+
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+var (
+	drainedAlerts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shutdown_drained_alerts",
+			Help: "Number of queued alerts sent while shutting down"},
+		[]string{"ircchannel"},
+	)
+	abandonedAlerts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shutdown_abandoned_alerts",
+			Help: "Number of queued alerts not sent because of shutting down"},
+		[]string{"ircchannel"},
+	)
+)
+
+// drainAlertMsgs sends the alerts still queued when stopping, depending on
+// DrainMode: none, those sent within ShutdownTimeout (best_effort), or all
+// of them (strict). Others are abandoned. When IRC is not connected, strict
+// draining reconnects for up to ShutdownTimeout, unless the notifier gave up
+// reconnecting, and the alerts are abandoned otherwise. Alerts queued for too
+// long are dropped as usual.
+func (notifier *IRCNotifier) drainAlertMsgs() {
+	deadline := time.Now().Add(notifier.ShutdownTimeout)
+	drained, abandoned := 0, 0
+	for {
+		var alertMsg AlertMsg
+		select {
+		case alertMsg = <-notifier.AlertMsgs:
+		default:
+			if drained > 0 || abandoned > 0 {
+				log.Printf("Shutting down, %d queued alerts sent, %d abandoned",
+					drained, abandoned)
+			}
+			return
+		}
+		if !notifier.sessionUp && notifier.DrainMode == drainStrict &&
+			!notifier.GaveUp {
+			notifier.waitForSession(deadline)
+		}
+		switch {
+		case !notifier.sessionUp,
+			notifier.DrainMode == drainNone,
+			notifier.DrainMode == drainBestEffort && !time.Now().Before(deadline):
+			abandonedAlerts.WithLabelValues(alertMsg.Channel).Inc()
+			abandoned++
+		case notifier.isExpired(&alertMsg):
+			notifier.trackBatch(&alertMsg, false)
+		default:
+			notifier.MaybeSendAlertMsg(&alertMsg)
+			drainedAlerts.WithLabelValues(alertMsg.Channel).Inc()
+			drained++
+		}
+	}
+}
+
+// waitForSession connects to IRC if needed and waits for the session to be
+// established, until deadline.
+func (notifier *IRCNotifier) waitForSession(deadline time.Time) {
+	for time.Now().Before(deadline) {
+		if !notifier.Client.Connected() {
+			log.Printf("Reconnecting to IRC to send the queued alerts")
+			if err := notifier.Client.Connect(); err != nil {
+				log.Printf("Could not connect to IRC: %s", err)
+				select {
+				case <-time.After(time.Second):
+				case <-time.After(deadline.Sub(time.Now())):
+				}
+				continue
+			}
+		}
+		select {
+		case <-notifier.sessionUpSignal:
+			notifier.sessionUp = true
+			notifier.MaybeIdentifyNick()
+			notifier.SendRequeuedAlertMsgs()
+			return
+		case <-notifier.sessionDownSignal:
+		case <-time.After(deadline.Sub(time.Now())):
+		}
+	}
+	log.Printf("IRC session not established within %s, abandoning the queued alerts",
+		notifier.ShutdownTimeout)
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// makeDrainTestNotifier returns a notifier with an established session and
+// count alerts queued for #foo, not running so they are left for draining.
+func makeDrainTestNotifier(t *testing.T, port int, count int) *IRCNotifier {
+	config := makeTestIRCConfig(port)
+	notifier, _ := makeTestNotifier(t, config)
+	notifier.AlertMsgs = make(chan AlertMsg, count)
+
+	if err := notifier.Client.Connect(); err != nil {
+		t.Fatalf("Could not connect to IRC: %s", err)
+	}
+	<-notifier.sessionUpSignal
+	notifier.sessionUp = true
+
+	for i := 0; i < count; i++ {
+		notifier.AlertMsgs <- AlertMsg{
+			Channel: "#foo", Alert: fmt.Sprintf("alert %d", i)}
+	}
+	return notifier
+}
+
+func stopDrainTestNotifier(notifier *IRCNotifier, server *testServer) {
+	notifier.Client.Quit("see ya")
+	<-notifier.sessionDownSignal
+	server.Stop()
+}
+
+func TestStrictDrainSendsQueuedAlerts(t *testing.T) {
+	server, port := makeTestServer(t)
+
+	var testStep sync.WaitGroup
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	notifier := makeDrainTestNotifier(t, port, 3)
+	defer stopDrainTestNotifier(notifier, server)
+	notifier.DrainMode = drainStrict
+
+	drainedBefore := testutil.ToFloat64(drainedAlerts.WithLabelValues("#foo"))
+	testStep.Add(3)
+	notifier.drainAlertMsgs()
+	testStep.Wait()
+
+	if drained := testutil.ToFloat64(drainedAlerts.WithLabelValues("#foo")); drained != drainedBefore+3 {
+		t.Errorf("Expected 3 drained alerts, got %v", drained-drainedBefore)
+	}
+	if len(notifier.AlertMsgs) != 0 {
+		t.Errorf("Expected the queue to be drained, %d alerts left",
+			len(notifier.AlertMsgs))
+	}
+}
+
+func TestStrictDrainReconnects(t *testing.T) {
+	server, port := makeTestServer(t)
+
+	var testStep sync.WaitGroup
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	// Disconnected, e.g. when stopped while reconnecting.
+	notifier, _ := makeTestNotifier(t, makeTestIRCConfig(port))
+	notifier.AlertMsgs = make(chan AlertMsg, 2)
+	notifier.AlertMsgs <- AlertMsg{Channel: "#foo", Alert: "first"}
+	notifier.AlertMsgs <- AlertMsg{Channel: "#foo", Alert: "second"}
+	notifier.DrainMode = drainStrict
+	notifier.ShutdownTimeout = 5 * time.Second
+	defer stopDrainTestNotifier(notifier, server)
+
+	testStep.Add(2)
+	notifier.drainAlertMsgs()
+	testStep.Wait()
+
+	if len(notifier.AlertMsgs) != 0 {
+		t.Errorf("Expected the queue to be drained, %d alerts left",
+			len(notifier.AlertMsgs))
+	}
+}
+
+func TestBestEffortDrainAbandonsAfterTimeout(t *testing.T) {
+	server, port := makeTestServer(t)
+
+	notifier := makeDrainTestNotifier(t, port, 3)
+	defer stopDrainTestNotifier(notifier, server)
+	notifier.DrainMode = drainBestEffort
+	notifier.ShutdownTimeout = 0
+
+	drainedBefore := testutil.ToFloat64(drainedAlerts.WithLabelValues("#foo"))
+	abandonedBefore := testutil.ToFloat64(abandonedAlerts.WithLabelValues("#foo"))
+	notifier.drainAlertMsgs()
+
+	if drained := testutil.ToFloat64(drainedAlerts.WithLabelValues("#foo")); drained != drainedBefore {
+		t.Errorf("Expected no drained alerts, got %v", drained-drainedBefore)
+	}
+	if abandoned := testutil.ToFloat64(abandonedAlerts.WithLabelValues("#foo")); abandoned != abandonedBefore+3 {
+		t.Errorf("Expected 3 abandoned alerts, got %v", abandoned-abandonedBefore)
+	}
+}
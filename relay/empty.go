@@ -0,0 +1,67 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	emptyMessageSkip        = "skip"
+	emptyMessagePlaceholder = "placeholder"
+	emptyMessageRaw         = "raw"
+)
+
+var (
+	emptyMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "empty_messages",
+			Help: "Number of alert messages rendered to nothing but whitespace"},
+		[]string{"ircchannel"},
+	)
+)
+
+// unlessEmpty returns lines, the rendered lines of alertMsg, unless there is
+// nothing but whitespace in them, as IRC servers may refuse blank lines.
+// It then returns nothing, EmptyMessagePlaceholder or the raw alert as
+// OnEmptyMessage says, unless a template failed and OnTemplateError applied
+// instead.
+func (f *Formatter) unlessEmpty(alertMsg *AlertMsg, lines []string,
+	templatesOK bool) []string {
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			return lines
+		}
+	}
+	if !templatesOK {
+		return nil
+	}
+	emptyMessages.WithLabelValues(alertMsg.Channel).Inc()
+	switch f.OnEmptyMessage {
+	case emptyMessagePlaceholder:
+		return f.splitLines([]string{f.EmptyMessagePlaceholder})
+	case emptyMessageRaw:
+		if data := msgData(alertMsg); data != nil {
+			log.Printf("Alert to %s rendered to nothing, sending raw alert",
+				alertMsg.Channel)
+			return f.splitLines([]string{f.rawAlert(data)})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"reflect"
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestOnEmptyMessage(t *testing.T) {
+	alerts := []promtmpl.Alert{
+		{Status: "firing", Labels: promtmpl.KV{"alertname": "airDown"}},
+		{Status: "resolved", Labels: promtmpl.KV{"alertname": "airDown"}},
+	}
+	for _, test := range []struct {
+		policy   string
+		expected [][]string
+	}{
+		{"", [][]string{{"airDown is firing"}, nil}},
+		{emptyMessageSkip, [][]string{{"airDown is firing"}, nil}},
+		{emptyMessagePlaceholder, [][]string{{"airDown is firing"}, {"(empty alert)"}}},
+		{emptyMessageRaw, [][]string{{"airDown is firing"},
+			{"[resolved] alertname=airDown"}}},
+	} {
+		// Renders blanks for resolved alerts.
+		formatter := makeTestFormatter(t, &Config{
+			MsgTemplate:             `{{ if eq .Status "firing" }}{{ .Labels.alertname }} is firing{{ else }}  {{ end }}`,
+			OnEmptyMessage:          test.policy,
+			EmptyMessagePlaceholder: "(empty alert)",
+			RawFallbackFormat:       rawFallbackCompact,
+		})
+		for i := range alerts {
+			lines := formatter.RenderMsgLines(
+				&AlertMsg{Channel: "#foo", AlertData: &alerts[i]})
+			if len(lines) == 0 {
+				lines = nil
+			}
+			if !reflect.DeepEqual(test.expected[i], lines) {
+				t.Errorf("Unexpected lines with the %q policy for the %s alert: %q",
+					test.policy, alerts[i].Status, lines)
+			}
+		}
+	}
+}
+
+func TestOnEmptyMessageKeepsDroppedTemplateErrors(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate:             "{{ .Labels.alertname.missing }}",
+		OnTemplateError:         templateErrorDrop,
+		OnEmptyMessage:          emptyMessagePlaceholder,
+		EmptyMessagePlaceholder: "(empty alert)",
+	})
+	alert := promtmpl.Alert{Labels: promtmpl.KV{"alertname": "airDown"}}
+	if lines := formatter.RenderMsgLines(
+		&AlertMsg{Channel: "#foo", AlertData: &alert}); len(lines) != 0 {
+		t.Errorf("Expected the alert dropped, got %q", lines)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	defaultExecTemplateTimeout = 2 * time.Second
+	maxExecOutputBytes         = 1024
+)
+
+// cappedBuffer keeps the first limit bytes written to it and silently drops
+// the rest.
+type cappedBuffer struct {
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		b.buf.Write(p[:room])
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// newExecTemplateFunc returns the "exec" template function, running one of
+// the allowed commands with the given arguments and returning its output.
+// Commands are run directly, without a shell.
+func newExecTemplateFunc(allowed []string, timeout time.Duration) func(string, ...string) (string, error) {
+	allowedCommands := make(map[string]bool)
+	for _, command := range allowed {
+		allowedCommands[command] = true
+	}
+	return func(command string, args ...string) (string, error) {
+		if !allowedCommands[command] {
+			return "", fmt.Errorf("command %q is not allowed", command)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		output := &cappedBuffer{limit: maxExecOutputBytes}
+		cmd := exec.CommandContext(ctx, command, args...)
+		cmd.Stdout = output
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("command %q timed out after %s",
+					command, timeout)
+			}
+			return "", fmt.Errorf("command %q failed: %s", command, err)
+		}
+		if output.truncated {
+			log.Printf("Output of command %q truncated to %d bytes",
+				command, maxExecOutputBytes)
+		}
+		return strings.TrimRight(output.buf.String(), "\n"), nil
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestExecTemplateFunc(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate:           `{{ .Labels.instance }} is owned by {{ exec "echo" "team-" .Labels.instance }}`,
+		AllowExecTemplateFunc: true,
+		ExecTemplateCommands:  []string{"echo"},
+		ExecTemplateTimeout:   time.Second,
+	})
+	alert := promtmpl.Alert{Labels: promtmpl.KV{"instance": "db1"}}
+
+	expected := "db1 is owned by team- db1"
+	msg := formatter.RenderMsg(&AlertMsg{Channel: "#foo", AlertData: &alert})
+	if msg != expected {
+		t.Errorf("Expected %q, got %q", expected, msg)
+	}
+}
+
+func TestExecTemplateFuncDisabled(t *testing.T) {
+	_, err := NewFormatter(&Config{MsgTemplate: `{{ exec "echo" "hi" }}`})
+	if err == nil {
+		t.Errorf("Expected templates using exec to fail unless allowed")
+	}
+}
+
+func TestExecTemplateFuncRestrictions(t *testing.T) {
+	exec := newExecTemplateFunc([]string{"echo", "sleep"}, 50*time.Millisecond)
+
+	if _, err := exec("cat", "/etc/passwd"); err == nil {
+		t.Errorf("Expected commands not allowed to fail")
+	}
+	if _, err := exec("sleep", "5"); err == nil ||
+		!strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected slow commands to time out, got: %v", err)
+	}
+	output, err := exec("echo", strings.Repeat("x", 2*maxExecOutputBytes))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(output) != maxExecOutputBytes {
+		t.Errorf("Expected output capped to %d bytes, got %d",
+			maxExecOutputBytes, len(output))
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// keyFilter keeps the allowed keys of label or annotation sets, all of them
+// when no key is allowed explicitly, then strips the denied ones.
+type keyFilter struct {
+	allowed map[string]bool
+	denied  map[string]bool
+}
+
+func newKeyFilter(allowed []string, denied []string) *keyFilter {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil
+	}
+	f := &keyFilter{denied: make(map[string]bool)}
+	if len(allowed) > 0 {
+		f.allowed = make(map[string]bool)
+		for _, key := range allowed {
+			f.allowed[key] = true
+		}
+	}
+	for _, key := range denied {
+		f.denied[key] = true
+	}
+	return f
+}
+
+// apply returns a copy of kv holding only the keys passing the filter, kv
+// itself if the filter is nil.
+func (f *keyFilter) apply(kv promtmpl.KV) promtmpl.KV {
+	if f == nil || kv == nil {
+		return kv
+	}
+	filtered := promtmpl.KV{}
+	for key, value := range kv {
+		if (f.allowed == nil || f.allowed[key]) && !f.denied[key] {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+// dataFilter strips labels and annotations from webhook data before it
+// reaches templates, so that none can leak their values to IRC.
+type dataFilter struct {
+	labels      *keyFilter
+	annotations *keyFilter
+}
+
+func newDataFilter(config *Config) *dataFilter {
+	labels := newKeyFilter(config.AllowedLabels, config.DeniedLabels)
+	annotations := newKeyFilter(config.AllowedAnnotations, config.DeniedAnnotations)
+	if labels == nil && annotations == nil {
+		return nil
+	}
+	return &dataFilter{labels: labels, annotations: annotations}
+}
+
+// apply returns a copy of data with the labels and annotations of the group
+// and of its alerts filtered. The group key is redacted when labels are
+// filtered, as it holds the group label values.
+func (f *dataFilter) apply(data *WebhookData) *WebhookData {
+	filtered := *data
+	if f.labels != nil && data.GroupKey != "" {
+		filtered.unfilteredGroupKey = data.GroupKey
+		filtered.GroupKey = ""
+	}
+	filtered.GroupLabels = f.labels.apply(data.GroupLabels)
+	filtered.CommonLabels = f.labels.apply(data.CommonLabels)
+	filtered.CommonAnnotations = f.annotations.apply(data.CommonAnnotations)
+	filtered.Alerts = make(promtmpl.Alerts, len(data.Alerts))
+	for i, alert := range data.Alerts {
+		alert.Labels = f.labels.apply(alert.Labels)
+		alert.Annotations = f.annotations.apply(alert.Annotations)
+		filtered.Alerts[i] = alert
+	}
+	return &filtered
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"reflect"
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestKeyFilter(t *testing.T) {
+	kv := promtmpl.KV{"alertname": "airDown", "instance": "host:9100", "owner": "jdoe"}
+	for _, test := range []struct {
+		allowed  []string
+		denied   []string
+		expected promtmpl.KV
+	}{
+		{nil, nil, kv},
+		{[]string{"alertname", "owner"}, nil,
+			promtmpl.KV{"alertname": "airDown", "owner": "jdoe"}},
+		{nil, []string{"owner"},
+			promtmpl.KV{"alertname": "airDown", "instance": "host:9100"}},
+		{[]string{"alertname", "owner"}, []string{"owner"},
+			promtmpl.KV{"alertname": "airDown"}},
+	} {
+		filtered := newKeyFilter(test.allowed, test.denied).apply(kv)
+		if !reflect.DeepEqual(test.expected, filtered) {
+			t.Errorf("allowed %v, denied %v: expected %v, got %v",
+				test.allowed, test.denied, test.expected, filtered)
+		}
+	}
+}
+
+func TestDataFilterCopiesData(t *testing.T) {
+	data := &WebhookData{Data: promtmpl.Data{
+		CommonAnnotations: promtmpl.KV{"summary": "down", "contact": "jdoe"},
+		Alerts: promtmpl.Alerts{
+			{Annotations: promtmpl.KV{"summary": "down", "contact": "jdoe"}},
+		},
+	}}
+	filter := newDataFilter(&Config{DeniedAnnotations: []string{"contact"}})
+	filtered := filter.apply(data)
+
+	expected := promtmpl.KV{"summary": "down"}
+	if !reflect.DeepEqual(expected, filtered.CommonAnnotations) ||
+		!reflect.DeepEqual(expected, filtered.Alerts[0].Annotations) {
+		t.Errorf("Expected the contact annotation stripped, got %+v", filtered)
+	}
+	if _, ok := data.Alerts[0].Annotations["contact"]; !ok {
+		t.Error("Expected the original data to be left alone")
+	}
+	if filtered.GroupKey != data.GroupKey {
+		t.Error("Expected the group key kept when labels are not filtered")
+	}
+	if newDataFilter(&Config{}) != nil {
+		t.Error("Expected no filter when nothing is allowed or denied")
+	}
+}
+
+func TestDataFilterRedactsGroupKey(t *testing.T) {
+	data := &WebhookData{GroupKey: `{}:{alertname="airDown", team="secret"}`}
+	filter := newDataFilter(&Config{DeniedLabels: []string{"team"}})
+	filtered := filter.apply(data)
+
+	if filtered.GroupKey != "" {
+		t.Errorf("Expected the group key redacted, got %s", filtered.GroupKey)
+	}
+	if filtered.groupKey() != data.GroupKey {
+		t.Errorf("Expected the group key kept for deduplication, got %s",
+			filtered.groupKey())
+	}
+}
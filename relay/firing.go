@@ -0,0 +1,77 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"time"
+)
+
+const (
+	defaultFiringTTL      = 24 * time.Hour
+	maxFiringFingerprints = 10000
+)
+
+// FiringTracker remembers the fingerprints of alerts seen firing, for up to
+// a TTL and a maximum number of fingerprints, the oldest being forgotten
+// first.
+type FiringTracker struct {
+	ttl    time.Duration
+	max    int
+	firing map[string]time.Time
+}
+
+func NewFiringTracker(ttl time.Duration, max int) *FiringTracker {
+	return &FiringTracker{
+		ttl:    ttl,
+		max:    max,
+		firing: make(map[string]time.Time),
+	}
+}
+
+func (f *FiringTracker) expire(now time.Time) {
+	for fingerprint, t := range f.firing {
+		if now.Sub(t) >= f.ttl {
+			delete(f.firing, fingerprint)
+		}
+	}
+}
+
+// Fired records fingerprint as firing.
+func (f *FiringTracker) Fired(fingerprint string, now time.Time) {
+	f.expire(now)
+	if _, ok := f.firing[fingerprint]; !ok && len(f.firing) >= f.max {
+		oldest := ""
+		for k, t := range f.firing {
+			if oldest == "" || t.Before(f.firing[oldest]) {
+				oldest = k
+			}
+		}
+		delete(f.firing, oldest)
+	}
+	f.firing[fingerprint] = now
+}
+
+// Resolved returns whether fingerprint was seen firing, and forgets it.
+func (f *FiringTracker) Resolved(fingerprint string, now time.Time) bool {
+	f.expire(now)
+	_, ok := f.firing[fingerprint]
+	delete(f.firing, fingerprint)
+	return ok
+}
+
+// Size returns the number of fingerprints currently remembered.
+func (f *FiringTracker) Size() int {
+	return len(f.firing)
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFiringTracker(t *testing.T) {
+	now := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	tracker := NewFiringTracker(time.Hour, 10)
+
+	if tracker.Resolved("a", now) {
+		t.Error("Expected a never fired alert to be unknown")
+	}
+	tracker.Fired("a", now)
+	if !tracker.Resolved("a", now.Add(time.Minute)) {
+		t.Error("Expected a fired alert to be known")
+	}
+	if tracker.Resolved("a", now.Add(time.Minute)) {
+		t.Error("Expected a resolved alert to be forgotten")
+	}
+
+	tracker.Fired("b", now)
+	if tracker.Resolved("b", now.Add(time.Hour)) {
+		t.Error("Expected an alert fired longer than the TTL ago to be forgotten")
+	}
+}
+
+func TestFiringTrackerBounded(t *testing.T) {
+	now := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	tracker := NewFiringTracker(time.Hour, 2)
+
+	tracker.Fired("a", now)
+	tracker.Fired("b", now.Add(time.Second))
+	tracker.Fired("c", now.Add(2*time.Second))
+
+	if tracker.Size() != 2 {
+		t.Errorf("Expected 2 fingerprints, got %d", tracker.Size())
+	}
+	if tracker.Resolved("a", now) {
+		t.Error("Expected the oldest fingerprint to be evicted")
+	}
+	if !tracker.Resolved("c", now) {
+		t.Error("Expected the newest fingerprint to be kept")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"text/template"
+	"time"
+)
+
+// Bounds the webhooks tracked until their footer is due.
+const maxSentBatches = 1000
+
+// FooterData is passed to channel footer templates, rendered once after the
+// messages of each webhook.
+type FooterData struct {
+	WebhookData
+
+	Channel string
+	// Count is the number of alerts in the webhook, Firing and Resolved
+	// break it down by status.
+	Count    int
+	Firing   int
+	Resolved int
+	// Time is when the footer is sent.
+	Time time.Time
+}
+
+func newFooterData(channel string, data *WebhookData, now time.Time) *FooterData {
+	footer := &FooterData{
+		WebhookData: *data,
+		Channel:     channel,
+		Count:       len(data.Alerts),
+		Time:        now,
+	}
+	for _, alert := range data.Alerts {
+		switch alert.Status {
+		case "firing":
+			footer.Firing++
+		case "resolved":
+			footer.Resolved++
+		}
+	}
+	return footer
+}
+
+// parseFooterTemplate returns nil when text is empty, as channels have no
+// footer by default.
+func parseFooterTemplate(text string,
+	parser templateParser) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return parser.parse("footer", text)
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+const (
+	formLabelPrefix      = "labels."
+	formAnnotationPrefix = "annotations."
+)
+
+// validateFormFieldMapping checks that every key of mapping names a field of
+// the alert that form values can be mapped onto.
+func validateFormFieldMapping(mapping map[string]string) error {
+	for target := range mapping {
+		switch {
+		case target == "status", target == "fingerprint",
+			target == "generatorURL", target == "startsAt",
+			target == "endsAt":
+		case strings.HasPrefix(target, formLabelPrefix) &&
+			len(target) > len(formLabelPrefix):
+		case strings.HasPrefix(target, formAnnotationPrefix) &&
+			len(target) > len(formAnnotationPrefix):
+		default:
+			return fmt.Errorf("invalid form field mapping target: %s", target)
+		}
+	}
+	return nil
+}
+
+// decodeFormAlert builds webhook data holding a single alert out of the
+// form values, as described by mapping (alert field -> form field).
+func decodeFormAlert(values url.Values, mapping map[string]string) (
+	*WebhookData, error) {
+	alert := promtmpl.Alert{
+		Status:      "firing",
+		Labels:      promtmpl.KV{},
+		Annotations: promtmpl.KV{},
+	}
+	for target, field := range mapping {
+		value := values.Get(field)
+		if value == "" {
+			continue
+		}
+		switch {
+		case target == "status":
+			alert.Status = value
+		case target == "fingerprint":
+			alert.Fingerprint = value
+		case target == "generatorURL":
+			alert.GeneratorURL = value
+		case target == "startsAt", target == "endsAt":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s time: %s", field, err)
+			}
+			if target == "startsAt" {
+				alert.StartsAt = t
+			} else {
+				alert.EndsAt = t
+			}
+		case strings.HasPrefix(target, formLabelPrefix):
+			alert.Labels[strings.TrimPrefix(target, formLabelPrefix)] = value
+		case strings.HasPrefix(target, formAnnotationPrefix):
+			alert.Annotations[strings.TrimPrefix(target, formAnnotationPrefix)] = value
+		}
+	}
+	if len(alert.Labels) == 0 {
+		return nil, fmt.Errorf("no label found in form")
+	}
+	return &WebhookData{Data: promtmpl.Data{
+		Status:            alert.Status,
+		Alerts:            promtmpl.Alerts{alert},
+		GroupLabels:       promtmpl.KV{},
+		CommonLabels:      alert.Labels,
+		CommonAnnotations: alert.Annotations,
+	}}, nil
+}
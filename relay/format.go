@@ -0,0 +1,679 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	ircColor = "\x03"
+)
+
+var (
+	templateRenderDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "template_render_duration_seconds",
+			Help:    "Time taken to render message templates, by template",
+			Buckets: prometheus.DefBuckets},
+		[]string{"template"},
+	)
+)
+
+// hashColors are the mIRC colors picked by hashColor, leaving out white,
+// black and the greys that are unreadable on some client themes.
+var hashColors = []int{2, 3, 4, 5, 6, 7, 9, 10, 11, 12, 13}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// hashColor wraps s in a mIRC color derived from its content, so that the
+// same string always gets the same color.
+func hashColor(s string) string {
+	color := hashColors[hashString(s)%uint32(len(hashColors))]
+	return fmt.Sprintf("%s%02d%s%s", ircColor, color, s, ircColor)
+}
+
+// shortHash returns a short hexadecimal hash of s.
+func shortHash(s string) string {
+	return fmt.Sprintf("%06x", hashString(s)&0xffffff)
+}
+
+// mapEntry is a key/value pair of a map, as returned by sortedMap.
+type mapEntry struct {
+	Key   string
+	Value string
+}
+
+// sortedMap returns the entries of m sorted by key, for ranging over labels
+// or annotations in a stable order.
+func sortedMap(m map[string]string) []mapEntry {
+	entries := make([]mapEntry, 0, len(m))
+	for key, value := range m {
+		entries = append(entries, mapEntry{Key: key, Value: value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// joinMap joins the entries of m sorted by key, with kvSep between each key
+// and value and sep between entries.
+func joinMap(m map[string]string, kvSep string, sep string) string {
+	entries := sortedMap(m)
+	parts := make([]string, len(entries))
+	for i, entry := range entries {
+		parts[i] = entry.Key + kvSep + entry.Value
+	}
+	return strings.Join(parts, sep)
+}
+
+const runbookAnnotation = "runbook_url"
+
+// runbook returns a "[runbook] <url>" reference to the runbook_url annotation
+// of data (the common annotations when sending one message per group), or
+// nothing when it is not set.
+func runbook(data interface{}) (string, error) {
+	var annotations map[string]string
+	switch d := data.(type) {
+	case AlertTemplateData:
+		annotations = d.Annotations
+	case CollapsedAlertData:
+		annotations = d.Annotations
+	case *WebhookData:
+		annotations = d.CommonAnnotations
+	case CollapsedGroupData:
+		annotations = d.CommonAnnotations
+	default:
+		return "", fmt.Errorf("runbook: unsupported data %T", data)
+	}
+	url := annotations[runbookAnnotation]
+	if url == "" {
+		return "", nil
+	}
+	return "[runbook] " + url, nil
+}
+
+// amHeader returns the "[FIRING:2] <group label values> (<other common
+// label values>)" title Alertmanager receivers give the group of data, when
+// sending one message per group, or to footers.
+func amHeader(data interface{}) (string, error) {
+	var group *WebhookData
+	switch d := data.(type) {
+	case *WebhookData:
+		group = d
+	case CollapsedGroupData:
+		group = &d.WebhookData
+	case *FooterData:
+		group = &d.WebhookData
+	default:
+		return "", fmt.Errorf("amHeader: unsupported data %T", data)
+	}
+	header := "[" + strings.ToUpper(group.Status)
+	if group.Status == "firing" {
+		header += fmt.Sprintf(":%d", len(group.Alerts.Firing()))
+	}
+	header += "] " + strings.Join(group.GroupLabels.Values(), " ")
+	if len(group.CommonLabels) > len(group.GroupLabels) {
+		others := group.CommonLabels.Remove(group.GroupLabels.Names())
+		header += " (" + strings.Join(others.Values(), " ") + ")"
+	}
+	return header, nil
+}
+
+const resolvedAnnotationPrefix = "resolved_"
+
+// statusAnnotation returns the name annotation of data (the common
+// annotations when sending one message per group), or resolved_<name>
+// instead if data is resolved and has it.
+func statusAnnotation(name string, data interface{}) (string, error) {
+	var status string
+	var annotations map[string]string
+	switch d := data.(type) {
+	case AlertTemplateData:
+		status, annotations = d.Status, d.Annotations
+	case CollapsedAlertData:
+		status, annotations = d.Status, d.Annotations
+	case *WebhookData:
+		status, annotations = d.Status, d.CommonAnnotations
+	case CollapsedGroupData:
+		status, annotations = d.Status, d.CommonAnnotations
+	default:
+		return "", fmt.Errorf("statusAnnotation: unsupported data %T", data)
+	}
+	if status == "resolved" {
+		if value, ok := annotations[resolvedAnnotationPrefix+name]; ok {
+			return value, nil
+		}
+	}
+	return annotations[name], nil
+}
+
+// newSeverityRankFunc returns the severityRank template function: the
+// position, from 1, of the severity label of data (the common labels when
+// sending one message per group) or of a severity in order, from the least
+// severe, or unknown if it is not listed.
+func newSeverityRankFunc(order []string, unknown int) func(interface{}) (int, error) {
+	ranks := make(map[string]int)
+	for i, severity := range order {
+		ranks[severity] = i + 1
+	}
+	return func(data interface{}) (int, error) {
+		var severity string
+		switch d := data.(type) {
+		case string:
+			severity = d
+		case promtmpl.Alert:
+			severity = d.Labels["severity"]
+		case AlertTemplateData:
+			severity = d.Labels["severity"]
+		case CollapsedAlertData:
+			severity = d.Labels["severity"]
+		case *WebhookData:
+			severity = d.CommonLabels["severity"]
+		case CollapsedGroupData:
+			severity = d.CommonLabels["severity"]
+		default:
+			return 0, fmt.Errorf("severityRank: unsupported data %T", data)
+		}
+		if rank, ok := ranks[severity]; ok {
+			return rank, nil
+		}
+		return unknown, nil
+	}
+}
+
+var templateFuncs = template.FuncMap{
+	"hashColor": hashColor,
+	"shorthash": shortHash,
+	"sortedMap": sortedMap,
+	"joinMap":   joinMap,
+
+	"silenceURL": silenceURL,
+	"runbook":    runbook,
+
+	"statusAnnotation": statusAnnotation,
+	"amHeader":         amHeader,
+	"stripFormatting":  stripFormatting,
+	"maxlen":           maxLen,
+}
+
+// Formatter renders alert messages with the configured templates.
+type Formatter struct {
+	MsgTemplate *template.Template
+	// Used instead of MsgTemplate for the webhooks of these receivers.
+	receiverTemplates map[string]*template.Template
+
+	// Only set when collapsing labels.
+	CollapseHeaderTemplate *template.Template
+	CollapseLineTemplate   *template.Template
+
+	// Rendered messages are split into lines on LineDelimiter, if set.
+	LineDelimiter  string
+	KeepEmptyLines bool
+	// Lines are trimmed and their runs of spaces and tabs collapsed.
+	CollapseWhitespace bool
+	// Mentions in lines are neutralized, for Discord bridges.
+	EscapeMentions bool
+	// Prefixes stripped from the label values shown, by label name.
+	labelPrefixes labelPrefixTrimmer
+	// Labels hidden from messages.
+	omittedLabels *labelOmitter
+	// Strip IRC formatting codes from label and annotation values.
+	StripValueFormatting bool
+	// Annotation values are cut to this many characters, if set.
+	MaxAnnotationLength int
+
+	OnTemplateError      string
+	TemplateErrorMessage string
+	RawFallbackFormat    string
+	// Renders taking longer are logged, if set.
+	SlowTemplateThreshold time.Duration
+	// What to send instead of messages rendered to nothing but whitespace.
+	OnEmptyMessage          string
+	EmptyMessagePlaceholder string
+
+	// Kept to parse MsgTemplate again when its files change.
+	parser           templateParser
+	msgTemplateText  string
+	receiverTexts    map[string]string
+	msgTemplateFiles []string
+	msgTemplateTimes map[string]time.Time
+}
+
+// formatterFuncs returns the template functions enabled by config.
+func formatterFuncs(config *Config) (template.FuncMap, error) {
+	funcs := template.FuncMap{}
+	for name, f := range templateFuncs {
+		funcs[name] = f
+	}
+	colors, err := themeColors(config.ThemeName)
+	if err != nil {
+		return nil, err
+	}
+	funcs["themed"] = newThemedFunc(colors)
+	link, err := newLinkFunc(config.LinkStyle)
+	if err != nil {
+		return nil, err
+	}
+	funcs["link"] = link
+	order := config.SeverityOrder
+	if len(order) == 0 {
+		order = severityOrder
+	}
+	funcs["severityRank"] = newSeverityRankFunc(order, config.UnknownSeverityRank)
+	funcs["renderLabels"] = newRenderLabelsFunc(config.MaxRenderedLabels)
+	if config.AllowExecTemplateFunc {
+		funcs["exec"] = newExecTemplateFunc(
+			config.ExecTemplateCommands, config.ExecTemplateTimeout)
+	}
+	return funcs, nil
+}
+
+// configuredFuncs are the template functions set up by formatterFuncs from
+// the configuration.
+var configuredFuncs = []string{"themed", "link", "severityRank", "renderLabels", "exec"}
+
+func isConfiguredFunc(name string) bool {
+	for _, f := range configuredFuncs {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictFuncs removes the functions of funcs not listed in allowed,
+// returning their names, unless allowed is empty. Listed names must be
+// template functions of the relay, even if not enabled.
+func restrictFuncs(funcs template.FuncMap, allowed []string) ([]string, error) {
+	if len(allowed) == 0 {
+		return nil, nil
+	}
+	isAllowed := make(map[string]bool)
+	for _, name := range allowed {
+		if _, ok := templateFuncs[name]; !ok && !isConfiguredFunc(name) {
+			return nil, fmt.Errorf("allowed_template_funcs: unknown template function %s", name)
+		}
+		isAllowed[name] = true
+	}
+	removed := []string{}
+	for name := range funcs {
+		if !isAllowed[name] {
+			delete(funcs, name)
+			removed = append(removed, name)
+		}
+	}
+	return removed, nil
+}
+
+// templateParser parses templates with the configured delimiters and
+// template functions, so that every template, not only the message ones, can
+// use all of them.
+type templateParser struct {
+	delims TemplateDelimiters
+	funcs  template.FuncMap
+	// Functions left out by AllowedTemplateFuncs, named in parse errors.
+	disallowed []string
+}
+
+func newTemplateParser(config *Config) (templateParser, error) {
+	funcs, err := formatterFuncs(config)
+	if err != nil {
+		return templateParser{}, err
+	}
+	disallowed, err := restrictFuncs(funcs, config.AllowedTemplateFuncs)
+	if err != nil {
+		return templateParser{}, err
+	}
+	return templateParser{delims: config.TemplateDelimiters, funcs: funcs,
+		disallowed: disallowed}, nil
+}
+
+func (p templateParser) parse(name string, text string) (*template.Template, error) {
+	tmpl, err := p.delims.newTemplate(name).Funcs(p.funcs).Parse(text)
+	if err != nil {
+		for _, f := range p.disallowed {
+			if strings.Contains(err.Error(), `function "`+f+`" not defined`) {
+				return nil, fmt.Errorf(
+					"template %s: function %s is not in allowed_template_funcs", name, f)
+			}
+		}
+	}
+	return tmpl, err
+}
+
+// NewFormatter parses the templates of config.
+func NewFormatter(config *Config) (*Formatter, error) {
+	parser, err := newTemplateParser(config)
+	if err != nil {
+		return nil, err
+	}
+	times, err := templateFileTimes(config.MsgTemplateFiles)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := parseMsgTemplate(parser, config.MsgTemplate,
+		config.MsgTemplateFiles)
+	if err != nil {
+		return nil, err
+	}
+	receiverTemplates, err := parseReceiverTemplates(parser,
+		config.MsgTemplatesByReceiver, config.MsgTemplateFiles)
+	if err != nil {
+		return nil, err
+	}
+	omitter, err := newLabelOmitter(config.OmitLabelsRegex)
+	if err != nil {
+		return nil, err
+	}
+	formatter := &Formatter{
+		MsgTemplate:    tmpl,
+		LineDelimiter:  config.MsgLineDelimiter,
+		KeepEmptyLines: config.KeepEmptyLines,
+
+		receiverTemplates: receiverTemplates,
+
+		CollapseWhitespace: config.CollapseWhitespace,
+		EscapeMentions:     config.EscapeMentions,
+		labelPrefixes:      config.LabelValueTrimPrefixes,
+		omittedLabels:      omitter,
+
+		StripValueFormatting: config.StripValueFormatting,
+		MaxAnnotationLength:  config.MaxAnnotationLength,
+
+		OnTemplateError:      config.OnTemplateError,
+		TemplateErrorMessage: config.TemplateErrorMessage,
+		RawFallbackFormat:    config.RawFallbackFormat,
+
+		OnEmptyMessage:          config.OnEmptyMessage,
+		EmptyMessagePlaceholder: config.EmptyMessagePlaceholder,
+
+		SlowTemplateThreshold: config.SlowTemplateThreshold,
+
+		parser:           parser,
+		msgTemplateText:  config.MsgTemplate,
+		receiverTexts:    config.MsgTemplatesByReceiver,
+		msgTemplateFiles: config.MsgTemplateFiles,
+		msgTemplateTimes: times,
+	}
+	if config.CollapseLabels {
+		formatter.CollapseHeaderTemplate, err = parser.parse("header",
+			config.CollapseHeaderTemplate)
+		if err != nil {
+			return nil, err
+		}
+		formatter.CollapseLineTemplate, err = parser.parse("line",
+			config.CollapseLineTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return formatter, nil
+}
+
+// FormatMsg applies the message template on data, falling back to its JSON
+// encoding if the template fails.
+func (f *Formatter) FormatMsg(data interface{}) string {
+	return f.execute(f.MsgTemplate, data)
+}
+
+// execute applies tmpl on data. On errors, depending on OnTemplateError,
+// this returns the JSON encoding of data, nothing, or TemplateErrorMessage.
+func (f *Formatter) execute(tmpl *template.Template, data interface{}) string {
+	msg, _ := f.executeOK(tmpl, data)
+	return msg
+}
+
+// executeOK is execute also returning whether tmpl succeeded.
+func (f *Formatter) executeOK(tmpl *template.Template, data interface{}) (string, bool) {
+	output := bytes.Buffer{}
+	var msg string
+	start := time.Now()
+	err := tmpl.Execute(&output, data)
+	took := time.Since(start)
+	templateRenderDuration.WithLabelValues(tmpl.Name()).Observe(took.Seconds())
+	if f.SlowTemplateThreshold > 0 && took > f.SlowTemplateThreshold {
+		log.Printf("Rendering template %s took %s, over %s",
+			tmpl.Name(), took, f.SlowTemplateThreshold)
+	}
+	if err != nil {
+		msg_bytes, _ := json.Marshal(data)
+		msg = string(msg_bytes)
+		log.Printf("Could not apply msg template on alert (%s): %s",
+			err, msg)
+		switch f.OnTemplateError {
+		case templateErrorDrop:
+			log.Printf("Dropping alert")
+			msg = ""
+		case templateErrorStatic:
+			log.Printf("Sending static message instead")
+			msg = f.TemplateErrorMessage
+		default:
+			log.Printf("Sending raw alert")
+			msg = f.rawAlert(data)
+		}
+		return msg, false
+	}
+	return output.String(), true
+}
+
+// rawAlert returns data as the raw alert sent instead of messages, formatted
+// as RawFallbackFormat.
+func (f *Formatter) rawAlert(data interface{}) string {
+	if f.RawFallbackFormat == rawFallbackCompact {
+		return compactRawAlert(data)
+	}
+	msg, _ := json.Marshal(data)
+	return string(msg)
+}
+
+// compactRawAlert returns the status and labels of data, e.g.
+// "[firing] alertname=airDown instance=instance1:3456", fitting IRC lines
+// better than JSON. Groups are reduced to their common labels, data without
+// labels is still sent as JSON.
+func compactRawAlert(data interface{}) string {
+	var status string
+	var labels map[string]string
+	switch d := data.(type) {
+	case AlertTemplateData:
+		status, labels = d.Status, d.Labels
+	case CollapsedAlertData:
+		status, labels = d.Status, d.Labels
+	case *WebhookData:
+		status, labels = d.Status, d.CommonLabels
+	case CollapsedGroupData:
+		status, labels = d.Status, d.CommonLabels
+	default:
+		msg, _ := json.Marshal(data)
+		return string(msg)
+	}
+	parts := []string{"[" + status + "]"}
+	for _, entry := range sortedMap(labels) {
+		parts = append(parts, entry.Key+"="+entry.Value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// RenderMsg returns the text to send for alertMsg, applying the template on
+// its structured data if any, or its pre-rendered text otherwise.
+func (f *Formatter) RenderMsg(alertMsg *AlertMsg) string {
+	msg, _ := f.renderMsg(f.labelsShown(alertMsg))
+	return msg
+}
+
+// labelsShown returns alertMsg with its labels and annotations as shown in
+// messages.
+func (f *Formatter) labelsShown(alertMsg *AlertMsg) *AlertMsg {
+	alertMsg = f.omittedLabels.apply(f.labelPrefixes.apply(alertMsg))
+	if f.StripValueFormatting {
+		alertMsg = stripValueFormatting(alertMsg)
+	}
+	if f.MaxAnnotationLength > 0 {
+		alertMsg = truncateAnnotations(alertMsg, f.MaxAnnotationLength)
+	}
+	return alertMsg
+}
+
+// renderMsg returns the text to send for alertMsg, and whether its template
+// succeeded.
+func (f *Formatter) renderMsg(alertMsg *AlertMsg) (string, bool) {
+	data := msgData(alertMsg)
+	if data == nil {
+		return alertMsg.Alert, true
+	}
+	return f.executeOK(f.msgTemplateFor(alertMsg), data)
+}
+
+// msgData returns what the message template is applied on for alertMsg, nil
+// without structured data.
+func msgData(alertMsg *AlertMsg) interface{} {
+	switch {
+	case alertMsg.AlertData != nil:
+		data := AlertTemplateData{Alert: *alertMsg.AlertData}
+		if alertMsg.GroupData != nil {
+			data.GroupKey = alertMsg.GroupData.GroupKey
+			data.GroupStatus = alertMsg.GroupData.Status
+			data.ExternalURL = alertMsg.GroupData.ExternalURL
+		}
+		return data
+	case alertMsg.GroupData != nil:
+		return alertMsg.GroupData
+	default:
+		return nil
+	}
+}
+
+// RenderMsgLines returns the lines to send for alertMsg, split on the line
+// delimiter. Unless labels are collapsed, these come from RenderMsg.
+func (f *Formatter) RenderMsgLines(alertMsg *AlertMsg) []string {
+	alertMsg = f.labelsShown(alertMsg)
+	if f.CollapseHeaderTemplate == nil || alertMsg.AlertData != nil ||
+		alertMsg.GroupData == nil {
+		msg, ok := f.renderMsg(alertMsg)
+		return f.unlessEmpty(alertMsg, f.splitLines([]string{msg}), ok)
+	}
+
+	group := alertMsg.GroupData
+	shared := sharedLabels(group.Alerts)
+	header, ok := f.executeOK(f.CollapseHeaderTemplate,
+		CollapsedGroupData{WebhookData: *group, SharedLabels: shared})
+	lines := []string{header}
+	for _, alert := range group.Alerts {
+		unique := promtmpl.KV{}
+		for name, value := range alert.Labels {
+			if _, ok := shared[name]; !ok {
+				unique[name] = value
+			}
+		}
+		data := CollapsedAlertData{
+			AlertTemplateData: AlertTemplateData{
+				Alert:       alert,
+				GroupKey:    group.GroupKey,
+				GroupStatus: group.Status,
+				ExternalURL: group.ExternalURL,
+			},
+			UniqueLabels: unique,
+		}
+		line, lineOK := f.executeOK(f.CollapseLineTemplate, data)
+		lines = append(lines, line)
+		ok = ok && lineOK
+	}
+	return f.unlessEmpty(alertMsg, f.splitLines(lines), ok)
+}
+
+// collapseWhitespace trims spaces and tabs around line, and replaces their
+// runs within it with a single space.
+func collapseWhitespace(line string) string {
+	return strings.Join(strings.FieldsFunc(line, func(r rune) bool {
+		return r == ' ' || r == '\t'
+	}), " ")
+}
+
+// splitLines splits each rendered message on the line delimiter. Empty
+// lines are dropped, or kept as a single space since IRC does not allow
+// empty messages. Empty messages, e.g. dropped on template errors, are
+// always dropped.
+func (f *Formatter) splitLines(msgs []string) []string {
+	lines := []string{}
+	for _, msg := range msgs {
+		if msg == "" {
+			continue
+		}
+		if f.LineDelimiter == "" {
+			if f.CollapseWhitespace {
+				msg = collapseWhitespace(msg)
+			}
+			if f.EscapeMentions {
+				msg = escapeMentions(msg)
+			}
+			if msg != "" {
+				lines = append(lines, msg)
+			}
+			continue
+		}
+		for _, line := range strings.Split(msg, f.LineDelimiter) {
+			if f.CollapseWhitespace {
+				line = collapseWhitespace(line)
+			}
+			if f.EscapeMentions {
+				line = escapeMentions(line)
+			}
+			if line == "" {
+				if !f.KeepEmptyLines {
+					continue
+				}
+				line = " "
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// sharedLabels returns the labels with the same value in all alerts.
+func sharedLabels(alerts promtmpl.Alerts) promtmpl.KV {
+	shared := promtmpl.KV{}
+	if len(alerts) == 0 {
+		return shared
+	}
+	for name, value := range alerts[0].Labels {
+		shared[name] = value
+	}
+	for _, alert := range alerts[1:] {
+		for name, value := range shared {
+			if alert.Labels[name] != value {
+				delete(shared, name)
+			}
+		}
+	}
+	return shared
+}
@@ -0,0 +1,429 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func makeTestFormatter(t *testing.T, config *Config) *Formatter {
+	formatter, err := NewFormatter(config)
+	if err != nil {
+		t.Fatalf("Could not create formatter: %s", err)
+	}
+	return formatter
+}
+
+func TestRenderMsgUsesStructuredData(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "Alert {{ .Labels.alertname }} is {{ .Status }}",
+	})
+	alert := promtmpl.Alert{
+		Status: "firing",
+		Labels: promtmpl.KV{"alertname": "airDown"},
+	}
+	alertMsg := AlertMsg{
+		Channel:   "#foo",
+		GroupData: &WebhookData{Data: promtmpl.Data{Alerts: promtmpl.Alerts{alert}}},
+		AlertData: &alert,
+	}
+
+	expected := "Alert airDown is firing"
+	if msg := formatter.RenderMsg(&alertMsg); msg != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, msg)
+	}
+}
+
+func TestRenderMsgUsesGroupDataWhenNoAlert(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "Group {{ .GroupLabels.alertname }} is {{ .Status }}",
+	})
+	alertMsg := AlertMsg{
+		Channel: "#foo",
+		GroupData: &WebhookData{Data: promtmpl.Data{
+			Status:      "resolved",
+			GroupLabels: promtmpl.KV{"alertname": "airDown"},
+		}},
+	}
+
+	expected := "Group airDown is resolved"
+	if msg := formatter.RenderMsg(&alertMsg); msg != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, msg)
+	}
+}
+
+func TestRenderMsgWithoutDataSendsText(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "Alert {{ .Labels.alertname }}",
+	})
+	alertMsg := AlertMsg{Channel: "#foo", Alert: "raw text"}
+
+	if msg := formatter.RenderMsg(&alertMsg); msg != "raw text" {
+		t.Errorf("Expected pre-rendered text to be sent, got '%s'", msg)
+	}
+}
+
+func TestHashHelpersAreStable(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "{{ hashColor .Labels.instance }} {{ shorthash .Labels.instance }}",
+	})
+	alert := promtmpl.Alert{Labels: promtmpl.KV{"instance": "instance1:3456"}}
+	alertMsg := AlertMsg{Channel: "#foo", AlertData: &alert}
+
+	// Hardcoded, as the output must not change across restarts.
+	expected := "\x0304instance1:3456\x03 ae4f05"
+	if msg := formatter.RenderMsg(&alertMsg); msg != expected {
+		t.Errorf("Expected %q, got %q", expected, msg)
+	}
+}
+
+func TestHashColorDiffersAcrossStrings(t *testing.T) {
+	colors := make(map[string]bool)
+	for _, s := range []string{"web-01", "web-02", "web-03", "db-01", "db-02"} {
+		colors[hashColor(s)[1:3]] = true
+	}
+	if len(colors) < 2 {
+		t.Errorf("Expected different strings to use different colors")
+	}
+}
+
+func TestMapHelpersAreSorted(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: `{{ range sortedMap .Labels }}{{ .Key }}={{ .Value }} {{ end }}` +
+			`[{{ joinMap .Annotations ": " ", " }}]`,
+	})
+	alert := promtmpl.Alert{
+		Labels: promtmpl.KV{
+			"instance": "instance1:3456", "alertname": "airDown", "job": "air"},
+		Annotations: promtmpl.KV{
+			"summary": "Air is down", "description": "No air", "runbook": "none"},
+	}
+	alertMsg := AlertMsg{Channel: "#foo", AlertData: &alert}
+
+	expected := "alertname=airDown instance=instance1:3456 job=air " +
+		"[description: No air, runbook: none, summary: Air is down]"
+	// Map iteration order is random, make sure it does not leak.
+	for i := 0; i < 20; i++ {
+		if msg := formatter.RenderMsg(&alertMsg); msg != expected {
+			t.Fatalf("Expected %q, got %q", expected, msg)
+		}
+	}
+}
+
+func TestJoinMapEmpty(t *testing.T) {
+	if joined := joinMap(nil, "=", ","); joined != "" {
+		t.Errorf("Expected an empty string, got %q", joined)
+	}
+}
+
+func TestRunbookReference(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "{{ .Labels.alertname }} {{ runbook . }}",
+	})
+	for _, test := range []struct {
+		annotations promtmpl.KV
+		expected    string
+	}{
+		{promtmpl.KV{"runbook_url": "https://runbooks.example.com/airDown"},
+			"airDown [runbook] https://runbooks.example.com/airDown"},
+		{promtmpl.KV{"summary": "Air is down"}, "airDown "},
+	} {
+		alert := promtmpl.Alert{
+			Labels:      promtmpl.KV{"alertname": "airDown"},
+			Annotations: test.annotations,
+		}
+		alertMsg := AlertMsg{Channel: "#foo", AlertData: &alert}
+		if msg := formatter.RenderMsg(&alertMsg); msg != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, msg)
+		}
+	}
+}
+
+func TestStatusAnnotation(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: `{{ statusAnnotation "description" . }}`,
+	})
+	both := promtmpl.KV{
+		"description":          "Air is down",
+		"resolved_description": "Air is back",
+	}
+	for _, test := range []struct {
+		status      string
+		annotations promtmpl.KV
+		expected    string
+	}{
+		{"firing", both, "Air is down"},
+		{"resolved", both, "Air is back"},
+		{"resolved", promtmpl.KV{"description": "Air is down"}, "Air is down"},
+		{"firing", promtmpl.KV{"resolved_description": "Air is back"}, ""},
+	} {
+		alert := promtmpl.Alert{Status: test.status, Annotations: test.annotations}
+		alertMsg := AlertMsg{Channel: "#foo", AlertData: &alert}
+		if msg := formatter.RenderMsg(&alertMsg); msg != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.status, test.expected, msg)
+		}
+	}
+}
+
+func TestAMHeader(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "{{ amHeader . }}",
+	})
+	for _, test := range []struct {
+		status       string
+		alerts       []string
+		commonLabels promtmpl.KV
+		expected     string
+	}{
+		{"firing", []string{"firing", "resolved", "firing"},
+			promtmpl.KV{"alertname": "airDown", "job": "air", "zone": "global"},
+			"[FIRING:2] airDown (air global)"},
+		{"resolved", []string{"resolved"},
+			promtmpl.KV{"alertname": "airDown", "job": "air"},
+			"[RESOLVED] airDown (air)"},
+		{"firing", []string{"firing"}, promtmpl.KV{"alertname": "airDown"},
+			"[FIRING:1] airDown"},
+	} {
+		data := &WebhookData{}
+		data.Status = test.status
+		data.GroupLabels = promtmpl.KV{"alertname": "airDown"}
+		data.CommonLabels = test.commonLabels
+		for _, status := range test.alerts {
+			data.Alerts = append(data.Alerts, promtmpl.Alert{Status: status})
+		}
+		alertMsg := AlertMsg{Channel: "#foo", GroupData: data}
+		if msg := formatter.RenderMsg(&alertMsg); msg != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, msg)
+		}
+	}
+
+	if _, err := amHeader(AlertTemplateData{}); err == nil {
+		t.Errorf("Expected an error for the data of a single alert")
+	}
+}
+
+func TestAllowedTemplateFuncs(t *testing.T) {
+	config := &Config{
+		MsgTemplate:          `{{ joinMap .Labels "=" " " }}`,
+		AllowedTemplateFuncs: []string{"joinMap", "themed"},
+	}
+	formatter := makeTestFormatter(t, config)
+	alert := promtmpl.Alert{Labels: promtmpl.KV{"alertname": "airDown"}}
+	if msg := formatter.RenderMsg(&AlertMsg{AlertData: &alert}); msg != "alertname=airDown" {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+
+	config.MsgTemplate = `{{ .Labels.alertname | shorthash }}`
+	_, err := NewFormatter(config)
+	if err == nil || !strings.Contains(err.Error(), "shorthash is not in allowed_template_funcs") {
+		t.Errorf("Expected disallowed function error, got: %v", err)
+	}
+
+	config.AllowedTemplateFuncs = []string{"fetchURL"}
+	if _, err := NewFormatter(config); err == nil {
+		t.Errorf("Expected error on unknown allowed template function")
+	}
+}
+
+func TestSeverityRank(t *testing.T) {
+	group := &WebhookData{}
+	group.CommonLabels = promtmpl.KV{"severity": "page"}
+	group.Alerts = promtmpl.Alerts{
+		{Labels: promtmpl.KV{"severity": "ticket"}},
+		{Labels: promtmpl.KV{"severity": "info"}},
+		{Labels: promtmpl.KV{"severity": "page"}},
+	}
+	alertMsg := &AlertMsg{Channel: "#foo", GroupData: group}
+
+	for _, test := range []struct {
+		order    []string
+		unknown  int
+		expected string
+	}{
+		{nil, 0, "0 0 1 0 4"},
+		{[]string{"info", "ticket", "page"}, 0, "3 2 1 3 0"},
+		{[]string{"ticket", "page"}, -1, "2 1 -1 2 -1"},
+	} {
+		formatter := makeTestFormatter(t, &Config{
+			MsgTemplate:         `{{ severityRank . }}{{ range .Alerts }} {{ severityRank . }}{{ end }} {{ severityRank "critical" }}`,
+			SeverityOrder:       test.order,
+			UnknownSeverityRank: test.unknown,
+		})
+		if msg := formatter.RenderMsg(alertMsg); msg != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.order, test.expected, msg)
+		}
+	}
+}
+
+func TestCompactRawAlertOfGroup(t *testing.T) {
+	data := &WebhookData{Data: promtmpl.Data{
+		Status:       "firing",
+		CommonLabels: promtmpl.KV{"job": "air", "alertname": "airDown"},
+	}}
+	expected := "[firing] alertname=airDown job=air"
+	if msg := compactRawAlert(data); msg != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, msg)
+	}
+	// Data without labels is still sent as JSON.
+	if msg := compactRawAlert(JoinCommandData{Channel: "#foo"}); msg != `{"Channel":"#foo","Nick":""}` {
+		t.Errorf("Expected JSON, got '%s'", msg)
+	}
+}
+
+func TestRenderMsgLinesCollapsesSharedLabels(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate:            "unused",
+		CollapseLabels:         true,
+		CollapseHeaderTemplate: defaultCollapseHeaderTemplate,
+		CollapseLineTemplate:   defaultCollapseLineTemplate,
+	})
+	group := &WebhookData{Data: promtmpl.Data{
+		Status: "firing",
+		Alerts: promtmpl.Alerts{
+			promtmpl.Alert{Status: "firing", Labels: promtmpl.KV{
+				"alertname": "airDown", "instance": "a:1", "job": "air"}},
+			promtmpl.Alert{Status: "resolved", Labels: promtmpl.KV{
+				"alertname": "airDown", "instance": "b:1", "job": "air"}},
+			promtmpl.Alert{Status: "firing", Labels: promtmpl.KV{
+				"alertname": "airDown", "instance": "c:1", "job": "water"}},
+		},
+	}}
+
+	expected := []string{
+		"Alerts alertname=airDown are firing",
+		"- instance=a:1 job=air is firing",
+		"- instance=b:1 job=air is resolved",
+		"- instance=c:1 job=water is firing",
+	}
+	lines := formatter.RenderMsgLines(&AlertMsg{Channel: "#foo", GroupData: group})
+	if !reflect.DeepEqual(expected, lines) {
+		t.Errorf("Unexpected lines:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestRenderMsgLinesCollapsesWhitespace(t *testing.T) {
+	alert := promtmpl.Alert{
+		Labels:      promtmpl.KV{"alertname": "airDown"},
+		Annotations: promtmpl.KV{"summary": "Air  is\tdown"},
+	}
+	alertMsg := &AlertMsg{Channel: "#foo", AlertData: &alert}
+
+	for _, test := range []struct {
+		keepEmpty bool
+		expected  []string
+	}{
+		{false, []string{"airDown: Air is down", "#runbook"}},
+		{true, []string{"airDown: Air is down", " ", "#runbook"}},
+	} {
+		formatter := makeTestFormatter(t, &Config{
+			MsgTemplate: `
+				{{- .Labels.alertname }}:   {{ .Annotations.summary }}
+			  	
+				#runbook	`,
+			MsgLineDelimiter:   "\n",
+			KeepEmptyLines:     test.keepEmpty,
+			CollapseWhitespace: true,
+		})
+		lines := formatter.RenderMsgLines(alertMsg)
+		if !reflect.DeepEqual(test.expected, lines) {
+			t.Errorf("keepEmpty %t: unexpected lines %q", test.keepEmpty, lines)
+		}
+	}
+}
+
+func TestRenderMsgLinesWithoutCollapse(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "Group {{ .Status }}",
+	})
+	group := &WebhookData{Data: promtmpl.Data{Status: "firing"}}
+
+	lines := formatter.RenderMsgLines(&AlertMsg{Channel: "#foo", GroupData: group})
+	if !reflect.DeepEqual([]string{"Group firing"}, lines) {
+		t.Errorf("Unexpected lines: %q", lines)
+	}
+}
+
+func TestRenderMsgLinesSplitsOnDelimiter(t *testing.T) {
+	alert := promtmpl.Alert{
+		Labels:      promtmpl.KV{"alertname": "airDown"},
+		Annotations: promtmpl.KV{"summary": "Air is down", "runbook": ""},
+	}
+	alertMsg := &AlertMsg{Channel: "#foo", AlertData: &alert}
+
+	for _, test := range []struct {
+		delimiter string
+		keepEmpty bool
+		expected  []string
+	}{
+		{"\n", false, []string{"airDown", "Air is down"}},
+		{"\n", true, []string{"airDown", " ", "Air is down", " "}},
+		{" | ", false, []string{"airDown\n\nAir is down\n"}},
+	} {
+		formatter := makeTestFormatter(t, &Config{
+			MsgTemplate:      "{{ .Labels.alertname }}\n\n{{ .Annotations.summary }}\n{{ .Annotations.runbook }}",
+			MsgLineDelimiter: test.delimiter,
+			KeepEmptyLines:   test.keepEmpty,
+		})
+		lines := formatter.RenderMsgLines(alertMsg)
+		if !reflect.DeepEqual(test.expected, lines) {
+			t.Errorf("Delimiter %q, keepEmpty %t: unexpected lines %q",
+				test.delimiter, test.keepEmpty, lines)
+		}
+	}
+}
+
+func TestSlowTemplateRenderLogged(t *testing.T) {
+	tmpl := template.Must(template.New("slow").Funcs(template.FuncMap{
+		"slow": func() string {
+			time.Sleep(20 * time.Millisecond)
+			return "done"
+		},
+	}).Parse("{{ slow }}"))
+	formatter := &Formatter{
+		MsgTemplate:           tmpl,
+		SlowTemplateThreshold: 10 * time.Millisecond,
+	}
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	if msg := formatter.FormatMsg(nil); msg != "done" {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+	if !strings.Contains(output.String(), "Rendering template slow took") {
+		t.Errorf("Expected the slow render logged, got: %s", output.String())
+	}
+
+	metric := &dto.Metric{}
+	templateRenderDuration.WithLabelValues("slow").(prometheus.Histogram).Write(metric)
+	if count := metric.GetHistogram().GetSampleCount(); count != 1 {
+		t.Errorf("Expected 1 render observed, got %d", count)
+	}
+	if sum := metric.GetHistogram().GetSampleSum(); sum < 0.02 {
+		t.Errorf("Expected at least 20ms observed, got %fs", sum)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	forwardQueueSize           = 100
+	defaultForwardTimeout      = 10 * time.Second
+	defaultForwardMaxRetries   = 5
+	defaultForwardRetryBackoff = time.Second
+)
+
+var webhookForwards = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "webhook_forwards",
+		Help: "Number of webhooks forwarded to another relay, by result"},
+	[]string{"ircchannel", "result"},
+)
+
+// Forwarder posts webhooks to another relay, from its own goroutine so that
+// webhook requests never wait on it. The connection and channel are preserved
+// by posting to the same path under the forward URL.
+type Forwarder struct {
+	*postQueue
+	url string
+}
+
+// NewForwarder starts forwarding webhooks to url, giving up on each after
+// maxRetries retries, backing off exponentially.
+func NewForwarder(url string, timeout time.Duration, maxRetries int) *Forwarder {
+	queue := newPostQueue("forward webhook", forwardQueueSize, timeout,
+		maxRetries, defaultForwardRetryBackoff)
+	queue.exponential = true
+	queue.result = func(channel string, result string) {
+		webhookForwards.WithLabelValues(channel, result).Inc()
+	}
+	return &Forwarder{postQueue: queue, url: strings.TrimSuffix(url, "/")}
+}
+
+// Forward queues the JSON webhook body for channel without blocking. The
+// connection is empty for webhooks received without a connection segment.
+// Webhooks are dropped if the other relay cannot keep up.
+func (f *Forwarder) Forward(connection string, channel string, body []byte) {
+	path := strings.TrimPrefix(channel, "#")
+	if connection != "" {
+		path = connection + "/" + path
+	}
+	request := postRequest{url: f.url + "/" + path, channel: channel, body: body}
+	if !f.enqueue(request) {
+		log.Printf("Forward queue full, dropping webhook for %s", channel)
+		webhookForwards.WithLabelValues(channel, "dropped").Inc()
+	}
+}
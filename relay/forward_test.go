@@ -0,0 +1,99 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type forwardedWebhook struct {
+	path string
+	body string
+}
+
+func TestForwarderRetries(t *testing.T) {
+	received := make(chan forwardedWebhook, 10)
+	failures := 2
+	relay := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			received <- forwardedWebhook{r.URL.Path, string(body)}
+		}))
+	defer relay.Close()
+
+	forwarder := NewForwarder(relay.URL+"/", time.Second, 2)
+	forwarder.retryBackoff = time.Millisecond
+	defer forwarder.Close()
+
+	retried := webhookForwards.WithLabelValues("#foo", "retried")
+	before := testutil.ToFloat64(retried)
+	forwarder.Forward("", "#foo", []byte(`{"status":"firing"}`))
+
+	select {
+	case webhook := <-received:
+		expected := forwardedWebhook{"/foo", `{"status":"firing"}`}
+		if webhook != expected {
+			t.Errorf("Unexpected forwarded webhook: %+v", webhook)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Webhook not forwarded after retries")
+	}
+	if after := testutil.ToFloat64(retried); after != before+2 {
+		t.Errorf("Expected 2 retries, got %f", after-before)
+	}
+}
+
+func TestForwarderGivesUp(t *testing.T) {
+	attempts := make(chan bool, 10)
+	relay := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			attempts <- true
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+	defer relay.Close()
+
+	forwarder := NewForwarder(relay.URL, time.Second, 1)
+	forwarder.retryBackoff = time.Millisecond
+
+	failed := webhookForwards.WithLabelValues("#bar", "failed")
+	before := testutil.ToFloat64(failed)
+	forwarder.Forward("", "#bar", []byte("{}"))
+	// One attempt and one retry.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-attempts:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 2 attempts, got %d", i)
+		}
+	}
+	forwarder.Close()
+	if len(attempts) != 0 {
+		t.Errorf("Expected no attempts beyond the retry cap")
+	}
+	if after := testutil.ToFloat64(failed); after != before+1 {
+		t.Errorf("Expected the webhook to be counted as failed")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+	"text/template"
+	"time"
+)
+
+const defaultHeartbeatTemplate = "Heartbeat from {{ .Nick }}"
+
+// HeartbeatData is passed to the heartbeat template.
+type HeartbeatData struct {
+	Channel string
+	Nick    string
+	Time    time.Time
+}
+
+func parseHeartbeatTemplate(text string,
+	parser templateParser) (*template.Template, error) {
+	return parser.parse("heartbeat", text)
+}
+
+// SendHeartbeat sends the heartbeat message, so that external monitoring
+// notices when it stops. It is skipped, not queued, while disconnected.
+func (notifier *IRCNotifier) SendHeartbeat() {
+	if !notifier.sessionUp {
+		log.Printf("Skipping heartbeat to %s: IRC not connected",
+			notifier.heartbeatChannel)
+		return
+	}
+	if !notifier.JoinChannel(&IRCChannel{Name: notifier.heartbeatChannel}) {
+		log.Printf("Skipping heartbeat to blocked channel %s",
+			notifier.heartbeatChannel)
+		return
+	}
+	data := HeartbeatData{
+		Channel: notifier.heartbeatChannel,
+		Nick:    notifier.Client.Me().Nick,
+		Time:    notifier.timeNow(),
+	}
+	msg := notifier.Formatter.execute(notifier.heartbeatTmpl, data)
+	notifier.sendLines(notifier.heartbeatChannel,
+		notifier.Formatter.splitLines([]string{msg}))
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+func TestSendHeartbeats(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.HeartbeatChannel = "#foo"
+	config.HeartbeatInterval = 10 * time.Millisecond
+	config.HeartbeatTemplate = "{{ .Nick }} alive in {{ .Channel }}"
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	var mu sync.Mutex
+	heartbeats := 0
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		mu.Lock()
+		defer mu.Unlock()
+		heartbeats++
+		if heartbeats <= 2 {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(3)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :foo alive in #foo",
+		"NOTICE #foo :foo alive in #foo",
+	}
+
+	// More heartbeats may be sent before quitting.
+	if len(server.Log) < len(expectedCommands) ||
+		!reflect.DeepEqual(expectedCommands, server.Log[:len(expectedCommands)]) {
+		t.Error("Heartbeats not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestHeartbeatSkippedWhileDisconnected(t *testing.T) {
+	server, port := makeTestServer(t)
+	defer server.Stop()
+	config := makeTestIRCConfig(port)
+	config.HeartbeatChannel = "#foo"
+	config.HeartbeatInterval = time.Minute
+	config.HeartbeatTemplate = "alive"
+	notifier, _ := makeTestNotifier(t, config)
+
+	notifier.SendHeartbeat()
+
+	if _, ok := notifier.JoinedChannels["#foo"]; ok {
+		t.Error("Expected no channel to be joined while disconnected")
+	}
+}
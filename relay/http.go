@@ -0,0 +1,994 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	promtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Lifecycle requests carry a channel name or an IRC line.
+const maxLifecycleBodySize = 1024
+
+const (
+	// Webhooks are cut there, and fail to decode.
+	maxWebhookBodySize = 10 * 1024 * 1024
+	// Only the start of webhooks that could not be decoded is logged.
+	maxLoggedBodySize = 4096
+)
+
+var (
+	emptyAlertGroups = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_empty_alert_groups",
+			Help: "Number of webhooks received without any alert"},
+		[]string{"ircchannel"},
+	)
+	unjoinedChannelWebhooks = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_unjoined_channels",
+			Help: "Number of webhooks received for channels not configured, by policy applied"},
+		[]string{"ircchannel", "policy"},
+	)
+	httpRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests handled, by route and status code"},
+		[]string{"route", "method", "code"},
+	)
+	webhooksInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "webhook_requests_in_flight",
+			Help: "Number of webhook requests being processed"},
+	)
+	httpRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of HTTP request handling, by route",
+			Buckets: prometheus.DefBuckets},
+		[]string{"route"},
+	)
+)
+
+// instrumentRoute counts and times requests to handler under the route
+// label, rather than the raw path which includes the channel name.
+func instrumentRoute(route string, handler http.Handler) http.Handler {
+	labels := prometheus.Labels{"route": route}
+	return promhttp.InstrumentHandlerDuration(
+		httpRequestDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(
+			httpRequests.MustCurryWith(labels), handler))
+}
+
+// webhookMethods answers the methods other than POST on webhook paths, for
+// health checkers and proxies: OPTIONS with 204, GET and HEAD with 405, both
+// listing POST as allowed.
+func webhookMethods(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "POST")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// HTTPListener serves handler on the given address until it fails.
+type HTTPListener func(string, http.Handler) error
+
+// HTTPServer receives Alertmanager webhooks and queues them for IRC, along
+// with serving metrics and the optional lifecycle endpoints.
+type HTTPServer struct {
+	StoppedRunning chan bool
+	Addr           string
+	Port           int
+	MsgOnce        bool
+	CollapseLabels bool
+	WarnOnEmpty    bool
+	AlertMsgs      chan AlertMsg
+	RawIRCLines    chan string
+	httpListener   HTTPListener
+	archiver       *WebhookArchiver
+	// Queues of the other IRC connections, by name, and the connection
+	// each of their channels is relayed through. Others use AlertMsgs.
+	connectionAlertMsgs map[string]chan AlertMsg
+	channelConnections  map[string]string
+	// Only set when selecting connections by alert label.
+	connectionSelector *ConnectionSelector
+	// Channels configured on each connection, by connection name, and what
+	// to do with webhooks to others.
+	configuredChannels    map[string]map[string]bool
+	unjoinedChannelPolicy string
+	fallbackChannel       string
+	// Only set when forwarding webhooks to another relay, forwardOnly
+	// skipping IRC.
+	forwarder   *Forwarder
+	forwardOnly bool
+	// Only set when stripping labels or annotations from alerts.
+	dataFilter *dataFilter
+	// Only set when relaying alerts whose status changed.
+	transitionTracker *TransitionTracker
+	timeNow           func() time.Time
+	// Only set when listening on the network, to shut it down.
+	httpServer *http.Server
+	// Requests from these addresses are identified by X-Forwarded-For.
+	trustedProxies []*net.IPNet
+	// Only set when limiting webhooks per client.
+	rateLimiter *IPRateLimiter
+	// Only set when webhooks of some severities have their own limits,
+	// nil for those not limited.
+	severityRateLimiters map[string]*IPRateLimiter
+	// Labels alerts are regrouped by, if any.
+	regroupBy []string
+	// Whether resolved alerts are sent, by default and to the channels
+	// overriding it, by channelKey.
+	sendResolved          bool
+	sendResolvedOverrides map[string]bool
+	// Replied to webhooks with alerts dropped on a full queue, unless 200.
+	queueFullStatus int
+	// Only set when replying to webhooks with a body on success.
+	successResponseTmpl *template.Template
+	// Only set when limiting concurrent webhooks, holding a token per
+	// webhook being processed.
+	inFlight chan struct{}
+	// Joined IRC channels, reported by /-/info when set, and those of the
+	// other IRC connections, by name.
+	ChannelTracker     *ChannelTracker
+	connectionTrackers map[string]*ChannelTracker
+	// Paused and resumed through the lifecycle endpoints, holding alerts
+	// in the IRC notifiers sharing it.
+	DeliveryPause *DeliveryPause
+
+	formFieldMapping map[string]string
+	// Decodes JSON webhooks, as sent by the configured alerting source.
+	decodePayload payloadDecoder
+	payloadFormat string
+	// Only set when acknowledging duplicate webhooks without relaying them.
+	idempotency *webhookIdempotency
+
+	lifecycleEnabled bool
+	lifecycleToken   string
+	rawIRCEnabled    bool
+}
+
+// NewHTTPServer returns a server listening on the configured address and
+// sending the messages built from webhooks to alertMsgs.
+func NewHTTPServer(config *Config, alertMsgs chan AlertMsg,
+	rawIRCLines chan string) (*HTTPServer, error) {
+	httpServer, err := newHTTPServerFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	server, err := NewHTTPServerForTesting(config, alertMsgs, rawIRCLines,
+		func(addr string, handler http.Handler) error {
+			httpServer.Addr = addr
+			if config.HTTPTLSCertFile != "" {
+				httpServer.Handler = handler
+				return httpServer.ListenAndServeTLS(
+					config.HTTPTLSCertFile, config.HTTPTLSKeyFile)
+			}
+			httpServer.Handler = maybeH2C(config, handler)
+			return httpServer.ListenAndServe()
+		})
+	if err != nil {
+		return nil, err
+	}
+	server.httpServer = httpServer
+	return server, nil
+}
+
+// newHTTPServerFromConfig sets timeouts so that slow clients cannot hold
+// connections forever, and the TLS versions and cipher suites accepted when
+// serving HTTPS. HTTP/2 is then only negotiated if enabled.
+func newHTTPServerFromConfig(config *Config) (*http.Server, error) {
+	tlsConfig, err := newTLSConfig(config.HTTPTLSMinVersion,
+		config.HTTPTLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	httpServer := &http.Server{
+		ReadTimeout:  config.HTTPReadTimeout,
+		WriteTimeout: config.HTTPWriteTimeout,
+		IdleTimeout:  config.HTTPIdleTimeout,
+		TLSConfig:    tlsConfig,
+	}
+	if !config.EnableHTTP2 {
+		httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	httpServer.SetKeepAlivesEnabled(!config.HTTPDisableKeepAlives)
+	return httpServer, nil
+}
+
+// maybeH2C wraps handler to also serve HTTP/2 over cleartext (h2c), if
+// enabled.
+func maybeH2C(config *Config, handler http.Handler) http.Handler {
+	if !config.EnableHTTP2 {
+		return handler
+	}
+	h2Server := &http2.Server{
+		MaxConcurrentStreams: config.HTTP2MaxConcurrentStreams,
+		IdleTimeout:          config.HTTPIdleTimeout,
+	}
+	return h2c.NewHandler(handler, h2Server)
+}
+
+// NewHTTPServerForTesting returns a server serving through httpListener
+// rather than listening on the network.
+func NewHTTPServerForTesting(config *Config, alertMsgs chan AlertMsg,
+	rawIRCLines chan string, httpListener HTTPListener) (*HTTPServer, error) {
+	server := &HTTPServer{
+		StoppedRunning: make(chan bool),
+		Addr:           config.HTTPHost,
+		Port:           config.HTTPPort,
+		MsgOnce:        config.MsgOnce,
+		CollapseLabels: config.CollapseLabels,
+		WarnOnEmpty:    config.WarnOnEmptyAlerts,
+		AlertMsgs:      alertMsgs,
+		RawIRCLines:    rawIRCLines,
+		httpListener:   httpListener,
+
+		lifecycleEnabled: config.EnableLifecycleEndpoints,
+		lifecycleToken:   config.LifecycleToken,
+		rawIRCEnabled:    config.EnableIRCRawEndpoint,
+
+		formFieldMapping: config.FormFieldMapping,
+		decodePayload:    newPayloadDecoder(config.PayloadFormat),
+		payloadFormat:    config.PayloadFormat,
+
+		connectionAlertMsgs: make(map[string]chan AlertMsg),
+		channelConnections:  channelConnections(config.IRCConnections),
+		configuredChannels:  configuredChannels(config),
+		connectionTrackers:  make(map[string]*ChannelTracker),
+		DeliveryPause:       NewDeliveryPause(),
+		connectionSelector:  config.ConnectionByLabel,
+
+		unjoinedChannelPolicy: config.UnjoinedChannelPolicy,
+		fallbackChannel:       config.FallbackChannel,
+		queueFullStatus:       config.QueueFullStatus,
+		regroupBy:             config.RegroupBy,
+
+		sendResolved:          config.SendResolved == nil || *config.SendResolved,
+		sendResolvedOverrides: sendResolvedOverrides(config),
+
+		timeNow: time.Now,
+	}
+
+	if config.WebhookArchiveFile != "" {
+		archiver, err := NewWebhookArchiver(config.WebhookArchiveFile)
+		if err != nil {
+			return nil, err
+		}
+		server.archiver = archiver
+	}
+
+	if config.ForwardURL != "" {
+		server.forwarder = NewForwarder(config.ForwardURL,
+			config.ForwardTimeout, config.ForwardMaxRetries)
+		server.forwardOnly = config.ForwardOnly
+	}
+
+	trustedProxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	server.trustedProxies = trustedProxies
+
+	if config.HTTPPerIPRateLimit > 0 {
+		server.rateLimiter = NewIPRateLimiter(config.HTTPPerIPRateLimit,
+			config.HTTPPerIPBurst, maxRateLimitedClients)
+	}
+	if len(config.HTTPSeverityRateLimits) > 0 {
+		server.severityRateLimiters = newSeverityRateLimiters(
+			config.HTTPSeverityRateLimits)
+	}
+
+	server.dataFilter = newDataFilter(config)
+	if config.IdempotencyWindow > 0 {
+		server.idempotency = newWebhookIdempotency(config.IdempotencyWindow,
+			config.IdempotencyKey)
+	}
+
+	parser, err := newTemplateParser(config)
+	if err != nil {
+		return nil, err
+	}
+	server.successResponseTmpl, err = parseSuccessResponseTemplate(
+		config.SuccessResponseTemplate, parser)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.MaxConcurrentRequests > 0 {
+		server.inFlight = make(chan struct{}, config.MaxConcurrentRequests)
+	}
+
+	if config.NotifyOnlyTransitions {
+		server.transitionTracker = NewTransitionTracker(
+			config.NotifyOnlyTransitionsTTL, maxTransitionAlerts)
+	}
+
+	return server, nil
+}
+
+// AddConnection sends alerts relayed through the IRC connection name to
+// alertMsgs, reporting its channels from tracker when not nil.
+func (server *HTTPServer) AddConnection(name string, alertMsgs chan AlertMsg,
+	tracker *ChannelTracker) {
+	server.connectionAlertMsgs[name] = alertMsgs
+	if tracker != nil {
+		server.connectionTrackers[name] = tracker
+	}
+}
+
+// channelTrackers returns the channel trackers of all IRC connections.
+func (server *HTTPServer) channelTrackers() []*ChannelTracker {
+	trackers := []*ChannelTracker{}
+	if server.ChannelTracker != nil {
+		trackers = append(trackers, server.ChannelTracker)
+	}
+	for _, tracker := range server.connectionTrackers {
+		trackers = append(trackers, tracker)
+	}
+	return trackers
+}
+
+// alertMsgsFor returns the queue of the IRC connection ircChannel is relayed
+// through, connection if set, or false if there is no such connection.
+func (server *HTTPServer) alertMsgsFor(connection string,
+	ircChannel string) (chan AlertMsg, bool) {
+	if connection == "" {
+		connection = server.channelConnections[channelKey(ircChannel)]
+	}
+	if connection == "" || connection == defaultConnection {
+		return server.AlertMsgs, true
+	}
+	alertMsgs, ok := server.connectionAlertMsgs[connection]
+	return alertMsgs, ok
+}
+
+// routeUnjoinedChannel applies the unjoined channel policy to webhooks to
+// ircChannel through connection ("" when routed by channel) if it is not
+// configured, returning the channel to relay to, or false when rejected.
+func (server *HTTPServer) routeUnjoinedChannel(connection string,
+	ircChannel string) (string, bool) {
+	if connection == "" {
+		connection = server.channelConnections[channelKey(ircChannel)]
+	}
+	if connection == "" {
+		connection = defaultConnection
+	}
+	if server.configuredChannels[connection][channelKey(ircChannel)] {
+		return ircChannel, true
+	}
+	unjoinedChannelWebhooks.WithLabelValues(
+		ircChannel, server.unjoinedChannelPolicy).Inc()
+	switch server.unjoinedChannelPolicy {
+	case unjoinedChannelReject:
+		return "", false
+	case unjoinedChannelFallback:
+		log.Printf("Relaying webhook for unjoined channel %s to %s",
+			ircChannel, server.fallbackChannel)
+		return server.fallbackChannel, true
+	default:
+		return ircChannel, true
+	}
+}
+
+// groupMsgs returns the messages to send to ircChannel for the alerts of
+// group, once filtered.
+func (server *HTTPServer) groupMsgs(ircChannel string,
+	group *WebhookData) []AlertMsg {
+	msgs := []AlertMsg{}
+	if server.dataFilter != nil {
+		group = server.dataFilter.apply(group)
+	}
+	if server.transitionTracker != nil {
+		group = server.onlyTransitions(ircChannel, group)
+		if len(group.Alerts) == 0 {
+			return msgs
+		}
+	}
+	// After tracking transitions, for resolved alerts to be tracked even
+	// when not sent.
+	if !server.sendsResolved(ircChannel) {
+		group = withoutResolved(group)
+		if len(group.Alerts) == 0 {
+			return msgs
+		}
+	}
+	// The group status is the one of the alerts left, or missing from
+	// senders other than Alertmanager.
+	if status := groupStatus(group.Alerts); status != group.Status {
+		withStatus := *group
+		withStatus.Status = status
+		group = &withStatus
+	}
+	// Collapsing labels needs the whole group, it is split into lines when
+	// rendered. Regrouped alerts are sent a message per group.
+	if server.MsgOnce || server.CollapseLabels || len(server.regroupBy) > 0 {
+		return append(msgs,
+			AlertMsg{Channel: ircChannel, GroupData: group,
+				StartsAt: earliestStartsAt(group.Alerts)})
+	}
+	for i := range group.Alerts {
+		alert := &group.Alerts[i]
+		msgs = append(msgs,
+			AlertMsg{Channel: ircChannel, GroupData: group,
+				AlertData: alert, StartsAt: alert.StartsAt})
+	}
+	return msgs
+}
+
+func (server *HTTPServer) GetMsgsFromAlertMessage(ircChannel string,
+	data *WebhookData) []AlertMsg {
+	msgs := []AlertMsg{}
+	if len(data.Alerts) == 0 {
+		emptyAlertGroups.WithLabelValues(ircChannel).Inc()
+		if server.WarnOnEmpty {
+			log.Printf("Received webhook for %s without alerts, skipping",
+				ircChannel)
+		}
+		return msgs
+	}
+	groups := []*WebhookData{data}
+	if len(server.regroupBy) > 0 {
+		groups = regroup(data, server.regroupBy)
+	}
+	for _, group := range groups {
+		msgs = append(msgs, server.groupMsgs(ircChannel, group)...)
+	}
+	if len(msgs) == 0 {
+		log.Printf("Received webhook for %s without alerts to send, skipping",
+			ircChannel)
+		return msgs
+	}
+	if len(groups) > 1 {
+		// Footers are sent once per webhook.
+		webhook := data
+		if server.dataFilter != nil {
+			webhook = server.dataFilter.apply(data)
+		}
+		for i := range msgs {
+			msgs[i].webhook = webhook
+		}
+	}
+	msgs[len(msgs)-1].EndsBatch = true
+	return msgs
+}
+
+// onlyTransitions returns a copy of data holding only the alerts whose
+// status changed since last seen in ircChannel. Alerts without fingerprint
+// cannot be tracked and are always kept.
+func (server *HTTPServer) onlyTransitions(ircChannel string,
+	data *WebhookData) *WebhookData {
+	now := server.timeNow()
+	filtered := *data
+	filtered.Alerts = promtmpl.Alerts{}
+	for _, alert := range data.Alerts {
+		if alert.Fingerprint != "" && !server.transitionTracker.Changed(
+			ircChannel, alert.Fingerprint, alert.Status, now) {
+			repeatedAlerts.WithLabelValues(ircChannel).Inc()
+			continue
+		}
+		filtered.Alerts = append(filtered.Alerts, alert)
+	}
+	return &filtered
+}
+
+// sendsResolved returns whether resolved alerts are sent to ircChannel.
+func (server *HTTPServer) sendsResolved(ircChannel string) bool {
+	if send, ok := server.sendResolvedOverrides[channelKey(ircChannel)]; ok {
+		return send
+	}
+	return server.sendResolved
+}
+
+// withoutResolved returns a copy of data without its resolved alerts.
+func withoutResolved(data *WebhookData) *WebhookData {
+	filtered := *data
+	filtered.Alerts = data.Alerts.Firing()
+	return &filtered
+}
+
+func earliestStartsAt(alerts promtmpl.Alerts) time.Time {
+	var earliest time.Time
+	for _, alert := range alerts {
+		if earliest.IsZero() || alert.StartsAt.Before(earliest) {
+			earliest = alert.StartsAt
+		}
+	}
+	return earliest
+}
+
+// decodeJSONAlert decodes webhook data from the whole body stream, however
+// it is chunked, returning the HTTP status to reply with on errors: 400 for
+// empty, truncated or malformed JSON, 422 for valid JSON that does not fit.
+func decodeJSONAlert(body io.Reader) (*WebhookData, int, error) {
+	alertMessage := &WebhookData{}
+	decoder := json.NewDecoder(body)
+	err := decoder.Decode(alertMessage)
+	if err == nil {
+		// Only a single value is expected, reading it to the end.
+		if err = decoder.Decode(&json.RawMessage{}); err == io.EOF {
+			return alertMessage, 0, nil
+		}
+		if err == nil {
+			err = errors.New("unexpected data after the webhook")
+		}
+	}
+	switch err.(type) {
+	case *json.UnmarshalTypeError:
+		return nil, 422, err // Unprocessable entity
+	}
+	switch err {
+	case io.EOF:
+		err = errors.New("empty body")
+	case io.ErrUnexpectedEOF:
+		err = errors.New("truncated body")
+	}
+	return nil, http.StatusBadRequest, err
+}
+
+// decodeAlertMessage decodes the webhook data according to the request
+// content type, returning the HTTP status to reply with on errors.
+func (server *HTTPServer) decodeAlertMessage(r *http.Request, body io.Reader) (
+	*WebhookData, int, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, http.StatusUnsupportedMediaType, err
+		}
+		contentType = mediaType
+	}
+
+	isForm := contentType == "application/x-www-form-urlencoded"
+	switch {
+	// Without a form mapping, form data is JSON posted with e.g. curl -d.
+	case contentType == "" || contentType == "application/json" ||
+		(isForm && len(server.formFieldMapping) == 0):
+		return server.decodePayload(body)
+	case isForm:
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, 422, err
+		}
+		alertMessage, err := decodeFormAlert(values, server.formFieldMapping)
+		if err != nil {
+			return nil, 422, err
+		}
+		return alertMessage, 0, nil
+	default:
+		return nil, http.StatusUnsupportedMediaType,
+			fmt.Errorf("unsupported content type: %s", contentType)
+	}
+}
+
+// rateLimited replies 429 to clients over the per client rate limit, if any.
+// With severity rate limits this can only be told once data is decoded, nil
+// before.
+func (server *HTTPServer) rateLimited(w http.ResponseWriter, r *http.Request,
+	data *WebhookData) bool {
+	if (server.severityRateLimiters != nil) != (data != nil) {
+		return false
+	}
+	limiter := server.rateLimiter
+	if data != nil {
+		if severity := webhookRateLimitSeverity(
+			data, server.severityRateLimiters); severity != "" {
+			limiter = server.severityRateLimiters[severity]
+		}
+	}
+	if limiter == nil ||
+		limiter.Allow(clientAddr(r, server.trustedProxies), server.timeNow()) {
+		return false
+	}
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return true
+}
+
+func (server *HTTPServer) RelayAlert(w http.ResponseWriter, r *http.Request) {
+	if server.rateLimited(w, r, nil) {
+		return
+	}
+	if server.inFlight != nil {
+		select {
+		case server.inFlight <- struct{}{}:
+			defer func() { <-server.inFlight }()
+		default:
+			http.Error(w, "Too many concurrent requests",
+				http.StatusServiceUnavailable)
+			return
+		}
+	}
+	webhooksInFlight.Inc()
+	defer webhooksInFlight.Dec()
+	vars := mux.Vars(r)
+	ircChannel := "#" + vars["IRCChannel"]
+	// Connections are not set up when only forwarding.
+	alertMsgs, ok := server.alertMsgsFor(vars["IRCConnection"], ircChannel)
+	if !ok && !server.forwardOnly {
+		http.Error(w, "Unknown IRC connection", http.StatusNotFound)
+		return
+	}
+	if !server.forwardOnly {
+		channel, ok := server.routeUnjoinedChannel(
+			vars["IRCConnection"], ircChannel)
+		if !ok {
+			http.Error(w, "Channel not configured", http.StatusForbidden)
+			return
+		}
+		if channel != ircChannel {
+			ircChannel = channel
+			alertMsgs, _ = server.alertMsgsFor(vars["IRCConnection"], ircChannel)
+		}
+	}
+
+	// Keep what was read to archive or forward it, or else only its start
+	// to log it on errors.
+	body := &cappedBuffer{limit: maxLoggedBodySize}
+	if server.archiver != nil || server.forwarder != nil {
+		body.limit = maxWebhookBodySize
+	}
+	alertMessage, status, err := server.decodeAlertMessage(r,
+		io.TeeReader(io.LimitReader(r.Body, maxWebhookBodySize), body))
+	if err != nil {
+		log.Printf("Could not decode request body from %s (%s): %s",
+			clientAddr(r, server.trustedProxies), err, body.buf.Bytes())
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(err); err != nil {
+			log.Printf("Could not write decoding error: %s", err)
+			return
+		}
+		return
+	}
+	if server.rateLimited(w, r, alertMessage) {
+		return
+	}
+	if server.idempotency != nil && server.idempotency.Seen(
+		vars["IRCConnection"], ircChannel, alertMessage, server.timeNow()) {
+		log.Printf("Ignoring duplicate webhook for %s from %s", ircChannel,
+			clientAddr(r, server.trustedProxies))
+		duplicateWebhooks.WithLabelValues(ircChannel).Inc()
+		if server.successResponseTmpl != nil {
+			writeSuccessResponse(w, server.successResponseTmpl,
+				&SuccessResponseData{
+					Connection: vars["IRCConnection"],
+					Channels:   []string{ircChannel},
+				})
+		}
+		return
+	}
+	if server.archiver != nil || server.forwarder != nil {
+		webhook := body.buf.Bytes()
+		if !json.Valid(webhook) || (server.payloadFormat != "" &&
+			server.payloadFormat != payloadFormatAlertmanager) {
+			// Archive and forward decoded form data, or webhooks of other
+			// sources, as Alertmanager JSON so it can be replayed.
+			webhook, _ = json.Marshal(alertMessage)
+		}
+		if server.archiver != nil {
+			server.archiver.Archive(ircChannel, webhook)
+		}
+		if server.forwarder != nil {
+			server.forwarder.Forward(vars["IRCConnection"], ircChannel,
+				webhook)
+		}
+	}
+	if server.forwardOnly {
+		return
+	}
+	dropped := false
+	enqueued := 0
+	for _, part := range server.selectedParts(
+		vars["IRCConnection"], alertMessage) {
+		queue := alertMsgs
+		if part.connection != "" {
+			queue, _ = server.alertMsgsFor(part.connection, ircChannel)
+		}
+		for _, alertMsg := range server.GetMsgsFromAlertMessage(
+			ircChannel, part.data) {
+			alertMsg.EnqueuedAt = server.timeNow()
+			select {
+			case queue <- alertMsg:
+				enqueued++
+			default:
+				log.Printf("Could not send this alert to the IRC routine: %+v",
+					alertMsg)
+				dropped = true
+			}
+		}
+	}
+	if dropped && server.queueFullStatus != 0 &&
+		server.queueFullStatus != http.StatusOK {
+		http.Error(w, "Alert queue full", server.queueFullStatus)
+		return
+	}
+	if server.successResponseTmpl != nil {
+		writeSuccessResponse(w, server.successResponseTmpl,
+			&SuccessResponseData{
+				Connection: vars["IRCConnection"],
+				Channels:   []string{ircChannel},
+				Enqueued:   enqueued,
+			})
+	}
+}
+
+// authorizeLifecycle checks the bearer token of lifecycle requests, writing
+// an error response if the request is not authorized.
+func (server *HTTPServer) authorizeLifecycle(w http.ResponseWriter,
+	r *http.Request) bool {
+	if server.lifecycleToken == "" {
+		return true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare(
+		[]byte(token), []byte(server.lifecycleToken)) != 1 {
+		log.Printf("Unauthorized lifecycle request from %s to %s",
+			clientAddr(r, server.trustedProxies), r.URL.Path)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// readLifecycleBody reads the body of lifecycle requests, replying 413 when
+// it is larger than maxLifecycleBodySize rather than acting on part of it.
+func readLifecycleBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxLifecycleBodySize+1))
+	if err != nil {
+		log.Printf("Could not get body: %s", err)
+		return nil, false
+	}
+	if len(body) > maxLifecycleBodySize {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return body, true
+}
+
+func (server *HTTPServer) SendRawIRCLine(w http.ResponseWriter, r *http.Request) {
+	if !server.authorizeLifecycle(w, r) {
+		return
+	}
+
+	body, ok := readLifecycleBody(w, r)
+	if !ok {
+		return
+	}
+	line := strings.TrimSpace(string(body))
+	if line == "" || strings.ContainsAny(line, "\r\n") {
+		http.Error(w, "Expected a single IRC line", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Raw IRC line requested by %s: %s",
+		clientAddr(r, server.trustedProxies), line)
+	select {
+	case server.RawIRCLines <- line:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		log.Printf("Could not send raw line to the IRC routine: %s", line)
+		http.Error(w, "IRC routine busy", http.StatusServiceUnavailable)
+	}
+}
+
+// PauseDelivery pauses the delivery of alerts to IRC, the IRC notifiers
+// holding them until ResumeDelivery.
+func (server *HTTPServer) PauseDelivery(w http.ResponseWriter, r *http.Request) {
+	if !server.authorizeLifecycle(w, r) {
+		return
+	}
+	if server.DeliveryPause.Pause() {
+		log.Printf("Delivery paused by %s", clientAddr(r, server.trustedProxies))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ResumeDelivery resumes the delivery of alerts to IRC, sending those held
+// meanwhile.
+func (server *HTTPServer) ResumeDelivery(w http.ResponseWriter, r *http.Request) {
+	if !server.authorizeLifecycle(w, r) {
+		return
+	}
+	if server.DeliveryPause.Resume() {
+		log.Printf("Delivery resumed by %s", clientAddr(r, server.trustedProxies))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// UnblockChannel allows joining the channel named in the body again, after
+// the server refused a join.
+func (server *HTTPServer) UnblockChannel(w http.ResponseWriter, r *http.Request) {
+	if !server.authorizeLifecycle(w, r) {
+		return
+	}
+
+	body, ok := readLifecycleBody(w, r)
+	if !ok {
+		return
+	}
+	channel := strings.TrimSpace(string(body))
+	// Channels are relayed through a single connection.
+	unblocked := false
+	for _, tracker := range server.channelTrackers() {
+		if tracker.Unblock(channel) {
+			unblocked = true
+		}
+	}
+	if !unblocked {
+		http.Error(w, "Channel not blocked", http.StatusNotFound)
+		return
+	}
+	log.Printf("Channel %s unblocked by %s",
+		channel, clientAddr(r, server.trustedProxies))
+	w.WriteHeader(http.StatusOK)
+}
+
+type connectionInfo struct {
+	Channels        []ChannelInfo    `json:"channels"`
+	BlockedChannels []BlockedChannel `json:"blocked_channels"`
+}
+
+// infoResponse has the channels of the default connection at the top level,
+// and those of the other connections by name.
+type infoResponse struct {
+	connectionInfo
+	Connections map[string]connectionInfo `json:"connections,omitempty"`
+	Paused      bool                      `json:"paused"`
+}
+
+func newConnectionInfo(tracker *ChannelTracker) connectionInfo {
+	if tracker == nil {
+		return connectionInfo{
+			Channels:        []ChannelInfo{},
+			BlockedChannels: []BlockedChannel{},
+		}
+	}
+	return connectionInfo{
+		Channels:        tracker.Channels(),
+		BlockedChannels: tracker.BlockedChannels(),
+	}
+}
+
+// Info reports the state of the relay, for debugging.
+func (server *HTTPServer) Info(w http.ResponseWriter, r *http.Request) {
+	if !server.authorizeLifecycle(w, r) {
+		return
+	}
+	info := infoResponse{
+		connectionInfo: newConnectionInfo(server.ChannelTracker),
+		Paused:         server.DeliveryPause.Paused(),
+	}
+	if len(server.connectionTrackers) > 0 {
+		info.Connections = make(map[string]connectionInfo)
+		for name, tracker := range server.connectionTrackers {
+			info.Connections[name] = newConnectionInfo(tracker)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		log.Printf("Could not write info response: %s", err)
+	}
+}
+
+// Shutdown gracefully stops the server, making Run return.
+func (server *HTTPServer) Shutdown(ctx context.Context) error {
+	if server.httpServer == nil {
+		return nil
+	}
+	return server.httpServer.Shutdown(ctx)
+}
+
+// normalizePath collapses duplicate slashes of request paths and strips the
+// trailing one before routing, so that /ops/ and //ops are relayed to #ops
+// like /ops instead of being redirected, which loses the webhook.
+func normalizePath(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		for strings.Contains(path, "//") {
+			path = strings.Replace(path, "//", "/", -1)
+		}
+		if len(path) > 1 {
+			path = strings.TrimSuffix(path, "/")
+		}
+		r.URL.Path = path
+		r.URL.RawPath = ""
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Run serves HTTP requests until the server stops, then signals
+// StoppedRunning.
+func (server *HTTPServer) Run() {
+	router := mux.NewRouter().StrictSlash(true)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.RelayAlert(w, r)
+	})
+	router.Path("/metrics").Handler(promhttp.Handler()).Methods("GET")
+	if server.lifecycleEnabled {
+		router.Path("/-/info").Handler(instrumentRoute("info",
+			http.HandlerFunc(server.Info))).Methods("GET")
+		router.Path("/-/irc-unblock").Handler(instrumentRoute("irc_unblock",
+			http.HandlerFunc(server.UnblockChannel))).Methods("POST")
+		router.Path("/-/pause").Handler(instrumentRoute("pause",
+			http.HandlerFunc(server.PauseDelivery))).Methods("POST")
+		router.Path("/-/resume").Handler(instrumentRoute("resume",
+			http.HandlerFunc(server.ResumeDelivery))).Methods("POST")
+	}
+	if server.lifecycleEnabled && server.rawIRCEnabled {
+		router.Path("/-/irc-raw").Handler(instrumentRoute("irc_raw",
+			http.HandlerFunc(server.SendRawIRCLine))).Methods("POST")
+	}
+	router.Path("/{IRCChannel}").Handler(
+		instrumentRoute("webhook", handler)).Methods("POST")
+	router.Path("/{IRCChannel}").HandlerFunc(webhookMethods).Methods(
+		"GET", "HEAD", "OPTIONS")
+	if len(server.connectionAlertMsgs) > 0 {
+		router.Path("/{IRCConnection}/{IRCChannel}").Handler(
+			instrumentRoute("webhook", handler)).Methods("POST")
+		router.Path("/{IRCConnection}/{IRCChannel}").HandlerFunc(
+			webhookMethods).Methods("GET", "HEAD", "OPTIONS")
+	}
+
+	listenAddr := strings.Join(
+		[]string{server.Addr, strconv.Itoa(server.Port)}, ":")
+	log.Printf("Starting HTTP server")
+	if err := server.httpListener(listenAddr, normalizePath(router)); err != nil &&
+		err != http.ErrServerClosed {
+		log.Printf("Could not start http server: %s", err)
+	}
+	server.StoppedRunning <- true
+}
+
+// closeSenders flushes and stops archiving and forwarding. It must only be
+// called once no handler runs anymore, after Shutdown returned.
+func (server *HTTPServer) closeSenders() {
+	if server.archiver != nil {
+		server.archiver.Close()
+	}
+	if server.forwarder != nil {
+		server.forwarder.Close()
+	}
+}
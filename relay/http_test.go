@@ -0,0 +1,1705 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gorilla/mux"
+	promtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/http2"
+)
+
+type FakeHTTPListener struct {
+	StartedServing chan bool
+	StopServing    chan bool
+	AlertMsgs      chan AlertMsg // kinda ugly putting it here, but convenient
+	RawIRCLines    chan string
+	router         http.Handler
+}
+
+func (listener *FakeHTTPListener) Serve(_ string, router http.Handler) error {
+	listener.router = router
+
+	listener.StartedServing <- true
+	<-listener.StopServing
+	return nil
+}
+
+func NewFakeHTTPListener() *FakeHTTPListener {
+	return &FakeHTTPListener{
+		StartedServing: make(chan bool),
+		StopServing:    make(chan bool),
+		AlertMsgs:      make(chan AlertMsg, 10),
+		RawIRCLines:    make(chan string, 10),
+	}
+}
+
+func MakeHTTPTestingConfig() *Config {
+	return &Config{
+		HTTPHost:    "test.web",
+		HTTPPort:    8888,
+		MsgTemplate: "Alert {{ .Labels.alertname }} on {{ .Labels.instance }} is {{ .Status }}",
+	}
+}
+
+// renderAlertMsg renders alertMsg the way the IRC notifier does, keeping only
+// the fields the expectations are written against.
+func renderAlertMsg(t *testing.T, config *Config, alertMsg AlertMsg) AlertMsg {
+	formatter, err := NewFormatter(config)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create formatter: %s", err))
+	}
+	return AlertMsg{
+		Channel:  alertMsg.Channel,
+		Alert:    formatter.RenderMsg(&alertMsg),
+		StartsAt: alertMsg.StartsAt,
+	}
+}
+
+func RunHTTPTest(t *testing.T,
+	alertData string, url string,
+	testingConfig *Config, listener *FakeHTTPListener) *http.Response {
+	alertDataReader := strings.NewReader(alertData)
+	request, err := http.NewRequest("POST", url, alertDataReader)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+	}
+	return RunHTTPTestRequest(t, request, testingConfig, listener)
+}
+
+func RunHTTPTestRequest(t *testing.T, request *http.Request,
+	testingConfig *Config, listener *FakeHTTPListener) *http.Response {
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+
+	go httpServer.Run()
+
+	<-listener.StartedServing
+
+	responseRecorder := httptest.NewRecorder()
+
+	listener.router.ServeHTTP(responseRecorder, request)
+
+	listener.StopServing <- true
+	<-httpServer.StoppedRunning
+	httpServer.closeSenders()
+	return responseRecorder.Result()
+}
+
+func TestWebhookPathMethods(t *testing.T) {
+	for _, test := range []struct {
+		method       string
+		expectedCode int
+	}{
+		{"GET", 405},
+		{"HEAD", 405},
+		{"OPTIONS", 204},
+	} {
+		listener := NewFakeHTTPListener()
+		testingConfig := MakeHTTPTestingConfig()
+		request, err := http.NewRequest(test.method, "/somechannel", nil)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+		}
+		response := RunHTTPTestRequest(t, request, testingConfig, listener)
+
+		if response.StatusCode != test.expectedCode {
+			t.Errorf("%s: expected %d status, got %d",
+				test.method, test.expectedCode, response.StatusCode)
+		}
+		if allow := response.Header.Get("Allow"); allow != "POST" {
+			t.Errorf("%s: expected Allow: POST, got %q", test.method, allow)
+		}
+		if body, _ := ioutil.ReadAll(response.Body); len(body) != 0 {
+			t.Errorf("%s: expected no body, got %q", test.method, body)
+		}
+	}
+}
+
+func TestAlertsDispatched(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+
+	expectedAlertMsgs := []AlertMsg{
+		AlertMsg{
+			Channel:  "#somechannel",
+			Alert:    "Alert airDown on instance1:3456 is resolved",
+			StartsAt: time.Date(2017, 5, 15, 13, 49, 37, 834000000, time.UTC),
+		},
+		AlertMsg{
+			Channel:  "#somechannel",
+			Alert:    "Alert airDown on instance2:7890 is resolved",
+			StartsAt: time.Date(2017, 5, 15, 11, 47, 37, 834000000, time.UTC),
+		},
+	}
+	expectedStatusCode := 200
+
+	response := RunHTTPTest(
+		t, testdataSimpleAlertJson, "/somechannel",
+		testingConfig, listener)
+
+	if expectedStatusCode != response.StatusCode {
+		t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+			expectedStatusCode, response.StatusCode))
+	}
+
+	for _, expectedAlertMsg := range expectedAlertMsgs {
+		alertMsg := renderAlertMsg(t, testingConfig, <-listener.AlertMsgs)
+		if !reflect.DeepEqual(expectedAlertMsg, alertMsg) {
+			t.Error(fmt.Sprintf(
+				"Unexpected alert msg.\nExpected: %+v\nActual: %+v",
+				expectedAlertMsg, alertMsg))
+		}
+	}
+}
+
+func TestAlertsDispatchedOnce(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.MsgOnce = true
+	testingConfig.MsgTemplate = "Alert {{ .GroupLabels.alertname }} is {{ .Status }}"
+
+	expectedAlertMsgs := []AlertMsg{
+		AlertMsg{
+			Channel: "#somechannel",
+			Alert:   "Alert airDown is resolved",
+			// The earliest of the alerts in the group.
+			StartsAt: time.Date(2017, 5, 15, 11, 47, 37, 834000000, time.UTC),
+		},
+	}
+	expectedStatusCode := 200
+
+	response := RunHTTPTest(
+		t, testdataSimpleAlertJson, "/somechannel",
+		testingConfig, listener)
+
+	if expectedStatusCode != response.StatusCode {
+		t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+			expectedStatusCode, response.StatusCode))
+	}
+
+	for _, expectedAlertMsg := range expectedAlertMsgs {
+		alertMsg := renderAlertMsg(t, testingConfig, <-listener.AlertMsgs)
+		if !reflect.DeepEqual(expectedAlertMsg, alertMsg) {
+			t.Error(fmt.Sprintf(
+				"Unexpected alert msg.\nExpected: %+v\nActual: %+v",
+				expectedAlertMsg, alertMsg))
+		}
+	}
+}
+
+func TestSendResolvedPerChannel(t *testing.T) {
+	no, yes := false, true
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.SendResolved = &no
+	testingConfig.IRCChannels = []IRCChannel{
+		{Name: "#noc", SendResolved: &yes},
+		{Name: "#mgmt"},
+	}
+	// The second alert of the group is still firing.
+	second := strings.Index(testdataSimpleAlertJson, "instance2")
+	payload := testdataSimpleAlertJson[:second] + strings.Replace(
+		testdataSimpleAlertJson[second:], `"resolved"`, `"firing"`, 1)
+
+	for _, test := range []struct {
+		path     string
+		expected []string
+	}{
+		{"/noc", []string{
+			"Alert airDown on instance1:3456 is resolved",
+			"Alert airDown on instance2:7890 is firing",
+		}},
+		{"/mgmt", []string{
+			"Alert airDown on instance2:7890 is firing",
+		}},
+	} {
+		listener := NewFakeHTTPListener()
+		RunHTTPTest(t, payload, test.path, testingConfig, listener)
+
+		for _, expected := range test.expected {
+			alertMsg := renderAlertMsg(t, testingConfig, <-listener.AlertMsgs)
+			if alertMsg.Alert != expected {
+				t.Errorf("%s: expected %q, got %q",
+					test.path, expected, alertMsg.Alert)
+			}
+		}
+		select {
+		case alertMsg := <-listener.AlertMsgs:
+			t.Errorf("%s: unexpected alert msg %+v", test.path, alertMsg)
+		default:
+		}
+	}
+
+	// Only resolved alerts, nothing to send.
+	listener := NewFakeHTTPListener()
+	RunHTTPTest(t, testdataSimpleAlertJson, "/mgmt", testingConfig, listener)
+	select {
+	case alertMsg := <-listener.AlertMsgs:
+		t.Errorf("Unexpected resolved alert msg %+v", alertMsg)
+	default:
+	}
+}
+
+func TestLastAlertMsgEndsBatch(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+
+	RunHTTPTest(t, testdataSimpleAlertJson, "/somechannel",
+		testingConfig, listener)
+
+	// The simple alert group holds two alerts.
+	for i, expected := range []bool{false, true} {
+		alertMsg := <-listener.AlertMsgs
+		if alertMsg.EndsBatch != expected {
+			t.Errorf("Expected alert msg %d to end batch: %t", i, expected)
+		}
+	}
+}
+
+func TestWebhookPathNormalized(t *testing.T) {
+	for _, path := range []string{
+		"/somechannel", "/somechannel/", "//somechannel", "//somechannel//"} {
+		listener := NewFakeHTTPListener()
+		testingConfig := MakeHTTPTestingConfig()
+
+		// Unlike http.NewRequest, parses //somechannel as a path the way
+		// servers do.
+		request := httptest.NewRequest("POST", path,
+			strings.NewReader(testdataSimpleAlertJson))
+		response := RunHTTPTestRequest(t, request, testingConfig, listener)
+
+		if response.StatusCode != 200 {
+			t.Errorf("%s: expected 200 status in response, got %d",
+				path, response.StatusCode)
+			continue
+		}
+		if len(listener.AlertMsgs) != 2 {
+			t.Errorf("%s: expected 2 alerts, got %d", path, len(listener.AlertMsgs))
+			continue
+		}
+		if alertMsg := <-listener.AlertMsgs; alertMsg.Channel != "#somechannel" {
+			t.Errorf("%s: expected alerts sent to #somechannel, got %s",
+				path, alertMsg.Channel)
+		}
+	}
+}
+
+func TestLifecyclePathNotTakenForChannel(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.EnableLifecycleEndpoints = true
+
+	request, err := http.NewRequest("GET", "/-/info/", nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+	}
+	response := RunHTTPTestRequest(t, request, testingConfig, listener)
+	if response.StatusCode != 200 {
+		t.Errorf("Expected 200 status in response, got %d", response.StatusCode)
+	}
+}
+
+func TestNotifyOnlyTransitions(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.NotifyOnlyTransitions = true
+	testingConfig.NotifyOnlyTransitionsTTL = time.Hour
+
+	server, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+
+	makeData := func() *WebhookData {
+		data, _, err := decodeJSONAlert(strings.NewReader(testdataSimpleAlertJson))
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Could not decode test data: %s", err))
+		}
+		return data
+	}
+
+	if msgs := server.GetMsgsFromAlertMessage("#somechannel", makeData()); len(msgs) != 2 {
+		t.Errorf("Expected both alerts to be relayed at first, got %d", len(msgs))
+	}
+	for i := 0; i < 3; i++ {
+		if msgs := server.GetMsgsFromAlertMessage("#somechannel", makeData()); len(msgs) != 0 {
+			t.Errorf("Expected repeated alerts to be suppressed, got %d", len(msgs))
+		}
+	}
+
+	data := makeData()
+	data.Alerts[1].Status = "firing"
+	msgs := server.GetMsgsFromAlertMessage("#somechannel", data)
+	if len(msgs) != 1 || msgs[0].AlertData.Fingerprint != data.Alerts[1].Fingerprint {
+		t.Fatalf("Expected only the alert firing again to be relayed, got %+v", msgs)
+	}
+	if !msgs[0].EndsBatch {
+		t.Error("Expected the only relayed alert to end the batch")
+	}
+}
+
+func TestGroupKeyAvailableInTemplate(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.MsgTemplate = "{{ .GroupKey }}: {{ .Labels.instance }}"
+	payload := strings.Replace(testdataSimpleAlertJson,
+		`"status": "resolved",`,
+		`"status": "resolved", "groupKey": "{}:{alertname=\"airDown\"}",`, 1)
+
+	response := RunHTTPTest(
+		t, payload, "/somechannel", testingConfig, listener)
+
+	if response.StatusCode != 200 {
+		t.Fatalf("Expected 200 status in response, got %d",
+			response.StatusCode)
+	}
+
+	expected := "{}:{alertname=\"airDown\"}: instance1:3456"
+	alertMsg := renderAlertMsg(t, testingConfig, <-listener.AlertMsgs)
+	if alertMsg.Alert != expected {
+		t.Errorf("Expected %q, got %q", expected, alertMsg.Alert)
+	}
+}
+
+func TestAlertsCollapsedIntoGroupMsg(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.CollapseLabels = true
+
+	response := RunHTTPTest(
+		t, testdataSimpleAlertJson, "/somechannel",
+		testingConfig, listener)
+
+	if response.StatusCode != 200 {
+		t.Fatalf("Expected 200 status in response, got %d",
+			response.StatusCode)
+	}
+
+	alertMsg := <-listener.AlertMsgs
+	if alertMsg.AlertData != nil || alertMsg.GroupData == nil ||
+		len(alertMsg.GroupData.Alerts) != 2 {
+		t.Errorf("Expected a single msg for the whole group, got %+v", alertMsg)
+	}
+	select {
+	case extra := <-listener.AlertMsgs:
+		t.Errorf("Unexpected extra alert msg: %+v", extra)
+	default:
+	}
+}
+
+func TestEmptyAlertsSkipped(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.MsgOnce = true
+	testingConfig.WarnOnEmptyAlerts = true
+
+	expectedStatusCode := 200
+
+	response := RunHTTPTest(
+		t, testdataEmptyAlertsJson, "/somechannel",
+		testingConfig, listener)
+
+	if expectedStatusCode != response.StatusCode {
+		t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+			expectedStatusCode, response.StatusCode))
+	}
+
+	if len(listener.AlertMsgs) != 0 {
+		t.Error(fmt.Sprintf("Expected no alert msg, got %d",
+			len(listener.AlertMsgs)))
+	}
+}
+
+func TestFormAlertDispatched(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.FormFieldMapping = map[string]string{
+		"status":              "state",
+		"startsAt":            "since",
+		"labels.alertname":    "name",
+		"labels.instance":     "host",
+		"annotations.SUMMARY": "text",
+	}
+
+	form := url.Values{}
+	form.Set("state", "firing")
+	form.Set("since", "2017-05-15T13:49:37Z")
+	form.Set("name", "airDown")
+	form.Set("host", "instance1:3456")
+	form.Set("text", "air down")
+	form.Set("ignored", "value")
+	request, err := http.NewRequest("POST", "/somechannel",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+	}
+	request.Header.Set("Content-Type",
+		"application/x-www-form-urlencoded; charset=UTF-8")
+
+	response := RunHTTPTestRequest(t, request, testingConfig, listener)
+
+	expectedStatusCode := 200
+	if expectedStatusCode != response.StatusCode {
+		t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+			expectedStatusCode, response.StatusCode))
+	}
+
+	expectedAlertMsg := AlertMsg{
+		Channel:  "#somechannel",
+		Alert:    "Alert airDown on instance1:3456 is firing",
+		StartsAt: time.Date(2017, 5, 15, 13, 49, 37, 0, time.UTC),
+	}
+	alertMsg := renderAlertMsg(t, testingConfig, <-listener.AlertMsgs)
+	if !reflect.DeepEqual(expectedAlertMsg, alertMsg) {
+		t.Error(fmt.Sprintf(
+			"Unexpected alert msg.\nExpected: %+v\nActual: %+v",
+			expectedAlertMsg, alertMsg))
+	}
+}
+
+func TestUnsupportedContentTypeReturnsError(t *testing.T) {
+	for _, contentType := range []string{
+		"text/plain",
+		"application/xml",
+	} {
+		listener := NewFakeHTTPListener()
+		testingConfig := MakeHTTPTestingConfig()
+
+		request, err := http.NewRequest("POST", "/somechannel",
+			strings.NewReader(testdataSimpleAlertJson))
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+		}
+		request.Header.Set("Content-Type", contentType)
+
+		response := RunHTTPTestRequest(t, request, testingConfig, listener)
+
+		expectedStatusCode := 415
+		if expectedStatusCode != response.StatusCode {
+			t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+				expectedStatusCode, response.StatusCode))
+		}
+	}
+}
+
+func TestFormContentTypeWithoutMappingDecodedAsJSON(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+
+	// As sent by curl -d @alert.json.
+	request, err := http.NewRequest("POST", "/somechannel",
+		strings.NewReader(testdataSimpleAlertJson))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response := RunHTTPTestRequest(t, request, testingConfig, listener)
+
+	if response.StatusCode != 200 {
+		t.Errorf("Expected 200 status in response, got %d", response.StatusCode)
+	}
+	if len(listener.AlertMsgs) != 2 {
+		t.Errorf("Expected 2 alerts relayed, got %d", len(listener.AlertMsgs))
+	}
+}
+
+func TestRootReturnsError(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+
+	expectedStatusCode := 404
+
+	response := RunHTTPTest(
+		t, testdataSimpleAlertJson, "/",
+		testingConfig, listener)
+
+	if expectedStatusCode != response.StatusCode {
+		t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+			expectedStatusCode, response.StatusCode))
+	}
+}
+
+func TestInvalidDataReturnsError(t *testing.T) {
+	for _, test := range []struct {
+		body               string
+		expectedStatusCode int
+	}{
+		{testdataBogusAlertJson, 400},
+		{"", 400},
+		{testdataSimpleAlertJson[:100], 400},
+		{testdataSimpleAlertJson + "{}", 400},
+		{`{"alerts": "not a list"}`, 422},
+	} {
+		listener := NewFakeHTTPListener()
+		testingConfig := MakeHTTPTestingConfig()
+
+		response := RunHTTPTest(
+			t, test.body, "/somechannel",
+			testingConfig, listener)
+
+		if test.expectedStatusCode != response.StatusCode {
+			t.Error(fmt.Sprintf("Expected %d status in response to %q, got %d",
+				test.expectedStatusCode, test.body, response.StatusCode))
+		}
+	}
+}
+
+func TestChunkedBodyDecoded(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+
+	// Bodies can come in arbitrarily small chunks.
+	request, err := http.NewRequest("POST", "/somechannel",
+		iotest.OneByteReader(strings.NewReader(testdataSimpleAlertJson)))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+	}
+	request.TransferEncoding = []string{"chunked"}
+
+	response := RunHTTPTestRequest(t, request, testingConfig, listener)
+
+	if response.StatusCode != 200 {
+		t.Error(fmt.Sprintf("Expected 200 status in response, got %d",
+			response.StatusCode))
+	}
+	if len(listener.AlertMsgs) != 2 {
+		t.Errorf("Expected 2 alert messages, got %d", len(listener.AlertMsgs))
+	}
+}
+
+func TestWebhookRequestsCounted(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	counter := httpRequests.WithLabelValues("webhook", "post", "400")
+	before := testutil.ToFloat64(counter)
+
+	RunHTTPTest(
+		t, testdataBogusAlertJson, "/somechannel",
+		testingConfig, listener)
+
+	if after := testutil.ToFloat64(counter); after != before+1 {
+		t.Errorf("Expected the 400 webhook counter to go from %f to %f, got %f",
+			before, before+1, after)
+	}
+}
+
+func TestTemplateErrorsFallback(t *testing.T) {
+	for _, test := range []struct {
+		onTemplateError string
+		expectedAlerts  []string
+	}{
+		{
+			"raw",
+			[]string{
+				`{"status":"resolved","labels":{"alertname":"airDown","instance":"instance1:3456","job":"air","service":"prometheus","severity":"ticket","zone":"global"},"annotations":{"DESCRIPTION":"service /prometheus has irc gateway down on instance1","SUMMARY":"service /prometheus air down on instance1"},"startsAt":"2017-05-15T13:49:37.834Z","endsAt":"2017-05-15T13:50:37.835Z","generatorURL":"https://prometheus.example.com/prometheus/...","fingerprint":"66214a361160fb6f"}`,
+				`{"status":"resolved","labels":{"alertname":"airDown","instance":"instance2:7890","job":"air","service":"prometheus","severity":"ticket","zone":"global"},"annotations":{"DESCRIPTION":"service /prometheus has irc gateway down on instance2","SUMMARY":"service /prometheus air down on instance2"},"startsAt":"2017-05-15T11:47:37.834Z","endsAt":"2017-05-15T11:48:37.834Z","generatorURL":"https://prometheus.example.com/prometheus/...","fingerprint":"25a874c99325d1ce"}`,
+			},
+		},
+		{"drop", []string{"", ""}},
+		{"static", []string{"Broken template", "Broken template"}},
+	} {
+		listener := NewFakeHTTPListener()
+		testingConfig := MakeHTTPTestingConfig()
+		testingConfig.MsgTemplate = "Bogus template {{ nil }}"
+		testingConfig.OnTemplateError = test.onTemplateError
+		testingConfig.TemplateErrorMessage = "Broken template"
+
+		expectedAlertMsgs := []AlertMsg{
+			AlertMsg{
+				Channel:  "#somechannel",
+				Alert:    test.expectedAlerts[0],
+				StartsAt: time.Date(2017, 5, 15, 13, 49, 37, 834000000, time.UTC),
+			},
+			AlertMsg{
+				Channel:  "#somechannel",
+				Alert:    test.expectedAlerts[1],
+				StartsAt: time.Date(2017, 5, 15, 11, 47, 37, 834000000, time.UTC),
+			},
+		}
+		expectedStatusCode := 200
+
+		response := RunHTTPTest(
+			t, testdataSimpleAlertJson, "/somechannel",
+			testingConfig, listener)
+
+		if expectedStatusCode != response.StatusCode {
+			t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+				expectedStatusCode, response.StatusCode))
+		}
+
+		for _, expectedAlertMsg := range expectedAlertMsgs {
+			alertMsg := renderAlertMsg(t, testingConfig, <-listener.AlertMsgs)
+			if !reflect.DeepEqual(expectedAlertMsg, alertMsg) {
+				t.Error(fmt.Sprintf(
+					"%s: Unexpected alert msg.\nExpected: %+v\nActual: %+v",
+					test.onTemplateError, expectedAlertMsg, alertMsg))
+			}
+		}
+	}
+}
+
+func TestTemplateErrorsCompactFallback(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.MsgTemplate = "Bogus template {{ nil }}"
+	testingConfig.OnTemplateError = "raw"
+	testingConfig.RawFallbackFormat = "compact"
+
+	expectedAlertMsgs := []AlertMsg{
+		AlertMsg{
+			Channel:  "#somechannel",
+			Alert:    "[resolved] alertname=airDown instance=instance1:3456 job=air service=prometheus severity=ticket zone=global",
+			StartsAt: time.Date(2017, 5, 15, 13, 49, 37, 834000000, time.UTC),
+		},
+		AlertMsg{
+			Channel:  "#somechannel",
+			Alert:    "[resolved] alertname=airDown instance=instance2:7890 job=air service=prometheus severity=ticket zone=global",
+			StartsAt: time.Date(2017, 5, 15, 11, 47, 37, 834000000, time.UTC),
+		},
+	}
+	expectedStatusCode := 200
+
+	response := RunHTTPTest(
+		t, testdataSimpleAlertJson, "/somechannel",
+		testingConfig, listener)
+
+	if expectedStatusCode != response.StatusCode {
+		t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+			expectedStatusCode, response.StatusCode))
+	}
+
+	for _, expectedAlertMsg := range expectedAlertMsgs {
+		alertMsg := renderAlertMsg(t, testingConfig, <-listener.AlertMsgs)
+		if !reflect.DeepEqual(expectedAlertMsg, alertMsg) {
+			t.Error(fmt.Sprintf(
+				"Unexpected alert msg.\nExpected: %+v\nActual: %+v",
+				expectedAlertMsg, alertMsg))
+		}
+	}
+}
+
+func TestDeniedAnnotationsStripped(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.MsgTemplate = `{{ .Labels.instance }}: '{{ index .Annotations "SUMMARY" }}' {{ .Annotations }}`
+	testingConfig.DeniedAnnotations = []string{"SUMMARY"}
+	testingConfig.AllowedLabels = []string{"alertname", "instance"}
+
+	expectedAlertMsgs := []AlertMsg{
+		AlertMsg{
+			Channel:  "#somechannel",
+			Alert:    "instance1:3456: '' map[DESCRIPTION:service /prometheus has irc gateway down on instance1]",
+			StartsAt: time.Date(2017, 5, 15, 13, 49, 37, 834000000, time.UTC),
+		},
+		AlertMsg{
+			Channel:  "#somechannel",
+			Alert:    "instance2:7890: '' map[DESCRIPTION:service /prometheus has irc gateway down on instance2]",
+			StartsAt: time.Date(2017, 5, 15, 11, 47, 37, 834000000, time.UTC),
+		},
+	}
+
+	RunHTTPTest(t, testdataSimpleAlertJson, "/somechannel",
+		testingConfig, listener)
+
+	for _, expectedAlertMsg := range expectedAlertMsgs {
+		alertMsg := <-listener.AlertMsgs
+		if labels := alertMsg.AlertData.Labels.Names(); !reflect.DeepEqual(
+			[]string{"alertname", "instance"}, labels) {
+			t.Errorf("Expected only allowed labels, got %s", labels)
+		}
+		if alertMsg := renderAlertMsg(t, testingConfig, alertMsg); !reflect.DeepEqual(expectedAlertMsg, alertMsg) {
+			t.Error(fmt.Sprintf(
+				"Unexpected alert msg.\nExpected: %+v\nActual: %+v",
+				expectedAlertMsg, alertMsg))
+		}
+	}
+}
+
+func TestWebhooksArchived(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "airtestarchive")
+	if err != nil {
+		t.Fatalf("Could not create tmpfile for testing: %s", err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.WebhookArchiveFile = tmpfile.Name()
+
+	RunHTTPTest(
+		t, testdataSimpleAlertJson, "/somechannel",
+		testingConfig, listener)
+
+	// Invalid webhooks are not archived.
+	listener = NewFakeHTTPListener()
+	RunHTTPTest(
+		t, testdataBogusAlertJson, "/somechannel",
+		testingConfig, listener)
+
+	data, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Could not read archive: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 archived webhook, got %d:\n%s", len(lines), data)
+	}
+
+	var record archiveRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Could not decode archive record: %s", err)
+	}
+	if record.Channel != "#somechannel" {
+		t.Errorf("Expected channel #somechannel, got %s", record.Channel)
+	}
+	if record.Time.IsZero() {
+		t.Errorf("Expected archive record to be timestamped")
+	}
+	expectedBody := bytes.Buffer{}
+	json.Compact(&expectedBody, []byte(testdataSimpleAlertJson))
+	if string(record.Body) != expectedBody.String() {
+		t.Errorf("Archived body does not match webhook: %s", record.Body)
+	}
+}
+
+func makeRawIRCLineRequest(t *testing.T, token string, line string) *http.Request {
+	request, err := http.NewRequest("POST", "/-/irc-raw", strings.NewReader(line))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	return request
+}
+
+func TestRawIRCLineEndpoint(t *testing.T) {
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.EnableLifecycleEndpoints = true
+	testingConfig.EnableIRCRawEndpoint = true
+	testingConfig.LifecycleToken = "secret"
+
+	for _, test := range []struct {
+		token, line        string
+		expectedStatusCode int
+		expectedLine       string
+	}{
+		{"secret", "MODE #somechannel +t\n", 202, "MODE #somechannel +t"},
+		{"", "MODE #somechannel +t", 401, ""},
+		{"wrong", "MODE #somechannel +t", 401, ""},
+		{"secret", "MODE #somechannel +t\r\nQUIT", 400, ""},
+		{"secret", "", 400, ""},
+		// Longer lines are refused rather than truncated.
+		{"secret", "PRIVMSG #somechannel :" + strings.Repeat("a", 1024), 413, ""},
+	} {
+		listener := NewFakeHTTPListener()
+		response := RunHTTPTestRequest(t,
+			makeRawIRCLineRequest(t, test.token, test.line),
+			testingConfig, listener)
+
+		if test.expectedStatusCode != response.StatusCode {
+			t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+				test.expectedStatusCode, response.StatusCode))
+		}
+		if test.expectedLine == "" {
+			if len(listener.RawIRCLines) != 0 {
+				t.Error(fmt.Sprintf("Unexpected raw line: %s",
+					<-listener.RawIRCLines))
+			}
+			continue
+		}
+		if line := <-listener.RawIRCLines; line != test.expectedLine {
+			t.Error(fmt.Sprintf("Expected raw line '%s', got '%s'",
+				test.expectedLine, line))
+		}
+	}
+}
+
+func TestRawIRCLineEndpointDisabled(t *testing.T) {
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.EnableIRCRawEndpoint = true
+	testingConfig.LifecycleToken = "secret"
+
+	listener := NewFakeHTTPListener()
+	response := RunHTTPTestRequest(t,
+		makeRawIRCLineRequest(t, "secret", "MODE #somechannel +t"),
+		testingConfig, listener)
+
+	expectedStatusCode := 404
+	if expectedStatusCode != response.StatusCode {
+		t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+			expectedStatusCode, response.StatusCode))
+	}
+}
+
+func TestHTTP2Cleartext(t *testing.T) {
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.EnableHTTP2 = true
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%d", r.ProtoMajor)
+	})
+	testServer := httptest.NewServer(maybeH2C(testingConfig, handler))
+	defer testServer.Close()
+
+	// Prior knowledge h2c, as sent by HTTP/2 clients to plaintext servers.
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	response, err := client.Get(testServer.URL)
+	if err != nil {
+		t.Fatalf("Could not send HTTP/2 request: %s", err)
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	if response.ProtoMajor != 2 || string(body) != "2" {
+		t.Errorf("Expected an HTTP/2 response, got %s: %s", response.Proto, body)
+	}
+}
+
+func TestInfoEndpoint(t *testing.T) {
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.EnableLifecycleEndpoints = true
+	testingConfig.LifecycleToken = "secret"
+	listener := NewFakeHTTPListener()
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	httpServer.ChannelTracker = NewChannelTracker(defaultConnection)
+	httpServer.ChannelTracker.Names("#foo", []string{"foo", "@bar", "baz"})
+	httpServer.ChannelTracker.EndOfNames("#foo")
+	httpServer.ChannelTracker.Block("#bar", "474: banned")
+
+	for _, test := range []struct {
+		token, expectedBody string
+		expectedStatusCode  int
+	}{
+		{"secret", `{"channels":[{"name":"#foo","members":3,"nicks":["bar","baz","foo"]}],` +
+			`"blocked_channels":[{"name":"#bar","reason":"474: banned"}],"paused":false}` + "\n", 200},
+		{"wrong", "Unauthorized\n", 401},
+	} {
+		request, err := http.NewRequest("GET", "/-/info", nil)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+		}
+		request.Header.Set("Authorization", "Bearer "+test.token)
+		responseRecorder := httptest.NewRecorder()
+		httpServer.Info(responseRecorder, request)
+		response := responseRecorder.Result()
+		body, _ := ioutil.ReadAll(response.Body)
+		if test.expectedStatusCode != response.StatusCode {
+			t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+				test.expectedStatusCode, response.StatusCode))
+		}
+		if test.expectedBody != string(body) {
+			t.Error(fmt.Sprintf("Expected body %q, got %q",
+				test.expectedBody, body))
+		}
+	}
+}
+
+func TestPauseAndResumeDelivery(t *testing.T) {
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.EnableLifecycleEndpoints = true
+	testingConfig.LifecycleToken = "secret"
+	listener := NewFakeHTTPListener()
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	resumed := httpServer.DeliveryPause.subscribe()
+
+	for _, test := range []struct {
+		handler      http.HandlerFunc
+		token        string
+		expectedCode int
+		paused       bool
+	}{
+		{httpServer.PauseDelivery, "wrong", 401, false},
+		{httpServer.PauseDelivery, "secret", 200, true},
+		{httpServer.PauseDelivery, "secret", 200, true},
+		{httpServer.ResumeDelivery, "secret", 200, false},
+	} {
+		request := httptest.NewRequest("POST", "/-/pause", nil)
+		request.Header.Set("Authorization", "Bearer "+test.token)
+		responseRecorder := httptest.NewRecorder()
+		test.handler(responseRecorder, request)
+		if responseRecorder.Code != test.expectedCode {
+			t.Errorf("Expected %d status, got %d",
+				test.expectedCode, responseRecorder.Code)
+		}
+		if paused := httpServer.DeliveryPause.Paused(); paused != test.paused {
+			t.Errorf("Expected paused %t, got %t", test.paused, paused)
+		}
+	}
+
+	select {
+	case <-resumed:
+	default:
+		t.Errorf("Expected notifiers to be signalled on resume")
+	}
+}
+
+func TestInfoEndpointWithConnections(t *testing.T) {
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.EnableLifecycleEndpoints = true
+	listener := NewFakeHTTPListener()
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	httpServer.ChannelTracker = NewChannelTracker(defaultConnection)
+	tracker := NewChannelTracker("other")
+	tracker.Block("#qux", "474: banned")
+	httpServer.AddConnection("other", make(chan AlertMsg), tracker)
+
+	request := httptest.NewRequest("GET", "/-/info", nil)
+	responseRecorder := httptest.NewRecorder()
+	httpServer.Info(responseRecorder, request)
+
+	expectedBody := `{"channels":[],"blocked_channels":[],"connections":` +
+		`{"other":{"channels":[],"blocked_channels":[{"name":"#qux","reason":"474: banned"}]}},"paused":false}` + "\n"
+	if body := responseRecorder.Body.String(); body != expectedBody {
+		t.Errorf("Expected body %q, got %q", expectedBody, body)
+	}
+
+	// Channels of other connections can be unblocked too.
+	request = httptest.NewRequest("POST", "/-/irc-unblock", strings.NewReader("#qux"))
+	responseRecorder = httptest.NewRecorder()
+	httpServer.UnblockChannel(responseRecorder, request)
+	if responseRecorder.Code != 200 {
+		t.Errorf("Expected 200 status in response, got %d", responseRecorder.Code)
+	}
+	if _, blocked := tracker.Blocked("#qux"); blocked {
+		t.Error("Expected #qux to be unblocked")
+	}
+}
+
+func TestUnblockChannelEndpoint(t *testing.T) {
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.EnableLifecycleEndpoints = true
+	listener := NewFakeHTTPListener()
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	httpServer.ChannelTracker = NewChannelTracker(defaultConnection)
+	httpServer.ChannelTracker.Block("#foo", "474: banned")
+
+	// Only the first request finds the channel blocked.
+	for _, expectedStatusCode := range []int{200, 404} {
+		request, err := http.NewRequest("POST", "/-/irc-unblock",
+			strings.NewReader("#foo"))
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+		}
+		responseRecorder := httptest.NewRecorder()
+		httpServer.UnblockChannel(responseRecorder, request)
+		if expectedStatusCode != responseRecorder.Code {
+			t.Error(fmt.Sprintf("Expected %d status in response, got %d",
+				expectedStatusCode, responseRecorder.Code))
+		}
+	}
+	if _, blocked := httpServer.ChannelTracker.Blocked("#foo"); blocked {
+		t.Error("Expected #foo to be unblocked")
+	}
+}
+
+func TestWebhookForwardedOnly(t *testing.T) {
+	received := make(chan string, 1)
+	relay := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			received <- r.URL.Path
+		}))
+	defer relay.Close()
+
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.ForwardURL = relay.URL
+	testingConfig.ForwardOnly = true
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	// Not running the server, as stopping it drops webhooks not forwarded
+	// yet.
+	defer httpServer.forwarder.Close()
+
+	request, err := http.NewRequest("POST", "/somechannel",
+		strings.NewReader(testdataSimpleAlertJson))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+	}
+	request = mux.SetURLVars(request, map[string]string{"IRCChannel": "somechannel"})
+	responseRecorder := httptest.NewRecorder()
+	httpServer.RelayAlert(responseRecorder, request)
+
+	if responseRecorder.Code != 200 {
+		t.Error(fmt.Sprintf("Expected 200 status in response, got %d",
+			responseRecorder.Code))
+	}
+	select {
+	case path := <-received:
+		if path != "/somechannel" {
+			t.Errorf("Expected the webhook forwarded to /somechannel, got %s", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Webhook not forwarded")
+	}
+	if len(listener.AlertMsgs) != 0 {
+		t.Errorf("Expected no alerts sent to IRC, got %d", len(listener.AlertMsgs))
+	}
+}
+
+func TestWebhookForwardedWithConnection(t *testing.T) {
+	received := make(chan string, 1)
+	relay := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			received <- r.URL.Path
+		}))
+	defer relay.Close()
+
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.ForwardURL = relay.URL + "/"
+	testingConfig.ForwardOnly = true
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	defer httpServer.forwarder.Close()
+
+	request, err := http.NewRequest("POST", "/libera/somechannel",
+		strings.NewReader(testdataSimpleAlertJson))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP request: %s", err))
+	}
+	request = mux.SetURLVars(request, map[string]string{
+		"IRCConnection": "libera", "IRCChannel": "somechannel"})
+	responseRecorder := httptest.NewRecorder()
+	httpServer.RelayAlert(responseRecorder, request)
+
+	select {
+	case path := <-received:
+		if path != "/libera/somechannel" {
+			t.Errorf("Expected the webhook forwarded to /libera/somechannel, got %s", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Webhook not forwarded")
+	}
+}
+
+func TestWebhookRateLimitedPerClient(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.HTTPPerIPRateLimit = 1
+	testingConfig.TrustedProxies = []string{"10.0.0.0/8"}
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	clock := &fakeClock{now: time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)}
+	httpServer.timeNow = clock.Now
+
+	relayFrom := func(remoteAddr string, forwardedFor string) int {
+		request := httptest.NewRequest("POST", "/somechannel",
+			strings.NewReader(testdataSimpleAlertJson))
+		request = mux.SetURLVars(request, map[string]string{"IRCChannel": "somechannel"})
+		request.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			request.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		responseRecorder := httptest.NewRecorder()
+		httpServer.RelayAlert(responseRecorder, request)
+		return responseRecorder.Code
+	}
+
+	steps := []struct {
+		remoteAddr   string
+		forwardedFor string
+		expected     int
+	}{
+		{"198.51.100.7:1234", "", 200},
+		{"198.51.100.7:1234", "", 429},
+		// Spoofing the header from an untrusted source does not help.
+		{"198.51.100.7:1234", "203.0.113.1", 429},
+		{"10.1.1.1:1234", "203.0.113.9", 200},
+		{"10.1.1.1:1234", "203.0.113.9", 429},
+		{"10.1.1.1:1234", "203.0.113.10", 200},
+	}
+	for i, step := range steps {
+		if code := relayFrom(step.remoteAddr, step.forwardedFor); code != step.expected {
+			t.Errorf("Step %d: expected %d status, got %d", i, step.expected, code)
+		}
+	}
+
+	clock.Set(clock.Now().Add(time.Second))
+	if code := relayFrom("198.51.100.7:1234", ""); code != 200 {
+		t.Errorf("Expected 200 status once the bucket refilled, got %d", code)
+	}
+}
+
+func TestWebhookRateLimitedPerSeverity(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.HTTPPerIPRateLimit = 1
+	testingConfig.HTTPSeverityRateLimits = map[string]SeverityRateLimit{
+		"critical": {},
+		"warning":  {Rate: 1, Burst: 2},
+	}
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	clock := &fakeClock{now: time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)}
+	httpServer.timeNow = clock.Now
+
+	relay := func(severity string) int {
+		request := httptest.NewRequest("POST", "/somechannel",
+			strings.NewReader(strings.Replace(testdataSimpleAlertJson,
+				`"severity": "ticket"`, `"severity": "`+severity+`"`, -1)))
+		request = mux.SetURLVars(request, map[string]string{"IRCChannel": "somechannel"})
+		responseRecorder := httptest.NewRecorder()
+		httpServer.RelayAlert(responseRecorder, request)
+		return responseRecorder.Code
+	}
+
+	steps := []struct {
+		severity string
+		expected int
+	}{
+		{"warning", 200},
+		{"warning", 200},
+		{"warning", 429},
+		{"critical", 200},
+		{"warning", 429},
+		{"critical", 200},
+		// Other severities share the per client limit.
+		{"ticket", 200},
+		{"ticket", 429},
+		{"critical", 200},
+	}
+	for i, step := range steps {
+		if code := relay(step.severity); code != step.expected {
+			t.Errorf("Step %d: expected %d status for %s, got %d",
+				i, step.expected, step.severity, code)
+		}
+	}
+}
+
+func TestWebhooksRoutedToConnections(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.IRCConnections = []IRCConnection{
+		{Name: "other", IRCChannels: []IRCChannel{{Name: "#otherchannel"}}},
+	}
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	otherAlertMsgs := make(chan AlertMsg, 10)
+	httpServer.AddConnection("other", otherAlertMsgs, nil)
+	defaultAlertMsgs := make(chan AlertMsg, 10)
+	httpServer.AlertMsgs = defaultAlertMsgs
+
+	for _, test := range []struct {
+		vars         map[string]string
+		expectedCode int
+		expectedMsgs chan AlertMsg
+	}{
+		{map[string]string{"IRCChannel": "somechannel"}, 200, defaultAlertMsgs},
+		{map[string]string{"IRCChannel": "otherchannel"}, 200, otherAlertMsgs},
+		{map[string]string{"IRCConnection": "other", "IRCChannel": "somechannel"},
+			200, otherAlertMsgs},
+		{map[string]string{"IRCConnection": "default", "IRCChannel": "otherchannel"},
+			200, defaultAlertMsgs},
+		{map[string]string{"IRCConnection": "unknown", "IRCChannel": "somechannel"},
+			404, nil},
+	} {
+		request := httptest.NewRequest("POST", "/somechannel",
+			strings.NewReader(testdataSimpleAlertJson))
+		request = mux.SetURLVars(request, test.vars)
+		responseRecorder := httptest.NewRecorder()
+		httpServer.RelayAlert(responseRecorder, request)
+
+		if responseRecorder.Code != test.expectedCode {
+			t.Errorf("%v: expected %d status, got %d",
+				test.vars, test.expectedCode, responseRecorder.Code)
+		}
+		if test.expectedMsgs != nil && len(test.expectedMsgs) != 2 {
+			t.Errorf("%v: expected 2 alerts queued for the connection, got %d",
+				test.vars, len(test.expectedMsgs))
+		}
+		for _, alertMsgs := range []chan AlertMsg{defaultAlertMsgs, otherAlertMsgs} {
+			for len(alertMsgs) > 0 {
+				<-alertMsgs
+			}
+		}
+	}
+}
+
+func TestWebhooksRoutedByLabel(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.IRCConnections = []IRCConnection{{Name: "eunet"}, {Name: "usnet"}}
+	testingConfig.ConnectionByLabel = &ConnectionSelector{
+		Label:       "region",
+		Connections: map[string]string{"eu-west": "eunet", "us-east": "usnet"},
+	}
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	euAlertMsgs := make(chan AlertMsg, 10)
+	httpServer.AddConnection("eunet", euAlertMsgs, nil)
+	usAlertMsgs := make(chan AlertMsg, 10)
+	httpServer.AddConnection("usnet", usAlertMsgs, nil)
+	defaultAlertMsgs := make(chan AlertMsg, 10)
+	httpServer.AlertMsgs = defaultAlertMsgs
+
+	data := &WebhookData{}
+	data.Status = "firing"
+	for i, region := range []string{"eu-west", "us-east", "eu-west", "ap-south"} {
+		data.Alerts = append(data.Alerts, promtmpl.Alert{
+			Status: "firing",
+			Labels: promtmpl.KV{"alertname": "airDown",
+				"instance": fmt.Sprintf("instance%d", i), "region": region},
+		})
+	}
+	webhook, _ := json.Marshal(data)
+
+	for _, test := range []struct {
+		vars     map[string]string
+		expected map[chan AlertMsg]int
+	}{
+		{map[string]string{"IRCChannel": "somechannel"},
+			map[chan AlertMsg]int{euAlertMsgs: 2, usAlertMsgs: 1, defaultAlertMsgs: 1}},
+		// Connections of webhook URLs take precedence.
+		{map[string]string{"IRCConnection": "usnet", "IRCChannel": "somechannel"},
+			map[chan AlertMsg]int{usAlertMsgs: 4}},
+	} {
+		request := httptest.NewRequest("POST", "/somechannel",
+			bytes.NewReader(webhook))
+		request = mux.SetURLVars(request, test.vars)
+		responseRecorder := httptest.NewRecorder()
+		httpServer.RelayAlert(responseRecorder, request)
+
+		if responseRecorder.Code != 200 {
+			t.Errorf("%v: expected 200 status, got %d",
+				test.vars, responseRecorder.Code)
+		}
+		for _, alertMsgs := range []chan AlertMsg{euAlertMsgs, usAlertMsgs, defaultAlertMsgs} {
+			if len(alertMsgs) != test.expected[alertMsgs] {
+				t.Errorf("%v: expected %d alerts queued, got %d",
+					test.vars, test.expected[alertMsgs], len(alertMsgs))
+			}
+			for len(alertMsgs) > 0 {
+				alertMsg := <-alertMsgs
+				region := alertMsg.AlertData.Labels["region"]
+				if alertMsgs == euAlertMsgs && (region != "eu-west" ||
+					alertMsg.GroupData.CommonLabels["region"] != "eu-west") {
+					t.Errorf("%v: unexpected alert for eunet: %v", test.vars, alertMsg.AlertData)
+				}
+			}
+		}
+	}
+}
+
+func TestWebhooksToUnjoinedChannels(t *testing.T) {
+	for _, test := range []struct {
+		policy          string
+		vars            map[string]string
+		expectedCode    int
+		expectedChannel string
+	}{
+		{"join", map[string]string{"IRCChannel": "somechannel"}, 200, "#somechannel"},
+		{"reject", map[string]string{"IRCChannel": "somechannel"}, 403, ""},
+		{"reject", map[string]string{"IRCChannel": "foo"}, 200, "#foo"},
+		{"reject", map[string]string{"IRCChannel": "otherchannel"}, 200, "#otherchannel"},
+		// Channels are only configured on their own connection.
+		{"reject", map[string]string{"IRCConnection": "default", "IRCChannel": "otherchannel"},
+			403, ""},
+		{"reject", map[string]string{"IRCConnection": "unknown", "IRCChannel": "foo"},
+			404, ""},
+		{"fallback", map[string]string{"IRCChannel": "somechannel"}, 200, "#unrouted"},
+		{"fallback", map[string]string{"IRCChannel": "foo"}, 200, "#foo"},
+	} {
+		listener := NewFakeHTTPListener()
+		testingConfig := MakeHTTPTestingConfig()
+		testingConfig.IRCChannels = []IRCChannel{{Name: "#foo"}}
+		testingConfig.IRCConnections = []IRCConnection{
+			{Name: "other", IRCChannels: []IRCChannel{{Name: "#otherchannel"}}},
+		}
+		testingConfig.UnjoinedChannelPolicy = test.policy
+		testingConfig.FallbackChannel = "#unrouted"
+		httpServer, err := NewHTTPServerForTesting(testingConfig,
+			listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+		}
+		alertMsgs := make(chan AlertMsg, 10)
+		httpServer.AddConnection("other", alertMsgs, nil)
+		httpServer.AlertMsgs = alertMsgs
+
+		request := httptest.NewRequest("POST", "/somechannel",
+			strings.NewReader(testdataSimpleAlertJson))
+		request = mux.SetURLVars(request, test.vars)
+		responseRecorder := httptest.NewRecorder()
+		httpServer.RelayAlert(responseRecorder, request)
+
+		if responseRecorder.Code != test.expectedCode {
+			t.Errorf("%s %v: expected %d status, got %d", test.policy,
+				test.vars, test.expectedCode, responseRecorder.Code)
+		}
+		if test.expectedChannel == "" {
+			if len(alertMsgs) != 0 {
+				t.Errorf("%s %v: expected no alert queued", test.policy, test.vars)
+			}
+			continue
+		}
+		if len(alertMsgs) != 2 {
+			t.Errorf("%s %v: expected 2 alerts queued, got %d",
+				test.policy, test.vars, len(alertMsgs))
+			continue
+		}
+		if alertMsg := <-alertMsgs; alertMsg.Channel != test.expectedChannel {
+			t.Errorf("%s %v: expected alerts to %s, got %s", test.policy,
+				test.vars, test.expectedChannel, alertMsg.Channel)
+		}
+	}
+}
+
+func TestQueueFullStatus(t *testing.T) {
+	for _, test := range []struct {
+		status   int
+		expected int
+	}{
+		{0, 200},
+		{200, 200},
+		{429, 429},
+		{503, 503},
+	} {
+		listener := NewFakeHTTPListener()
+		testingConfig := MakeHTTPTestingConfig()
+		testingConfig.QueueFullStatus = test.status
+		httpServer, err := NewHTTPServerForTesting(testingConfig,
+			listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+		}
+		// Only the first of the two alerts fits.
+		alertMsgs := make(chan AlertMsg, 1)
+		httpServer.AlertMsgs = alertMsgs
+
+		request := httptest.NewRequest("POST", "/somechannel",
+			strings.NewReader(testdataSimpleAlertJson))
+		request = mux.SetURLVars(request, map[string]string{"IRCChannel": "somechannel"})
+		responseRecorder := httptest.NewRecorder()
+		httpServer.RelayAlert(responseRecorder, request)
+
+		if responseRecorder.Code != test.expected {
+			t.Errorf("Status %d: expected %d status, got %d",
+				test.status, test.expected, responseRecorder.Code)
+		}
+		if len(alertMsgs) != 1 {
+			t.Errorf("Status %d: expected the first alert queued", test.status)
+		}
+	}
+}
+
+func TestSuccessResponseTemplate(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.SuccessResponseTemplate =
+		`{"enqueued": {{ .Enqueued }}, "channels": "{{ range .Channels }}{{ . }}{{ end }}"}`
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+
+	request := httptest.NewRequest("POST", "/somechannel",
+		strings.NewReader(testdataSimpleAlertJson))
+	request = mux.SetURLVars(request, map[string]string{"IRCChannel": "somechannel"})
+	responseRecorder := httptest.NewRecorder()
+	httpServer.RelayAlert(responseRecorder, request)
+
+	if responseRecorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 status, got %d", responseRecorder.Code)
+	}
+	expectedBody := `{"enqueued": 2, "channels": "#somechannel"}`
+	if body := responseRecorder.Body.String(); body != expectedBody {
+		t.Errorf("Expected body %q, got %q", expectedBody, body)
+	}
+}
+
+func TestSuccessResponseTemplateNotRenderedOnFullQueue(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.QueueFullStatus = http.StatusServiceUnavailable
+	testingConfig.SuccessResponseTemplate = "{{ .Enqueued }} queued"
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	// Only the first of the two alerts fits.
+	httpServer.AlertMsgs = make(chan AlertMsg, 1)
+
+	request := httptest.NewRequest("POST", "/somechannel",
+		strings.NewReader(testdataSimpleAlertJson))
+	request = mux.SetURLVars(request, map[string]string{"IRCChannel": "somechannel"})
+	responseRecorder := httptest.NewRecorder()
+	httpServer.RelayAlert(responseRecorder, request)
+
+	if responseRecorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 status, got %d", responseRecorder.Code)
+	}
+	if strings.Contains(responseRecorder.Body.String(), "queued") {
+		t.Errorf("Unexpected success body %q", responseRecorder.Body.String())
+	}
+}
+
+func TestConcurrentWebhooksLimited(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.MaxConcurrentRequests = 1
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+
+	relay := func() int {
+		request := httptest.NewRequest("POST", "/somechannel",
+			strings.NewReader(testdataSimpleAlertJson))
+		request = mux.SetURLVars(request, map[string]string{"IRCChannel": "somechannel"})
+		responseRecorder := httptest.NewRecorder()
+		httpServer.RelayAlert(responseRecorder, request)
+		return responseRecorder.Code
+	}
+
+	// Hold the only slot, as a webhook being processed.
+	httpServer.inFlight <- struct{}{}
+	if code := relay(); code != 503 {
+		t.Errorf("Expected 503 status over the limit, got %d", code)
+	}
+	<-httpServer.inFlight
+	if code := relay(); code != 200 {
+		t.Errorf("Expected 200 status within the limit, got %d", code)
+	}
+	if code := relay(); code != 200 {
+		t.Errorf("Expected the slot to be released, got %d", code)
+	}
+	if inFlight := testutil.ToFloat64(webhooksInFlight); inFlight != 0 {
+		t.Errorf("Expected no webhook in flight, got %f", inFlight)
+	}
+}
+
+func TestWebhookArchivedAfterListenerStopped(t *testing.T) {
+	archive, err := ioutil.TempFile("", "airtestarchive")
+	if err != nil {
+		t.Fatalf("Could not create tmpfile for testing: %s", err)
+	}
+	archive.Close()
+	defer os.Remove(archive.Name())
+
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.WebhookArchiveFile = archive.Name()
+	httpServer, err := NewHTTPServerForTesting(testingConfig,
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	go httpServer.Run()
+	<-listener.StartedServing
+	listener.StopServing <- true
+	<-httpServer.StoppedRunning
+
+	// Handlers still in flight once the listener returned keep archiving.
+	request := httptest.NewRequest("POST", "/somechannel",
+		strings.NewReader(testdataSimpleAlertJson))
+	request = mux.SetURLVars(request, map[string]string{"IRCChannel": "somechannel"})
+	httpServer.RelayAlert(httptest.NewRecorder(), request)
+	httpServer.closeSenders()
+
+	archived, err := ioutil.ReadFile(archive.Name())
+	if err != nil {
+		t.Fatalf("Could not read archive: %s", err)
+	}
+	if !strings.Contains(string(archived), `"channel":"#somechannel"`) {
+		t.Errorf("Expected the webhook archived, got: %s", archived)
+	}
+}
+
+func TestAlertMsgsEnqueuedAtReceipt(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	httpServer, err := NewHTTPServerForTesting(MakeHTTPTestingConfig(),
+		listener.AlertMsgs, listener.RawIRCLines, listener.Serve)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create HTTP server: %s", err))
+	}
+	clock := &fakeClock{now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	httpServer.timeNow = clock.Now
+
+	request := httptest.NewRequest("POST", "/somechannel",
+		strings.NewReader(testdataSimpleAlertJson))
+	request = mux.SetURLVars(request, map[string]string{"IRCChannel": "somechannel"})
+	httpServer.RelayAlert(httptest.NewRecorder(), request)
+
+	if alertMsg := <-listener.AlertMsgs; !alertMsg.EnqueuedAt.Equal(clock.now) {
+		t.Errorf("Expected the alert enqueued at %s, got %s",
+			clock.now, alertMsg.EnqueuedAt)
+	}
+}
+
+func TestOversizedWebhookRefused(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+
+	padding := strings.Repeat(" ", maxWebhookBodySize)
+	alert := strings.Replace(testdataSimpleAlertJson, `"status"`,
+		padding+`"status"`, 1)
+
+	response := RunHTTPTest(t, alert, "/somechannel", testingConfig, listener)
+
+	if response.StatusCode != 400 {
+		t.Errorf("Expected 400 status in response, got %d", response.StatusCode)
+	}
+	if len(listener.AlertMsgs) != 0 {
+		t.Errorf("Expected no alerts relayed, got %d", len(listener.AlertMsgs))
+	}
+}
+
+func TestAlertsRegrouped(t *testing.T) {
+	listener := NewFakeHTTPListener()
+	testingConfig := MakeHTTPTestingConfig()
+	testingConfig.RegroupBy = []string{"severity"}
+	testingConfig.MsgTemplate = "Alert {{ .GroupLabels.alertname }} " +
+		"{{ .GroupLabels.severity }} on {{ .CommonLabels.instance }} is {{ .Status }}"
+
+	// The second alert of the group is paged.
+	second := strings.Index(testdataSimpleAlertJson, "instance2")
+	payload := testdataSimpleAlertJson[:second] + strings.Replace(
+		testdataSimpleAlertJson[second:], "ticket", "page", 1)
+
+	expectedAlertMsgs := []AlertMsg{
+		AlertMsg{
+			Channel:  "#somechannel",
+			Alert:    "Alert airDown ticket on instance1:3456 is resolved",
+			StartsAt: time.Date(2017, 5, 15, 13, 49, 37, 834000000, time.UTC),
+		},
+		AlertMsg{
+			Channel:  "#somechannel",
+			Alert:    "Alert airDown page on instance2:7890 is resolved",
+			StartsAt: time.Date(2017, 5, 15, 11, 47, 37, 834000000, time.UTC),
+		},
+	}
+
+	RunHTTPTest(t, payload, "/somechannel", testingConfig, listener)
+
+	for i, expectedAlertMsg := range expectedAlertMsgs {
+		received := <-listener.AlertMsgs
+		if received.EndsBatch != (i == len(expectedAlertMsgs)-1) {
+			t.Errorf("Unexpected EndsBatch %t on message %d",
+				received.EndsBatch, i)
+		}
+		if received.batch() == received.GroupData {
+			t.Errorf("Message %d not tracked as part of the webhook", i)
+		}
+		alertMsg := renderAlertMsg(t, testingConfig, received)
+		if !reflect.DeepEqual(expectedAlertMsg, alertMsg) {
+			t.Error(fmt.Sprintf(
+				"Unexpected alert msg.\nExpected: %+v\nActual: %+v",
+				expectedAlertMsg, alertMsg))
+		}
+	}
+}
+
+func TestGroupStatus(t *testing.T) {
+	// The first alert is firing again, the group status follows.
+	payload := strings.Replace(testdataSimpleAlertJson,
+		`"startsAt": "2017-05-15T13:49:37.834Z",
+            "status": "resolved"`,
+		`"startsAt": "2017-05-15T13:49:37.834Z",
+            "status": "firing"`, 1)
+	// Senders other than Alertmanager may not set the group status.
+	noGroupStatus := strings.Replace(testdataSimpleAlertJson,
+		`"status": "resolved",`, "", 1)
+
+	for _, test := range []struct {
+		payload  string
+		msgOnce  bool
+		expected []string
+	}{
+		{testdataSimpleAlertJson, false, []string{"resolved/resolved", "resolved/resolved"}},
+		{payload, false, []string{"firing/firing", "firing/resolved"}},
+		{payload, true, []string{"firing"}},
+		{noGroupStatus, true, []string{"resolved"}},
+	} {
+		listener := NewFakeHTTPListener()
+		testingConfig := MakeHTTPTestingConfig()
+		testingConfig.MsgOnce = test.msgOnce
+		testingConfig.MsgTemplate = "{{ .GroupStatus }}/{{ .Status }}"
+		if test.msgOnce {
+			testingConfig.MsgTemplate = "{{ .Status }}"
+		}
+
+		RunHTTPTest(t, test.payload, "/somechannel", testingConfig, listener)
+
+		for _, expected := range test.expected {
+			alertMsg := renderAlertMsg(t, testingConfig, <-listener.AlertMsgs)
+			if alertMsg.Alert != expected {
+				t.Errorf("Expected %q, got %q", expected, alertMsg.Alert)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	idempotencyKeyPayload = "payload"
+	idempotencyKeyGroup   = "group"
+
+	// Bounds the webhooks remembered within the idempotency window.
+	maxIdempotencyKeys = 10000
+)
+
+var (
+	duplicateWebhooks = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "duplicate_webhooks",
+			Help: "Number of webhooks acknowledged without relaying them as duplicates received within the idempotency window"},
+		[]string{"ircchannel"},
+	)
+)
+
+// webhookIdempotency recognizes webhooks received again within its window,
+// e.g. retried by Alertmanager after a network hiccup. It is safe to use
+// from the HTTP handlers.
+type webhookIdempotency struct {
+	mu    sync.Mutex
+	key   string
+	dedup *Deduplicator
+}
+
+func newWebhookIdempotency(window time.Duration, key string) *webhookIdempotency {
+	if key == "" {
+		key = idempotencyKeyPayload
+	}
+	return &webhookIdempotency{key: key, dedup: NewDeduplicator(window)}
+}
+
+// Seen returns true if the same webhook for the same connection and channel
+// was received less than the window ago, recording it otherwise. Webhooks
+// are the same when their decoded payloads are, or when they have the same
+// group key and status when keyed by group. The oldest webhook is forgotten
+// past maxIdempotencyKeys.
+func (w *webhookIdempotency) Seen(connection string, channel string,
+	data *WebhookData, now time.Time) bool {
+	hash := sha256.New()
+	hash.Write([]byte(connection + "\x00" + channel + "\x00"))
+	if w.key == idempotencyKeyGroup {
+		hash.Write([]byte(data.groupKey() + "\x00" + data.Status))
+	} else {
+		payload, _ := json.Marshal(data)
+		hash.Write(payload)
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.dedup.Seen(key, now) {
+		return true
+	}
+	if w.dedup.Size() > maxIdempotencyKeys {
+		oldest := ""
+		for k, t := range w.dedup.seen {
+			if oldest == "" || t.Before(w.dedup.seen[oldest]) {
+				oldest = k
+			}
+		}
+		delete(w.dedup.seen, oldest)
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWebhookIdempotency(t *testing.T) {
+	now := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	idempotency := newWebhookIdempotency(time.Minute, idempotencyKeyPayload)
+
+	data := &WebhookData{}
+	if err := json.Unmarshal([]byte(testdataSimpleAlertJson), data); err != nil {
+		t.Fatal(err)
+	}
+	if idempotency.Seen("", "#foo", data, now) {
+		t.Error("Expected the first webhook not to be a duplicate")
+	}
+	if !idempotency.Seen("", "#foo", data, now.Add(time.Second)) {
+		t.Error("Expected the same webhook to be a duplicate")
+	}
+	if idempotency.Seen("", "#bar", data, now.Add(time.Second)) {
+		t.Error("Expected webhooks for other channels not to be duplicates")
+	}
+	if idempotency.Seen("other", "#foo", data, now.Add(time.Second)) {
+		t.Error("Expected webhooks for other connections not to be duplicates")
+	}
+
+	retried := *data
+	retried.Alerts = data.Alerts[:1]
+	if idempotency.Seen("", "#foo", &retried, now.Add(time.Second)) {
+		t.Error("Expected a different payload not to be a duplicate")
+	}
+	if idempotency.Seen("", "#foo", data, now.Add(2*time.Minute)) {
+		t.Error("Expected the webhook not to be a duplicate past the window")
+	}
+}
+
+func TestWebhookIdempotencyByGroup(t *testing.T) {
+	now := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	idempotency := newWebhookIdempotency(time.Minute, idempotencyKeyGroup)
+
+	data := &WebhookData{}
+	if err := json.Unmarshal([]byte(testdataSimpleAlertJson), data); err != nil {
+		t.Fatal(err)
+	}
+	idempotency.Seen("", "#foo", data, now)
+
+	retried := *data
+	retried.Alerts = data.Alerts[:1]
+	if !idempotency.Seen("", "#foo", &retried, now.Add(time.Second)) {
+		t.Error("Expected a webhook for the same group and status to be a duplicate")
+	}
+	retried.Status = "firing"
+	if idempotency.Seen("", "#foo", &retried, now.Add(time.Second)) {
+		t.Error("Expected a status change not to be a duplicate")
+	}
+}
@@ -0,0 +1,1470 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	irc "github.com/fluffle/goirc/client"
+	promtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	pingFrequencySecs          = 60
+	connectionTimeoutSecs      = 30
+	nickservWaitSecs           = 10
+	ircConnectMaxBackoffSecs   = 300
+	ircConnectBackoffResetSecs = 1800
+	staleAlertPrefix           = "(delayed) "
+	quietHoursCheckSecs        = 60
+	digestCheckSecs            = 10
+	hourlyCapCheckSecs         = 60
+	maxHeldAlertMsgs           = 100
+)
+
+var (
+	ircGaveUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "irc_reconnect_given_up",
+			Help: "Whether the maximum number of reconnection attempts was reached"},
+		[]string{"connection"},
+	)
+	ircRegisterTimeouts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "irc_register_timeouts",
+			Help: "Number of connections closed as registration did not complete in time"},
+		[]string{"connection"},
+	)
+	duplicateAlerts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "irc_duplicate_alerts",
+			Help: "Number of alerts dropped as duplicates"},
+		[]string{"ircchannel"},
+	)
+	unknownResolvedSuppressed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "irc_unknown_resolved_alerts",
+			Help: "Number of resolved alerts dropped as never seen firing"},
+		[]string{"ircchannel"},
+	)
+	quietHoursSuppressed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "irc_quiet_hours_suppressed_alerts",
+			Help: "Number of alerts dropped during quiet hours"},
+		[]string{"ircchannel"},
+	)
+	expiredAlerts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "irc_expired_alerts",
+			Help: "Number of alerts dropped after waiting longer than the max queue age"},
+		[]string{"ircchannel"},
+	)
+	hourlyCapSuppressed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "irc_hourly_cap_suppressed_alerts",
+			Help: "Number of alerts not sent over the hourly cap of their channel"},
+		[]string{"ircchannel"},
+	)
+	maintenanceSuppressed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "irc_maintenance_suppressed_alerts",
+			Help: "Number of alerts not sent during a maintenance window"},
+		[]string{"ircchannel"},
+	)
+	staleAlerts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "irc_stale_alerts",
+			Help: "Number of alerts delivered after the stale alert threshold"},
+		[]string{"ircchannel"},
+	)
+)
+
+func loggerHandler(_ *irc.Conn, line *irc.Line) {
+	log.Printf("Received: '%s'", line.Raw)
+}
+
+// JoinCommandData is passed to the on join command templates.
+type JoinCommandData struct {
+	Channel string
+	Nick    string
+}
+
+// ChannelState tracks a joined channel and its re-join backoff.
+type ChannelState struct {
+	Channel        IRCChannel
+	BackoffCounter Delayer
+	// When the last JOIN was sent.
+	JoinedAt time.Time
+}
+
+// IRCNotifier keeps the IRC session up and sends the queued alerts.
+type IRCNotifier struct {
+	// Nick stores the nickname specified in the config, because irc.Client
+	// might change its copy.
+	Nick           string
+	NickPassword   string
+	BouncerMode    bool
+	Client         *irc.Conn
+	StopRunning    chan bool
+	StoppedRunning chan bool
+	AlertMsgs      chan AlertMsg
+	RawIRCLines    chan string
+	Formatter      *Formatter
+	// Name of the IRC connection, labelling its metrics.
+	connection string
+	// Asks Run to log the state of the notifier, see DumpState.
+	stateDumps chan struct{}
+
+	// irc.Conn has a Connected() method that can tell us wether the TCP
+	// connection is up, and thus if we should trigger connect/disconnect.
+	// We need to track the session establishment also at a higher level to
+	// understand when the server has accepted us and thus when we can join
+	// channels, send notices, etc.
+	sessionUp         bool
+	sessionUpSignal   chan bool
+	sessionDownSignal chan bool
+
+	PreJoinChannels []IRCChannel
+	JoinedChannels  map[string]ChannelState
+	// Unlike JoinedChannels, only lists channels the server confirmed we
+	// joined, and can be read from other goroutines.
+	ChannelTracker *ChannelTracker
+	// Only read once built, on join commands are sent from the IRC client
+	// handlers.
+	onJoinCommands map[string][]*template.Template
+	// Footer templates by channel, for channels that have one, and the
+	// webhooks to these channels with messages delivered until the
+	// message ending their batch is handled.
+	footers     map[string]*template.Template
+	sentBatches map[*WebhookData]bool
+
+	UsePrivmsg bool
+	// Attach client tags to alert messages once message-tags is enabled.
+	messageTags bool
+	// Only set when sending the messages of alert groups as threads.
+	threads *threadTracker
+	// Transcodes messages to the IRC charset, nil for UTF-8.
+	encodeText func(string) string
+
+	StaleAlertThreshold time.Duration
+	PrefixStaleAlerts   bool
+
+	MaxQueueAge               time.Duration
+	MaxQueueAgeExemptResolved bool
+
+	// Only set when delivery acknowledgements are enabled.
+	AckSender *AckSender
+	// Only set when logging delivery attempts.
+	deliveryLogger *DeliveryLogger
+
+	// Drop alerts already sent within the dedup window, identified by their
+	// text or, if DedupByGroupKey is set, by their group key and status.
+	deduplicator    *Deduplicator
+	DedupByGroupKey bool
+
+	// Only set when suppressing resolved alerts never seen firing.
+	firingTracker *FiringTracker
+
+	MaintenanceWindows []MaintenanceWindow
+
+	// Alerts held back during the quiet hours of their channel, checked
+	// every QuietHoursCheckInterval to be sent once the quiet hours end.
+	QuietHoursCheckInterval time.Duration
+	quietHours              map[string]*QuietHours
+	heldAlertMsgs           map[string][]AlertMsg
+	timeNow                 TimeFunc
+
+	// Alerts to channels in digest mode, sent as a digest every
+	// DigestCheckInterval once due.
+	DigestCheckInterval time.Duration
+	digests             map[string]*digestBuffer
+	// Messages sent to channels with an hourly cap, whose windows are
+	// checked every HourlyCapCheckInterval for summaries to send.
+	HourlyCapCheckInterval time.Duration
+	capWindows             map[string]*capWindow
+
+	// Message template files are checked for changes every
+	// TemplateCheckInterval when watching them.
+	watchTemplates        bool
+	TemplateCheckInterval time.Duration
+
+	// Only set when pausing sending on repeated IRC errors. Alerts are held
+	// while it is open, checked every BreakerCheckInterval to be sent once
+	// it closes.
+	breaker              *CircuitBreaker
+	BreakerCheckInterval time.Duration
+	breakerHeldAlertMsgs []AlertMsg
+
+	// Only set by SetDeliveryPause. Alerts are held while paused, to be
+	// sent once signalled on resumed.
+	pause           *DeliveryPause
+	resumed         chan struct{}
+	pausedAlertMsgs []AlertMsg
+
+	// Rendered as the QUIT reason with the time Run started at and the
+	// alert messages delivered since.
+	quitTmpl  *template.Template
+	startedAt time.Time
+	delivered int
+
+	// Only set when sending an all clear to channels once their last
+	// firing alerts, as tracked by allClear, are resolved.
+	allClearTmpl *template.Template
+	allClear     allClearTracker
+
+	// Only set when sending a heartbeat to heartbeatChannel every
+	// HeartbeatInterval.
+	heartbeatChannel  string
+	heartbeatTmpl     *template.Template
+	HeartbeatInterval time.Duration
+
+	// Only set when telling adminChannel about reconnections, as tracked
+	// by reconnects.
+	adminChannel        string
+	reconnectNoticeTmpl *template.Template
+	reconnects          reconnectTracker
+
+	NickservDelayWait time.Duration
+	BackoffCounter    Delayer
+
+	JoinDelay   time.Duration
+	JoinStagger time.Duration
+	// Pre-join channels left to join once joinTimer fires.
+	pendingJoins []IRCChannel
+	joinTimer    *time.Timer
+	// Channels whose join the server did not confirm within
+	// JoinConfirmTimeout, if set, are joined again, checked every
+	// JoinConfirmCheckInterval.
+	JoinConfirmTimeout       time.Duration
+	JoinConfirmCheckInterval time.Duration
+	unconfirmedJoins         map[string]bool
+
+	// How queued alerts are drained when stopping, see drainAlertMsgs.
+	DrainMode       string
+	ShutdownTimeout time.Duration
+
+	// Set when connecting to the target of the SRV records of srvDomain,
+	// or to directServer without any.
+	srvDomain    string
+	directServer string
+	lookupSRV    func(string, string, string) (string, []*net.SRV, error)
+
+	MaxReconnectAttempts int
+	OnGiveUp             string
+	failedConnects       int
+	// GaveUp is set when the notifier stopped because it could not
+	// connect after MaxReconnectAttempts attempts.
+	GaveUp bool
+
+	// Connections not registered within RegisterTimeout, if set, are
+	// closed once registerTimer fires, to connect again.
+	RegisterTimeout time.Duration
+	registerTimer   *time.Timer
+
+	// Alerts that could not be sent while disconnected, at most
+	// ReconnectQueueSize per channel, sent in order once reconnected.
+	// requeuedChannels lists their channels in the order queued.
+	ReconnectQueueSize int
+	requeuedAlertMsgs  map[string][]AlertMsg
+	requeuedChannels   []string
+	requeued           int
+
+	// Only lists the channels including a sequence in their lines.
+	sequences sequenceCounters
+	// Channels not supporting formatting, by channel key.
+	plainChannels map[string]bool
+}
+
+// NewIRCNotifier returns a notifier sending the messages received on
+// alertMsgs and rawIRCLines, once Run.
+func NewIRCNotifier(config *Config, alertMsgs chan AlertMsg,
+	rawIRCLines chan string) (*IRCNotifier, error) {
+
+	formatter, err := NewFormatter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ircConfig := irc.NewConfig(config.IRCNick)
+	ircConfig.Me.Ident = config.IRCNick
+	ircConfig.Me.Name = config.IRCRealName
+	ircConfig.Server = strings.Join(
+		[]string{config.IRCHost, strconv.Itoa(config.IRCPort)}, ":")
+	ircConfig.SSL = config.IRCUseSSL
+	ircConfig.SSLConfig, err = newTLSConfig(config.IRCTLSMinVersion,
+		config.IRCTLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	ircConfig.SSLConfig.ServerName = config.IRCHost
+	ircConfig.PingFreq = pingFrequencySecs * time.Second
+	ircConfig.Timeout = connectionTimeoutSecs * time.Second
+	encodeText, err := newCharsetEncoder(config.IRCCharset)
+	if err != nil {
+		return nil, err
+	}
+	newNick, err := newNickFunc(config.IRCNickCollisionStrategy, config.IRCNick,
+		config.IRCNickMaxLength)
+	if err != nil {
+		return nil, err
+	}
+	ircConfig.NewNick = newNick
+	ircConfig.Pass = config.IRCPassword
+	ircConfig.LocalAddr = config.IRCLocalAddr
+	if config.IRCDialFallbackDelay != 0 {
+		ircConfig.Proxy = fallbackDialerURL(
+			config.IRCDialFallbackDelay, ircConfig.Timeout)
+	}
+	required := []string{}
+	if config.IRCMessageTags || config.IRCThreadGroups {
+		required = append(required, messageTagsCapability)
+	}
+	if config.IRCThreadGroups {
+		required = append(required, echoMessageCapability)
+	}
+	capabilities := withCapabilities(config.IRCCapabilities, required...)
+	if len(capabilities) > 0 {
+		// The dialer sends CAP LS 302 before registering, goirc then
+		// requests the listed capabilities that the server advertises
+		// and ends the negotiation once they are acknowledged or
+		// rejected.
+		tlsServerName := ""
+		if ircConfig.SSL {
+			tlsServerName = config.IRCHost
+			ircConfig.SSL = false
+		}
+		ircConfig.Proxy = capLSDialerURL(config.IRCDialFallbackDelay,
+			ircConfig.Timeout, tlsServerName, config.IRCTLSMinVersion,
+			config.IRCTLSCipherSuites)
+		ircConfig.Capabilites = capabilities
+	}
+
+	backoffCounter := NewBackoff(
+		ircConnectMaxBackoffSecs, ircConnectBackoffResetSecs,
+		time.Second)
+
+	notifier := &IRCNotifier{
+		Nick:                config.IRCNick,
+		NickPassword:        config.IRCNickPass,
+		BouncerMode:         config.IRCBouncerMode,
+		Client:              irc.Client(ircConfig),
+		StopRunning:         make(chan bool),
+		StoppedRunning:      make(chan bool),
+		stateDumps:          make(chan struct{}, 1),
+		AlertMsgs:           alertMsgs,
+		RawIRCLines:         rawIRCLines,
+		Formatter:           formatter,
+		sessionUpSignal:     make(chan bool),
+		sessionDownSignal:   make(chan bool),
+		PreJoinChannels:     config.IRCChannels,
+		JoinedChannels:      make(map[string]ChannelState),
+		ChannelTracker:      NewChannelTracker(config.connection()),
+		connection:          config.connection(),
+		onJoinCommands:      make(map[string][]*template.Template),
+		footers:             make(map[string]*template.Template),
+		sentBatches:         make(map[*WebhookData]bool),
+		UsePrivmsg:          config.UsePrivmsg,
+		messageTags:         config.IRCMessageTags,
+		encodeText:          encodeText,
+		StaleAlertThreshold: config.StaleAlertThreshold,
+		PrefixStaleAlerts:   config.PrefixStaleAlerts,
+		NickservDelayWait:   nickservWaitSecs * time.Second,
+		JoinDelay:           config.IRCJoinDelay,
+		JoinStagger:         config.IRCJoinStagger,
+		DrainMode:           config.DrainMode,
+		ShutdownTimeout:     config.ShutdownTimeout,
+		BackoffCounter:      backoffCounter,
+
+		JoinConfirmTimeout:       config.IRCJoinConfirmTimeout,
+		JoinConfirmCheckInterval: joinConfirmCheckSecs * time.Second,
+		unconfirmedJoins:         make(map[string]bool),
+
+		MaintenanceWindows: config.MaintenanceWindows,
+
+		MaxQueueAge:               config.MaxQueueAge,
+		MaxQueueAgeExemptResolved: config.MaxQueueAgeExemptResolved,
+
+		MaxReconnectAttempts: config.IRCMaxReconnectAttempts,
+		OnGiveUp:             config.OnGiveUp,
+		RegisterTimeout:      config.IRCRegisterTimeout,
+
+		ReconnectQueueSize: config.IRCReconnectQueueSize,
+		requeuedAlertMsgs:  make(map[string][]AlertMsg),
+
+		sequences:     newSequenceCounters(config.IRCChannels),
+		plainChannels: plainChannels(config.IRCChannels),
+
+		QuietHoursCheckInterval: quietHoursCheckSecs * time.Second,
+		quietHours:              make(map[string]*QuietHours),
+		heldAlertMsgs:           make(map[string][]AlertMsg),
+		timeNow:                 time.Now,
+
+		DigestCheckInterval: digestCheckSecs * time.Second,
+		digests:             make(map[string]*digestBuffer),
+
+		HourlyCapCheckInterval: hourlyCapCheckSecs * time.Second,
+		capWindows:             make(map[string]*capWindow),
+
+		BreakerCheckInterval: breakerCheckSecs * time.Second,
+
+		watchTemplates:        config.WatchTemplates,
+		TemplateCheckInterval: templateCheckSecs * time.Second,
+	}
+
+	parser, err := newTemplateParser(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.HeartbeatChannel != "" {
+		tmpl, err := parseHeartbeatTemplate(config.HeartbeatTemplate, parser)
+		if err != nil {
+			return nil, err
+		}
+		notifier.heartbeatChannel = config.HeartbeatChannel
+		notifier.heartbeatTmpl = tmpl
+		notifier.HeartbeatInterval = config.HeartbeatInterval
+	}
+	if config.AdminChannel != "" {
+		tmpl, err := parseReconnectNoticeTemplate(
+			config.ReconnectNoticeTemplate, parser)
+		if err != nil {
+			return nil, err
+		}
+		notifier.adminChannel = config.AdminChannel
+		notifier.reconnectNoticeTmpl = tmpl
+	}
+	notifier.reconnects.interval = config.ReconnectNoticeInterval
+
+	notifier.quitTmpl, err = parseQuitTemplate(config.IRCQuitMessage, parser)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier.allClearTmpl, err = parseAllClearTemplate(config.AllClearMessage, parser)
+	if err != nil {
+		return nil, err
+	}
+	notifier.allClear = make(allClearTracker)
+
+	if config.CircuitBreakerThreshold > 0 {
+		notifier.breaker = NewCircuitBreaker(config.connection(),
+			config.CircuitBreakerThreshold,
+			config.CircuitBreakerWindow, config.CircuitBreakerCooldown)
+	}
+
+	if config.IRCUseSRV {
+		notifier.srvDomain = config.IRCHost
+		notifier.directServer = ircConfig.Server
+		notifier.lookupSRV = net.LookupSRV
+	}
+
+	if config.AckCallbackURL != "" {
+		notifier.AckSender = NewAckSender(config.AckCallbackURL,
+			config.AckCallbackTimeout, config.AckCallbackMaxRetries)
+	}
+	if config.DeliveryLogFile != "" {
+		notifier.deliveryLogger, err = NewDeliveryLogger(
+			config.DeliveryLogFile, config.connection())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.DedupWindow > 0 {
+		notifier.deduplicator = NewDeduplicator(config.DedupWindow)
+		notifier.DedupByGroupKey = config.DedupByGroupKey
+	}
+
+	if config.SuppressUnknownResolved {
+		notifier.firingTracker = NewFiringTracker(
+			config.SuppressUnknownResolvedTTL, maxFiringFingerprints)
+	}
+
+	for _, channel := range config.IRCChannels {
+		if channel.QuietHours != nil {
+			notifier.quietHours[channel.Name] = channel.QuietHours
+		}
+		if channel.Digest != nil {
+			notifier.digests[channel.Name] = &digestBuffer{
+				digest: channel.Digest}
+		}
+		if channel.HourlyCap != nil {
+			notifier.capWindows[channel.Name] = &capWindow{
+				limit: channel.HourlyCap}
+		}
+		for _, command := range channel.OnJoinCommands {
+			tmpl, err := parser.parse("on_join", command)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", channel.Name, err)
+			}
+			notifier.onJoinCommands[channel.Name] = append(
+				notifier.onJoinCommands[channel.Name], tmpl)
+		}
+		footer, err := parseFooterTemplate(channel.FooterTemplate, parser)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", channel.Name, err)
+		}
+		if footer != nil {
+			notifier.footers[channel.Name] = footer
+		}
+	}
+
+	if config.IRCThreadGroups {
+		notifier.threads = newThreadTracker()
+		for _, event := range []string{irc.PRIVMSG, irc.NOTICE} {
+			notifier.Client.HandleFunc(event,
+				func(_ *irc.Conn, line *irc.Line) {
+					if len(line.Args) > 0 && line.Tags["msgid"] != "" &&
+						line.Nick == notifier.Client.Me().Nick {
+						notifier.threads.Echoed(
+							line.Args[0], line.Text(), line.Tags["msgid"])
+					}
+				})
+		}
+	}
+
+	notifier.Client.HandleFunc(irc.CONNECTED,
+		func(*irc.Conn, *irc.Line) {
+			log.Printf("Session established")
+			notifier.sessionUpSignal <- true
+		})
+
+	notifier.Client.HandleFunc(irc.DISCONNECTED,
+		func(*irc.Conn, *irc.Line) {
+			log.Printf("Disconnected from IRC")
+			notifier.ChannelTracker.Reset()
+			if notifier.threads != nil {
+				notifier.threads.Reset()
+			}
+			notifier.sessionDownSignal <- false
+		})
+
+	// NAMES replies, sent once a channel is joined.
+	notifier.Client.HandleFunc("353",
+		func(_ *irc.Conn, line *irc.Line) {
+			if len(line.Args) > 3 {
+				notifier.ChannelTracker.Names(
+					line.Args[2], strings.Fields(line.Args[3]))
+			}
+		})
+	notifier.Client.HandleFunc("366",
+		func(_ *irc.Conn, line *irc.Line) {
+			if len(line.Args) > 1 {
+				notifier.ChannelTracker.EndOfNames(line.Args[1])
+				notifier.SendOnJoinCommands(line.Args[1])
+			}
+		})
+
+	// Channel is invite only, we are banned, or the key is wrong.
+	for _, event := range []string{"473", "474", "475"} {
+		notifier.Client.HandleFunc(event,
+			func(_ *irc.Conn, line *irc.Line) {
+				if len(line.Args) > 1 {
+					notifier.HandleJoinError(line.Args[1], line.Cmd, line.Text())
+				}
+			})
+	}
+
+	// Messages or commands the server refused.
+	for _, event := range []string{"401", "403", "404", "442"} {
+		notifier.Client.HandleFunc(event,
+			func(_ *irc.Conn, line *irc.Line) {
+				log.Printf("IRC error %s: %s", line.Cmd, line.Text())
+				notifier.recordIRCError()
+			})
+	}
+
+	// Keep the member lists current. Our own JOIN is followed by NAMES
+	// replies, and our own KICK is handled below.
+	notifier.Client.HandleFunc(irc.JOIN,
+		func(_ *irc.Conn, line *irc.Line) {
+			if len(line.Args) > 0 && line.Nick != notifier.Client.Me().Nick {
+				notifier.ChannelTracker.MemberJoined(line.Args[0], line.Nick)
+			}
+		})
+	notifier.Client.HandleFunc(irc.PART,
+		func(_ *irc.Conn, line *irc.Line) {
+			if len(line.Args) == 0 {
+				return
+			}
+			if line.Nick == notifier.Client.Me().Nick {
+				notifier.ChannelTracker.Left(line.Args[0])
+				return
+			}
+			notifier.ChannelTracker.MemberLeft(line.Args[0], line.Nick)
+		})
+	notifier.Client.HandleFunc(irc.QUIT,
+		func(_ *irc.Conn, line *irc.Line) {
+			notifier.ChannelTracker.MemberQuit(line.Nick)
+		})
+	notifier.Client.HandleFunc(irc.NICK,
+		func(_ *irc.Conn, line *irc.Line) {
+			if len(line.Args) > 0 {
+				notifier.ChannelTracker.MemberRenamed(line.Nick, line.Args[0])
+			}
+		})
+
+	notifier.Client.HandleFunc(irc.KICK,
+		func(_ *irc.Conn, line *irc.Line) {
+			notifier.HandleKick(line.Args[1], line.Args[0])
+		})
+
+	for _, event := range []string{irc.NOTICE, irc.CAP, "433"} {
+		notifier.Client.HandleFunc(event, loggerHandler)
+	}
+
+	return notifier, nil
+}
+
+func (notifier *IRCNotifier) HandleKick(nick string, channel string) {
+	if nick != notifier.Client.Me().Nick {
+		// received kick info for somebody else
+		notifier.ChannelTracker.MemberLeft(channel, nick)
+		return
+	}
+	state, ok := notifier.JoinedChannels[channelKey(channel)]
+	if ok == false {
+		log.Printf("Being kicked out of non-joined channel (%s), ignoring", channel)
+		return
+	}
+	notifier.ChannelTracker.Left(channel)
+	notifier.recordIRCError()
+	log.Printf("Being kicked out of %s, re-joining", channel)
+	go func() {
+		state.BackoffCounter.Delay()
+		notifier.Client.Join(state.Channel.Name, state.Channel.Password)
+	}()
+
+}
+
+func (notifier *IRCNotifier) recordIRCError() {
+	if notifier.breaker != nil {
+		notifier.breaker.Failure(notifier.timeNow())
+	}
+}
+
+// HandleJoinError stops trying to join channel, as retrying would only get
+// the same error until someone changes the channel or unblocks it.
+func (notifier *IRCNotifier) HandleJoinError(channel string, numeric string,
+	reason string) {
+	log.Printf("Could not join %s (%s: %s), not retrying until unblocked",
+		channel, numeric, reason)
+	notifier.ChannelTracker.Block(channel, fmt.Sprintf("%s: %s", numeric, reason))
+}
+
+func (notifier *IRCNotifier) SendOnJoinCommands(channel string) {
+	data := JoinCommandData{
+		Channel: channel,
+		Nick:    notifier.Client.Me().Nick,
+	}
+	for _, tmpl := range notifier.onJoinCommands[channel] {
+		output := bytes.Buffer{}
+		if err := tmpl.Execute(&output, data); err != nil {
+			log.Printf("Could not render on join command for %s: %s",
+				channel, err)
+			continue
+		}
+		command := output.String()
+		if strings.ContainsAny(command, "\r\n") {
+			log.Printf("Not sending multi-line on join command for %s",
+				channel)
+			continue
+		}
+		log.Printf("Sending on join command for %s: %s", channel, command)
+		notifier.Client.Raw(command)
+	}
+}
+
+func (notifier *IRCNotifier) CleanupChannels() {
+	log.Printf("Deregistering all channels.")
+	notifier.JoinedChannels = make(map[string]ChannelState)
+}
+
+// JoinChannel joins channel unless already done, and returns whether
+// messages can be sent to it.
+func (notifier *IRCNotifier) JoinChannel(channel *IRCChannel) bool {
+	if reason, blocked := notifier.ChannelTracker.Blocked(channel.Name); blocked {
+		// Forget it, to join again once unblocked.
+		delete(notifier.JoinedChannels, channelKey(channel.Name))
+		log.Printf("Not joining blocked channel %s (%s)", channel.Name, reason)
+		return false
+	}
+	if _, joined := notifier.JoinedChannels[channelKey(channel.Name)]; joined == true {
+		return true
+	}
+	log.Printf("Joining %s", channel.Name)
+	notifier.Client.Join(channel.Name, channel.Password)
+	state := ChannelState{
+		Channel: *channel,
+		BackoffCounter: NewBackoff(
+			ircConnectMaxBackoffSecs, ircConnectBackoffResetSecs,
+			time.Second),
+		JoinedAt: notifier.timeNow(),
+	}
+	notifier.JoinedChannels[channelKey(channel.Name)] = state
+	return true
+}
+
+// JoinChannels joins the pre-join channels once registered, after
+// JoinDelay and JoinStagger apart, as joining too fast is taken for spam on
+// some networks. The waits are scheduled on joinTimer, for Run to keep
+// handling alerts meanwhile.
+func (notifier *IRCNotifier) JoinChannels() {
+	notifier.pendingJoins = append([]IRCChannel{}, notifier.PreJoinChannels...)
+	if notifier.JoinDelay > 0 {
+		log.Printf("Waiting %s before joining channels", notifier.JoinDelay)
+		notifier.scheduleJoins(notifier.JoinDelay)
+		return
+	}
+	notifier.JoinPendingChannels()
+}
+
+// JoinPendingChannels joins the pre-join channels left to join, scheduling
+// the next one after JoinStagger if set.
+func (notifier *IRCNotifier) JoinPendingChannels() {
+	for len(notifier.pendingJoins) > 0 {
+		channel := notifier.pendingJoins[0]
+		notifier.pendingJoins = notifier.pendingJoins[1:]
+		notifier.JoinChannel(&channel)
+		if notifier.JoinStagger > 0 && len(notifier.pendingJoins) > 0 {
+			notifier.scheduleJoins(notifier.JoinStagger)
+			return
+		}
+	}
+}
+
+func (notifier *IRCNotifier) scheduleJoins(delay time.Duration) {
+	notifier.cancelJoins()
+	notifier.joinTimer = time.NewTimer(delay)
+}
+
+// cancelJoins stops the pending joins, e.g. when disconnected.
+func (notifier *IRCNotifier) cancelJoins() {
+	if notifier.joinTimer != nil {
+		notifier.joinTimer.Stop()
+		notifier.joinTimer = nil
+	}
+}
+
+// joinTimerC is never ready when no join is scheduled.
+func (notifier *IRCNotifier) joinTimerC() <-chan time.Time {
+	if notifier.joinTimer == nil {
+		return nil
+	}
+	return notifier.joinTimer.C
+}
+
+// startRegisterTimer times the registration of a new connection, if
+// RegisterTimeout is set.
+func (notifier *IRCNotifier) startRegisterTimer() {
+	notifier.stopRegisterTimer()
+	if notifier.RegisterTimeout > 0 {
+		notifier.registerTimer = time.NewTimer(notifier.RegisterTimeout)
+	}
+}
+
+func (notifier *IRCNotifier) stopRegisterTimer() {
+	if notifier.registerTimer != nil {
+		notifier.registerTimer.Stop()
+		notifier.registerTimer = nil
+	}
+}
+
+// registerTimerC is never ready when registration is not timed.
+func (notifier *IRCNotifier) registerTimerC() <-chan time.Time {
+	if notifier.registerTimer == nil {
+		return nil
+	}
+	return notifier.registerTimer.C
+}
+
+func (notifier *IRCNotifier) MaybeIdentifyNick() {
+	if notifier.NickPassword == "" {
+		return
+	}
+	if notifier.BouncerMode {
+		log.Printf("Bouncer mode, leaving NickServ identification to the bouncer")
+		return
+	}
+
+	// Very lazy/optimistic, but this is good enough for my irssi config,
+	// so it should work here as well.
+	currentNick := notifier.Client.Me().Nick
+	if currentNick != notifier.Nick {
+		log.Printf("My nick is '%s', sending GHOST to NickServ to get '%s'",
+			currentNick, notifier.Nick)
+		notifier.Client.Privmsgf("NickServ", "GHOST %s %s", notifier.Nick,
+			notifier.NickPassword)
+		time.Sleep(notifier.NickservDelayWait)
+
+		log.Printf("Changing nick to '%s'", notifier.Nick)
+		notifier.Client.Nick(notifier.Nick)
+	}
+	log.Printf("Sending IDENTIFY to NickServ")
+	notifier.Client.Privmsgf("NickServ", "IDENTIFY %s", notifier.NickPassword)
+	time.Sleep(notifier.NickservDelayWait)
+}
+
+func (notifier *IRCNotifier) MaybeSendAlertMsg(alertMsg *AlertMsg) {
+	sent := notifier.sendAlertMsg(alertMsg)
+	if sent {
+		notifier.delivered++
+	}
+	if notifier.deliveryLogger != nil {
+		notifier.deliveryLogger.Log(alertMsg, sent, notifier.timeNow())
+	}
+	notifier.trackBatch(alertMsg, sent)
+}
+
+// sendAlertMsg sends alertMsg unless it is dropped or held, returning
+// whether it was sent.
+func (notifier *IRCNotifier) sendAlertMsg(alertMsg *AlertMsg) bool {
+	if notifier.holdWhilePaused(alertMsg) {
+		return false
+	}
+	if !notifier.sessionUp {
+		if notifier.requeueWhileDisconnected(alertMsg) {
+			log.Printf("IRC not connected, queueing alert to %s",
+				alertMsg.Channel)
+			return false
+		}
+		log.Printf("Cannot send alert to %s : IRC not connected",
+			alertMsg.Channel)
+		return false
+	}
+	if window := activeMaintenanceWindow(notifier.MaintenanceWindows,
+		alertMsg, notifier.timeNow()); window != nil {
+		log.Printf("Maintenance window %s active, dropping alert to %s",
+			window.Name, alertMsg.Channel)
+		maintenanceSuppressed.WithLabelValues(alertMsg.Channel).Inc()
+		return false
+	}
+	if notifier.holdDuringQuietHours(alertMsg) {
+		return false
+	}
+	if buffer, ok := notifier.digests[alertMsg.Channel]; ok {
+		buffer.Add(alertMsg, notifier.timeNow())
+		return false
+	}
+	if notifier.isUnknownResolved(alertMsg) {
+		log.Printf("Dropping resolved alert to %s never seen firing",
+			alertMsg.Channel)
+		unknownResolvedSuppressed.WithLabelValues(alertMsg.Channel).Inc()
+		return false
+	}
+	if notifier.holdWhileBreakerOpen(alertMsg) {
+		return false
+	}
+	if !notifier.JoinChannel(&IRCChannel{Name: alertMsg.Channel}) {
+		log.Printf("Dropping alert to blocked channel %s", alertMsg.Channel)
+		return false
+	}
+
+	lines := notifier.Formatter.RenderMsgLines(alertMsg)
+	if len(lines) == 0 {
+		log.Printf("Alert to %s rendered to nothing, skipping",
+			alertMsg.Channel)
+		return false
+	}
+	msg := strings.Join(lines, "\n")
+	if notifier.deduplicator != nil &&
+		notifier.deduplicator.Seen(notifier.dedupKey(alertMsg, msg), notifier.timeNow()) {
+		log.Printf("Dropping duplicate alert to %s: %s", alertMsg.Channel, msg)
+		duplicateAlerts.WithLabelValues(alertMsg.Channel).Inc()
+		return false
+	}
+	if notifier.overHourlyCap(alertMsg) {
+		return false
+	}
+	if notifier.isStale(alertMsg) {
+		staleAlerts.WithLabelValues(alertMsg.Channel).Inc()
+		if notifier.PrefixStaleAlerts {
+			lines[0] = staleAlertPrefix + lines[0]
+		}
+	}
+
+	tags := []string{}
+	if notifier.messageTags &&
+		notifier.Client.HasCapability(messageTagsCapability) {
+		if alertTags := alertMsgTags(alertMsg); alertTags != "" {
+			tags = append(tags, alertTags)
+		}
+	}
+	if replyTo := notifier.threadReply(alertMsg, lines[0]); replyTo != "" {
+		tags = append(tags, replyTag+"="+tagValueEscaper.Replace(replyTo))
+	}
+	notifier.sendTaggedLines(alertMsg.Channel, strings.Join(tags, ";"), lines)
+	notifier.maybeSendAllClear(alertMsg)
+	return true
+}
+
+// threadReply returns the msgid of the first message of the group of
+// alertMsg for it to reply to, if threads are enabled and supported. The
+// first message, whose first line is text, starts the thread otherwise.
+func (notifier *IRCNotifier) threadReply(alertMsg *AlertMsg, text string) string {
+	if notifier.threads == nil || alertMsg.GroupData == nil ||
+		alertMsg.GroupData.GroupKey == "" ||
+		!notifier.Client.HasCapability(messageTagsCapability) ||
+		!notifier.Client.HasCapability(echoMessageCapability) {
+		return ""
+	}
+	channel, groupKey := alertMsg.Channel, alertMsg.GroupData.GroupKey
+	msgid, started := notifier.threads.Root(channel, groupKey)
+	if !started {
+		if notifier.encodeText != nil {
+			text = notifier.encodeText(text)
+		}
+		notifier.threads.Started(channel, groupKey, text)
+	}
+	if alertMsg.GroupData.Status == "resolved" {
+		notifier.threads.Forget(channel, groupKey)
+	}
+	return msgid
+}
+
+// trackBatch sends the footer of the channel once the last message of a
+// webhook was handled, if any message of the webhook was sent, whatever
+// happened to the last one.
+func (notifier *IRCNotifier) trackBatch(alertMsg *AlertMsg, sent bool) {
+	if _, ok := notifier.footers[alertMsg.Channel]; !ok || alertMsg.batch() == nil {
+		return
+	}
+	if sent {
+		// The end of batches whose last message was lost before reaching
+		// the IRC routine is never seen.
+		if len(notifier.sentBatches) >= maxSentBatches {
+			notifier.sentBatches = make(map[*WebhookData]bool)
+		}
+		notifier.sentBatches[alertMsg.batch()] = true
+	}
+	if !alertMsg.EndsBatch {
+		return
+	}
+	if notifier.sentBatches[alertMsg.batch()] {
+		delete(notifier.sentBatches, alertMsg.batch())
+		notifier.sendFooter(alertMsg)
+	}
+}
+
+// sendFooter sends the footer of the channel, if any, after the last message
+// of a webhook.
+func (notifier *IRCNotifier) sendFooter(alertMsg *AlertMsg) {
+	tmpl, ok := notifier.footers[alertMsg.Channel]
+	if !ok || alertMsg.batch() == nil || !notifier.sessionUp {
+		return
+	}
+	data := newFooterData(alertMsg.Channel, alertMsg.batch(), notifier.timeNow())
+	msg := notifier.Formatter.execute(tmpl, data)
+	if msg == "" {
+		return
+	}
+	notifier.sendLines(alertMsg.Channel, notifier.Formatter.splitLines([]string{msg}))
+}
+
+func (notifier *IRCNotifier) sendLines(channel string, lines []string) {
+	notifier.sendTaggedLines(channel, "", lines)
+}
+
+// sendTaggedLines sends lines to channel, each with the message tags in
+// tags unless empty.
+func (notifier *IRCNotifier) sendTaggedLines(channel string, tags string,
+	lines []string) {
+	command := irc.NOTICE
+	if notifier.UsePrivmsg {
+		command = irc.PRIVMSG
+	}
+	plain := notifier.plainChannels[channelKey(channel)]
+	for _, line := range lines {
+		if plain {
+			if line = stripFormatting(line); line == "" {
+				continue
+			}
+		}
+		line = notifier.sequences.prefix(channel) + line
+		if notifier.encodeText != nil {
+			line = notifier.encodeText(line)
+		}
+		switch {
+		case tags != "":
+			notifier.Client.Raw(
+				"@" + tags + " " + command + " " + channel + " :" + line)
+		case notifier.UsePrivmsg:
+			notifier.Client.Privmsg(channel, line)
+		default:
+			notifier.Client.Notice(channel, line)
+		}
+	}
+	if notifier.AckSender != nil {
+		notifier.AckSender.Ack(
+			channel, strings.Join(lines, "\n"), notifier.timeNow())
+	}
+}
+
+// SendDueDigests sends the digests of channels whose interval has elapsed.
+func (notifier *IRCNotifier) SendDueDigests() {
+	if !notifier.sessionUp {
+		return
+	}
+	now := notifier.timeNow()
+	for channel, buffer := range notifier.digests {
+		data, due := buffer.Flush(channel, now)
+		if !due {
+			continue
+		}
+		if data.Dropped > 0 {
+			log.Printf("Digest buffer for %s was full, %d alerts dropped",
+				channel, data.Dropped)
+		}
+		if !notifier.JoinChannel(&IRCChannel{Name: channel}) {
+			log.Printf("Dropping digest to blocked channel %s", channel)
+			continue
+		}
+		msg := notifier.Formatter.execute(buffer.digest.tmpl, data)
+		notifier.sendLines(channel, notifier.Formatter.splitLines([]string{msg}))
+	}
+}
+
+// overHourlyCap returns true if alertMsg must not be sent as its channel
+// reached its hourly cap, sending the notice instead the first time.
+func (notifier *IRCNotifier) overHourlyCap(alertMsg *AlertMsg) bool {
+	window, ok := notifier.capWindows[alertMsg.Channel]
+	if !ok {
+		return false
+	}
+	now := notifier.timeNow()
+	notifier.expireCapWindow(alertMsg.Channel, window, now)
+	allowed, first := window.Allow(now)
+	if allowed {
+		return false
+	}
+	hourlyCapSuppressed.WithLabelValues(alertMsg.Channel).Inc()
+	if first {
+		log.Printf("Hourly cap of %s reached, suppressing alerts",
+			alertMsg.Channel)
+		notifier.sendLines(alertMsg.Channel, []string{capNotice(window.limit)})
+	}
+	return true
+}
+
+// SendCapSummaries ends the hourly cap windows that are over, sending the
+// summary of the alerts they suppressed if configured so.
+func (notifier *IRCNotifier) SendCapSummaries() {
+	if !notifier.sessionUp {
+		return
+	}
+	now := notifier.timeNow()
+	for channel, window := range notifier.capWindows {
+		notifier.expireCapWindow(channel, window, now)
+	}
+}
+
+// expireCapWindow ends the hourly cap window of channel if over at now,
+// sending its summary if any.
+func (notifier *IRCNotifier) expireCapWindow(channel string, window *capWindow,
+	now time.Time) {
+	suppressed := window.Expire(now)
+	if suppressed == 0 {
+		return
+	}
+	if !notifier.JoinChannel(&IRCChannel{Name: channel}) {
+		log.Printf("Dropping hourly cap summary to blocked channel %s", channel)
+		return
+	}
+	notifier.sendLines(channel, []string{capSummary(window.limit, suppressed)})
+}
+
+// isUnknownResolved records the firing alerts of alertMsg, and returns true
+// if all its alerts are resolved and none of them was seen firing. Alerts
+// without fingerprint cannot be tracked and are always known.
+func (notifier *IRCNotifier) isUnknownResolved(alertMsg *AlertMsg) bool {
+	if notifier.firingTracker == nil {
+		return false
+	}
+	var alerts []promtmpl.Alert
+	switch {
+	case alertMsg.AlertData != nil:
+		alerts = []promtmpl.Alert{*alertMsg.AlertData}
+	case alertMsg.GroupData != nil:
+		alerts = alertMsg.GroupData.Alerts
+	default:
+		return false
+	}
+	now := notifier.timeNow()
+	known := false
+	for _, alert := range alerts {
+		switch {
+		case alert.Fingerprint == "":
+			known = true
+		case alert.Status == "firing":
+			notifier.firingTracker.Fired(alert.Fingerprint, now)
+			known = true
+		case notifier.firingTracker.Resolved(alert.Fingerprint, now):
+			known = true
+		}
+	}
+	return !known
+}
+
+func (notifier *IRCNotifier) dedupKey(alertMsg *AlertMsg, msg string) string {
+	group := alertMsg.GroupData
+	if !notifier.DedupByGroupKey || group == nil || group.groupKey() == "" {
+		return strings.Join([]string{alertMsg.Channel, msg}, "\x00")
+	}
+	key := []string{alertMsg.Channel, group.groupKey(), group.Status}
+	if alertMsg.AlertData != nil {
+		key = append(key,
+			alertMsg.AlertData.Fingerprint, alertMsg.AlertData.Status)
+	} else {
+		// Alerts joining or leaving a group still firing are not
+		// duplicates.
+		alerts := []string{}
+		for _, alert := range group.Alerts {
+			alerts = append(alerts, alert.Fingerprint+"="+alert.Status)
+		}
+		sort.Strings(alerts)
+		key = append(key, alerts...)
+	}
+	return strings.Join(key, "\x00")
+}
+
+// holdDuringQuietHours returns true if alertMsg must not be sent now because
+// of the quiet hours of its channel, queueing it if configured so.
+func (notifier *IRCNotifier) holdDuringQuietHours(alertMsg *AlertMsg) bool {
+	quietHours, ok := notifier.quietHours[alertMsg.Channel]
+	if !ok || !quietHours.Active(notifier.timeNow()) ||
+		!quietHours.Holds(alertMsgSeverity(alertMsg)) {
+		return false
+	}
+	if quietHours.Action == quietHoursSuppress {
+		log.Printf("Quiet hours in %s, dropping alert", alertMsg.Channel)
+		quietHoursSuppressed.WithLabelValues(alertMsg.Channel).Inc()
+		return true
+	}
+	held := notifier.heldAlertMsgs[alertMsg.Channel]
+	if len(held) >= maxHeldAlertMsgs {
+		log.Printf("Too many alerts held for %s, dropping the oldest",
+			alertMsg.Channel)
+		quietHoursSuppressed.WithLabelValues(alertMsg.Channel).Inc()
+		held = held[1:]
+	}
+	log.Printf("Quiet hours in %s, holding alert until they end",
+		alertMsg.Channel)
+	notifier.heldAlertMsgs[alertMsg.Channel] = append(held, *alertMsg)
+	return true
+}
+
+// SendHeldAlertMsgs sends the alerts held for channels whose quiet hours
+// have ended.
+func (notifier *IRCNotifier) SendHeldAlertMsgs() {
+	if !notifier.sessionUp {
+		return
+	}
+	now := notifier.timeNow()
+	for channel, held := range notifier.heldAlertMsgs {
+		if notifier.quietHours[channel].Active(now) {
+			continue
+		}
+		log.Printf("Quiet hours ended in %s, sending %d held alerts",
+			channel, len(held))
+		delete(notifier.heldAlertMsgs, channel)
+		for i := range held {
+			notifier.MaybeSendAlertMsg(&held[i])
+		}
+	}
+}
+
+func (notifier *IRCNotifier) holdWhileBreakerOpen(alertMsg *AlertMsg) bool {
+	if notifier.breaker == nil || notifier.breaker.Allow(notifier.timeNow()) {
+		return false
+	}
+	held := notifier.breakerHeldAlertMsgs
+	if len(held) >= maxBreakerHeldAlertMsgs {
+		log.Printf("Too many alerts held after IRC errors, dropping the oldest")
+		held = held[1:]
+	}
+	log.Printf("Sending paused after IRC errors, holding alert to %s",
+		alertMsg.Channel)
+	notifier.breakerHeldAlertMsgs = append(held, *alertMsg)
+	circuitBreakerHeldAlerts.WithLabelValues(notifier.connection).Set(
+		float64(len(notifier.breakerHeldAlertMsgs)))
+	return true
+}
+
+// SendBreakerHeldAlertMsgs sends the alerts held while sending was paused
+// after IRC errors, once resumed.
+func (notifier *IRCNotifier) SendBreakerHeldAlertMsgs() {
+	if !notifier.sessionUp || len(notifier.breakerHeldAlertMsgs) == 0 ||
+		!notifier.breaker.Allow(notifier.timeNow()) {
+		return
+	}
+	held := notifier.breakerHeldAlertMsgs
+	log.Printf("Sending %d alerts held after IRC errors", len(held))
+	notifier.breakerHeldAlertMsgs = nil
+	circuitBreakerHeldAlerts.WithLabelValues(notifier.connection).Set(0)
+	for i := range held {
+		notifier.MaybeSendAlertMsg(&held[i])
+	}
+}
+
+// isStale returns true if alertMsg is delivered long after its alert started
+// firing. Unlike the max queue age, this includes the time spent in
+// Alertmanager before the webhook was sent.
+func (notifier *IRCNotifier) isStale(alertMsg *AlertMsg) bool {
+	if notifier.StaleAlertThreshold == 0 || alertMsg.StartsAt.IsZero() {
+		return false
+	}
+	latency := notifier.timeNow().Sub(alertMsg.StartsAt)
+	if latency <= notifier.StaleAlertThreshold {
+		return false
+	}
+	log.Printf("Alert to %s is delivered %s after it started, above the %s threshold",
+		alertMsg.Channel, latency, notifier.StaleAlertThreshold)
+	return true
+}
+
+// isExpired returns true if alertMsg waited in the queue for longer than
+// the max queue age.
+func (notifier *IRCNotifier) isExpired(alertMsg *AlertMsg) bool {
+	if notifier.MaxQueueAge == 0 || alertMsg.EnqueuedAt.IsZero() {
+		return false
+	}
+	if notifier.MaxQueueAgeExemptResolved && alertMsgStatus(alertMsg) == "resolved" {
+		return false
+	}
+	age := notifier.timeNow().Sub(alertMsg.EnqueuedAt)
+	if age <= notifier.MaxQueueAge {
+		return false
+	}
+	log.Printf("Dropping alert to %s queued %s ago, above the %s max queue age",
+		alertMsg.Channel, age, notifier.MaxQueueAge)
+	expiredAlerts.WithLabelValues(alertMsg.Channel).Inc()
+	return true
+}
+
+func (notifier *IRCNotifier) MaybeSendRawLine(line string) {
+	if !notifier.sessionUp {
+		log.Printf("Cannot send raw line: IRC not connected")
+		return
+	}
+	log.Printf("Sending raw line: %s", line)
+	notifier.Client.Raw(line)
+}
+
+// resolveServer updates the server to connect to from the SRV records, if
+// enabled. net.LookupSRV already sorts them by priority and randomizes them
+// by weight, so the first one is used. Without any SRV record, the
+// configured host and port are used.
+func (notifier *IRCNotifier) resolveServer() {
+	if notifier.srvDomain == "" {
+		return
+	}
+	service := "irc"
+	if notifier.Client.Config().SSL {
+		service = "ircs"
+	}
+	_, records, err := notifier.lookupSRV(service, "tcp", notifier.srvDomain)
+	if err != nil || len(records) == 0 {
+		log.Printf("No _%s._tcp SRV record for %s, connecting directly: %v",
+			service, notifier.srvDomain, err)
+		notifier.Client.Config().Server = notifier.directServer
+		return
+	}
+	server := net.JoinHostPort(strings.TrimSuffix(records[0].Target, "."),
+		strconv.Itoa(int(records[0].Port)))
+	log.Printf("Using %s from the SRV records of %s", server, notifier.srvDomain)
+	notifier.Client.Config().Server = server
+}
+
+// maybeGiveUp records a failed connection attempt and returns true if the
+// notifier should stop running.
+func (notifier *IRCNotifier) maybeGiveUp() bool {
+	notifier.failedConnects++
+	if notifier.MaxReconnectAttempts == 0 ||
+		notifier.failedConnects < notifier.MaxReconnectAttempts {
+		return false
+	}
+	if notifier.failedConnects == notifier.MaxReconnectAttempts {
+		log.Printf("Could not connect to IRC after %d attempts, giving up",
+			notifier.failedConnects)
+	}
+	ircGaveUp.WithLabelValues(notifier.connection).Set(1)
+	if notifier.OnGiveUp == giveUpUnready {
+		// Keep trying, monitoring is expected to act on the metric.
+		return false
+	}
+	notifier.GaveUp = true
+	return true
+}
+
+// Run connects to IRC and sends messages until asked to stop on
+// StopRunning, or until giving up reconnecting, then signals StoppedRunning.
+func (notifier *IRCNotifier) Run() {
+	notifier.startedAt = notifier.timeNow()
+	quietHoursTicker := time.NewTicker(notifier.QuietHoursCheckInterval)
+	defer quietHoursTicker.Stop()
+	digestTicker := time.NewTicker(notifier.DigestCheckInterval)
+	defer digestTicker.Stop()
+	hourlyCapTicker := time.NewTicker(notifier.HourlyCapCheckInterval)
+	defer hourlyCapTicker.Stop()
+	breakerTicker := time.NewTicker(notifier.BreakerCheckInterval)
+	defer breakerTicker.Stop()
+	// Never ready when heartbeats are disabled.
+	var heartbeats <-chan time.Time
+	if notifier.heartbeatTmpl != nil {
+		heartbeatTicker := time.NewTicker(notifier.HeartbeatInterval)
+		defer heartbeatTicker.Stop()
+		heartbeats = heartbeatTicker.C
+	}
+	var joinConfirmChecks <-chan time.Time
+	if notifier.JoinConfirmTimeout > 0 {
+		joinConfirmTicker := time.NewTicker(notifier.JoinConfirmCheckInterval)
+		defer joinConfirmTicker.Stop()
+		joinConfirmChecks = joinConfirmTicker.C
+	}
+	var templateChecks <-chan time.Time
+	if notifier.watchTemplates {
+		templateTicker := time.NewTicker(notifier.TemplateCheckInterval)
+		defer templateTicker.Stop()
+		templateChecks = templateTicker.C
+	}
+
+	keepGoing := true
+	for keepGoing {
+		if !notifier.Client.Connected() {
+			log.Printf("Connecting to IRC")
+			notifier.BackoffCounter.Delay()
+			notifier.resolveServer()
+			// The client keeps the nick picked on collision, try to get
+			// the configured one back.
+			notifier.Client.Config().Me.Nick = notifier.Nick
+			if err := notifier.Client.Connect(); err != nil {
+				log.Printf("Could not connect to IRC: %s", err)
+				notifier.reconnects.failed()
+				if notifier.maybeGiveUp() {
+					keepGoing = false
+					continue
+				}
+				select {
+				case <-notifier.StopRunning:
+					log.Printf("IRC routine not connected but asked to terminate")
+					keepGoing = false
+				default:
+				}
+				continue
+			}
+			log.Printf("Connected to IRC server, waiting to establish session")
+			notifier.startRegisterTimer()
+			notifier.failedConnects = 0
+			ircGaveUp.WithLabelValues(notifier.connection).Set(0)
+		}
+
+		select {
+		case alertMsg := <-notifier.AlertMsgs:
+			if !notifier.isExpired(&alertMsg) {
+				notifier.MaybeSendAlertMsg(&alertMsg)
+			} else {
+				notifier.trackBatch(&alertMsg, false)
+			}
+		case line := <-notifier.RawIRCLines:
+			notifier.MaybeSendRawLine(line)
+		case <-quietHoursTicker.C:
+			notifier.SendHeldAlertMsgs()
+		case <-digestTicker.C:
+			notifier.SendDueDigests()
+		case <-hourlyCapTicker.C:
+			notifier.SendCapSummaries()
+		case <-breakerTicker.C:
+			notifier.SendBreakerHeldAlertMsgs()
+		case <-heartbeats:
+			notifier.SendHeartbeat()
+		case <-joinConfirmChecks:
+			notifier.CheckJoinConfirmations()
+		case <-templateChecks:
+			notifier.Formatter.ReloadTemplates()
+		case <-notifier.stateDumps:
+			notifier.logState()
+		case <-notifier.resumed:
+			notifier.SendPausedAlertMsgs()
+		case <-notifier.sessionUpSignal:
+			notifier.stopRegisterTimer()
+			notifier.sessionUp = true
+			notifier.MaybeIdentifyNick()
+			notifier.JoinChannels()
+			notifier.MaybeSendReconnectNotice()
+			notifier.SendRequeuedAlertMsgs()
+			notifier.SendPausedAlertMsgs()
+		case <-notifier.registerTimerC():
+			notifier.registerTimer = nil
+			log.Printf("Registration did not complete within %s, reconnecting",
+				notifier.RegisterTimeout)
+			ircRegisterTimeouts.WithLabelValues(notifier.connection).Inc()
+			// Not waited for, as Close dispatches the disconnection to the
+			// handler signalling sessionDownSignal.
+			go notifier.Client.Close()
+		case <-notifier.joinTimerC():
+			notifier.joinTimer = nil
+			notifier.JoinPendingChannels()
+		case <-notifier.sessionDownSignal:
+			if notifier.sessionUp {
+				notifier.reconnects.down(notifier.timeNow())
+			} else {
+				notifier.reconnects.failed()
+			}
+			notifier.sessionUp = false
+			notifier.stopRegisterTimer()
+			notifier.cancelJoins()
+			notifier.forgetUnconfirmedJoins()
+			notifier.CleanupChannels()
+			notifier.Client.Quit(notifier.quitMessage())
+		case <-notifier.StopRunning:
+			log.Printf("IRC routine asked to terminate")
+			keepGoing = false
+		}
+	}
+	notifier.stopRegisterTimer()
+	notifier.cancelJoins()
+	notifier.drainAlertMsgs()
+	notifier.abandonRequeuedAlertMsgs()
+	if notifier.Client.Connected() {
+		log.Printf("IRC client connected, quitting")
+		notifier.Client.Quit(notifier.quitMessage())
+
+		if notifier.sessionUp {
+			log.Printf("Session is up, wait for IRC disconnect to complete")
+			select {
+			case <-notifier.sessionDownSignal:
+			case <-time.After(notifier.Client.Config().Timeout):
+				log.Printf("Timeout while waiting for IRC disconnect to complete, stopping anyway")
+			}
+		}
+	}
+	if notifier.AckSender != nil {
+		notifier.AckSender.Close()
+	}
+	if notifier.deliveryLogger != nil {
+		notifier.deliveryLogger.Close()
+	}
+	notifier.StoppedRunning <- true
+}
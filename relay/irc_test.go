@@ -0,0 +1,2576 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"fmt"
+	irc "github.com/fluffle/goirc/client"
+	promtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type LineHandlerFunc func(*bufio.ReadWriter, *irc.Line) error
+
+func (s *testServer) h_NICK(conn *bufio.ReadWriter, line *irc.Line) error {
+	s.nick = line.Args[0]
+	s.maybeRegister(conn)
+	return nil
+}
+
+func (s *testServer) h_USER(conn *bufio.ReadWriter, line *irc.Line) error {
+	s.userReceived = true
+	s.maybeRegister(conn)
+	return nil
+}
+
+func (s *testServer) h_CAP(conn *bufio.ReadWriter, line *irc.Line) error {
+	switch line.Args[0] {
+	case "LS":
+		// Registration is suspended until negotiation ends.
+		s.capNegotiating = true
+		r := fmt.Sprintf(":example.com CAP * LS :%s\n",
+			strings.Join(s.Capabilities, " "))
+		conn.WriteString(r)
+	case "REQ":
+		r := fmt.Sprintf(":example.com CAP * ACK :%s\n", line.Args[1])
+		conn.WriteString(r)
+	case "END":
+		s.capNegotiating = false
+		s.maybeRegister(conn)
+	}
+	return nil
+}
+
+// maybeRegister welcomes the client once both an accepted nick and the
+// USER command have been received, like a real server would.
+func (s *testServer) maybeRegister(conn *bufio.ReadWriter) {
+	if s.registered || s.capNegotiating || s.nick == "" || !s.userReceived {
+		return
+	}
+	s.registered = true
+	r := fmt.Sprintf(":example.com 001 %s :Welcome\n", s.nick)
+	conn.WriteString(r)
+}
+
+func h_QUIT(conn *bufio.ReadWriter, line *irc.Line) error {
+	return fmt.Errorf("client asked to terminate")
+}
+
+type closeEarlyHandler func()
+
+type testServer struct {
+	net.Listener
+	Client net.Conn
+
+	ServingWaitGroup     sync.WaitGroup
+	ConnectionsWaitGroup sync.WaitGroup
+
+	lineHandlersMu sync.Mutex
+	lineHandlers   map[string]LineHandlerFunc
+
+	Log []string
+
+	// Capabilities advertised in reply to CAP LS.
+	Capabilities []string
+
+	// Registration state of the current client connection.
+	nick           string
+	userReceived   bool
+	capNegotiating bool
+	registered     bool
+
+	closeEarlyMu sync.Mutex
+	closeEarlyHandler
+}
+
+func (s *testServer) setDefaultHandlers() {
+	if s.lineHandlers == nil {
+		s.lineHandlers = make(map[string]LineHandlerFunc)
+	}
+	s.lineHandlers["NICK"] = s.h_NICK
+	s.lineHandlers["USER"] = s.h_USER
+	s.lineHandlers["CAP"] = s.h_CAP
+	s.lineHandlers["QUIT"] = h_QUIT
+}
+
+func (s *testServer) getHandler(cmd string) LineHandlerFunc {
+	s.lineHandlersMu.Lock()
+	defer s.lineHandlersMu.Unlock()
+	return s.lineHandlers[cmd]
+}
+
+func (s *testServer) SetHandler(cmd string, h LineHandlerFunc) {
+	s.lineHandlersMu.Lock()
+	defer s.lineHandlersMu.Unlock()
+	if h == nil {
+		delete(s.lineHandlers, cmd)
+	} else {
+		s.lineHandlers[cmd] = h
+	}
+}
+
+func (s *testServer) handleLine(conn *bufio.ReadWriter, line *irc.Line) error {
+	s.Log = append(s.Log, strings.Trim(line.Raw, " \r\n"))
+	handler := s.getHandler(line.Cmd)
+	if handler == nil {
+		log.Printf("=Server= No handler for command '%s', skipping", line.Cmd)
+		return nil
+	}
+	return handler(conn, line)
+}
+
+func (s *testServer) handleConnection(conn net.Conn) {
+	defer func() {
+		s.Client = nil
+		conn.Close()
+		s.ConnectionsWaitGroup.Done()
+	}()
+	s.nick = ""
+	s.userReceived = false
+	s.capNegotiating = false
+	s.registered = false
+	bufConn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		msg, err := bufConn.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				log.Printf("=Server= Client %s disconnected", conn.RemoteAddr().String())
+			} else {
+				log.Printf("=Server= Could not read from %s: %s", conn.RemoteAddr().String(), err)
+			}
+			return
+		}
+		log.Printf("=Server= Received %s", msg)
+		line := irc.ParseLine(string(msg))
+		if line == nil {
+			log.Printf("=Server= Could not parse received line")
+			continue
+		}
+		err = s.handleLine(bufConn, line)
+		if err != nil {
+			log.Printf("=Server= Closing connection: %s", err)
+			return
+		}
+		bufConn.Flush()
+	}
+}
+
+func (s *testServer) SetCloseEarly(h closeEarlyHandler) {
+	s.closeEarlyMu.Lock()
+	defer s.closeEarlyMu.Unlock()
+	s.closeEarlyHandler = h
+}
+
+func (s *testServer) handleCloseEarly(conn net.Conn) bool {
+	s.closeEarlyMu.Lock()
+	defer s.closeEarlyMu.Unlock()
+	if s.closeEarlyHandler == nil {
+		return false
+	}
+	log.Printf("=Server= Closing connection early")
+	conn.Close()
+	s.closeEarlyHandler()
+	return true
+}
+
+func (s *testServer) Serve() {
+	defer s.ServingWaitGroup.Done()
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			log.Printf("=Server= Stopped accepting new connections")
+			return
+		}
+		log.Printf("=Server= New client connected from %s", conn.RemoteAddr().String())
+		if s.handleCloseEarly(conn) {
+			continue
+		}
+		s.Client = conn
+		s.ConnectionsWaitGroup.Add(1)
+		s.handleConnection(conn)
+	}
+}
+
+func (s *testServer) Stop() {
+	s.Listener.Close()
+	s.ServingWaitGroup.Wait()
+	s.ConnectionsWaitGroup.Wait()
+}
+
+func makeTestServer(t *testing.T) (*testServer, int) {
+	server := new(testServer)
+	server.Log = make([]string, 0)
+	server.setDefaultHandlers()
+
+	addr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("=Server= Could not resolve tcp addr: %s", err)
+	}
+	listener, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		t.Fatalf("=Server= Could not create listener: %s", err)
+	}
+	addr = listener.Addr().(*net.TCPAddr)
+	log.Printf("=Server= Test server listening on %s", addr.String())
+
+	server.Listener = listener
+
+	server.ServingWaitGroup.Add(1)
+	go func() {
+		server.Serve()
+	}()
+
+	addr = listener.Addr().(*net.TCPAddr)
+	return server, addr.Port
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+type FakeDelayer struct {
+}
+
+func (f *FakeDelayer) Delay() {
+	log.Printf("Faking Backoff")
+}
+
+func makeTestIRCConfig(IRCPort int) *Config {
+	return &Config{
+		IRCNick:     "foo",
+		IRCNickPass: "",
+		IRCHost:     "127.0.0.1",
+		IRCPort:     IRCPort,
+		IRCUseSSL:   false,
+		IRCChannels: []IRCChannel{
+			IRCChannel{Name: "#foo"},
+			IRCChannel{Name: "#bar"},
+			IRCChannel{Name: "#baz"},
+		},
+		UsePrivmsg: false,
+	}
+}
+
+func makeTestNotifier(t *testing.T, config *Config) (*IRCNotifier, chan AlertMsg) {
+	alertMsgs := make(chan AlertMsg)
+	rawIRCLines := make(chan string)
+	notifier, err := NewIRCNotifier(config, alertMsgs, rawIRCLines)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("Could not create IRC notifier: %s", err))
+	}
+	notifier.Client.Config().Flood = true
+	notifier.BackoffCounter = &FakeDelayer{}
+
+	return notifier, alertMsgs
+}
+
+func TestPreJoinChannels(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Did not pre-join channels")
+	}
+}
+
+func TestSendAlertOnPreJoinedChannel(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	testChannel := "#foo"
+	testMessage := "test message"
+
+	// Send the alert after configured channels have joined, to ensure we
+	// check for no re-join attempt.
+	joinedHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		if line.Args[0] == testChannel {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinedHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(1)
+	alertMsgs <- AlertMsg{Channel: testChannel, Alert: testMessage}
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :test message",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestSendAlertInCharset(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCCharset = "ISO-8859-1"
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinedHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinedHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(1)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "Température élevée ☀"}
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :Temp\xe9rature \xe9lev\xe9e ?",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestUsePrivmsgToSendAlertOnPreJoinedChannel(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.UsePrivmsg = true
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	testChannel := "#foo"
+	testMessage := "test message"
+
+	// Send the alert after configured channels have joined, to ensure we
+	// check for no re-join attempt.
+	joinedHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		if line.Args[0] == testChannel {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinedHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	privmsgHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("PRIVMSG", privmsgHandler)
+
+	testStep.Add(1)
+	alertMsgs <- AlertMsg{Channel: testChannel, Alert: testMessage}
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"PRIVMSG #foo :test message",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestSendStaleAlertWithPrefix(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.StaleAlertThreshold = time.Minute
+	config.PrefixStaleAlerts = true
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(2)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "fresh alert",
+		StartsAt: time.Now()}
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "stale alert",
+		StartsAt: time.Now().Add(-time.Hour)}
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :fresh alert",
+		"NOTICE #foo :(delayed) stale alert",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestSendAlertAndJoinChannel(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	testChannel := "#foobar"
+	testMessage := "test message"
+
+	// Send the alert after configured channels have joined, to ensure log
+	// ordering.
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(1)
+	alertMsgs <- AlertMsg{Channel: testChannel, Alert: testMessage}
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		// #foobar joined before sending message
+		"JOIN #foobar",
+		"NOTICE #foobar :test message",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestSendAlertOnChannelJoinedInOtherCase(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(1)
+	alertMsgs <- AlertMsg{Channel: "#FOO", Alert: "test message"}
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		// #FOO is the same channel as #foo, not joined again.
+		"NOTICE #FOO :test message",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestSendRawLine(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	modeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("MODE", modeHandler)
+
+	testStep.Add(1)
+	notifier.RawIRCLines <- "MODE #foo +t"
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"MODE #foo +t",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Raw line not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestQuietHoursQueueAlerts(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.MsgTemplate = "{{ .Labels.alertname }} is {{ .Labels.severity }}"
+	quietHours := &QuietHours{
+		Start: "22:00", End: "07:00", Timezone: "UTC", Action: "queue"}
+	if err := quietHours.Init(); err != nil {
+		t.Fatalf("Could not init quiet hours: %s", err)
+	}
+	config.IRCChannels[0].QuietHours = quietHours
+	notifier, alertMsgs := makeTestNotifier(t, config)
+	clock := &fakeClock{now: time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)}
+	notifier.timeNow = clock.Now
+	notifier.QuietHoursCheckInterval = 10 * time.Millisecond
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	makeAlertMsg := func(name string, severity string) AlertMsg {
+		alert := promtmpl.Alert{
+			Labels: promtmpl.KV{"alertname": name, "severity": severity}}
+		return AlertMsg{Channel: "#foo", AlertData: &alert}
+	}
+
+	// Only the critical alert goes through during quiet hours.
+	testStep.Add(1)
+	alertMsgs <- makeAlertMsg("airDown", "warning")
+	alertMsgs <- makeAlertMsg("airGone", "critical")
+	testStep.Wait()
+
+	// The held alert is sent once quiet hours end.
+	testStep.Add(1)
+	clock.Set(time.Date(2017, 5, 16, 7, 0, 0, 0, time.UTC))
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :airGone is critical",
+		"NOTICE #foo :airDown is warning",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestCircuitBreakerHoldsAlerts(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.CircuitBreakerThreshold = 2
+	config.CircuitBreakerWindow = time.Minute
+	config.CircuitBreakerCooldown = 5 * time.Minute
+	notifier, alertMsgs := makeTestNotifier(t, config)
+	clock := &fakeClock{now: time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)}
+	notifier.timeNow = clock.Now
+	notifier.BreakerCheckInterval = 10 * time.Millisecond
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	// Sending to #foo fails.
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		if line.Args[0] == "#foo" {
+			conn.WriteString(":example.com 404 foo #foo :Cannot send to channel\n")
+		}
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(2)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "first"}
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "second"}
+	testStep.Wait()
+
+	// The errors are handled asynchronously from the notices.
+	for i := 0; i < 100 && notifier.breaker.Allow(clock.Now()); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	alertMsgs <- AlertMsg{Channel: "#bar", Alert: "held"}
+	for i := 0; i < 100 && testutil.ToFloat64(circuitBreakerHeldAlerts.WithLabelValues(defaultConnection)) != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if held := testutil.ToFloat64(circuitBreakerHeldAlerts.WithLabelValues(defaultConnection)); held != 1 {
+		t.Errorf("Expected 1 held alert, got %f", held)
+	}
+
+	// Held alerts are sent after the cooldown.
+	testStep.Add(1)
+	clock.Set(time.Date(2017, 5, 15, 23, 5, 0, 0, time.UTC))
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :first",
+		"NOTICE #foo :second",
+		"NOTICE #bar :held",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestDedupAlertsByGroupKey(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.MsgTemplate = "{{ .GroupKey }} {{ .Status }} {{ .CommonLabels.alertname }}"
+	config.DedupWindow = time.Hour
+	config.DedupByGroupKey = true
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	makeAlertMsg := func(groupKey string, name string,
+		fingerprints ...string) AlertMsg {
+		data := &WebhookData{GroupKey: groupKey}
+		data.Status = "firing"
+		data.CommonLabels = promtmpl.KV{"alertname": name}
+		for _, fingerprint := range fingerprints {
+			data.Alerts = append(data.Alerts,
+				promtmpl.Alert{Fingerprint: fingerprint, Status: "firing"})
+		}
+		return AlertMsg{Channel: "#foo", GroupData: data}
+	}
+
+	// The second group update repeats the group key, status and alerts
+	// and is dropped even though its text differs. The third one has an
+	// alert joining the group.
+	testStep.Add(3)
+	alertMsgs <- makeAlertMsg("{}:{a=\"1\"}", "airDown", "f1")
+	alertMsgs <- makeAlertMsg("{}:{a=\"1\"}", "airGone", "f1")
+	alertMsgs <- makeAlertMsg("{}:{a=\"1\"}", "airJoined", "f2", "f1")
+	alertMsgs <- makeAlertMsg("{}:{a=\"2\"}", "airDown", "f1")
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :{}:{a=\"1\"} firing airDown",
+		"NOTICE #foo :{}:{a=\"1\"} firing airJoined",
+		"NOTICE #foo :{}:{a=\"2\"} firing airDown",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestSuppressUnknownResolved(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.MsgTemplate = "{{ .Fingerprint }} {{ .Status }}"
+	config.SuppressUnknownResolved = true
+	config.SuppressUnknownResolvedTTL = time.Hour
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	makeAlertMsg := func(fingerprint string, status string) AlertMsg {
+		alert := &promtmpl.Alert{Fingerprint: fingerprint, Status: status}
+		return AlertMsg{Channel: "#foo", AlertData: alert}
+	}
+
+	// The lone resolved alert is dropped.
+	testStep.Add(2)
+	alertMsgs <- makeAlertMsg("aaaa", "resolved")
+	alertMsgs <- makeAlertMsg("bbbb", "firing")
+	alertMsgs <- makeAlertMsg("bbbb", "resolved")
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :bbbb firing",
+		"NOTICE #foo :bbbb resolved",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestSendFooterAfterBatch(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.MsgTemplate = "{{ if .Fingerprint }}{{ .Fingerprint }} {{ .Status }}{{ end }}"
+	config.IRCChannels[0].FooterTemplate =
+		`-- {{ .Count }} alerts ({{ .Resolved }} resolved) @ {{ .Time.Format "15:04 MST" }}`
+	notifier, alertMsgs := makeTestNotifier(t, config)
+	clock := &fakeClock{now: time.Date(2017, 5, 15, 14, 2, 0, 0, time.UTC)}
+	notifier.timeNow = clock.Now
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	data := &WebhookData{}
+	data.Alerts = promtmpl.Alerts{
+		{Fingerprint: "aaaa", Status: "firing"},
+		{Fingerprint: "bbbb", Status: "resolved"},
+	}
+
+	// Only the last message of the batch is followed by the footer, channels
+	// without one are left alone. The footer follows batches whose last
+	// message is not sent, here as it renders to nothing, but not those
+	// without any message sent.
+	otherData := &WebhookData{}
+	otherData.Alerts = promtmpl.Alerts{
+		{Fingerprint: "cccc", Status: "firing"},
+		{Fingerprint: "", Status: ""},
+	}
+	droppedData := &WebhookData{}
+	droppedData.Alerts = promtmpl.Alerts{{Fingerprint: "", Status: ""}}
+	testStep.Add(7)
+	alertMsgs <- AlertMsg{Channel: "#foo", GroupData: data,
+		AlertData: &data.Alerts[0]}
+	alertMsgs <- AlertMsg{Channel: "#foo", GroupData: data,
+		AlertData: &data.Alerts[1], EndsBatch: true}
+	alertMsgs <- AlertMsg{Channel: "#bar", GroupData: data,
+		AlertData: &data.Alerts[0], EndsBatch: true}
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "raw", EndsBatch: true}
+	alertMsgs <- AlertMsg{Channel: "#foo", GroupData: droppedData,
+		AlertData: &droppedData.Alerts[0], EndsBatch: true}
+	alertMsgs <- AlertMsg{Channel: "#foo", GroupData: otherData,
+		AlertData: &otherData.Alerts[0]}
+	alertMsgs <- AlertMsg{Channel: "#foo", GroupData: otherData,
+		AlertData: &otherData.Alerts[1], EndsBatch: true}
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :aaaa firing",
+		"NOTICE #foo :bbbb resolved",
+		"NOTICE #foo :-- 2 alerts (1 resolved) @ 14:02 UTC",
+		"NOTICE #bar :aaaa firing",
+		"NOTICE #foo :raw",
+		"NOTICE #foo :cccc firing",
+		"NOTICE #foo :-- 2 alerts (0 resolved) @ 14:02 UTC",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestJoinDelayAndStagger(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCJoinDelay = 50 * time.Millisecond
+	config.IRCJoinStagger = 20 * time.Millisecond
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	var mu sync.Mutex
+	var registeredAt time.Time
+	joinedAt := []time.Time{}
+	userHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		mu.Lock()
+		registeredAt = time.Now()
+		mu.Unlock()
+		return server.h_USER(conn, line)
+	}
+	server.SetHandler("USER", userHandler)
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		mu.Lock()
+		joinedAt = append(joinedAt, time.Now())
+		mu.Unlock()
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if delay := joinedAt[0].Sub(registeredAt); delay < config.IRCJoinDelay {
+		t.Errorf("Expected the first join at least %s after registering, got %s",
+			config.IRCJoinDelay, delay)
+	}
+	for i := 1; i < len(joinedAt); i++ {
+		if stagger := joinedAt[i].Sub(joinedAt[i-1]); stagger < config.IRCJoinStagger {
+			t.Errorf("Expected joins at least %s apart, got %s",
+				config.IRCJoinStagger, stagger)
+		}
+	}
+}
+
+func TestSendAlertDuringJoinDelay(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCJoinDelay = time.Hour
+	// Identifying right before scheduling the joins tells when the session
+	// is up.
+	config.IRCNickPass = "nickpassword"
+	notifier, alertMsgs := makeTestNotifier(t, config)
+	notifier.NickservDelayWait = 0 * time.Second
+
+	var testStep sync.WaitGroup
+
+	identifyHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("PRIVMSG", identifyHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(1)
+	// Joining on demand rather than waiting for the pre-join channels.
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "test message"}
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"PRIVMSG NickServ :IDENTIFY nickpassword",
+		"JOIN #foo",
+		"NOTICE #foo :test message",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Did not send IRC commands in expected order. Received commands:\n",
+			strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestSendOnJoinCommands(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCChannels[0].OnJoinCommands = []string{
+		"PRIVMSG ChanServ :OP {{ .Channel }} {{ .Nick }}"}
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		r := fmt.Sprintf(":example.com 366 foo %s :End of /NAMES list.\n",
+			line.Args[0])
+		conn.WriteString(r)
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	privmsgHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("PRIVMSG", privmsgHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	sent := 0
+	for _, command := range server.Log {
+		if strings.HasPrefix(command, "PRIVMSG ") {
+			if command != "PRIVMSG ChanServ :OP #foo foo" {
+				t.Errorf("Unexpected on join command: %s", command)
+			}
+			sent++
+		}
+	}
+	if sent != 1 {
+		t.Error("Expected one on join command. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestTrackJoinedChannels(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		r := fmt.Sprintf(":example.com 353 foo = %s :foo @bar baz\n"+
+			":example.com 366 foo %s :End of /NAMES list.\n",
+			line.Args[0], line.Args[0])
+		conn.WriteString(r)
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	// The replies are handled asynchronously from the JOIN.
+	nicks := []string{"bar", "baz", "foo"}
+	expected := []ChannelInfo{
+		{"#bar", 3, nicks}, {"#baz", 3, nicks}, {"#foo", 3, nicks}}
+	var channels []ChannelInfo
+	for i := 0; i < 100; i++ {
+		channels = notifier.ChannelTracker.Channels()
+		if reflect.DeepEqual(expected, channels) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	if !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected joined channels %v, got %v", expected, channels)
+	}
+}
+
+// testTrackMembers sends event once all channels are joined, each with
+// members foo, bar and baz, and returns the joined channels once they match
+// expected or after a timeout.
+func testTrackMembers(t *testing.T, event string,
+	expected []ChannelInfo) []ChannelInfo {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		r := fmt.Sprintf(":example.com 353 foo = %s :foo @bar baz\n"+
+			":example.com 366 foo %s :End of /NAMES list.\n",
+			line.Args[0], line.Args[0])
+		if line.Args[0] == "#baz" {
+			r += event + "\n"
+			testStep.Done()
+		}
+		conn.WriteString(r)
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	var channels []ChannelInfo
+	for i := 0; i < 100; i++ {
+		channels = notifier.ChannelTracker.Channels()
+		if reflect.DeepEqual(expected, channels) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	notifier.StopRunning <- true
+	server.Stop()
+	return channels
+}
+
+func TestTrackMemberJoin(t *testing.T) {
+	nicks := []string{"bar", "baz", "foo"}
+	expected := []ChannelInfo{
+		{"#bar", 3, nicks}, {"#baz", 3, nicks},
+		{"#foo", 4, []string{"bar", "baz", "foo", "qux"}}}
+	channels := testTrackMembers(t, ":qux!q@example.com JOIN #foo", expected)
+	if !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected joined channels %v, got %v", expected, channels)
+	}
+}
+
+func TestTrackMemberPart(t *testing.T) {
+	nicks := []string{"bar", "baz", "foo"}
+	expected := []ChannelInfo{
+		{"#bar", 3, nicks}, {"#baz", 3, nicks},
+		{"#foo", 2, []string{"bar", "foo"}}}
+	channels := testTrackMembers(t, ":baz!b@example.com PART #foo :bye",
+		expected)
+	if !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected joined channels %v, got %v", expected, channels)
+	}
+}
+
+func TestTrackOwnPart(t *testing.T) {
+	nicks := []string{"bar", "baz", "foo"}
+	expected := []ChannelInfo{{"#bar", 3, nicks}, {"#baz", 3, nicks}}
+	channels := testTrackMembers(t, ":foo!f@example.com PART #foo", expected)
+	if !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected joined channels %v, got %v", expected, channels)
+	}
+}
+
+func TestTrackMemberQuit(t *testing.T) {
+	nicks := []string{"baz", "foo"}
+	expected := []ChannelInfo{
+		{"#bar", 2, nicks}, {"#baz", 2, nicks}, {"#foo", 2, nicks}}
+	channels := testTrackMembers(t, ":bar!b@example.com QUIT :gone",
+		expected)
+	if !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected joined channels %v, got %v", expected, channels)
+	}
+}
+
+func TestTrackMemberKick(t *testing.T) {
+	nicks := []string{"bar", "baz", "foo"}
+	expected := []ChannelInfo{
+		{"#bar", 3, nicks}, {"#baz", 2, []string{"bar", "foo"}},
+		{"#foo", 3, nicks}}
+	channels := testTrackMembers(t, ":bar!b@example.com KICK #baz baz :out",
+		expected)
+	if !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected joined channels %v, got %v", expected, channels)
+	}
+}
+
+func TestTrackMemberNick(t *testing.T) {
+	nicks := []string{"bar", "foo", "qux"}
+	expected := []ChannelInfo{
+		{"#bar", 3, nicks}, {"#baz", 3, nicks}, {"#foo", 3, nicks}}
+	channels := testTrackMembers(t, ":baz!b@example.com NICK qux", expected)
+	if !reflect.DeepEqual(expected, channels) {
+		t.Errorf("Expected joined channels %v, got %v", expected, channels)
+	}
+}
+
+func TestStopJoiningBlockedChannel(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	banned := true
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		if line.Args[0] == "#foo" && banned {
+			banned = false
+			conn.WriteString(":example.com 474 foo #foo :Cannot join channel (+b)\n")
+		}
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	// The error is handled asynchronously from the JOIN.
+	for i := 0; i < 100; i++ {
+		if _, blocked := notifier.ChannelTracker.Blocked("#foo"); blocked {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	// Alerts are processed in order, so the one to #bar being sent means the
+	// one to #foo was dropped.
+	testStep.Add(1)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "dropped"}
+	alertMsgs <- AlertMsg{Channel: "#bar", Alert: "sent"}
+	testStep.Wait()
+
+	notifier.ChannelTracker.Unblock("#foo")
+	testStep.Add(1)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "sent after unblocking"}
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #bar :sent",
+		"JOIN #foo",
+		"NOTICE #foo :sent after unblocking",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Blocked channel not handled correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	digest := &Digest{
+		Interval: 5 * time.Minute,
+		Template: `{{ len .Alerts }} alerts across {{ len (.LabelValues "service") }} services`,
+	}
+	config.MsgTemplate = "{{ .Labels.alertname }} on {{ .Labels.service }}"
+	if err := digest.Init(templateParser{funcs: templateFuncs}); err != nil {
+		t.Fatalf("Could not init digest: %s", err)
+	}
+	config.IRCChannels[0].Digest = digest
+	notifier, alertMsgs := makeTestNotifier(t, config)
+	clock := &fakeClock{now: time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)}
+	notifier.timeNow = clock.Now
+	notifier.DigestCheckInterval = 10 * time.Millisecond
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	for _, service := range []string{"air", "water", "air"} {
+		alertMsgs <- *makeDigestAlertMsg(service)
+	}
+	// Alerts to other channels are still sent individually.
+	testStep.Add(1)
+	alertMsg := *makeDigestAlertMsg("fire")
+	alertMsg.Channel = "#bar"
+	alertMsgs <- alertMsg
+	testStep.Wait()
+
+	testStep.Add(1)
+	clock.Set(time.Date(2017, 5, 15, 23, 5, 0, 0, time.UTC))
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #bar :airDown on fire",
+		"NOTICE #foo :3 alerts across 2 services",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Digest not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestHourlyCapSummarized(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.MsgTemplate = "{{ .Labels.alertname }} on {{ .Labels.service }}"
+	config.IRCChannels[0].HourlyCap = &HourlyCap{
+		Messages: 1, Policy: overCapSummarize}
+	notifier, alertMsgs := makeTestNotifier(t, config)
+	clock := &fakeClock{now: time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)}
+	notifier.timeNow = clock.Now
+	notifier.HourlyCapCheckInterval = 10 * time.Millisecond
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	// The first alert, then the notice in place of the second one.
+	testStep.Add(2)
+	for _, service := range []string{"air", "water", "fire"} {
+		alertMsgs <- *makeDigestAlertMsg(service)
+	}
+	// Alerts to other channels are not capped.
+	testStep.Add(1)
+	alertMsg := *makeDigestAlertMsg("earth")
+	alertMsg.Channel = "#bar"
+	alertMsgs <- alertMsg
+	testStep.Wait()
+
+	testStep.Add(1)
+	clock.Set(time.Date(2017, 5, 16, 0, 0, 0, 0, time.UTC))
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :airDown on air",
+		"NOTICE #foo :Hourly cap of 1 messages reached, suppressing further alerts this hour",
+		"NOTICE #bar :airDown on earth",
+		"NOTICE #foo :2 alerts suppressed over the hourly cap of 1 messages",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Hourly cap not applied correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestExpireQueuedAlerts(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.MsgTemplate = "{{ .Labels.alertname }} is {{ .Status }}"
+	config.MaxQueueAge = 10 * time.Minute
+	config.MaxQueueAgeExemptResolved = true
+	notifier, alertMsgs := makeTestNotifier(t, config)
+	now := time.Date(2017, 5, 15, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: now}
+	notifier.timeNow = clock.Now
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	makeAlertMsg := func(name string, status string, age time.Duration) AlertMsg {
+		alert := promtmpl.Alert{
+			Status: status, Labels: promtmpl.KV{"alertname": name}}
+		return AlertMsg{Channel: "#foo", AlertData: &alert,
+			EnqueuedAt: now.Add(-age)}
+	}
+
+	testStep.Add(2)
+	alertMsgs <- makeAlertMsg("airDown", "firing", 30*time.Minute)
+	alertMsgs <- makeAlertMsg("airGone", "resolved", 30*time.Minute)
+	alertMsgs <- makeAlertMsg("airLow", "firing", time.Minute)
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :airGone is resolved",
+		"NOTICE #foo :airLow is firing",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alerts not expired correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestSendAlertDisconnected(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep, holdUserStep sync.WaitGroup
+
+	testChannel := "#foo"
+	disconnectedTestMessage := "disconnected test message"
+	connectedTestMessage := "connected test message"
+
+	// First send an alert while the session is not established.
+	testStep.Add(1)
+	holdUserStep.Add(1)
+	holdUser := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		log.Printf("=Server= Wait before completing session")
+		testStep.Wait()
+		log.Printf("=Server= Completing session")
+		holdUserStep.Done()
+		return server.h_USER(conn, line)
+	}
+	server.SetHandler("USER", holdUser)
+
+	go notifier.Run()
+
+	alertMsgs <- AlertMsg{Channel: testChannel, Alert: disconnectedTestMessage}
+
+	testStep.Done()
+	holdUserStep.Wait()
+
+	// Make sure session is established by checking that pre-joined
+	// channels are there.
+	testStep.Add(1)
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Wait()
+
+	// Now send and wait until a notice has been received.
+	testStep.Add(1)
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	alertMsgs <- AlertMsg{Channel: testChannel, Alert: connectedTestMessage}
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		// Only message sent while being connected is received.
+		"NOTICE #foo :connected test message",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	// Wait until the last pre-joined channel is seen.
+	testStep.Wait()
+
+	// Simulate disconnection.
+	testStep.Add(1)
+	server.Client.Close()
+
+	// Wait again until the last pre-joined channel is seen.
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		// Commands from first connection
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		// Commands from reconnection
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Reconnection did not happen correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestConnectErrorRetry(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	// Attempt SSL handshake. The server does not support it, resulting in
+	// a connection error.
+	config.IRCUseSSL = true
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep, joinStep sync.WaitGroup
+
+	testStep.Add(1)
+	earlyHandler := func() {
+		testStep.Done()
+	}
+
+	server.SetCloseEarly(earlyHandler)
+
+	go notifier.Run()
+
+	testStep.Wait()
+
+	// We have caused a connection failure, now check for a reconnection
+	notifier.Client.Config().SSL = false
+	joinStep.Add(1)
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			joinStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+	server.SetCloseEarly(nil)
+
+	joinStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Reconnection did not happen correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestGiveUpAfterMaxReconnectAttempts(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	// Attempt SSL handshake. The server does not support it, resulting in
+	// a connection error.
+	config.IRCUseSSL = true
+	config.IRCMaxReconnectAttempts = 3
+	notifier, _ := makeTestNotifier(t, config)
+
+	var attemptsMu sync.Mutex
+	attempts := 0
+	server.SetCloseEarly(func() {
+		attemptsMu.Lock()
+		defer attemptsMu.Unlock()
+		attempts++
+	})
+
+	go notifier.Run()
+
+	select {
+	case <-notifier.StoppedRunning:
+	case <-time.After(5 * time.Second):
+		t.Fatal("IRC notifier did not give up")
+	}
+	server.Stop()
+
+	if !notifier.GaveUp {
+		t.Error("Expected notifier to report giving up")
+	}
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	if attempts != 3 {
+		t.Errorf("Expected 3 connection attempts, got %d", attempts)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCNickPass = "nickpassword"
+	notifier, _ := makeTestNotifier(t, config)
+	notifier.NickservDelayWait = 0 * time.Second
+
+	var testStep sync.WaitGroup
+
+	// Wait until the last pre-joined channel is seen (joining happens
+	// after identification).
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"PRIVMSG NickServ :IDENTIFY nickpassword",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Identification did not happen correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestBouncerModeSkipsNickServ(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCNickPass = "nickpassword"
+	config.IRCPassword = "relay/libera:secret"
+	config.IRCBouncerMode = true
+	notifier, _ := makeTestNotifier(t, config)
+	notifier.NickservDelayWait = 0 * time.Second
+
+	var testStep sync.WaitGroup
+
+	// ZNC expects "user/network:password".
+	passHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		if !regexp.MustCompile(`^[^/]+/[^:]+:.+$`).MatchString(line.Args[0]) {
+			t.Errorf("Unexpected bouncer password format: %s", line.Args[0])
+		}
+		return nil
+	}
+	server.SetHandler("PASS", passHandler)
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"PASS relay/libera:secret",
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Bouncer login did not happen correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestGhostAndIdentify(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCNickPass = "nickpassword"
+	notifier, _ := makeTestNotifier(t, config)
+	notifier.NickservDelayWait = 0 * time.Second
+
+	var testStep, usedNick, unregisteredNickHandler sync.WaitGroup
+
+	// Trigger 433 for first nick
+	usedNick.Add(1)
+	unregisteredNickHandler.Add(1)
+	nickHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		if line.Args[0] == "foo" {
+			conn.WriteString(":example.com 433 * foo :nick in use\n")
+		}
+		usedNick.Done()
+		unregisteredNickHandler.Wait()
+		return nil
+	}
+	server.SetHandler("NICK", nickHandler)
+
+	// Wait until the last pre-joined channel is seen (joining happens
+	// after identification).
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	usedNick.Wait()
+	server.SetHandler("NICK", server.h_NICK)
+	unregisteredNickHandler.Done()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"NICK foo^",
+		"PRIVMSG NickServ :GHOST foo nickpassword",
+		"NICK foo",
+		"PRIVMSG NickServ :IDENTIFY nickpassword",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Ghosting did not happen correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestNickCollisionStrategy(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCNickCollisionStrategy = nickCollisionCounter
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	// The configured nick is only in use during the first connection.
+	primaryInUse := true
+	nickHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		nick := line.Args[0]
+		if (nick == "foo" && primaryInUse) || nick == "foo1" {
+			if nick == "foo" {
+				primaryInUse = false
+			}
+			conn.WriteString(fmt.Sprintf(
+				":example.com 433 * %s :nick in use\n", nick))
+			return nil
+		}
+		return server.h_NICK(conn, line)
+	}
+	server.SetHandler("NICK", nickHandler)
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	// Reconnecting should get the configured nick back.
+	testStep.Add(1)
+	server.Client.Close()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"NICK foo1",
+		"NICK foo2",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Nick collisions not handled correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestCapabilityNegotiation(t *testing.T) {
+	server, port := makeTestServer(t)
+	server.Capabilities = []string{"sasl", "server-time", "multi-prefix"}
+	config := makeTestIRCConfig(port)
+	config.IRCCapabilities = []string{"server-time", "message-tags"}
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"CAP LS 302",
+		"NICK foo",
+		"USER foo 12 * :",
+		// Only capabilities advertised by the server are requested.
+		"CAP REQ :server-time",
+		"CAP END",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Capabilities not negotiated correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+
+	if !notifier.Client.HasCapability("server-time") {
+		t.Error("Expected server-time capability to be enabled")
+	}
+}
+
+func TestMessageTagsOnAlerts(t *testing.T) {
+	server, port := makeTestServer(t)
+	server.Capabilities = []string{"message-tags"}
+	config := makeTestIRCConfig(port)
+	config.MsgTemplate = "{{ .Labels.alertname }} is {{ .Labels.severity }}"
+	config.UsePrivmsg = true
+	config.IRCMessageTags = true
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	privmsgHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("PRIVMSG", privmsgHandler)
+
+	alert := promtmpl.Alert{
+		Labels:      promtmpl.KV{"alertname": "airDown", "severity": "page me"},
+		Fingerprint: "a1b2"}
+	testStep.Add(2)
+	alertMsgs <- AlertMsg{Channel: "#foo", AlertData: &alert}
+	// Messages not built from alerts have no tags.
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "test message"}
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"CAP LS 302",
+		"NICK foo",
+		"USER foo 12 * :",
+		"CAP REQ :message-tags",
+		"CAP END",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		`@+alertname=airDown;+severity=page\sme;+fingerprint=a1b2 PRIVMSG #foo :airDown is page me`,
+		"PRIVMSG #foo :test message",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not tagged correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestThreadGroups(t *testing.T) {
+	server, port := makeTestServer(t)
+	server.Capabilities = []string{"message-tags", "echo-message"}
+	config := makeTestIRCConfig(port)
+	config.MsgTemplate = "{{ .Labels.alertname }} is {{ .Status }}"
+	config.UsePrivmsg = true
+	config.IRCThreadGroups = true
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	echoed := 0
+	privmsgHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		echoed++
+		conn.WriteString(fmt.Sprintf("@msgid=m%d :foo!foo@example.com PRIVMSG %s :%s\n",
+			echoed, line.Args[0], line.Args[1]))
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("PRIVMSG", privmsgHandler)
+
+	makeAlertMsg := func(name string, groupKey string, status string) AlertMsg {
+		alert := promtmpl.Alert{Labels: promtmpl.KV{"alertname": name}, Status: status}
+		group := &WebhookData{GroupKey: groupKey}
+		group.Status = status
+		return AlertMsg{Channel: "#foo", AlertData: &alert, GroupData: group}
+	}
+
+	testStep.Add(1)
+	alertMsgs <- makeAlertMsg("airDown", "{}:{a=\"1\"}", "firing")
+	testStep.Wait()
+	// The reply is only sent once the echo of the first message was seen.
+	for i := 0; i < 100; i++ {
+		if msgid, _ := notifier.threads.Root("#foo", "{}:{a=\"1\"}"); msgid != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	testStep.Add(3)
+	alertMsgs <- makeAlertMsg("airGone", "{}:{a=\"1\"}", "resolved")
+	alertMsgs <- makeAlertMsg("airDown", "{}:{a=\"2\"}", "firing")
+	// The thread of a resolved group ends.
+	alertMsgs <- makeAlertMsg("airBack", "{}:{a=\"1\"}", "firing")
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"CAP LS 302",
+		"NICK foo",
+		"USER foo 12 * :",
+		"CAP REQ :echo-message message-tags",
+		"CAP END",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"PRIVMSG #foo :airDown is firing",
+		"@+draft/reply=m1 PRIVMSG #foo :airGone is resolved",
+		"PRIVMSG #foo :airDown is firing",
+		"PRIVMSG #foo :airBack is firing",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alerts not threaded correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestConnectWithFallbackDelay(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCDialFallbackDelay = 100 * time.Millisecond
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Did not connect through the fallback dialer. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestConnectFromLocalAddr(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCLocalAddr = "127.0.0.1"
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	var remoteAddr string
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			remoteAddr = server.Client.RemoteAddr().String()
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	if host, _, _ := net.SplitHostPort(remoteAddr); host != "127.0.0.1" {
+		t.Errorf("Expected a connection from 127.0.0.1, got %s", remoteAddr)
+	}
+}
+
+func TestConnectToSRVTarget(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port + 1)
+	config.IRCHost = "example.com"
+	config.IRCUseSRV = true
+	notifier, _ := makeTestNotifier(t, config)
+	notifier.lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if service != "irc" || proto != "tcp" || name != "example.com" {
+			t.Errorf("Unexpected SRV lookup: _%s._%s.%s", service, proto, name)
+		}
+		return "", []*net.SRV{
+			&net.SRV{Target: "127.0.0.1.", Port: uint16(port)}}, nil
+	}
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+}
+
+func TestResolveServerWithoutSRVRecord(t *testing.T) {
+	config := makeTestIRCConfig(6697)
+	config.IRCUseSSL = true
+	config.IRCHost = "example.com"
+	config.IRCUseSRV = true
+	notifier, _ := makeTestNotifier(t, config)
+
+	notifier.lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if service != "ircs" {
+			t.Errorf("Expected an _ircs SRV lookup with SSL, got _%s", service)
+		}
+		return "", []*net.SRV{&net.SRV{Target: "irc1.example.com.", Port: 6900}}, nil
+	}
+	notifier.resolveServer()
+	if server := notifier.Client.Config().Server; server != "irc1.example.com:6900" {
+		t.Errorf("Expected the SRV target, got %s", server)
+	}
+
+	notifier.lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name}
+	}
+	notifier.resolveServer()
+	if server := notifier.Client.Config().Server; server != "example.com:6697" {
+		t.Errorf("Expected to fall back to the configured server, got %s", server)
+	}
+}
+
+func TestStopRunningWhenHalfConnected(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep, holdQuitWait sync.WaitGroup
+
+	// Send a StopRunning request while the client is connected but the
+	// session is not up
+	testStep.Add(1)
+	holdUser := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		log.Printf("=Server= NOT completing session")
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("USER", holdUser)
+
+	// Ignore quit, but wait for it to have deterministic test commands
+	holdQuitWait.Add(1)
+	holdQuit := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		log.Printf("=Server= Ignoring quit")
+		holdQuitWait.Done()
+		return nil
+	}
+	server.SetHandler("QUIT", holdQuit)
+
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+
+	<-notifier.StoppedRunning
+
+	holdQuitWait.Wait()
+
+	// Client has left, cleanup the server side before stopping
+	server.Client.Close()
+
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alert not sent correctly. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
+
+func TestRegisterTimeout(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCRegisterTimeout = 50 * time.Millisecond
+	notifier, _ := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	// Stall registration of the first connection.
+	connections := 0
+	userHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		connections++
+		if connections == 1 {
+			return nil
+		}
+		return server.h_USER(conn, line)
+	}
+	server.SetHandler("USER", userHandler)
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		// Commands from the stalled connection
+		"NICK foo",
+		"USER foo 12 * :",
+		// Commands from reconnection
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Did not reconnect on registration timeout. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+	if timeouts := testutil.ToFloat64(ircRegisterTimeouts.WithLabelValues(defaultConnection)); timeouts != 1 {
+		t.Errorf("Expected 1 registration timeout, got %f", timeouts)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const joinConfirmCheckSecs = 5
+
+var (
+	channelJoinUnconfirmed = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "irc_channel_join_unconfirmed",
+			Help: "Whether joining an IRC channel was not confirmed in time and is retried"},
+		[]string{"connection", "ircchannel"},
+	)
+)
+
+// Joined returns whether the server confirmed joining channel, whatever the
+// case it is written in.
+func (c *ChannelTracker) Joined(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := channelKey(channel)
+	for name := range c.joined {
+		if channelKey(name) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckJoinConfirmations joins again the channels whose join the server
+// did not confirm (366) within JoinConfirmTimeout, marking them unconfirmed
+// until it does. Alerts to other channels are sent meanwhile.
+func (notifier *IRCNotifier) CheckJoinConfirmations() {
+	if !notifier.sessionUp {
+		return
+	}
+	now := notifier.timeNow()
+	for key, state := range notifier.JoinedChannels {
+		name := state.Channel.Name
+		if notifier.ChannelTracker.Joined(name) {
+			if notifier.unconfirmedJoins[key] {
+				log.Printf("Join of %s confirmed", name)
+				delete(notifier.unconfirmedJoins, key)
+				channelJoinUnconfirmed.DeleteLabelValues(notifier.connection, name)
+			}
+			continue
+		}
+		if _, blocked := notifier.ChannelTracker.Blocked(name); blocked {
+			continue
+		}
+		if now.Sub(state.JoinedAt) < notifier.JoinConfirmTimeout {
+			continue
+		}
+		log.Printf("Join of %s not confirmed after %s, joining again",
+			name, notifier.JoinConfirmTimeout)
+		notifier.unconfirmedJoins[key] = true
+		channelJoinUnconfirmed.WithLabelValues(notifier.connection, name).Set(1)
+		notifier.Client.Join(name, state.Channel.Password)
+		state.JoinedAt = now
+		notifier.JoinedChannels[key] = state
+	}
+}
+
+// forgetUnconfirmedJoins clears the unconfirmed channels, e.g. when
+// disconnected, as all are joined again.
+func (notifier *IRCNotifier) forgetUnconfirmedJoins() {
+	for key := range notifier.unconfirmedJoins {
+		if state, ok := notifier.JoinedChannels[key]; ok {
+			channelJoinUnconfirmed.DeleteLabelValues(
+				notifier.connection, state.Channel.Name)
+		}
+	}
+	notifier.unconfirmedJoins = make(map[string]bool)
+}
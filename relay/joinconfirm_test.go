@@ -0,0 +1,94 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestJoinConfirmTimeout(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCJoinConfirmTimeout = 50 * time.Millisecond
+	notifier, alertMsgs := makeTestNotifier(t, config)
+	notifier.JoinConfirmCheckInterval = 10 * time.Millisecond
+
+	var testStep sync.WaitGroup
+
+	barJoins := 0
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// Never confirm joining #bar.
+		if line.Args[0] == "#bar" {
+			barJoins++
+			if barJoins == 2 {
+				testStep.Done()
+			}
+			return nil
+		}
+		r := fmt.Sprintf(":example.com 366 foo %s :End of /NAMES list.\n",
+			line.Args[0])
+		conn.WriteString(r)
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	// Alerts are still sent to the confirmed channels.
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(1)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "alert"}
+	testStep.Wait()
+
+	if unconfirmed := testutil.ToFloat64(
+		channelJoinUnconfirmed.WithLabelValues(defaultConnection, "#bar")); unconfirmed != 1 {
+		t.Errorf("Expected #bar unconfirmed, got %f", unconfirmed)
+	}
+	if notifier.ChannelTracker.Joined("#bar") {
+		t.Error("Expected #bar not to be joined")
+	}
+	if !notifier.ChannelTracker.Joined("#FOO") {
+		t.Error("Expected #foo to be joined")
+	}
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	joins := 0
+	for _, command := range server.Log {
+		if command == "JOIN #bar" {
+			joins++
+		}
+	}
+	if joins < 2 {
+		t.Error("Expected #bar joined again. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// mapMsgLabels returns a copy of alertMsg with the labels of its alert and
+// group replaced by f, leaving those of alertMsg untouched.
+func mapMsgLabels(alertMsg *AlertMsg, f func(promtmpl.KV) promtmpl.KV) *AlertMsg {
+	return mapMsgKVs(alertMsg, f, func(kv promtmpl.KV) promtmpl.KV { return kv })
+}
+
+// mapMsgKVs is mapMsgLabels also replacing annotations by annotations.
+func mapMsgKVs(alertMsg *AlertMsg, labels func(promtmpl.KV) promtmpl.KV,
+	annotations func(promtmpl.KV) promtmpl.KV) *AlertMsg {
+	mapped := *alertMsg
+	if alertMsg.AlertData != nil {
+		alert := *alertMsg.AlertData
+		alert.Labels = labels(alert.Labels)
+		alert.Annotations = annotations(alert.Annotations)
+		mapped.AlertData = &alert
+	}
+	if alertMsg.GroupData != nil {
+		group := *alertMsg.GroupData
+		group.GroupLabels = labels(group.GroupLabels)
+		group.CommonLabels = labels(group.CommonLabels)
+		group.CommonAnnotations = annotations(group.CommonAnnotations)
+		group.Alerts = make(promtmpl.Alerts, len(alertMsg.GroupData.Alerts))
+		for i, alert := range alertMsg.GroupData.Alerts {
+			alert.Labels = labels(alert.Labels)
+			alert.Annotations = annotations(alert.Annotations)
+			group.Alerts[i] = alert
+		}
+		mapped.GroupData = &group
+	}
+	return &mapped
+}
+
+// labelOmitter hides the labels whose whole name matches a regular
+// expression from messages, for display only.
+type labelOmitter struct {
+	re *regexp.Regexp
+}
+
+// newLabelOmitter returns nil when expr is empty.
+func newLabelOmitter(expr string) (*labelOmitter, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid omit_labels_regex: %s", err)
+	}
+	return &labelOmitter{re: re}, nil
+}
+
+func (o *labelOmitter) omit(labels promtmpl.KV) promtmpl.KV {
+	if labels == nil {
+		return nil
+	}
+	kept := promtmpl.KV{}
+	for name, value := range labels {
+		if !o.re.MatchString(name) {
+			kept[name] = value
+		}
+	}
+	return kept
+}
+
+// apply returns a copy of alertMsg without the omitted labels, alertMsg
+// itself if o is nil.
+func (o *labelOmitter) apply(alertMsg *AlertMsg) *AlertMsg {
+	if o == nil {
+		return alertMsg
+	}
+	return mapMsgLabels(alertMsg, o.omit)
+}
+
+// newRenderLabelsFunc returns the renderLabels template function, which
+// renders labels as sorted name=value pairs. Past max pairs, if positive,
+// the rest are counted as "(+N more)".
+func newRenderLabelsFunc(max int) func(promtmpl.KV) string {
+	return func(labels promtmpl.KV) string {
+		pairs := labels.SortedPairs()
+		more := 0
+		if max > 0 && len(pairs) > max {
+			more = len(pairs) - max
+			pairs = pairs[:max]
+		}
+		rendered := make([]string, 0, len(pairs)+1)
+		for _, pair := range pairs {
+			rendered = append(rendered, pair.Name+"="+pair.Value)
+		}
+		if more > 0 {
+			rendered = append(rendered, fmt.Sprintf("(+%d more)", more))
+		}
+		return strings.Join(rendered, " ")
+	}
+}
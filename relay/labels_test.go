@@ -0,0 +1,87 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func makeManyLabels(n int) promtmpl.KV {
+	labels := promtmpl.KV{"alertname": "airDown"}
+	for i := 1; len(labels) < n; i++ {
+		labels[fmt.Sprintf("label%02d", i)] = fmt.Sprintf("value%02d", i)
+	}
+	return labels
+}
+
+func TestMaxRenderedLabels(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate:       "{{ renderLabels .Labels }}",
+		MaxRenderedLabels: 3,
+	})
+	alert := promtmpl.Alert{Labels: makeManyLabels(50)}
+	alertMsg := &AlertMsg{Channel: "#foo", AlertData: &alert}
+
+	expected := "alertname=airDown label01=value01 label02=value02 (+47 more)"
+	if msg := formatter.RenderMsg(alertMsg); msg != expected {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+}
+
+func TestRenderLabelsUnlimited(t *testing.T) {
+	renderLabels := newRenderLabelsFunc(0)
+	labels := promtmpl.KV{"instance": "instance1", "alertname": "airDown"}
+
+	if rendered := renderLabels(labels); rendered != "alertname=airDown instance=instance1" {
+		t.Errorf("Unexpected rendered labels: %q", rendered)
+	}
+}
+
+func TestOmitLabelsRegex(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate:       "{{ range .Alerts }}{{ renderLabels .Labels }}{{ end }}",
+		OmitLabelsRegex:   "label[0-4].",
+		MaxRenderedLabels: 3,
+	})
+	group := &WebhookData{}
+	group.Alerts = promtmpl.Alerts{{Labels: makeManyLabels(50)}}
+	group.CommonLabels = makeManyLabels(50)
+	alertMsg := &AlertMsg{Channel: "#foo", GroupData: group}
+
+	// All of label01 to label49 match.
+	expected := "alertname=airDown"
+	if msg := formatter.RenderMsg(alertMsg); msg != expected {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+	if len(alertMsg.GroupData.Alerts[0].Labels) != 50 {
+		t.Errorf("Expected the labels of the alert untouched, got %d",
+			len(alertMsg.GroupData.Alerts[0].Labels))
+	}
+}
+
+func TestOmitLabelsRegexMatchesWholeNames(t *testing.T) {
+	omitter, err := newLabelOmitter("label")
+	if err != nil {
+		t.Fatalf("Could not create omitter: %s", err)
+	}
+	labels := omitter.omit(promtmpl.KV{"label": "a", "label01": "b"})
+
+	if _, ok := labels["label01"]; !ok || len(labels) != 1 {
+		t.Errorf("Expected only label01 kept, got %v", labels)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+)
+
+const (
+	ircReset = "\x0f"
+
+	defaultLinkStyle = "spaced"
+)
+
+// linkStyles wrap URLs so that IRC clients linkify them without the
+// punctuation around them.
+var linkStyles = map[string]func(string) string{
+	"plain":  func(url string) string { return url },
+	"spaced": func(url string) string { return " " + url + " " },
+	"angle":  func(url string) string { return "<" + url + ">" },
+	// The zero-width formatting reset ends the link for clients that stop
+	// it at control codes.
+	"reset": func(url string) string { return ircReset + url + ircReset },
+}
+
+// newLinkFunc returns the link template function wrapping URLs in style, the
+// default one when unset.
+func newLinkFunc(style string) (func(string) string, error) {
+	if style == "" {
+		style = defaultLinkStyle
+	}
+	link, ok := linkStyles[style]
+	if !ok {
+		return nil, fmt.Errorf("invalid link_style value: %s", style)
+	}
+	return link, nil
+}
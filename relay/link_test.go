@@ -0,0 +1,50 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestLinkStyles(t *testing.T) {
+	alert := promtmpl.Alert{GeneratorURL: "http://prometheus/graph?g0.expr=up"}
+	alertMsg := AlertMsg{Channel: "#foo", AlertData: &alert}
+
+	for _, test := range []struct {
+		style, expected string
+	}{
+		{"", "See  http://prometheus/graph?g0.expr=up ."},
+		{"plain", "See http://prometheus/graph?g0.expr=up."},
+		{"spaced", "See  http://prometheus/graph?g0.expr=up ."},
+		{"angle", "See <http://prometheus/graph?g0.expr=up>."},
+		{"reset", "See \x0fhttp://prometheus/graph?g0.expr=up\x0f."},
+	} {
+		formatter := makeTestFormatter(t, &Config{
+			MsgTemplate: `See {{ link .GeneratorURL }}.`,
+			LinkStyle:   test.style,
+		})
+		if msg := formatter.RenderMsg(&alertMsg); msg != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.style, test.expected, msg)
+		}
+	}
+}
+
+func TestUnknownLinkStyle(t *testing.T) {
+	if _, err := NewFormatter(&Config{LinkStyle: "bold"}); err == nil {
+		t.Error("Expected an error for an unknown link style")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"errors"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// MaintenanceWindow suppresses the alerts whose labels match all of
+// Matchers between Start and End. Without matchers, all alerts match.
+type MaintenanceWindow struct {
+	Name     string            `yaml:"name"`
+	Start    time.Time         `yaml:"start"`
+	End      time.Time         `yaml:"end"`
+	Matchers map[string]string `yaml:"matchers"`
+}
+
+// Init validates the maintenance window configuration.
+func (w *MaintenanceWindow) Init() error {
+	if w.Start.IsZero() || w.End.IsZero() {
+		return errors.New("maintenance window needs a start and an end")
+	}
+	if !w.End.After(w.Start) {
+		return errors.New("maintenance window must end after its start")
+	}
+	return nil
+}
+
+// Active returns true if now is within the window.
+func (w *MaintenanceWindow) Active(now time.Time) bool {
+	return !now.Before(w.Start) && now.Before(w.End)
+}
+
+// Matches returns true if labels match all of the window matchers.
+func (w *MaintenanceWindow) Matches(labels promtmpl.KV) bool {
+	for name, value := range w.Matchers {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// activeMaintenanceWindow returns the window active at now covering the
+// alert(s) of alertMsg, if any. Messages for a whole group are only covered
+// if all its alerts match.
+func activeMaintenanceWindow(windows []MaintenanceWindow, alertMsg *AlertMsg,
+	now time.Time) *MaintenanceWindow {
+	var alerts promtmpl.Alerts
+	switch {
+	case alertMsg.AlertData != nil:
+		alerts = promtmpl.Alerts{*alertMsg.AlertData}
+	case alertMsg.GroupData != nil:
+		alerts = alertMsg.GroupData.Alerts
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+	for i := range windows {
+		window := &windows[i]
+		if !window.Active(now) {
+			continue
+		}
+		covered := true
+		for _, alert := range alerts {
+			if !window.Matches(alert.Labels) {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			return window
+		}
+	}
+	return nil
+}
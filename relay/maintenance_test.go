@@ -0,0 +1,70 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestMaintenanceWindowCoversAlerts(t *testing.T) {
+	windows := []MaintenanceWindow{
+		MaintenanceWindow{
+			Name:     "db upgrade",
+			Start:    time.Date(2017, 5, 15, 22, 0, 0, 0, time.UTC),
+			End:      time.Date(2017, 5, 16, 2, 0, 0, 0, time.UTC),
+			Matchers: map[string]string{"service": "db"},
+		},
+	}
+	during := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	after := time.Date(2017, 5, 16, 2, 0, 0, 0, time.UTC)
+	dbAlert := promtmpl.Alert{Labels: promtmpl.KV{"service": "db"}}
+	webAlert := promtmpl.Alert{Labels: promtmpl.KV{"service": "web"}}
+
+	for _, test := range []struct {
+		alertMsg *AlertMsg
+		now      time.Time
+		covered  bool
+	}{
+		{&AlertMsg{AlertData: &dbAlert}, during, true},
+		{&AlertMsg{AlertData: &dbAlert}, after, false},
+		{&AlertMsg{AlertData: &webAlert}, during, false},
+		{&AlertMsg{GroupData: &WebhookData{Data: promtmpl.Data{
+			Alerts: promtmpl.Alerts{dbAlert, dbAlert}}}}, during, true},
+		// Groups are sent as long as one of their alerts is not covered.
+		{&AlertMsg{GroupData: &WebhookData{Data: promtmpl.Data{
+			Alerts: promtmpl.Alerts{dbAlert, webAlert}}}}, during, false},
+	} {
+		window := activeMaintenanceWindow(windows, test.alertMsg, test.now)
+		if (window != nil) != test.covered {
+			t.Errorf("Alert msg %+v at %s: expected covered=%t",
+				test.alertMsg, test.now, test.covered)
+		}
+	}
+}
+
+func TestMaintenanceWindowInvalid(t *testing.T) {
+	start := time.Date(2017, 5, 15, 22, 0, 0, 0, time.UTC)
+	for _, window := range []MaintenanceWindow{
+		MaintenanceWindow{Start: start},
+		MaintenanceWindow{Start: start, End: start},
+	} {
+		if err := window.Init(); err == nil {
+			t.Errorf("Expected an error for window %+v", window)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"regexp"
+)
+
+// mentions matches the Discord mentions a bridge would turn into pings:
+// @everyone and @here, and user or role mentions such as <@123>, <@!123>
+// and <@&123>. Addresses and other uses of @ are left alone.
+var mentions = regexp.MustCompile(`(^|[^\w@])@(everyone|here)\b|<@[!&]?[0-9]+>`)
+
+// zeroWidthSpace, inserted after the @ of mentions, stops Discord from
+// recognizing them while they still read the same.
+const zeroWidthSpace = "\u200b"
+
+// escapeMentions returns s with its mentions neutralized.
+func escapeMentions(s string) string {
+	return mentions.ReplaceAllStringFunc(s, func(mention string) string {
+		i := 0
+		for mention[i] != '@' {
+			i++
+		}
+		return mention[:i+1] + zeroWidthSpace + mention[i+1:]
+	})
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestEscapeMentions(t *testing.T) {
+	for input, expected := range map[string]string{
+		"@everyone disk full":       "@\u200beveryone disk full",
+		"ping @here, now":           "ping @\u200bhere, now",
+		"owner <@123> and <@!456>":  "owner <@\u200b123> and <@\u200b!456>",
+		"role <@&789> paged":        "role <@\u200b&789> paged",
+		"mail ops@everyone.example": "mail ops@everyone.example",
+		"@everyonex @herebefore":    "@everyonex @herebefore",
+		"channel <#123> <@user>":    "channel <#123> <@user>",
+	} {
+		if output := escapeMentions(input); output != expected {
+			t.Errorf("Unexpected output for %q: %q", input, output)
+		}
+	}
+}
+
+func TestEscapeMentionsInLines(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate:      "{{ .Annotations.summary }}",
+		MsgLineDelimiter: "\n",
+		EscapeMentions:   true,
+	})
+	alert := promtmpl.Alert{
+		Annotations: promtmpl.KV{"summary": "Disk full\ntell @everyone"},
+	}
+	lines := formatter.RenderMsgLines(&AlertMsg{Channel: "#foo", AlertData: &alert})
+	if len(lines) != 2 || lines[0] != "Disk full" ||
+		lines[1] != "tell @\u200beveryone" {
+		t.Errorf("Unexpected lines: %q", lines)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	nickCollisionCaret      = "caret"
+	nickCollisionUnderscore = "underscore"
+	nickCollisionCounter    = "counter"
+	nickCollisionRandom     = "random"
+
+	randomNickSuffixLen = 4
+	// NICKLEN is only advertised once registered, too late for collisions
+	// while registering.
+	defaultNickMaxLength = 30
+)
+
+// newNickFunc returns the function picking the next nick to try when nick
+// is in use, following strategy. Candidates are derived from the configured
+// nick, cut so that they fit in maxLength along with their suffix.
+func newNickFunc(strategy string, nick string,
+	maxLength int) (func(string) string, error) {
+	withSuffix := func(suffix string) string {
+		if room := maxLength - len(suffix); len(nick) > room && room > 0 {
+			return nick[:room] + suffix
+		}
+		return nick + suffix
+	}
+	switch strategy {
+	case "", nickCollisionCaret:
+		return repeatedSuffixNickFunc(nick, "^", withSuffix), nil
+	case nickCollisionUnderscore:
+		return repeatedSuffixNickFunc(nick, "_", withSuffix), nil
+	case nickCollisionCounter:
+		return func(n string) string {
+			var digits string
+			if strings.HasPrefix(n, nick) {
+				digits = strings.TrimPrefix(n, nick)
+			} else {
+				// Cut to fit the counter.
+				digits = n[len(strings.TrimRight(n, "0123456789")):]
+			}
+			counter, err := strconv.Atoi(digits)
+			if err != nil || n == nick {
+				counter = 0
+			}
+			return withSuffix(strconv.Itoa(counter + 1))
+		}, nil
+	case nickCollisionRandom:
+		return func(string) string {
+			return withSuffix(fmt.Sprintf("%0*d", randomNickSuffixLen,
+				rand.Intn(10000)))
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid irc_nick_collision_strategy value: %s",
+		strategy)
+}
+
+// repeatedSuffixNickFunc appends one more suffix to the configured nick than
+// the nick in use has.
+func repeatedSuffixNickFunc(nick string, suffix string,
+	withSuffix func(string) string) func(string) string {
+	trailing := func(n string) int {
+		return len(n) - len(strings.TrimRight(n, suffix))
+	}
+	return func(n string) string {
+		count := trailing(n) - trailing(nick) + 1
+		if count < 1 {
+			count = 1
+		}
+		return withSuffix(strings.Repeat(suffix, count))
+	}
+}
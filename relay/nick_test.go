@@ -0,0 +1,96 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNewNickFunc(t *testing.T) {
+	for _, test := range []struct {
+		strategy string
+		inUse    []string
+		expected []string
+	}{
+		{nickCollisionCaret, []string{"foo", "foo^"}, []string{"foo^", "foo^^"}},
+		{nickCollisionUnderscore, []string{"foo", "foo_"}, []string{"foo_", "foo__"}},
+		{nickCollisionCounter,
+			[]string{"foo", "foo1", "foo9", "bar"},
+			[]string{"foo1", "foo2", "foo10", "foo1"}},
+	} {
+		newNick, err := newNickFunc(test.strategy, "foo", defaultNickMaxLength)
+		if err != nil {
+			t.Fatalf("Could not create %s nick function: %s", test.strategy, err)
+		}
+		for i, inUse := range test.inUse {
+			if nick := newNick(inUse); nick != test.expected[i] {
+				t.Errorf("%s: expected %s after %s, got %s",
+					test.strategy, test.expected[i], inUse, nick)
+			}
+		}
+	}
+}
+
+func TestNewNickFuncMaxLength(t *testing.T) {
+	for _, test := range []struct {
+		strategy string
+		inUse    []string
+		expected []string
+	}{
+		{nickCollisionCaret,
+			[]string{"foobar", "foob^", "foo^^"},
+			[]string{"foob^", "foo^^", "fo^^^"}},
+		{nickCollisionUnderscore, []string{"foobar"}, []string{"foob_"}},
+		{nickCollisionCounter,
+			[]string{"foobar", "foob1", "foob9", "foo10"},
+			[]string{"foob1", "foob2", "foo10", "foo11"}},
+	} {
+		newNick, err := newNickFunc(test.strategy, "foobar", 5)
+		if err != nil {
+			t.Fatalf("Could not create %s nick function: %s", test.strategy, err)
+		}
+		for i, inUse := range test.inUse {
+			if nick := newNick(inUse); nick != test.expected[i] {
+				t.Errorf("%s: expected %s after %s, got %s",
+					test.strategy, test.expected[i], inUse, nick)
+			}
+		}
+	}
+
+	newNick, _ := newNickFunc(nickCollisionRandom, "foobar", 5)
+	if nick := newNick("foobar"); !regexp.MustCompile(`^f[0-9]{4}$`).MatchString(nick) {
+		t.Errorf("Unexpected random nick: %s", nick)
+	}
+}
+
+func TestNewNickFuncRandom(t *testing.T) {
+	newNick, err := newNickFunc(nickCollisionRandom, "foo", defaultNickMaxLength)
+	if err != nil {
+		t.Fatalf("Could not create random nick function: %s", err)
+	}
+	expected := regexp.MustCompile(`^foo[0-9]{4}$`)
+	for _, inUse := range []string{"foo", "foo1234"} {
+		if nick := newNick(inUse); !expected.MatchString(nick) {
+			t.Errorf("Unexpected random nick after %s: %s", inUse, nick)
+		}
+	}
+}
+
+func TestNewNickFuncInvalid(t *testing.T) {
+	if _, err := newNickFunc("bogus", "foo", defaultNickMaxLength); err == nil {
+		t.Error("Expected an error for an invalid strategy")
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Bounds the alerts each IRC notifier holds while delivery is paused.
+const maxPausedAlertMsgs = 1000
+
+var (
+	deliveryPaused = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "delivery_paused",
+			Help: "Whether delivery of alerts to IRC is paused by an operator"},
+	)
+	pausedAlerts = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "irc_paused_alerts",
+			Help: "Number of alerts held while delivery is paused"},
+		[]string{"connection"},
+	)
+)
+
+// DeliveryPause is the switch operators pause delivery of alerts to IRC
+// with, shared by the HTTP server and the IRC notifiers, which hold alerts
+// until resumed. It is safe to use from any goroutine.
+type DeliveryPause struct {
+	mu     sync.Mutex
+	paused bool
+	// Signalled on resume, one per notifier.
+	resumed []chan struct{}
+}
+
+func NewDeliveryPause() *DeliveryPause {
+	return &DeliveryPause{}
+}
+
+// Pause pauses delivery, returning whether it was not already paused.
+func (p *DeliveryPause) Pause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		return false
+	}
+	p.paused = true
+	deliveryPaused.Set(1)
+	return true
+}
+
+// Resume resumes delivery, returning whether it was paused.
+func (p *DeliveryPause) Resume() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return false
+	}
+	p.paused = false
+	deliveryPaused.Set(0)
+	for _, resumed := range p.resumed {
+		select {
+		case resumed <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// Paused returns whether delivery is paused.
+func (p *DeliveryPause) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// subscribe returns a channel signalled when delivery is resumed.
+func (p *DeliveryPause) subscribe() chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	resumed := make(chan struct{}, 1)
+	p.resumed = append(p.resumed, resumed)
+	return resumed
+}
+
+// SetDeliveryPause makes the notifier hold alerts while pause is paused.
+// It must be called before Run.
+func (notifier *IRCNotifier) SetDeliveryPause(pause *DeliveryPause) {
+	notifier.pause = pause
+	notifier.resumed = pause.subscribe()
+}
+
+// holdWhilePaused returns true if alertMsg must not be sent now as delivery
+// is paused, holding it until resumed.
+func (notifier *IRCNotifier) holdWhilePaused(alertMsg *AlertMsg) bool {
+	if notifier.pause == nil || !notifier.pause.Paused() {
+		return false
+	}
+	held := notifier.pausedAlertMsgs
+	if len(held) >= maxPausedAlertMsgs {
+		log.Printf("Too many alerts held while paused, dropping the oldest")
+		held = held[1:]
+	}
+	notifier.pausedAlertMsgs = append(held, *alertMsg)
+	pausedAlerts.WithLabelValues(notifier.connection).Set(
+		float64(len(notifier.pausedAlertMsgs)))
+	return true
+}
+
+// SendPausedAlertMsgs sends the alerts held while delivery was paused, once
+// resumed and connected.
+func (notifier *IRCNotifier) SendPausedAlertMsgs() {
+	if !notifier.sessionUp || len(notifier.pausedAlertMsgs) == 0 ||
+		notifier.pause.Paused() {
+		return
+	}
+	held := notifier.pausedAlertMsgs
+	log.Printf("Sending %d alerts held while paused", len(held))
+	notifier.pausedAlertMsgs = nil
+	pausedAlerts.WithLabelValues(notifier.connection).Set(0)
+	for i := range held {
+		notifier.MaybeSendAlertMsg(&held[i])
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDeliveryPauseHoldsAlerts(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	notifier, alertMsgs := makeTestNotifier(t, config)
+	pause := NewDeliveryPause()
+	notifier.SetDeliveryPause(pause)
+	defer pause.Resume()
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	server.SetHandler("JOIN", nil)
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	pause.Pause()
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "first"}
+	alertMsgs <- AlertMsg{Channel: "#bar", Alert: "second"}
+	for i := 0; i < 100 && testutil.ToFloat64(pausedAlerts.WithLabelValues(defaultConnection)) != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if held := testutil.ToFloat64(pausedAlerts.WithLabelValues(defaultConnection)); held != 2 {
+		t.Errorf("Expected 2 held alerts, got %f", held)
+	}
+
+	// Held alerts are sent once resumed.
+	testStep.Add(2)
+	pause.Resume()
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedCommands := []string{
+		"NICK foo",
+		"USER foo 12 * :",
+		"JOIN #foo",
+		"JOIN #bar",
+		"JOIN #baz",
+		"NOTICE #foo :first",
+		"NOTICE #bar :second",
+		"QUIT :see ya",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, server.Log) {
+		t.Error("Alerts not held while paused. Received commands:\n", strings.Join(server.Log, "\n"))
+	}
+}
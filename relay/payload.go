@@ -0,0 +1,152 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+const (
+	payloadFormatAlertmanager = "alertmanager"
+	payloadFormatOpsGenie     = "opsgenie"
+)
+
+// payloadDecoder decodes the JSON webhooks of an alerting source, returning
+// the HTTP status to reply with on errors.
+type payloadDecoder func(io.Reader) (*WebhookData, int, error)
+
+var payloadDecoders = map[string]payloadDecoder{
+	payloadFormatAlertmanager: decodeJSONAlert,
+	payloadFormatOpsGenie:     decodeOpsGenieAlert,
+}
+
+// newPayloadDecoder returns the decoder of format, Alertmanager webhooks when
+// unset.
+func newPayloadDecoder(format string) payloadDecoder {
+	if decoder, ok := payloadDecoders[format]; ok {
+		return decoder
+	}
+	return decodeJSONAlert
+}
+
+// opsGenieWebhook holds the fields of OpsGenie webhooks that are relayed.
+type opsGenieWebhook struct {
+	Action string `json:"action"`
+	Alert  *struct {
+		AlertID     string            `json:"alertId"`
+		Message     string            `json:"message"`
+		Description string            `json:"description"`
+		Alias       string            `json:"alias"`
+		Entity      string            `json:"entity"`
+		Source      string            `json:"source"`
+		Priority    string            `json:"priority"`
+		Tags        []string          `json:"tags"`
+		Details     map[string]string `json:"details"`
+		CreatedAt   int64             `json:"createdAt"`
+		UpdatedAt   int64             `json:"updatedAt"`
+	} `json:"alert"`
+	IntegrationName string `json:"integrationName"`
+}
+
+// decodeOpsGenieAlert maps an OpsGenie webhook onto webhook data holding its
+// alert, resolved once closed or deleted. The message is the alertname and
+// summary, the details, entity, source, priority and tags are labels.
+// Other fields are ignored.
+func decodeOpsGenieAlert(body io.Reader) (*WebhookData, int, error) {
+	webhook := &opsGenieWebhook{}
+	if err := json.NewDecoder(body).Decode(webhook); err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, 422, err
+		}
+		if err == io.EOF {
+			err = errors.New("empty body")
+		}
+		return nil, http.StatusBadRequest, err
+	}
+	if webhook.Action == "" || webhook.Alert == nil ||
+		webhook.Alert.AlertID == "" || webhook.Alert.Message == "" {
+		return nil, http.StatusBadRequest,
+			errors.New("OpsGenie webhook without action, alert.alertId or alert.message")
+	}
+	og := webhook.Alert
+
+	labels := promtmpl.KV{}
+	for name, value := range og.Details {
+		labels[name] = value
+	}
+	labels["alertname"] = og.Message
+	for name, value := range map[string]string{
+		"alias":    og.Alias,
+		"entity":   og.Entity,
+		"source":   og.Source,
+		"priority": og.Priority,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	if len(og.Tags) > 0 {
+		tags := append([]string{}, og.Tags...)
+		sort.Strings(tags)
+		labels["tags"] = strings.Join(tags, ",")
+	}
+	annotations := promtmpl.KV{"summary": og.Message}
+	if og.Description != "" {
+		annotations["description"] = og.Description
+	}
+
+	alert := promtmpl.Alert{
+		Status:      "firing",
+		Labels:      labels,
+		Annotations: annotations,
+		Fingerprint: og.AlertID,
+	}
+	if og.CreatedAt != 0 {
+		alert.StartsAt = opsGenieTime(og.CreatedAt)
+	}
+	switch webhook.Action {
+	case "Close", "Delete":
+		alert.Status = "resolved"
+		if og.UpdatedAt != 0 {
+			alert.EndsAt = opsGenieTime(og.UpdatedAt)
+		}
+	}
+
+	data := &WebhookData{GroupKey: og.AlertID}
+	data.Receiver = webhook.IntegrationName
+	data.Status = alert.Status
+	data.Alerts = promtmpl.Alerts{alert}
+	data.GroupLabels = promtmpl.KV{"alertname": og.Message}
+	data.CommonLabels = labels
+	data.CommonAnnotations = annotations
+	return data, 0, nil
+}
+
+// opsGenieTime returns the time of an OpsGenie timestamp, in milliseconds
+// since the epoch, or in nanoseconds as updatedAt is.
+func opsGenieTime(timestamp int64) time.Time {
+	if timestamp < 1e15 {
+		timestamp *= int64(time.Millisecond)
+	}
+	return time.Unix(0, timestamp).UTC()
+}
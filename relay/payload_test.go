@@ -0,0 +1,136 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+const testdataOpsGenieJson = `
+{
+  "action": "Create",
+  "alert": {
+    "alertId": "70413a06-38d6-4c85-92b8-5ebc900d42e2",
+    "message": "airDown",
+    "tags": ["prod", "network"],
+    "tinyId": "1791",
+    "entity": "instance1:3456",
+    "alias": "airDown-instance1",
+    "createdAt": 1494856177834,
+    "updatedAt": 1494856177834000000,
+    "username": "Alert API",
+    "userId": "7a2a7d3c-bd7b-4a8b-a6a5-7a4b2c3fbe9b",
+    "description": "The air is down",
+    "team": "ops",
+    "responders": [{"id": "ops", "type": "team"}],
+    "details": {"severity": "critical"},
+    "priority": "P1",
+    "source": "prometheus"
+  },
+  "source": {"name": "", "type": "API"},
+  "integrationName": "Legacy Webhook",
+  "integrationId": "3b3c5b2a-a0d4-4c4e-8d9e-5b6e2e6c6f0e",
+  "integrationType": "Webhook"
+}
+`
+
+func TestDecodeOpsGenieAlert(t *testing.T) {
+	data, _, err := decodeOpsGenieAlert(strings.NewReader(testdataOpsGenieJson))
+	if err != nil {
+		t.Fatalf("Could not decode OpsGenie webhook: %s", err)
+	}
+	expectedAlert := promtmpl.Alert{
+		Status: "firing",
+		Labels: promtmpl.KV{
+			"alertname": "airDown",
+			"alias":     "airDown-instance1",
+			"entity":    "instance1:3456",
+			"source":    "prometheus",
+			"priority":  "P1",
+			"severity":  "critical",
+			"tags":      "network,prod",
+		},
+		Annotations: promtmpl.KV{
+			"summary":     "airDown",
+			"description": "The air is down",
+		},
+		StartsAt:    time.Date(2017, 5, 15, 13, 49, 37, 834000000, time.UTC),
+		Fingerprint: "70413a06-38d6-4c85-92b8-5ebc900d42e2",
+	}
+	if len(data.Alerts) != 1 || !reflect.DeepEqual(expectedAlert, data.Alerts[0]) {
+		t.Errorf("Unexpected alerts: %+v", data.Alerts)
+	}
+	if data.Status != "firing" || data.Receiver != "Legacy Webhook" ||
+		data.GroupLabels["alertname"] != "airDown" {
+		t.Errorf("Unexpected group: %+v", data)
+	}
+
+	closed := strings.Replace(testdataOpsGenieJson, `"Create"`, `"Close"`, 1)
+	data, _, err = decodeOpsGenieAlert(strings.NewReader(closed))
+	if err != nil {
+		t.Fatalf("Could not decode OpsGenie webhook: %s", err)
+	}
+	if data.Status != "resolved" || data.Alerts[0].Status != "resolved" ||
+		!data.Alerts[0].EndsAt.Equal(time.Date(2017, 5, 15, 13, 49, 37, 834000000, time.UTC)) {
+		t.Errorf("Unexpected closed alert: %+v", data.Alerts[0])
+	}
+}
+
+func TestOpsGenieWebhooks(t *testing.T) {
+	for _, test := range []struct {
+		payload      string
+		expectedCode int
+	}{
+		{testdataOpsGenieJson, 200},
+		{`{"action": "Create", "alert": {"message": "airDown"}}`, 400},
+		{`{"alert": {"alertId": "1", "message": "airDown"}}`, 400},
+		{`{"action": "Create"}`, 400},
+		{`{"action": "Create", "alert": {"alertId": 1}}`, 422},
+		{`{"action": `, 400},
+	} {
+		listener := NewFakeHTTPListener()
+		testingConfig := MakeHTTPTestingConfig()
+		testingConfig.PayloadFormat = payloadFormatOpsGenie
+		testingConfig.MsgTemplate = "Alert {{ .Labels.alertname }} on {{ .Labels.entity }} is {{ .Status }}"
+
+		response := RunHTTPTest(
+			t, test.payload, "/somechannel", testingConfig, listener)
+		if response.StatusCode != test.expectedCode {
+			t.Errorf("Expected %d status for %s, got %d",
+				test.expectedCode, test.payload, response.StatusCode)
+			continue
+		}
+		if test.expectedCode != 200 {
+			continue
+		}
+		expectedAlertMsg := AlertMsg{
+			Channel:  "#somechannel",
+			Alert:    "Alert airDown on instance1:3456 is firing",
+			StartsAt: time.Date(2017, 5, 15, 13, 49, 37, 834000000, time.UTC),
+		}
+		alertMsg := renderAlertMsg(t, testingConfig, <-listener.AlertMsgs)
+		if !reflect.DeepEqual(expectedAlertMsg, alertMsg) {
+			t.Error(fmt.Sprintf(
+				"Unexpected alert msg.\nExpected: %+v\nActual: %+v",
+				expectedAlertMsg, alertMsg))
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+type postRequest struct {
+	url     string
+	channel string
+	body    []byte
+}
+
+// postQueue posts JSON bodies from its own goroutine so that callers never
+// wait on the receiving end, retrying failed posts. It is shared by the
+// Forwarder and the AckSender.
+type postQueue struct {
+	// what is logged when a post fails, e.g. "forward webhook".
+	what         string
+	client       *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+	// exponential doubles the backoff after each retry.
+	exponential bool
+	// result, if set, is called with "sent", "retried" or "failed" for each
+	// attempt.
+	result   func(channel string, result string)
+	requests chan postRequest
+	stop     chan bool
+	done     chan bool
+}
+
+func newPostQueue(what string, queueSize int, timeout time.Duration,
+	maxRetries int, retryBackoff time.Duration) *postQueue {
+	queue := &postQueue{
+		what:         what,
+		client:       &http.Client{Timeout: timeout},
+		maxRetries:   maxRetries,
+		retryBackoff: retryBackoff,
+		requests:     make(chan postRequest, queueSize),
+		stop:         make(chan bool),
+		done:         make(chan bool),
+	}
+	go queue.run()
+	return queue
+}
+
+// enqueue queues the request without blocking, returning false if the queue
+// is full.
+func (q *postQueue) enqueue(request postRequest) bool {
+	select {
+	case q.requests <- request:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *postQueue) record(channel string, result string) {
+	if q.result != nil {
+		q.result(channel, result)
+	}
+}
+
+func (q *postQueue) post(request postRequest) error {
+	response, err := q.client.Post(request.url, "application/json",
+		bytes.NewReader(request.body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+func (q *postQueue) send(request postRequest) {
+	backoff := q.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := q.post(request)
+		if err == nil {
+			q.record(request.channel, "sent")
+			return
+		}
+		if attempt == q.maxRetries {
+			log.Printf("Could not %s for %s, giving up: %s",
+				q.what, request.channel, err)
+			q.record(request.channel, "failed")
+			return
+		}
+		log.Printf("Could not %s for %s, retrying in %s: %s",
+			q.what, request.channel, backoff, err)
+		q.record(request.channel, "retried")
+		select {
+		case <-time.After(backoff):
+			if q.exponential {
+				backoff *= 2
+			}
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+func (q *postQueue) run() {
+	defer close(q.done)
+	for {
+		select {
+		case request := <-q.requests:
+			q.send(request)
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// Close stops posting, dropping the queued requests.
+func (q *postQueue) Close() {
+	close(q.stop)
+	<-q.done
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientAddr(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Could not parse trusted proxies: %s", err)
+	}
+	testCases := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expectedAddr string
+	}{
+		{"direct", "198.51.100.7:1234", nil, "198.51.100.7"},
+		{"untrusted forwarder ignored", "198.51.100.7:1234",
+			[]string{"203.0.113.9"}, "198.51.100.7"},
+		{"trusted proxy", "10.1.2.3:1234",
+			[]string{"203.0.113.9"}, "203.0.113.9"},
+		{"trusted proxy by address", "192.0.2.1:1234",
+			[]string{"203.0.113.9"}, "203.0.113.9"},
+		{"spoofed hops ignored", "10.1.2.3:1234",
+			[]string{"1.2.3.4, 203.0.113.9, 10.4.5.6"}, "203.0.113.9"},
+		{"several headers", "10.1.2.3:1234",
+			[]string{"1.2.3.4", "203.0.113.9"}, "203.0.113.9"},
+		{"only trusted hops", "10.1.2.3:1234",
+			[]string{"10.4.5.6"}, "10.4.5.6"},
+		{"garbage header", "10.1.2.3:1234",
+			[]string{"not an address"}, "10.1.2.3"},
+		{"no header", "10.1.2.3:1234", nil, "10.1.2.3"},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest("POST", "/foo", nil)
+		r.RemoteAddr = tc.remoteAddr
+		for _, header := range tc.forwardedFor {
+			r.Header.Add("X-Forwarded-For", header)
+		}
+		if addr := clientAddr(r, trusted); addr != tc.expectedAddr {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.expectedAddr, addr)
+		}
+	}
+}
+
+func TestParseBadTrustedProxies(t *testing.T) {
+	for _, proxy := range []string{"10.0.0.0/33", "proxy.example.com"} {
+		if _, err := parseTrustedProxies([]string{proxy}); err == nil {
+			t.Errorf("Expected error for trusted proxy %s", proxy)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	quietHoursSuppress = "suppress"
+	quietHoursQueue    = "queue"
+
+	criticalSeverity = "critical"
+)
+
+// severityOrder lists known severities, from least to most severe.
+var severityOrder = []string{"info", "warning", "error", criticalSeverity}
+
+// severityRank returns the position of severity in severityOrder, unknown
+// severities being the least severe.
+func severityRank(severity string) int {
+	for i, s := range severityOrder {
+		if s == severity {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// alertMsgSeverity returns the severity label of the alert(s) in alertMsg.
+func alertMsgSeverity(alertMsg *AlertMsg) string {
+	switch {
+	case alertMsg.AlertData != nil:
+		return alertMsg.AlertData.Labels["severity"]
+	case alertMsg.GroupData != nil:
+		return alertMsg.GroupData.CommonLabels["severity"]
+	default:
+		return ""
+	}
+}
+
+// QuietHours holds back alerts below MinSeverity sent to a channel between
+// Start and End (e.g. "22:00" and "07:00") in Timezone. Held alerts are
+// either dropped or queued until the quiet hours end, depending on Action.
+type QuietHours struct {
+	Start       string `yaml:"start"`
+	End         string `yaml:"end"`
+	Timezone    string `yaml:"timezone"`
+	MinSeverity string `yaml:"min_severity"`
+	Action      string `yaml:"action"`
+
+	startMinute int
+	endMinute   int
+	location    *time.Location
+}
+
+func parseMinuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quiet hours time %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Init validates the quiet hours configuration and applies defaults.
+func (q *QuietHours) Init() error {
+	var err error
+	if q.startMinute, err = parseMinuteOfDay(q.Start); err != nil {
+		return err
+	}
+	if q.endMinute, err = parseMinuteOfDay(q.End); err != nil {
+		return err
+	}
+	if q.location, err = time.LoadLocation(q.Timezone); err != nil {
+		return err
+	}
+	if q.MinSeverity == "" {
+		q.MinSeverity = criticalSeverity
+	}
+	if q.Action == "" {
+		q.Action = quietHoursSuppress
+	}
+	if q.Action != quietHoursSuppress && q.Action != quietHoursQueue {
+		return fmt.Errorf("invalid quiet hours action: %s", q.Action)
+	}
+	return nil
+}
+
+// Active returns true if now is within the quiet hours.
+func (q *QuietHours) Active(now time.Time) bool {
+	local := now.In(q.location)
+	minute := local.Hour()*60 + local.Minute()
+	if q.startMinute <= q.endMinute {
+		return minute >= q.startMinute && minute < q.endMinute
+	}
+	// The quiet hours span midnight.
+	return minute >= q.startMinute || minute < q.endMinute
+}
+
+// Holds returns true if alerts of this severity are held back during the
+// quiet hours. Critical alerts always pass.
+func (q *QuietHours) Holds(severity string) bool {
+	if severity == criticalSeverity {
+		return false
+	}
+	return severityRank(severity) < severityRank(q.MinSeverity)
+}
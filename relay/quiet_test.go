@@ -0,0 +1,90 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func makeTestQuietHours(t *testing.T, start string, end string) *QuietHours {
+	quietHours := &QuietHours{
+		Start:       start,
+		End:         end,
+		Timezone:    "Europe/Zurich",
+		MinSeverity: "error",
+	}
+	if err := quietHours.Init(); err != nil {
+		t.Fatalf("Could not init quiet hours: %s", err)
+	}
+	return quietHours
+}
+
+func TestQuietHoursActive(t *testing.T) {
+	overnight := makeTestQuietHours(t, "22:00", "07:00")
+	daytime := makeTestQuietHours(t, "12:00", "14:00")
+
+	for _, test := range []struct {
+		quietHours *QuietHours
+		utcTime    string
+		expected   bool
+	}{
+		// Zurich is UTC+2 in May.
+		{overnight, "19:59", false},
+		{overnight, "20:00", true},
+		{overnight, "02:00", true},
+		{overnight, "04:59", true},
+		{overnight, "05:00", false},
+		{daytime, "09:59", false},
+		{daytime, "10:00", true},
+		{daytime, "12:00", false},
+	} {
+		now, _ := time.Parse("2006-01-02 15:04", "2017-05-15 "+test.utcTime)
+		if active := test.quietHours.Active(now); active != test.expected {
+			t.Errorf("Quiet hours %s-%s at %s UTC: expected active=%t",
+				test.quietHours.Start, test.quietHours.End,
+				test.utcTime, test.expected)
+		}
+	}
+}
+
+func TestQuietHoursHolds(t *testing.T) {
+	quietHours := makeTestQuietHours(t, "22:00", "07:00")
+
+	for severity, expected := range map[string]bool{
+		"":         true,
+		"unknown":  true,
+		"info":     true,
+		"warning":  true,
+		"error":    false,
+		"critical": false,
+	} {
+		if held := quietHours.Holds(severity); held != expected {
+			t.Errorf("Severity %q: expected held=%t", severity, expected)
+		}
+	}
+}
+
+func TestQuietHoursInvalid(t *testing.T) {
+	for _, quietHours := range []*QuietHours{
+		&QuietHours{Start: "22h", End: "07:00"},
+		&QuietHours{Start: "22:00", End: "07:00", Timezone: "Nowhere/Land"},
+		&QuietHours{Start: "22:00", End: "07:00", Action: "ignore"},
+	} {
+		if err := quietHours.Init(); err == nil {
+			t.Errorf("Expected error for quiet hours %+v", quietHours)
+		}
+	}
+}
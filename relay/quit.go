@@ -0,0 +1,62 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultQuitMessage = "see ya"
+
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// QuitData is passed to the quit message template.
+type QuitData struct {
+	Nick string
+	// Since the notifier started running, and the alert messages it sent
+	// meanwhile.
+	Uptime    time.Duration
+	Delivered int
+}
+
+// parseQuitTemplate parses the quit message template, defaultQuitMessage
+// when text is empty.
+func parseQuitTemplate(text string,
+	parser templateParser) (*template.Template, error) {
+	if text == "" {
+		text = defaultQuitMessage
+	}
+	return parser.parse("quit", text)
+}
+
+// quitMessage renders the quit message, defaultQuitMessage on errors. Line
+// breaks are replaced by spaces, as they would end the QUIT command.
+func (notifier *IRCNotifier) quitMessage() string {
+	data := QuitData{
+		Nick:      notifier.Client.Me().Nick,
+		Uptime:    notifier.timeNow().Sub(notifier.startedAt).Truncate(time.Second),
+		Delivered: notifier.delivered,
+	}
+	output := bytes.Buffer{}
+	if err := notifier.quitTmpl.Execute(&output, data); err != nil {
+		log.Printf("Could not render quit message: %s", err)
+		return defaultQuitMessage
+	}
+	return lineBreaks.Replace(output.String())
+}
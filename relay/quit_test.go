@@ -0,0 +1,65 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"sync"
+	"testing"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+func TestQuitMessageTemplate(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCQuitMessage = "shutting down,\ndelivered {{ .Delivered }} alerts"
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+
+	testStep.Wait()
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(2)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "first"}
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "second"}
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	quit := server.Log[len(server.Log)-1]
+	if expected := "QUIT :shutting down, delivered 2 alerts"; quit != expected {
+		t.Errorf("Expected %q, got %q", expected, quit)
+	}
+}
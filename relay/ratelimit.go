@@ -0,0 +1,147 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const maxRateLimitedClients = 10000
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// SeverityRateLimit is a per client rate limit of webhooks of a severity, in
+// webhooks per second and bursts as for IPRateLimiter. A 0 rate lets them
+// through unthrottled.
+type SeverityRateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// IPRateLimiter is a token bucket per client, refilled with rate tokens per
+// second up to burst. Buckets are forgotten once full again, and at most
+// max are kept, evicting the least recently used first.
+type IPRateLimiter struct {
+	rate  float64
+	burst float64
+	max   int
+	// The time after which an idle bucket is full, hence forgotten.
+	idle time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewIPRateLimiter returns a limiter allowing rate requests per second per
+// client, in bursts of up to burst requests (rate rounded up when 0).
+func NewIPRateLimiter(rate float64, burst int, max int) *IPRateLimiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &IPRateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		max:     max,
+		idle:    time.Duration(float64(burst) / rate * float64(time.Second)),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (l *IPRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idle {
+		return
+	}
+	l.lastSweep = now
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= l.idle {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// Allow takes a token from the bucket of client, returning false if it is
+// empty.
+func (l *IPRateLimiter) Allow(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= l.max {
+			oldest := ""
+			for k, b := range l.buckets {
+				if oldest == "" || b.last.Before(l.buckets[oldest].last) {
+					oldest = k
+				}
+			}
+			delete(l.buckets, oldest)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(l.burst,
+		bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// Size returns the number of clients currently tracked.
+func (l *IPRateLimiter) Size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// newSeverityRateLimiters returns the limiters of configured severities, nil
+// for those let through unthrottled.
+func newSeverityRateLimiters(
+	limits map[string]SeverityRateLimit) map[string]*IPRateLimiter {
+	limiters := make(map[string]*IPRateLimiter)
+	for severity, limit := range limits {
+		limiters[severity] = nil
+		if limit.Rate > 0 {
+			limiters[severity] = NewIPRateLimiter(limit.Rate, limit.Burst,
+				maxRateLimitedClients)
+		}
+	}
+	return limiters
+}
+
+// webhookRateLimitSeverity returns the most severe of the severities of the
+// alerts in data having their own limiter, or "" if none has.
+func webhookRateLimitSeverity(data *WebhookData,
+	limiters map[string]*IPRateLimiter) string {
+	severity := ""
+	for _, alert := range data.Alerts {
+		s := alert.Labels["severity"]
+		if _, ok := limiters[s]; !ok {
+			continue
+		}
+		if severity == "" || severityRank(s) > severityRank(severity) {
+			severity = s
+		}
+	}
+	return severity
+}
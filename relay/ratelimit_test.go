@@ -0,0 +1,63 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPRateLimiter(t *testing.T) {
+	now := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	limiter := NewIPRateLimiter(2, 3, 10)
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("a", now) {
+			t.Errorf("Expected request %d within the burst to be allowed", i)
+		}
+	}
+	if limiter.Allow("a", now) {
+		t.Error("Expected request over the burst to be refused")
+	}
+	if !limiter.Allow("b", now) {
+		t.Error("Expected other clients to be allowed")
+	}
+	if !limiter.Allow("a", now.Add(500*time.Millisecond)) {
+		t.Error("Expected a token to be refilled after half a second")
+	}
+	if limiter.Allow("a", now.Add(500*time.Millisecond)) {
+		t.Error("Expected a single token to be refilled after half a second")
+	}
+}
+
+func TestIPRateLimiterForgetsClients(t *testing.T) {
+	now := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	limiter := NewIPRateLimiter(1, 0, 2)
+
+	limiter.Allow("a", now)
+	limiter.Allow("b", now.Add(time.Millisecond))
+	limiter.Allow("c", now.Add(2*time.Millisecond))
+	if limiter.Size() != 2 {
+		t.Errorf("Expected 2 clients, got %d", limiter.Size())
+	}
+	if !limiter.Allow("a", now.Add(3*time.Millisecond)) {
+		t.Error("Expected the least recently used client to be evicted")
+	}
+
+	limiter.Allow("d", now.Add(time.Hour))
+	if limiter.Size() != 1 {
+		t.Errorf("Expected idle clients to expire, got %d", limiter.Size())
+	}
+}
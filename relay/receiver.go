@@ -0,0 +1,46 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"text/template"
+)
+
+// parseReceiverTemplates parses the message templates of texts, by
+// Alertmanager receiver name, along with the template definitions of files.
+func parseReceiverTemplates(parser templateParser, texts map[string]string,
+	files []string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	for receiver, text := range texts {
+		tmpl, err := parseMsgTemplate(parser, text, files)
+		if err != nil {
+			return nil, fmt.Errorf("msg_templates_by_receiver: %s: %s", receiver, err)
+		}
+		templates[receiver] = tmpl
+	}
+	return templates, nil
+}
+
+// msgTemplateFor returns the message template of the receiver alertMsg was
+// sent to, MsgTemplate when it has none.
+func (f *Formatter) msgTemplateFor(alertMsg *AlertMsg) *template.Template {
+	if alertMsg.GroupData != nil {
+		if tmpl, ok := f.receiverTemplates[alertMsg.GroupData.Receiver]; ok {
+			return tmpl
+		}
+	}
+	return f.MsgTemplate
+}
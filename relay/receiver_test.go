@@ -0,0 +1,70 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestMsgTemplatesByReceiver(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "Alert {{ .Labels.alertname }} is {{ .Status }}",
+		MsgTemplatesByReceiver: map[string]string{
+			"team-db":  "[db] {{ .Labels.alertname }} {{ .Status }}",
+			"team-web": "[web] {{ .Labels.alertname }} {{ .Status }}",
+		},
+	})
+	alert := promtmpl.Alert{Status: "firing",
+		Labels: promtmpl.KV{"alertname": "airDown"}}
+
+	for receiver, expected := range map[string]string{
+		"team-db":  "[db] airDown firing",
+		"team-web": "[web] airDown firing",
+		"team-net": "Alert airDown is firing",
+	} {
+		group := &WebhookData{}
+		group.Receiver = receiver
+		alertMsg := &AlertMsg{Channel: "#foo", GroupData: group, AlertData: &alert}
+		if msg := formatter.RenderMsg(alertMsg); msg != expected {
+			t.Errorf("Unexpected message for receiver %s: %q", receiver, msg)
+		}
+	}
+}
+
+func TestMsgTemplatesByReceiverForGroups(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate:            "{{ .Status }}",
+		MsgTemplatesByReceiver: map[string]string{"team-db": "[{{ .Receiver }}] {{ .Status }}"},
+	})
+	group := &WebhookData{}
+	group.Receiver = "team-db"
+	group.Status = "resolved"
+
+	if msg := formatter.RenderMsg(&AlertMsg{Channel: "#foo", GroupData: group}); msg != "[team-db] resolved" {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+}
+
+func TestBadMsgTemplateByReceiver(t *testing.T) {
+	_, err := NewFormatter(&Config{
+		MsgTemplate:            "{{ .Status }}",
+		MsgTemplatesByReceiver: map[string]string{"team-db": "{{ .Status "},
+	})
+	if err == nil {
+		t.Error("Expected an error upon an invalid receiver template")
+	}
+}
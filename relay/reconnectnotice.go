@@ -0,0 +1,124 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultReconnectNoticeTemplate = "Reconnected to IRC after {{ .Failures }} failures, {{ .Downtime }} downtime"
+	defaultReconnectNoticeInterval = 5 * time.Minute
+)
+
+// ReconnectNoticeData is passed to the reconnect notice template. When
+// notices were rate limited, it covers all the Reconnects since the last
+// one.
+type ReconnectNoticeData struct {
+	Channel    string
+	Nick       string
+	Time       time.Time
+	Reconnects int
+	// Connection attempts that failed before reconnecting.
+	Failures int
+	// Rounded to the second.
+	Downtime time.Duration
+}
+
+func parseReconnectNoticeTemplate(text string,
+	parser templateParser) (*template.Template, error) {
+	return parser.parse("reconnect_notice", text)
+}
+
+// reconnectTracker follows the reconnections of a notifier between reconnect
+// notices, at most one per interval. Connecting the first time is not a
+// reconnection.
+type reconnectTracker struct {
+	interval   time.Duration
+	hadSession bool
+	downSince  time.Time
+	downtime   time.Duration
+	failures   int
+	reconnects int
+	lastNotice time.Time
+}
+
+// failed records a connection attempt that did not establish a session.
+func (r *reconnectTracker) failed() {
+	if r.hadSession {
+		r.failures++
+	}
+}
+
+// down records losing the session at now.
+func (r *reconnectTracker) down(now time.Time) {
+	if r.hadSession && r.downSince.IsZero() {
+		r.downSince = now
+	}
+}
+
+// up records establishing a session at now, returning whether a reconnect
+// notice is due.
+func (r *reconnectTracker) up(now time.Time) bool {
+	if !r.hadSession {
+		r.hadSession = true
+		return false
+	}
+	if !r.downSince.IsZero() {
+		r.downtime += now.Sub(r.downSince)
+		r.downSince = time.Time{}
+	}
+	r.reconnects++
+	return r.lastNotice.IsZero() || now.Sub(r.lastNotice) >= r.interval
+}
+
+// notice returns the data of the due notice sent at now, starting over.
+func (r *reconnectTracker) notice(now time.Time) ReconnectNoticeData {
+	data := ReconnectNoticeData{
+		Time:       now,
+		Reconnects: r.reconnects,
+		Failures:   r.failures,
+		Downtime:   r.downtime.Round(time.Second),
+	}
+	r.downtime, r.failures, r.reconnects = 0, 0, 0
+	r.lastNotice = now
+	return data
+}
+
+// MaybeSendReconnectNotice tells the admin channel, if any, about the
+// reconnections since the last notice, once reconnected and unless the
+// last notice was sent less than the notice interval ago.
+func (notifier *IRCNotifier) MaybeSendReconnectNotice() {
+	if notifier.reconnectNoticeTmpl == nil {
+		return
+	}
+	now := notifier.timeNow()
+	if !notifier.reconnects.up(now) {
+		return
+	}
+	if !notifier.JoinChannel(&IRCChannel{Name: notifier.adminChannel}) {
+		log.Printf("Skipping reconnect notice to blocked channel %s",
+			notifier.adminChannel)
+		return
+	}
+	data := notifier.reconnects.notice(now)
+	data.Channel = notifier.adminChannel
+	data.Nick = notifier.Client.Me().Nick
+	msg := notifier.Formatter.execute(notifier.reconnectNoticeTmpl, data)
+	notifier.sendLines(notifier.adminChannel,
+		notifier.Formatter.splitLines([]string{msg}))
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+func TestReconnectTracker(t *testing.T) {
+	start := time.Date(2017, 5, 15, 14, 0, 0, 0, time.UTC)
+	tracker := &reconnectTracker{interval: 3 * time.Minute}
+
+	// Neither connecting the first time nor failing to is a reconnection.
+	tracker.failed()
+	if tracker.up(start) {
+		t.Error("Expected no notice for the first connection")
+	}
+
+	tracker.down(start.Add(time.Minute))
+	tracker.failed()
+	tracker.failed()
+	tracker.failed()
+	now := start.Add(time.Minute + 47*time.Second + 300*time.Millisecond)
+	if !tracker.up(now) {
+		t.Fatal("Expected a notice for the first reconnection")
+	}
+	data := tracker.notice(now)
+	if data.Reconnects != 1 || data.Failures != 3 || data.Downtime != 47*time.Second {
+		t.Errorf("Unexpected notice data: %+v", data)
+	}
+
+	// Flapping within the interval is rate limited, then summed up.
+	for i := 1; i <= 3; i++ {
+		now = now.Add(time.Minute)
+		tracker.down(now)
+		tracker.failed()
+		now = now.Add(10 * time.Second)
+		if tracker.up(now) != (i == 3) {
+			t.Errorf("Unexpected notice due state after reconnection %d", i)
+		}
+	}
+	data = tracker.notice(now)
+	if data.Reconnects != 3 || data.Failures != 3 || data.Downtime != 30*time.Second {
+		t.Errorf("Unexpected notice data after flapping: %+v", data)
+	}
+}
+
+func TestReconnectNotice(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.AdminChannel = "#admin"
+	config.ReconnectNoticeTemplate = "Reconnected after {{ .Failures }} failures, {{ .Downtime }} downtime"
+	config.ReconnectNoticeInterval = time.Minute
+	notifier, _ := makeTestNotifier(t, config)
+	clock := &fakeClock{now: time.Date(2017, 5, 15, 14, 0, 0, 0, time.UTC)}
+	notifier.timeNow = clock.Now
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+	testStep.Wait()
+
+	// Reconnect 47s later.
+	holdUser := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		clock.Set(clock.Now().Add(47 * time.Second))
+		return server.h_USER(conn, line)
+	}
+	server.SetHandler("USER", holdUser)
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(2)
+	server.Client.Close()
+	testStep.Wait()
+
+	// Reconnecting again right away is not noticed.
+	testStep.Add(1)
+	server.Client.Close()
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	notices := []string{}
+	for _, command := range server.Log {
+		if strings.HasPrefix(command, "NOTICE ") || command == "JOIN #admin" {
+			notices = append(notices, command)
+		}
+	}
+	expected := []string{
+		"JOIN #admin",
+		"NOTICE #admin :Reconnected after 0 failures, 47s downtime",
+	}
+	if strings.Join(notices, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %q, got %q", expected, notices)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"strings"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// regroup splits the alerts of data by the values of the labels, in the
+// order they first appear. Each part is a group of its own: the labels are
+// added to its group labels and group key, and its status, common labels
+// and annotations are those of its alerts.
+func regroup(data *WebhookData, labels []string) []*WebhookData {
+	parts := []*WebhookData{}
+	byKey := make(map[string]*WebhookData)
+	for _, alert := range data.Alerts {
+		values := make([]string, len(labels))
+		for i, label := range labels {
+			values[i] = fmt.Sprintf("%s=%q", label, alert.Labels[label])
+		}
+		key := "{" + strings.Join(values, ",") + "}"
+		part, ok := byKey[key]
+		if !ok {
+			part = regroupPart(data, labels, alert.Labels, key)
+			byKey[key] = part
+			parts = append(parts, part)
+		}
+		part.Alerts = append(part.Alerts, alert)
+	}
+	for _, part := range parts {
+		part.Status = groupStatus(part.Alerts)
+		part.CommonLabels = sharedLabels(part.Alerts)
+		part.CommonAnnotations = sharedAnnotations(part.Alerts)
+	}
+	return parts
+}
+
+// regroupPart returns an empty part of data for the alerts with these
+// values of labels, whose key is appended to group keys.
+func regroupPart(data *WebhookData, labels []string, values promtmpl.KV,
+	key string) *WebhookData {
+	part := *data
+	part.Alerts = nil
+	part.GroupLabels = promtmpl.KV{}
+	for name, value := range data.GroupLabels {
+		part.GroupLabels[name] = value
+	}
+	for _, label := range labels {
+		part.GroupLabels[label] = values[label]
+	}
+	if part.GroupKey != "" {
+		part.GroupKey += ":" + key
+	}
+	if part.unfilteredGroupKey != "" {
+		part.unfilteredGroupKey += ":" + key
+	}
+	return &part
+}
+
+// sharedAnnotations returns the annotations with the same value in all
+// alerts.
+func sharedAnnotations(alerts promtmpl.Alerts) promtmpl.KV {
+	shared := promtmpl.KV{}
+	if len(alerts) == 0 {
+		return shared
+	}
+	for name, value := range alerts[0].Annotations {
+		shared[name] = value
+	}
+	for _, alert := range alerts[1:] {
+		for name, value := range shared {
+			if alert.Annotations[name] != value {
+				delete(shared, name)
+			}
+		}
+	}
+	return shared
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"reflect"
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestRegroup(t *testing.T) {
+	data := &WebhookData{
+		Data: promtmpl.Data{
+			Status:      "firing",
+			GroupLabels: promtmpl.KV{"alertname": "airDown"},
+			Alerts: promtmpl.Alerts{
+				{Status: "firing", Labels: promtmpl.KV{
+					"alertname": "airDown", "severity": "page", "zone": "a"},
+					Annotations: promtmpl.KV{"runbook": "air"}},
+				{Status: "resolved", Labels: promtmpl.KV{
+					"alertname": "airDown", "severity": "ticket", "zone": "a"}},
+				{Status: "resolved", Labels: promtmpl.KV{
+					"alertname": "airDown", "severity": "page", "zone": "b"},
+					Annotations: promtmpl.KV{"runbook": "air"}},
+			},
+		},
+	}
+	data.GroupKey = `{}:{alertname="airDown"}`
+
+	parts := regroup(data, []string{"severity"})
+	if len(parts) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(parts))
+	}
+
+	page, ticket := parts[0], parts[1]
+	if len(page.Alerts) != 2 || len(ticket.Alerts) != 1 {
+		t.Errorf("Unexpected alerts per group: %d page, %d ticket",
+			len(page.Alerts), len(ticket.Alerts))
+	}
+	expectedGroupLabels := promtmpl.KV{"alertname": "airDown", "severity": "page"}
+	if !reflect.DeepEqual(expectedGroupLabels, page.GroupLabels) {
+		t.Errorf("Unexpected group labels %v", page.GroupLabels)
+	}
+	if _, ok := data.GroupLabels["severity"]; ok {
+		t.Error("Regrouping changed the group labels of the webhook")
+	}
+	expectedGroupKey := `{}:{alertname="airDown"}:{severity="page"}`
+	if page.GroupKey != expectedGroupKey {
+		t.Errorf("Unexpected group key %q", page.GroupKey)
+	}
+	if page.Status != "firing" || ticket.Status != "resolved" {
+		t.Errorf("Unexpected statuses %s and %s", page.Status, ticket.Status)
+	}
+	expectedCommonLabels := promtmpl.KV{"alertname": "airDown", "severity": "page"}
+	if !reflect.DeepEqual(expectedCommonLabels, page.CommonLabels) {
+		t.Errorf("Unexpected common labels %v", page.CommonLabels)
+	}
+	expectedCommonAnnotations := promtmpl.KV{"runbook": "air"}
+	if !reflect.DeepEqual(expectedCommonAnnotations, page.CommonAnnotations) {
+		t.Errorf("Unexpected common annotations %v", page.CommonAnnotations)
+	}
+	if len(ticket.CommonAnnotations) != 0 {
+		t.Errorf("Unexpected common annotations %v", ticket.CommonAnnotations)
+	}
+}
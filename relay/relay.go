@@ -0,0 +1,235 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package relay relays Prometheus Alertmanager webhooks to IRC.
+//
+// A Relay runs an HTTP server receiving webhooks and an IRC notifier sending
+// them to the configured channels:
+//
+//	config, err := relay.LoadConfig("/etc/alertmanager-irc-relay.yml")
+//	...
+//	r, err := relay.New(config)
+//	...
+//	go r.Run()
+//	...
+//	r.Shutdown()
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	alertMsgsQueueSize   = 10
+	rawIRCLinesQueueSize = 10
+	httpShutdownSecs     = 5
+)
+
+var (
+	// ErrIRCGaveUp is returned by Run when the IRC notifier gave up
+	// reconnecting.
+	ErrIRCGaveUp = errors.New("gave up connecting to IRC")
+	// ErrHTTPServerStopped is returned by Run when the HTTP server stopped
+	// on its own, e.g. because it could not listen.
+	ErrHTTPServerStopped = errors.New("HTTP server stopped")
+)
+
+// Relay ties together the HTTP server and the IRC notifier.
+type Relay struct {
+	HTTPServer *HTTPServer
+	// Not set when only forwarding webhooks to another relay.
+	IRCNotifier *IRCNotifier
+	// Notifiers of the other IRC connections, by name.
+	ConnectionNotifiers map[string]*IRCNotifier
+
+	mu       sync.Mutex
+	started  bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// New returns a relay for config, ready to Run.
+func New(config *Config) (*Relay, error) {
+	alertMsgs := make(chan AlertMsg, alertMsgsQueueSize)
+	rawIRCLines := make(chan string, rawIRCLinesQueueSize)
+
+	var ircNotifier *IRCNotifier
+	connectionNotifiers := make(map[string]*IRCNotifier)
+	connectionAlertMsgs := make(map[string]chan AlertMsg)
+	if !config.ForwardOnly {
+		var err error
+		ircNotifier, err = NewIRCNotifier(config, alertMsgs, rawIRCLines)
+		if err != nil {
+			return nil, err
+		}
+		for i := range config.IRCConnections {
+			conn := &config.IRCConnections[i]
+			// Raw IRC lines are only sent through the default connection.
+			connAlertMsgs := make(chan AlertMsg, alertMsgsQueueSize)
+			notifier, err := NewIRCNotifier(config.connectionConfig(conn),
+				connAlertMsgs, make(chan string))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", conn.Name, err)
+			}
+			connectionNotifiers[conn.Name] = notifier
+			connectionAlertMsgs[conn.Name] = connAlertMsgs
+		}
+	}
+	httpServer, err := NewHTTPServer(config, alertMsgs, rawIRCLines)
+	if err != nil {
+		return nil, err
+	}
+	if ircNotifier != nil {
+		httpServer.ChannelTracker = ircNotifier.ChannelTracker
+		ircNotifier.SetDeliveryPause(httpServer.DeliveryPause)
+	}
+	for _, notifier := range connectionNotifiers {
+		notifier.SetDeliveryPause(httpServer.DeliveryPause)
+	}
+	for name, connAlertMsgs := range connectionAlertMsgs {
+		httpServer.AddConnection(name, connAlertMsgs,
+			connectionNotifiers[name].ChannelTracker)
+	}
+	return &Relay{
+		HTTPServer:          httpServer,
+		IRCNotifier:         ircNotifier,
+		ConnectionNotifiers: connectionNotifiers,
+		stop:                make(chan struct{}),
+		done:                make(chan struct{}),
+	}, nil
+}
+
+// ircNotifiers returns the notifiers of all IRC connections.
+func (relay *Relay) ircNotifiers() []*IRCNotifier {
+	notifiers := []*IRCNotifier{}
+	if relay.IRCNotifier != nil {
+		notifiers = append(notifiers, relay.IRCNotifier)
+	}
+	for _, notifier := range relay.ConnectionNotifiers {
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers
+}
+
+// Run runs the relay until Shutdown is called, returning nil, or until the
+// HTTP server or the IRC notifier stops on its own, returning why.
+func (relay *Relay) Run() error {
+	relay.mu.Lock()
+	if relay.started {
+		relay.mu.Unlock()
+		return errors.New("relay already running")
+	}
+	relay.started = true
+	relay.mu.Unlock()
+	defer close(relay.done)
+
+	select {
+	case <-relay.stop:
+		return nil
+	default:
+	}
+
+	// Nothing is ever received when there is no IRC notifier.
+	notifiers := relay.ircNotifiers()
+	ircStopped := make(chan *IRCNotifier, len(notifiers))
+	for _, notifier := range notifiers {
+		go notifier.Run()
+		go func(notifier *IRCNotifier) {
+			<-notifier.StoppedRunning
+			ircStopped <- notifier
+		}(notifier)
+	}
+	go relay.HTTPServer.Run()
+
+	select {
+	case <-relay.HTTPServer.StoppedRunning:
+		log.Printf("Http server terminated, stopping")
+		relay.HTTPServer.closeSenders()
+		relay.stopIRCNotifiers(ircStopped, nil)
+		return ErrHTTPServerStopped
+	case stopped := <-ircStopped:
+		log.Printf("IRC notifier stopped running, stopping")
+		relay.stopIRCNotifiers(ircStopped, stopped)
+		relay.stopHTTPServer()
+		if stopped.GaveUp {
+			return ErrIRCGaveUp
+		}
+		return nil
+	case <-relay.stop:
+		// No more alerts are queued once the HTTP server stopped, the IRC
+		// notifiers can then drain their queue.
+		relay.stopHTTPServer()
+		relay.stopIRCNotifiers(ircStopped, nil)
+		return nil
+	}
+}
+
+// Shutdown stops the relay, quitting IRC, and waits for Run to return.
+func (relay *Relay) Shutdown() {
+	relay.stopOnce.Do(func() { close(relay.stop) })
+	relay.mu.Lock()
+	started := relay.started
+	relay.mu.Unlock()
+	if started {
+		<-relay.done
+	}
+}
+
+// stopIRCNotifiers stops the notifiers still running, all but stopped, and
+// waits for them on ircStopped. Others may stop on their own meanwhile,
+// giving up reconnecting, and are then not asked to.
+func (relay *Relay) stopIRCNotifiers(ircStopped chan *IRCNotifier,
+	stopped *IRCNotifier) {
+	notifiers := relay.ircNotifiers()
+	done := make(map[*IRCNotifier]bool)
+	if stopped != nil {
+		done[stopped] = true
+	}
+	for _, notifier := range notifiers {
+	stopping:
+		for !done[notifier] {
+			select {
+			case notifier.StopRunning <- true:
+				break stopping
+			case other := <-ircStopped:
+				done[other] = true
+			}
+		}
+	}
+	if len(done) < len(notifiers) {
+		log.Printf("Waiting for IRC to quit")
+	}
+	for len(done) < len(notifiers) {
+		done[<-ircStopped] = true
+	}
+}
+
+func (relay *Relay) stopHTTPServer() {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), httpShutdownSecs*time.Second)
+	defer cancel()
+	if err := relay.HTTPServer.Shutdown(ctx); err != nil {
+		log.Printf("Could not shut down HTTP server: %s", err)
+	}
+	<-relay.HTTPServer.StoppedRunning
+	// In-flight webhooks may still archive or forward until Shutdown
+	// returned.
+	relay.HTTPServer.closeSenders()
+}
@@ -0,0 +1,221 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+	"github.com/gorilla/mux"
+)
+
+func TestRelayRunAndShutdown(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.HTTPHost = "127.0.0.1"
+	config.HTTPPort = 0
+	r, err := New(config)
+	if err != nil {
+		t.Fatalf("Could not create relay: %s", err)
+	}
+	r.IRCNotifier.Client.Config().Flood = true
+	r.IRCNotifier.BackoffCounter = &FakeDelayer{}
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	runErr := make(chan error)
+	go func() { runErr <- r.Run() }()
+
+	testStep.Wait()
+
+	r.Shutdown()
+	if err := <-runErr; err != nil {
+		t.Errorf("Expected Run to return nil after Shutdown, got: %s", err)
+	}
+	server.Stop()
+
+	if last := server.Log[len(server.Log)-1]; last != "QUIT :see ya" {
+		t.Errorf("Expected the relay to quit IRC, last command: %s", last)
+	}
+}
+
+func TestRelayShutdownBeforeRun(t *testing.T) {
+	r, err := New(makeTestIRCConfig(0))
+	if err != nil {
+		t.Fatalf("Could not create relay: %s", err)
+	}
+	r.Shutdown()
+	if err := r.Run(); err != nil {
+		t.Errorf("Expected Run to return nil after Shutdown, got: %s", err)
+	}
+}
+
+func TestRelayForwardOnly(t *testing.T) {
+	config := makeTestIRCConfig(0)
+	config.HTTPHost = "127.0.0.1"
+	config.HTTPPort = 0
+	config.ForwardURL = "http://127.0.0.1:0"
+	config.ForwardOnly = true
+	r, err := New(config)
+	if err != nil {
+		t.Fatalf("Could not create relay: %s", err)
+	}
+	if r.IRCNotifier != nil {
+		t.Error("Expected no IRC notifier when only forwarding")
+	}
+
+	runErr := make(chan error)
+	go func() { runErr <- r.Run() }()
+
+	r.Shutdown()
+	if err := <-runErr; err != nil {
+		t.Errorf("Expected Run to return nil after Shutdown, got: %s", err)
+	}
+}
+
+func TestRelayThroughSeveralConnections(t *testing.T) {
+	server, port := makeTestServer(t)
+	otherServer, otherPort := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.HTTPHost = "127.0.0.1"
+	config.HTTPPort = 0
+	config.MsgTemplate = "{{ .Labels.instance }} is {{ .Status }}"
+	config.IRCConnections = []IRCConnection{
+		IRCConnection{
+			Name:        "other",
+			IRCHost:     "127.0.0.1",
+			IRCPort:     otherPort,
+			IRCNick:     "bar",
+			IRCChannels: []IRCChannel{IRCChannel{Name: "#qux"}},
+		},
+	}
+	r, err := New(config)
+	if err != nil {
+		t.Fatalf("Could not create relay: %s", err)
+	}
+	for _, notifier := range r.ircNotifiers() {
+		notifier.Client.Config().Flood = true
+		notifier.BackoffCounter = &FakeDelayer{}
+	}
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz and #qux are the last channels to pre-join
+		if line.Args[0] == "#baz" || line.Args[0] == "#qux" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+	otherServer.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(2)
+	runErr := make(chan error)
+	go func() { runErr <- r.Run() }()
+
+	testStep.Wait()
+
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	otherServer.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(2)
+	request := httptest.NewRequest("POST", "/qux",
+		strings.NewReader(testdataSimpleAlertJson))
+	request = mux.SetURLVars(request, map[string]string{"IRCChannel": "qux"})
+	r.HTTPServer.RelayAlert(httptest.NewRecorder(), request)
+	testStep.Wait()
+
+	r.Shutdown()
+	if err := <-runErr; err != nil {
+		t.Errorf("Expected Run to return nil after Shutdown, got: %s", err)
+	}
+	server.Stop()
+	otherServer.Stop()
+
+	expectedCommands := []string{
+		"NICK bar",
+		"USER bar 12 * :",
+		"JOIN #qux",
+		"NOTICE #qux :instance1:3456 is resolved",
+		"NOTICE #qux :instance2:7890 is resolved",
+		"QUIT :see ya",
+	}
+	if !reflect.DeepEqual(expectedCommands, otherServer.Log) {
+		t.Error("Alerts not sent through the other connection. Received commands:\n",
+			strings.Join(otherServer.Log, "\n"))
+	}
+	for _, command := range server.Log {
+		if strings.HasPrefix(command, "NOTICE") {
+			t.Errorf("Expected no alert through the default connection, got: %s", command)
+		}
+	}
+}
+
+func TestRelayStopsWhenSeveralConnectionsGiveUp(t *testing.T) {
+	server, port := makeTestServer(t)
+	otherServer, otherPort := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.HTTPHost = "127.0.0.1"
+	config.HTTPPort = 0
+	// The servers do not support SSL, failing every connection attempt.
+	config.IRCUseSSL = true
+	config.IRCMaxReconnectAttempts = 2
+	config.IRCConnections = []IRCConnection{
+		IRCConnection{Name: "other", IRCHost: "127.0.0.1", IRCPort: otherPort},
+	}
+	r, err := New(config)
+	if err != nil {
+		t.Fatalf("Could not create relay: %s", err)
+	}
+	for _, notifier := range r.ircNotifiers() {
+		notifier.BackoffCounter = &FakeDelayer{}
+	}
+	server.SetCloseEarly(func() {})
+	otherServer.SetCloseEarly(func() {})
+
+	runErr := make(chan error)
+	go func() { runErr <- r.Run() }()
+
+	select {
+	case err := <-runErr:
+		if err != ErrIRCGaveUp {
+			t.Errorf("Expected Run to return ErrIRCGaveUp, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Relay did not stop once its connections gave up")
+	}
+	server.Stop()
+	otherServer.Stop()
+}
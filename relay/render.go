@@ -0,0 +1,57 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"io"
+)
+
+// Render prints the IRC lines that would be sent to channel for the webhook
+// read from r, to try templates without an IRC server.
+func Render(config *Config, channel string, r io.Reader, w io.Writer) error {
+	formatter, err := NewFormatter(config)
+	if err != nil {
+		return err
+	}
+	data, _, err := newPayloadDecoder(config.PayloadFormat)(r)
+	if err != nil {
+		return fmt.Errorf("could not decode webhook: %s", err)
+	}
+
+	server := &HTTPServer{
+		MsgOnce:        config.MsgOnce,
+		CollapseLabels: config.CollapseLabels,
+		WarnOnEmpty:    config.WarnOnEmptyAlerts,
+		dataFilter:     newDataFilter(config),
+		regroupBy:      config.RegroupBy,
+
+		sendResolved:          config.SendResolved == nil || *config.SendResolved,
+		sendResolvedOverrides: sendResolvedOverrides(config),
+	}
+	command := "NOTICE"
+	if config.UsePrivmsg {
+		command = "PRIVMSG"
+	}
+	for _, alertMsg := range server.GetMsgsFromAlertMessage(channel, data) {
+		for _, line := range formatter.RenderMsgLines(&alertMsg) {
+			if _, err := fmt.Fprintf(w, "%s %s :%s\n",
+				command, channel, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
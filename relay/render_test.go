@@ -0,0 +1,65 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	config := MakeHTTPTestingConfig()
+	config.UsePrivmsg = true
+	output := bytes.Buffer{}
+
+	if err := Render(config, "#somechannel",
+		strings.NewReader(testdataSimpleAlertJson), &output); err != nil {
+		t.Fatalf("Could not render webhook: %s", err)
+	}
+
+	expected := "PRIVMSG #somechannel :Alert airDown on instance1:3456 is resolved\n" +
+		"PRIVMSG #somechannel :Alert airDown on instance2:7890 is resolved\n"
+	if output.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, output.String())
+	}
+}
+
+func TestRenderStripsDeniedLabels(t *testing.T) {
+	config := MakeHTTPTestingConfig()
+	config.MsgTemplate = "Alert {{ .GroupKey }}{{ index .Labels \"instance\" }}"
+	config.DeniedLabels = []string{"instance"}
+	output := bytes.Buffer{}
+
+	if err := Render(config, "#somechannel",
+		strings.NewReader(testdataSimpleAlertJson), &output); err != nil {
+		t.Fatalf("Could not render webhook: %s", err)
+	}
+
+	expected := "NOTICE #somechannel :Alert \n" + "NOTICE #somechannel :Alert \n"
+	if output.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, output.String())
+	}
+}
+
+func TestRenderBadWebhook(t *testing.T) {
+	config := MakeHTTPTestingConfig()
+	output := bytes.Buffer{}
+
+	if err := Render(config, "#somechannel",
+		strings.NewReader("{bogus"), &output); err == nil {
+		t.Error("Expected an error for an invalid webhook")
+	}
+}
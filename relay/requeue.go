@@ -0,0 +1,101 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requeuedAlerts = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "irc_requeued_alerts",
+			Help: "Number of alerts queued while disconnected from IRC, to send once reconnected"},
+		[]string{"connection"},
+	)
+)
+
+// requeueWhileDisconnected returns true if alertMsg, which cannot be sent
+// while disconnected, is queued behind the alerts to its channel that could
+// not be sent either, to send them all in order once reconnected. The
+// oldest alerts are dropped past ReconnectQueueSize per channel.
+func (notifier *IRCNotifier) requeueWhileDisconnected(alertMsg *AlertMsg) bool {
+	if notifier.ReconnectQueueSize <= 0 {
+		return false
+	}
+	queue, ok := notifier.requeuedAlertMsgs[alertMsg.Channel]
+	if !ok {
+		notifier.requeuedChannels = append(notifier.requeuedChannels,
+			alertMsg.Channel)
+	}
+	if len(queue) >= notifier.ReconnectQueueSize {
+		log.Printf("Too many alerts to %s queued while disconnected, dropping the oldest",
+			alertMsg.Channel)
+		queue = queue[1:]
+		notifier.requeued--
+	}
+	notifier.requeuedAlertMsgs[alertMsg.Channel] = append(queue, *alertMsg)
+	notifier.requeued++
+	requeuedAlerts.WithLabelValues(notifier.connection).Set(
+		float64(notifier.requeued))
+	return true
+}
+
+// SendRequeuedAlertMsgs sends the alerts queued while disconnected once
+// reconnected, channel by channel in the order they were queued in. As
+// sending only stops while disconnected, which only Run notices, none is
+// sent out of order meanwhile.
+func (notifier *IRCNotifier) SendRequeuedAlertMsgs() {
+	if !notifier.sessionUp || notifier.requeued == 0 {
+		return
+	}
+	log.Printf("Sending %d alerts queued while disconnected", notifier.requeued)
+	queues, channels := notifier.requeuedAlertMsgs, notifier.requeuedChannels
+	notifier.resetRequeuedAlertMsgs()
+	for _, channel := range channels {
+		queue := queues[channel]
+		for i := range queue {
+			if notifier.isExpired(&queue[i]) {
+				notifier.trackBatch(&queue[i], false)
+				continue
+			}
+			notifier.MaybeSendAlertMsg(&queue[i])
+		}
+	}
+}
+
+// abandonRequeuedAlertMsgs drops the alerts still queued when stopping
+// while disconnected.
+func (notifier *IRCNotifier) abandonRequeuedAlertMsgs() {
+	if notifier.requeued == 0 {
+		return
+	}
+	log.Printf("Shutting down, %d alerts queued while disconnected abandoned",
+		notifier.requeued)
+	for channel, queue := range notifier.requeuedAlertMsgs {
+		abandonedAlerts.WithLabelValues(channel).Add(float64(len(queue)))
+	}
+	notifier.resetRequeuedAlertMsgs()
+}
+
+func (notifier *IRCNotifier) resetRequeuedAlertMsgs() {
+	notifier.requeuedAlertMsgs = make(map[string][]AlertMsg)
+	notifier.requeuedChannels = nil
+	notifier.requeued = 0
+	requeuedAlerts.WithLabelValues(notifier.connection).Set(0)
+}
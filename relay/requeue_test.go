@@ -0,0 +1,136 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	irc "github.com/fluffle/goirc/client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequeueKeepsOrderAcrossReconnect(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCReconnectQueueSize = 10
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep, reconnectStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+	testStep.Wait()
+
+	// Drop the connection after the first message of the batch, and hold
+	// the registration of the next one until the rest of the batch is
+	// queued.
+	notices := []string{}
+	var noticesMu sync.Mutex
+	dropped := false
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		noticesMu.Lock()
+		notices = append(notices, strings.TrimSpace(line.Text()))
+		noticesMu.Unlock()
+		testStep.Done()
+		if !dropped {
+			dropped = true
+			return errors.New("dropping the connection")
+		}
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+	reconnectStep.Add(1)
+	holdUser := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		reconnectStep.Wait()
+		return server.h_USER(conn, line)
+	}
+	server.SetHandler("USER", holdUser)
+
+	// The first notice, then the USER command of the reconnection.
+	testStep.Add(2)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "alert 1"}
+	testStep.Wait()
+
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "alert 2"}
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "alert 3"}
+
+	// The pre-joins and the queued alerts, then a new one.
+	testStep.Add(3)
+	reconnectStep.Done()
+	testStep.Wait()
+	testStep.Add(1)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "alert 4"}
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	expectedNotices := []string{"alert 1", "alert 2", "alert 3", "alert 4"}
+	if !reflect.DeepEqual(expectedNotices, notices) {
+		t.Errorf("Expected notices in order %q, got %q", expectedNotices, notices)
+	}
+	if queued := testutil.ToFloat64(
+		requeuedAlerts.WithLabelValues(defaultConnection)); queued != 0 {
+		t.Errorf("Expected no alert left queued, got %f", queued)
+	}
+}
+
+func TestRequeueDropsOldest(t *testing.T) {
+	notifier := &IRCNotifier{
+		ReconnectQueueSize: 2,
+		requeuedAlertMsgs:  make(map[string][]AlertMsg),
+		connection:         "requeue-test",
+	}
+	for _, alert := range []string{"1", "2", "3"} {
+		if !notifier.requeueWhileDisconnected(
+			&AlertMsg{Channel: "#foo", Alert: alert}) {
+			t.Fatalf("Expected alert %s to be queued", alert)
+		}
+	}
+	notifier.requeueWhileDisconnected(&AlertMsg{Channel: "#bar", Alert: "4"})
+
+	alerts := []string{}
+	for _, channel := range notifier.requeuedChannels {
+		for _, alertMsg := range notifier.requeuedAlertMsgs[channel] {
+			alerts = append(alerts, channel+" "+alertMsg.Alert)
+		}
+	}
+	if got := strings.Join(alerts, ", "); got != "#foo 2, #foo 3, #bar 4" {
+		t.Errorf("Unexpected queued alerts: %s", got)
+	}
+	if notifier.requeued != 3 {
+		t.Errorf("Expected 3 queued alerts, got %d", notifier.requeued)
+	}
+
+	notifier.ReconnectQueueSize = 0
+	if notifier.requeueWhileDisconnected(&AlertMsg{Channel: "#foo"}) {
+		t.Error("Expected no alert queued when disabled")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"text/template"
+)
+
+// SuccessResponseData is passed to the success response template, rendered
+// as the body of replies to webhooks whose messages were all queued.
+type SuccessResponseData struct {
+	Connection string
+	Channels   []string
+	// Enqueued is the number of messages queued for the IRC routine.
+	Enqueued int
+}
+
+// parseSuccessResponseTemplate returns nil when text is empty, as successful
+// replies have no body by default.
+func parseSuccessResponseTemplate(text string,
+	parser templateParser) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return parser.parse("success_response", text)
+}
+
+// writeSuccessResponse renders tmpl as the body of the reply, which is left
+// empty if that fails as the webhook was handled anyway.
+func writeSuccessResponse(w http.ResponseWriter, tmpl *template.Template,
+	data *SuccessResponseData) {
+	body := bytes.Buffer{}
+	if err := tmpl.Execute(&body, data); err != nil {
+		log.Printf("Could not apply success response template: %s", err)
+		return
+	}
+	if _, err := w.Write(body.Bytes()); err != nil {
+		log.Printf("Could not write success response: %s", err)
+	}
+}
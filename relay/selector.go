@@ -0,0 +1,98 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ConnectionSelector relays alerts through the IRC connection named after
+// the value of their Label, e.g. the one closest to their region. Alerts
+// with other values are routed by channel as usual.
+type ConnectionSelector struct {
+	Label       string            `yaml:"label"`
+	Connections map[string]string `yaml:"connections"`
+}
+
+// validate checks the selected connections are configured.
+func (s *ConnectionSelector) validate(connections []IRCConnection) error {
+	if s.Label == "" {
+		return errors.New("connection_by_label requires a label")
+	}
+	names := map[string]bool{defaultConnection: true}
+	for _, conn := range connections {
+		names[conn.Name] = true
+	}
+	for value, name := range s.Connections {
+		if !names[name] {
+			return fmt.Errorf("connection_by_label: unknown irc connection %s for %s",
+				name, value)
+		}
+	}
+	return nil
+}
+
+// connectionPart holds the alerts of a webhook relayed through connection,
+// "" for those routed by channel.
+type connectionPart struct {
+	connection string
+	data       *WebhookData
+}
+
+// split returns the alerts of data by selected connection, in the order
+// they first appear. data is returned whole when all its alerts go through
+// the same connection, else each part gets the status, common labels and
+// annotations of its alerts.
+func (s *ConnectionSelector) split(data *WebhookData) []connectionPart {
+	parts := []connectionPart{}
+	byConnection := make(map[string]int)
+	for _, alert := range data.Alerts {
+		connection := s.Connections[alert.Labels[s.Label]]
+		i, ok := byConnection[connection]
+		if !ok {
+			part := *data
+			part.Alerts = nil
+			i = len(parts)
+			byConnection[connection] = i
+			parts = append(parts, connectionPart{connection: connection, data: &part})
+		}
+		parts[i].data.Alerts = append(parts[i].data.Alerts, alert)
+	}
+	if len(parts) <= 1 {
+		connection := ""
+		if len(parts) == 1 {
+			connection = parts[0].connection
+		}
+		return []connectionPart{{connection: connection, data: data}}
+	}
+	for _, part := range parts {
+		part.data.Status = groupStatus(part.data.Alerts)
+		part.data.CommonLabels = sharedLabels(part.data.Alerts)
+		part.data.CommonAnnotations = sharedAnnotations(part.data.Alerts)
+	}
+	return parts
+}
+
+// selectedParts returns the parts of data to relay by connection.
+// connection is the one of the webhook URL, if any, which all alerts are
+// relayed through.
+func (server *HTTPServer) selectedParts(connection string,
+	data *WebhookData) []connectionPart {
+	if server.connectionSelector == nil || connection != "" {
+		return []connectionPart{{connection: connection, data: data}}
+	}
+	return server.connectionSelector.split(data)
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestConnectionSelectorSplit(t *testing.T) {
+	selector := &ConnectionSelector{
+		Label:       "region",
+		Connections: map[string]string{"eu-west": "eunet"},
+	}
+	data := &WebhookData{}
+	data.Status = "firing"
+	data.Alerts = promtmpl.Alerts{
+		{Status: "resolved", Labels: promtmpl.KV{"region": "eu-west", "job": "a"}},
+		{Status: "firing", Labels: promtmpl.KV{"region": "ap-south", "job": "a"}},
+		{Status: "resolved", Labels: promtmpl.KV{"region": "eu-west", "job": "b"}},
+	}
+
+	parts := selector.split(data)
+	if len(parts) != 2 {
+		t.Fatalf("Expected 2 parts, got %d", len(parts))
+	}
+	if parts[0].connection != "eunet" || len(parts[0].data.Alerts) != 2 ||
+		parts[0].data.Status != "resolved" ||
+		parts[0].data.CommonLabels["region"] != "eu-west" {
+		t.Errorf("Unexpected eunet part: %+v", parts[0].data)
+	}
+	if parts[1].connection != "" || len(parts[1].data.Alerts) != 1 ||
+		parts[1].data.Status != "firing" {
+		t.Errorf("Unexpected unmatched part: %+v", parts[1].data)
+	}
+	if len(data.Alerts) != 3 {
+		t.Errorf("Expected the webhook untouched, got %d alerts", len(data.Alerts))
+	}
+
+	// Webhooks relayed through a single connection are kept whole.
+	data.Alerts = data.Alerts[:1]
+	parts = selector.split(data)
+	if len(parts) != 1 || parts[0].connection != "eunet" || parts[0].data != data {
+		t.Errorf("Expected the whole webhook for eunet, got %+v", parts)
+	}
+}
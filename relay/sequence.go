@@ -0,0 +1,45 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// sequenceCounters numbers the lines sent to the channels including a
+// sequence, by channel key, for observers to notice gaps. The counters are
+// kept across reconnects.
+type sequenceCounters map[string]*uint64
+
+func newSequenceCounters(channels []IRCChannel) sequenceCounters {
+	counters := make(sequenceCounters)
+	for _, channel := range channels {
+		if channel.IncludeSequence {
+			counters[channelKey(channel.Name)] = new(uint64)
+		}
+	}
+	return counters
+}
+
+// prefix returns the next "[#N] " line prefix of channel, or an empty
+// string when not including a sequence.
+func (s sequenceCounters) prefix(channel string) string {
+	counter, ok := s[channelKey(channel)]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("[#%d] ", atomic.AddUint64(counter, 1))
+}
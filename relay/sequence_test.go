@@ -0,0 +1,86 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+func TestIncludeSequenceAcrossReconnect(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.IRCChannels[0].IncludeSequence = true
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+	testStep.Wait()
+
+	testStep.Add(3)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "first"}
+	alertMsgs <- AlertMsg{Channel: "#bar", Alert: "unnumbered"}
+	alertMsgs <- AlertMsg{Channel: "#FOO", Alert: "second"}
+	testStep.Wait()
+
+	// The numbering goes on after reconnecting.
+	testStep.Add(1)
+	server.Client.Close()
+	testStep.Wait()
+
+	testStep.Add(1)
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "third"}
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	notices := []string{}
+	for _, command := range server.Log {
+		if strings.HasPrefix(command, "NOTICE ") {
+			notices = append(notices, command)
+		}
+	}
+	expectedNotices := []string{
+		"NOTICE #foo :[#1] first",
+		"NOTICE #bar :unnumbered",
+		"NOTICE #FOO :[#2] second",
+		"NOTICE #foo :[#3] third",
+	}
+	if !reflect.DeepEqual(expectedNotices, notices) {
+		t.Errorf("Expected notices %q, got %q", expectedNotices, notices)
+	}
+}
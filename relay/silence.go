@@ -0,0 +1,57 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// silenceFilter returns the Alertmanager matchers expression selecting
+// labels, e.g. {alertname="airDown",instance="instance1:3456"}.
+func silenceFilter(labels map[string]string) string {
+	matchers := []string{}
+	for _, entry := range sortedMap(labels) {
+		matchers = append(matchers, entry.Key+"="+strconv.Quote(entry.Value))
+	}
+	return "{" + strings.Join(matchers, ",") + "}"
+}
+
+// silenceURL returns the link to the Alertmanager UI form creating a silence
+// for the labels of data: the alert labels when rendering per alert, the
+// group labels otherwise.
+func silenceURL(data interface{}) (string, error) {
+	var externalURL string
+	var labels map[string]string
+	switch d := data.(type) {
+	case AlertTemplateData:
+		externalURL, labels = d.ExternalURL, d.Labels
+	case CollapsedAlertData:
+		externalURL, labels = d.ExternalURL, d.Labels
+	case *WebhookData:
+		externalURL, labels = d.ExternalURL, d.GroupLabels
+	case CollapsedGroupData:
+		externalURL, labels = d.ExternalURL, d.GroupLabels
+	default:
+		return "", fmt.Errorf("silenceURL: unsupported data %T", data)
+	}
+	// The UI parses the fragment itself, which does not turn + into spaces.
+	filter := strings.Replace(
+		url.QueryEscape(silenceFilter(labels)), "+", "%20", -1)
+	return strings.TrimSuffix(externalURL, "/") +
+		"/#/silences/new?filter=" + filter, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestSilenceURL(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "{{ silenceURL . }}",
+	})
+	alert := promtmpl.Alert{Labels: promtmpl.KV{
+		"instance": "instance1:3456", "alertname": "airDown",
+		"summary": `air "is" down`}}
+	group := &WebhookData{Data: promtmpl.Data{
+		ExternalURL: "http://am.example.com/",
+		GroupLabels: promtmpl.KV{"alertname": "airDown"},
+	}}
+
+	for _, test := range []struct {
+		alertMsg AlertMsg
+		expected string
+	}{
+		{
+			AlertMsg{Channel: "#foo", AlertData: &alert, GroupData: group},
+			"http://am.example.com/#/silences/new?filter=" +
+				"%7Balertname%3D%22airDown%22%2C" +
+				"instance%3D%22instance1%3A3456%22%2C" +
+				"summary%3D%22air%20%5C%22is%5C%22%20down%22%7D",
+		},
+		{
+			AlertMsg{Channel: "#foo", GroupData: group},
+			"http://am.example.com/#/silences/new?filter=" +
+				"%7Balertname%3D%22airDown%22%7D",
+		},
+	} {
+		if msg := formatter.RenderMsg(&test.alertMsg); msg != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, msg)
+		}
+	}
+}
+
+func TestSilenceURLUnsupportedData(t *testing.T) {
+	if _, err := silenceURL("foo"); err == nil {
+		t.Error("Expected an error for unsupported data")
+	}
+}
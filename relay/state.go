@@ -0,0 +1,102 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// DumpState logs the state of the relay, for debugging it live. The IRC
+// notifiers log theirs from their own goroutine, once done with what they
+// are sending.
+func (relay *Relay) DumpState() {
+	relay.HTTPServer.logState()
+	for _, notifier := range relay.ircNotifiers() {
+		notifier.DumpState()
+	}
+}
+
+// logState logs the clients tracked by the webhook rate limiters.
+func (server *HTTPServer) logState() {
+	if server.rateLimiter != nil {
+		log.Printf("State: webhook rate limiter tracks %d clients",
+			server.rateLimiter.Size())
+	}
+	severities := []string{}
+	for severity := range server.severityRateLimiters {
+		severities = append(severities, severity)
+	}
+	sort.Strings(severities)
+	for _, severity := range severities {
+		if limiter := server.severityRateLimiters[severity]; limiter != nil {
+			log.Printf("State: %s webhook rate limiter tracks %d clients",
+				severity, limiter.Size())
+		}
+	}
+}
+
+// DumpState asks Run to log the state of the notifier, unless already
+// asked.
+func (notifier *IRCNotifier) DumpState() {
+	select {
+	case notifier.stateDumps <- struct{}{}:
+	default:
+	}
+}
+
+// logState logs the state of the notifier, from Run.
+func (notifier *IRCNotifier) logState() {
+	log.Printf("State: connection %s: connected %t, session up %t, "+
+		"%d/%d alerts queued", notifier.connection,
+		notifier.Client.Connected(), notifier.sessionUp,
+		len(notifier.AlertMsgs), cap(notifier.AlertMsgs))
+
+	joined := []string{}
+	for _, channel := range notifier.ChannelTracker.Channels() {
+		joined = append(joined, channel.Name)
+	}
+	log.Printf("State: connection %s: joined %s", notifier.connection,
+		strings.Join(joined, " "))
+	for _, channel := range notifier.ChannelTracker.BlockedChannels() {
+		log.Printf("State: connection %s: blocked %s (%s)",
+			notifier.connection, channel.Name, channel.Reason)
+	}
+
+	held := 0
+	for _, alertMsgs := range notifier.heldAlertMsgs {
+		held += len(alertMsgs)
+	}
+	log.Printf("State: connection %s: %d alerts held for quiet hours, "+
+		"%d for the circuit breaker, %d while paused", notifier.connection,
+		held, len(notifier.breakerHeldAlertMsgs), len(notifier.pausedAlertMsgs))
+	if notifier.deduplicator != nil {
+		log.Printf("State: connection %s: dedup cache holds %d messages",
+			notifier.connection, notifier.deduplicator.Size())
+	}
+
+	channels := []string{}
+	for channel := range notifier.capWindows {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		window := notifier.capWindows[channel]
+		log.Printf("State: connection %s: %s sent %d/%d messages this hour, "+
+			"%d suppressed", notifier.connection, channel, window.sent,
+			window.limit.Messages, window.suppressed)
+	}
+}
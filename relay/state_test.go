@@ -0,0 +1,69 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogNotifierState(t *testing.T) {
+	config := makeTestIRCConfig(0)
+	config.DedupWindow = time.Minute
+	config.IRCChannels[0].HourlyCap = &HourlyCap{Messages: 20}
+	alertMsgs := make(chan AlertMsg, 2)
+	notifier, err := NewIRCNotifier(config, alertMsgs, make(chan string))
+	if err != nil {
+		t.Fatalf("Could not create IRC notifier: %s", err)
+	}
+	alertMsgs <- AlertMsg{Channel: "#foo", Alert: "test message"}
+	notifier.ChannelTracker.EndOfNames("#foo")
+	notifier.ChannelTracker.EndOfNames("#bar")
+	notifier.ChannelTracker.Block("#baz", "banned")
+	notifier.capWindows["#foo"].sent = 3
+
+	output := bytes.Buffer{}
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+	notifier.logState()
+
+	for _, expected := range []string{
+		"connection default: connected false, session up false, 1/2 alerts queued",
+		"connection default: joined #bar #foo",
+		"connection default: blocked #baz (banned)",
+		"connection default: 0 alerts held for quiet hours, 0 for the circuit breaker, 0 while paused",
+		"connection default: dedup cache holds 0 messages",
+		"connection default: #foo sent 3/20 messages this hour, 0 suppressed",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected %q in state:\n%s", expected, output.String())
+		}
+	}
+}
+
+func TestDumpStateDoesNotBlock(t *testing.T) {
+	notifier, _ := makeTestNotifier(t, makeTestIRCConfig(0))
+	// Run is not reading, the second request is dropped.
+	notifier.DumpState()
+	notifier.DumpState()
+	if len(notifier.stateDumps) != 1 {
+		t.Errorf("Expected a single state dump pending, got %d",
+			len(notifier.stateDumps))
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"regexp"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// ircFormatting matches mIRC formatting codes: colors with their optional
+// foreground and background, hex colors, and the bold, reset, monospace,
+// reverse, italic, strikethrough and underline toggles.
+var ircFormatting = regexp.MustCompile(
+	"\x03(?:[0-9]{1,2}(?:,[0-9]{1,2})?)?" +
+		"|\x04(?:[0-9a-fA-F]{6}(?:,[0-9a-fA-F]{6})?)?" +
+		"|[\x02\x0f\x11\x16\x1d\x1e\x1f]")
+
+// stripFormatting returns s without IRC formatting codes.
+func stripFormatting(s string) string {
+	return ircFormatting.ReplaceAllString(s, "")
+}
+
+func stripKVFormatting(kv promtmpl.KV) promtmpl.KV {
+	if kv == nil {
+		return nil
+	}
+	stripped := make(promtmpl.KV, len(kv))
+	for name, value := range kv {
+		stripped[name] = stripFormatting(value)
+	}
+	return stripped
+}
+
+// plainChannels returns the keys of the channels not supporting formatting.
+func plainChannels(channels []IRCChannel) map[string]bool {
+	plain := make(map[string]bool)
+	for _, channel := range channels {
+		if channel.SupportsFormatting != nil && !*channel.SupportsFormatting {
+			plain[channelKey(channel.Name)] = true
+		}
+	}
+	return plain
+}
+
+// stripValueFormatting returns a copy of alertMsg with IRC formatting codes
+// stripped from its label and annotation values, as these may come from
+// untrusted sources, leaving those of alertMsg untouched.
+func stripValueFormatting(alertMsg *AlertMsg) *AlertMsg {
+	return mapMsgKVs(alertMsg, stripKVFormatting, stripKVFormatting)
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	irc "github.com/fluffle/goirc/client"
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestStripFormatting(t *testing.T) {
+	for input, expected := range map[string]string{
+		"\x0304,01red\x03 text":      "red text",
+		"\x034red\x0f":               "red",
+		"\x02bold\x02 \x1funder\x1f": "bold under",
+		"\x04FF0000,00FF00hex":       "hex",
+		"\x16\x1d\x1e\x11plain":      "plain",
+		"100,5 is a number":          "100,5 is a number",
+	} {
+		if output := stripFormatting(input); output != expected {
+			t.Errorf("Unexpected output for %q: %q", input, output)
+		}
+	}
+}
+
+func TestStripValueFormatting(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate:          "\x02{{ .Labels.alertname }}\x02 {{ .Annotations.summary }}",
+		StripValueFormatting: true,
+	})
+	alert := promtmpl.Alert{
+		Labels: promtmpl.KV{"alertname": "\x0303airDown"},
+		Annotations: promtmpl.KV{
+			"summary": "\x0300,00hidden\x03 \x0304[FIRING]\x0f text"},
+	}
+	alertMsg := &AlertMsg{Channel: "#foo", AlertData: &alert}
+
+	if msg := formatter.RenderMsg(alertMsg); msg != "\x02airDown\x02 hidden [FIRING] text" {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+	if alert.Annotations["summary"] != "\x0300,00hidden\x03 \x0304[FIRING]\x0f text" {
+		t.Errorf("Expected the annotations of the alert untouched, got %q",
+			alert.Annotations["summary"])
+	}
+}
+
+func TestStripFormattingForPlainChannels(t *testing.T) {
+	server, port := makeTestServer(t)
+	config := makeTestIRCConfig(port)
+	config.MsgTemplate = "\x0304\x02{{ .Labels.alertname }}\x02\x03 is {{ .Status }}"
+	plain := false
+	config.IRCChannels[1].SupportsFormatting = &plain
+	notifier, alertMsgs := makeTestNotifier(t, config)
+
+	var testStep sync.WaitGroup
+
+	joinHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		// #baz is configured as the last channel to pre-join
+		if line.Args[0] == "#baz" {
+			testStep.Done()
+		}
+		return nil
+	}
+	server.SetHandler("JOIN", joinHandler)
+	noticeHandler := func(conn *bufio.ReadWriter, line *irc.Line) error {
+		testStep.Done()
+		return nil
+	}
+	server.SetHandler("NOTICE", noticeHandler)
+
+	testStep.Add(1)
+	go notifier.Run()
+	testStep.Wait()
+
+	alert := promtmpl.Alert{
+		Status: "firing",
+		Labels: promtmpl.KV{"alertname": "airDown"},
+	}
+	testStep.Add(2)
+	alertMsgs <- AlertMsg{Channel: "#foo", AlertData: &alert}
+	alertMsgs <- AlertMsg{Channel: "#bar", AlertData: &alert}
+	testStep.Wait()
+
+	notifier.StopRunning <- true
+	server.Stop()
+
+	notices := []string{}
+	for _, command := range server.Log {
+		if strings.HasPrefix(command, "NOTICE ") {
+			notices = append(notices, command)
+		}
+	}
+	expectedNotices := []string{
+		"NOTICE #foo :\x0304\x02airDown\x02\x03 is firing",
+		"NOTICE #bar :airDown is firing",
+	}
+	if !reflect.DeepEqual(expectedNotices, notices) {
+		t.Errorf("Expected notices %q, got %q", expectedNotices, notices)
+	}
+}
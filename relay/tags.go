@@ -0,0 +1,59 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"strings"
+)
+
+const messageTagsCapability = "message-tags"
+
+// tagValueEscaper escapes tag values as required by IRCv3 message tags.
+var tagValueEscaper = strings.NewReplacer(
+	`\`, `\\`, ";", `\:`, " ", `\s`, "\r", `\r`, "\n", `\n`)
+
+// alertMsgTags returns the +alertname, +severity and +fingerprint client
+// tags of alertMsg, leaving out those without value, or "" if none has.
+func alertMsgTags(alertMsg *AlertMsg) string {
+	labels, fingerprint := alertMsgLabels(alertMsg)
+	tags := []string{}
+	for _, tag := range []struct{ name, value string }{
+		{"+alertname", labels["alertname"]},
+		{"+severity", labels["severity"]},
+		{"+fingerprint", fingerprint},
+	} {
+		if tag.value != "" {
+			tags = append(tags, tag.name+"="+tagValueEscaper.Replace(tag.value))
+		}
+	}
+	return strings.Join(tags, ";")
+}
+
+// withCapabilities returns capabilities with the required ones added, if
+// missing.
+func withCapabilities(capabilities []string, required ...string) []string {
+	if len(required) == 0 {
+		return capabilities
+	}
+	merged := []string{}
+	seen := make(map[string]bool)
+	for _, capability := range append(required, capabilities...) {
+		if !seen[capability] {
+			seen[capability] = true
+			merged = append(merged, capability)
+		}
+	}
+	return merged
+}
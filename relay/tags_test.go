@@ -0,0 +1,48 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestAlertMsgTags(t *testing.T) {
+	alert := promtmpl.Alert{
+		Labels: promtmpl.KV{
+			"alertname": `air;Down\`, "severity": "a b\r\nc"},
+		Fingerprint: "a1b2"}
+	group := &WebhookData{}
+	group.CommonLabels = promtmpl.KV{"alertname": "airDown"}
+	group.Alerts = promtmpl.Alerts{alert, alert}
+
+	testCases := []struct {
+		name     string
+		alertMsg AlertMsg
+		expected string
+	}{
+		{"alert", AlertMsg{AlertData: &alert},
+			`+alertname=air\:Down\\;+severity=a\sb\r\nc;+fingerprint=a1b2`},
+		// The fingerprint of groups is only known with a single alert.
+		{"group", AlertMsg{GroupData: group}, "+alertname=airDown"},
+		{"no data", AlertMsg{Alert: "test message"}, ""},
+	}
+	for _, tc := range testCases {
+		if tags := alertMsgTags(&tc.alertMsg); tags != tc.expected {
+			t.Errorf("%s: expected tags %q, got %q", tc.name, tc.expected, tags)
+		}
+	}
+}
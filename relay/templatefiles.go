@@ -0,0 +1,101 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"log"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Watched template files are polled for modification time changes every
+// templateCheckSecs, rather than watched with inotify, to do without another
+// dependency and to work on file systems without change notifications. A
+// change can take that long to be picked up.
+const templateCheckSecs = 5
+
+var templateReloads = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "msg_template_reloads",
+		Help: "Number of times changed message template files were parsed again, by result"},
+	[]string{"result"},
+)
+
+// parseMsgTemplate parses text along with the template definitions of files,
+// which text can then use with {{ template "name" . }}.
+func parseMsgTemplate(parser templateParser, text string,
+	files []string) (*template.Template, error) {
+	tmpl, err := parser.parse("msg", text)
+	if err != nil || len(files) == 0 {
+		return tmpl, err
+	}
+	return tmpl.ParseFiles(files...)
+}
+
+// templateFileTimes returns the modification time of each file.
+func templateFileTimes(files []string) (map[string]time.Time, error) {
+	times := make(map[string]time.Time)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		times[file] = info.ModTime()
+	}
+	return times, nil
+}
+
+// ReloadTemplates parses the message templates again when one of their files
+// changed since last parsed. On errors the current template is kept, until
+// the files change again.
+func (f *Formatter) ReloadTemplates() {
+	times, err := templateFileTimes(f.msgTemplateFiles)
+	if err != nil {
+		log.Printf("Could not check message template files: %s", err)
+		return
+	}
+	changed := false
+	for file, modTime := range times {
+		if !modTime.Equal(f.msgTemplateTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	f.msgTemplateTimes = times
+	tmpl, err := parseMsgTemplate(f.parser, f.msgTemplateText,
+		f.msgTemplateFiles)
+	if err != nil {
+		log.Printf("Could not parse changed message template files, keeping the current ones: %s", err)
+		templateReloads.WithLabelValues("failure").Inc()
+		return
+	}
+	receiverTemplates, err := parseReceiverTemplates(f.parser,
+		f.receiverTexts, f.msgTemplateFiles)
+	if err != nil {
+		log.Printf("Could not parse changed message template files, keeping the current ones: %s", err)
+		templateReloads.WithLabelValues("failure").Inc()
+		return
+	}
+	log.Printf("Parsed changed message template files")
+	f.MsgTemplate = tmpl
+	f.receiverTemplates = receiverTemplates
+	templateReloads.WithLabelValues("success").Inc()
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func writeTemplateFile(t *testing.T, file string, text string, modTime time.Time) {
+	if err := ioutil.WriteFile(file, []byte(text), 0644); err != nil {
+		t.Fatalf("Could not write template file: %s", err)
+	}
+	// Not relying on the file system timestamp granularity.
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatalf("Could not set template file time: %s", err)
+	}
+}
+
+func TestMsgTemplateFilesReloaded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "airtesttemplates")
+	if err != nil {
+		t.Fatalf("Could not create template dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "irc.tmpl")
+	modTime := time.Date(2017, 5, 15, 12, 0, 0, 0, time.UTC)
+	writeTemplateFile(t, file,
+		`{{ define "irc.msg" }}Alert {{ .Labels.alertname }}{{ end }}`, modTime)
+
+	formatter, err := NewFormatter(&Config{
+		MsgTemplate:      `{{ template "irc.msg" . }}`,
+		MsgTemplateFiles: []string{file},
+	})
+	if err != nil {
+		t.Fatalf("Could not create formatter: %s", err)
+	}
+	data := AlertTemplateData{Alert: promtmpl.Alert{
+		Labels: promtmpl.KV{"alertname": "airDown"}}}
+	if msg := formatter.FormatMsg(data); msg != "Alert airDown" {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+
+	// Unchanged file times, not parsed again.
+	ioutil.WriteFile(file, []byte(`{{ define "irc.msg" }}Unseen{{ end }}`), 0644)
+	os.Chtimes(file, modTime, modTime)
+	formatter.ReloadTemplates()
+	if msg := formatter.FormatMsg(data); msg != "Alert airDown" {
+		t.Errorf("Expected the template unchanged, got %q", msg)
+	}
+
+	modTime = modTime.Add(time.Minute)
+	writeTemplateFile(t, file,
+		`{{ define "irc.msg" }}{{ .Labels.alertname }} fired{{ end }}`, modTime)
+	formatter.ReloadTemplates()
+	if msg := formatter.FormatMsg(data); msg != "airDown fired" {
+		t.Errorf("Expected the changed template, got %q", msg)
+	}
+
+	// The last good template is kept on errors.
+	modTime = modTime.Add(time.Minute)
+	writeTemplateFile(t, file, `{{ define "irc.msg" }}{{ .Labels`, modTime)
+	formatter.ReloadTemplates()
+	if msg := formatter.FormatMsg(data); msg != "airDown fired" {
+		t.Errorf("Expected the last good template, got %q", msg)
+	}
+}
+
+func TestMsgTemplateFilesMissing(t *testing.T) {
+	_, err := NewFormatter(&Config{
+		MsgTemplate:      `{{ template "irc.msg" . }}`,
+		MsgTemplateFiles: []string{"/nonexistent/irc.tmpl"},
+	})
+	if err == nil {
+		t.Errorf("Expected an error for a missing template file")
+	}
+}
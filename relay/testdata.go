@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package main
+package relay
 
 const (
 	testdataSimpleAlertJson = `
@@ -73,6 +73,17 @@ const (
         }
     ]
 }
+`
+
+	testdataEmptyAlertsJson = `
+{
+    "status": "firing",
+    "receiver": "example_receiver",
+    "groupLabels": {
+        "alertname": "airDown"
+    },
+    "alerts": []
+}
 `
 
 	testdataBogusAlertJson = `{"this is not": "a valid alert",}`
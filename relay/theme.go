@@ -0,0 +1,66 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+)
+
+const defaultTheme = "classic"
+
+// themes map the semantic color names usable with themed to mIRC colors.
+var themes = map[string]map[string]int{
+	"classic": {
+		"error": 4,  // red
+		"warn":  7,  // orange
+		"ok":    3,  // green
+		"muted": 14, // grey
+	},
+	"solarized": {
+		"error": 5,  // brown
+		"warn":  8,  // yellow
+		"ok":    10, // teal
+		"muted": 15, // light grey
+	},
+}
+
+// themeColors returns the colors of theme, the default one when unset.
+func themeColors(theme string) (map[string]int, error) {
+	if theme == "" {
+		theme = defaultTheme
+	}
+	colors, ok := themes[theme]
+	if !ok {
+		return nil, fmt.Errorf("invalid theme value: %s", theme)
+	}
+	return colors, nil
+}
+
+// newThemedFunc returns the themed template function: themed "error" is the
+// mIRC color code of "error" in the theme, and themed "error" "text" wraps
+// "text" in that color.
+func newThemedFunc(colors map[string]int) func(string, ...string) (string, error) {
+	return func(name string, text ...string) (string, error) {
+		color, ok := colors[name]
+		if !ok {
+			return "", fmt.Errorf("themed: unknown color %s", name)
+		}
+		code := fmt.Sprintf("%s%02d", ircColor, color)
+		if len(text) == 0 {
+			return code, nil
+		}
+		return fmt.Sprintf("%s%s%s", code, text[0], ircColor), nil
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestThemedColors(t *testing.T) {
+	alert := promtmpl.Alert{Status: "firing"}
+	alertMsg := AlertMsg{Channel: "#foo", AlertData: &alert}
+
+	for _, test := range []struct {
+		theme, expected string
+	}{
+		{"", "\x0304firing\x03 \x0314muted"},
+		{"classic", "\x0304firing\x03 \x0314muted"},
+		{"solarized", "\x0305firing\x03 \x0315muted"},
+	} {
+		formatter := makeTestFormatter(t, &Config{
+			MsgTemplate: `{{ themed "error" .Status }} {{ themed "muted" }}muted`,
+			ThemeName:   test.theme,
+		})
+		if msg := formatter.RenderMsg(&alertMsg); msg != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.theme, test.expected, msg)
+		}
+	}
+}
+
+func TestThemedUnknownColor(t *testing.T) {
+	themed := newThemedFunc(themes[defaultTheme])
+	if _, err := themed("purple"); err == nil {
+		t.Error("Expected an error for an unknown color")
+	}
+}
+
+func TestThemesDefineAllColors(t *testing.T) {
+	for name, colors := range themes {
+		for _, color := range []string{"error", "warn", "ok", "muted"} {
+			if _, ok := colors[color]; !ok {
+				t.Errorf("Theme %s does not define %s", name, color)
+			}
+		}
+	}
+}
+
+func TestUnknownTheme(t *testing.T) {
+	if _, err := NewFormatter(&Config{ThemeName: "neon"}); err == nil {
+		t.Error("Expected an error for an unknown theme")
+	}
+}
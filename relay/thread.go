@@ -0,0 +1,130 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"sync"
+)
+
+const (
+	echoMessageCapability = "echo-message"
+	replyTag              = "+draft/reply"
+
+	// Bounds of the threads and of the thread starts awaiting their msgid
+	// remembered, forgotten all at once when reached.
+	maxThreads       = 1000
+	maxPendingThread = 100
+)
+
+type pendingThread struct {
+	channel  string
+	groupKey string
+	text     string
+}
+
+// threadTracker remembers the msgid of the first message sent for each
+// alert group, by channel, for the following messages of the group to be
+// sent as replies to it. The msgid is assigned by the server and learned
+// from the echo of the message.
+type threadTracker struct {
+	mu sync.Mutex
+	// Thread starts sent, oldest first, until echoed.
+	pending []pendingThread
+	// msgids by channel and group key, and their count.
+	roots   map[string]map[string]string
+	threads int
+}
+
+func newThreadTracker() *threadTracker {
+	return &threadTracker{roots: make(map[string]map[string]string)}
+}
+
+// Root returns the msgid of the thread of groupKey in channel, if known.
+// Otherwise started is true if the first message of the group was sent but
+// not echoed yet.
+func (t *threadTracker) Root(channel string, groupKey string) (msgid string, started bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if msgid, ok := t.roots[channel][groupKey]; ok {
+		return msgid, true
+	}
+	for _, p := range t.pending {
+		if p.channel == channel && p.groupKey == groupKey {
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// Started records that text, the first line of the first message of
+// groupKey, was sent to channel.
+func (t *threadTracker) Started(channel string, groupKey string, text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) >= maxPendingThread {
+		t.pending = nil
+	}
+	t.pending = append(t.pending, pendingThread{channel, groupKey, text})
+}
+
+// Echoed records msgid as the root of the thread started by text in
+// channel, if it is one.
+func (t *threadTracker) Echoed(channel string, text string, msgid string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, p := range t.pending {
+		if p.channel != channel || p.text != text {
+			continue
+		}
+		t.pending = append(t.pending[:i], t.pending[i+1:]...)
+		if t.threads >= maxThreads {
+			t.roots = make(map[string]map[string]string)
+			t.threads = 0
+		}
+		if t.roots[channel] == nil {
+			t.roots[channel] = make(map[string]string)
+		}
+		if _, ok := t.roots[channel][p.groupKey]; !ok {
+			t.threads++
+		}
+		t.roots[channel][p.groupKey] = msgid
+		return
+	}
+}
+
+// Forget ends the thread of groupKey in channel, e.g. once it resolved.
+func (t *threadTracker) Forget(channel string, groupKey string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.roots[channel][groupKey]; ok {
+		delete(t.roots[channel], groupKey)
+		t.threads--
+	}
+	pending := t.pending[:0]
+	for _, p := range t.pending {
+		if p.channel != channel || p.groupKey != groupKey {
+			pending = append(pending, p)
+		}
+	}
+	t.pending = pending
+}
+
+// Reset forgets the thread starts not echoed, as they never will be once
+// disconnected.
+func (t *threadTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+)
+
+func TestThreadTracker(t *testing.T) {
+	threads := newThreadTracker()
+	if _, started := threads.Root("#foo", "g1"); started {
+		t.Error("Expected no thread before the first message")
+	}
+	threads.Started("#foo", "g1", "airDown is firing")
+	if msgid, started := threads.Root("#foo", "g1"); !started || msgid != "" {
+		t.Errorf("Expected the thread started without msgid, got %q", msgid)
+	}
+
+	// Echoes of other messages are ignored.
+	threads.Echoed("#foo", "something else", "m0")
+	threads.Echoed("#bar", "airDown is firing", "m0")
+	threads.Echoed("#foo", "airDown is firing", "m1")
+	if msgid, _ := threads.Root("#foo", "g1"); msgid != "m1" {
+		t.Errorf("Expected msgid m1, got %q", msgid)
+	}
+	if msgid, started := threads.Root("#bar", "g1"); started || msgid != "" {
+		t.Errorf("Expected threads by channel, got %q", msgid)
+	}
+
+	threads.Forget("#foo", "g1")
+	if _, started := threads.Root("#foo", "g1"); started {
+		t.Error("Expected the thread forgotten")
+	}
+
+	// Thread starts are not echoed once disconnected.
+	threads.Started("#foo", "g2", "airGone is firing")
+	threads.Reset()
+	if _, started := threads.Root("#foo", "g2"); started {
+		t.Error("Expected pending thread starts reset")
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	tlsConfig, err := newTLSConfig("1.2", []string{
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Unexpected min version: %x", tlsConfig.MinVersion)
+	}
+	expectedCipherSuites := []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	if !reflect.DeepEqual(expectedCipherSuites, tlsConfig.CipherSuites) {
+		t.Errorf("Unexpected cipher suites: %v", tlsConfig.CipherSuites)
+	}
+}
+
+func TestNewTLSConfigDefaults(t *testing.T) {
+	tlsConfig, err := newTLSConfig("", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if tlsConfig.MinVersion != 0 || tlsConfig.CipherSuites != nil {
+		t.Errorf("Expected the library defaults, got: %+v", tlsConfig)
+	}
+}
+
+func TestNewTLSConfigInvalidNames(t *testing.T) {
+	if _, err := newTLSConfig("1.4", nil); err == nil {
+		t.Error("Expected an error for an unknown TLS version")
+	}
+	if _, err := newTLSConfig("1.2", []string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Error("Expected an error for an unsupported cipher suite")
+	}
+}
+
+func TestHTTPServerTLSConfig(t *testing.T) {
+	config := MakeHTTPTestingConfig()
+	config.HTTPTLSMinVersion = "1.3"
+	httpServer, err := newHTTPServerFromConfig(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if httpServer.TLSConfig.MinVersion != tlsVersion13 {
+		t.Errorf("Unexpected min version: %x", httpServer.TLSConfig.MinVersion)
+	}
+	if httpServer.TLSNextProto == nil {
+		t.Error("Expected HTTP/2 not to be negotiated unless enabled")
+	}
+}
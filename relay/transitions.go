@@ -0,0 +1,100 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultTransitionTTL = 24 * time.Hour
+	maxTransitionAlerts  = 10000
+)
+
+var (
+	repeatedAlerts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_repeated_alerts",
+			Help: "Number of alerts not relayed as their status did not change"},
+		[]string{"ircchannel"},
+	)
+)
+
+type seenStatus struct {
+	status string
+	seen   time.Time
+}
+
+// TransitionTracker remembers the last status seen for alerts, by channel
+// and fingerprint, for up to a TTL since they were last seen and a maximum
+// number of alerts, the least recently seen being forgotten first. It is
+// safe for concurrent use, webhooks being handled concurrently.
+type TransitionTracker struct {
+	ttl time.Duration
+	max int
+
+	mu       sync.Mutex
+	statuses map[string]seenStatus
+}
+
+func NewTransitionTracker(ttl time.Duration, max int) *TransitionTracker {
+	return &TransitionTracker{
+		ttl:      ttl,
+		max:      max,
+		statuses: make(map[string]seenStatus),
+	}
+}
+
+func (t *TransitionTracker) expire(now time.Time) {
+	for key, s := range t.statuses {
+		if now.Sub(s.seen) >= t.ttl {
+			delete(t.statuses, key)
+		}
+	}
+}
+
+// Changed records status as the last seen for the alert with fingerprint in
+// channel, and returns whether it differs from the previous one. Alerts seen
+// for the first time, or forgotten since, are changed.
+func (t *TransitionTracker) Changed(channel string, fingerprint string,
+	status string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+	key := channel + "\x00" + fingerprint
+	previous, ok := t.statuses[key]
+	if !ok && len(t.statuses) >= t.max {
+		oldest := ""
+		for k, s := range t.statuses {
+			if oldest == "" || s.seen.Before(t.statuses[oldest].seen) {
+				oldest = k
+			}
+		}
+		delete(t.statuses, oldest)
+	}
+	t.statuses[key] = seenStatus{status: status, seen: now}
+	return !ok || previous.status != status
+}
+
+// Size returns the number of alerts currently remembered.
+func (t *TransitionTracker) Size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.statuses)
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransitionTracker(t *testing.T) {
+	now := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	tracker := NewTransitionTracker(time.Hour, 10)
+
+	if !tracker.Changed("#foo", "a", "firing", now) {
+		t.Error("Expected a new alert to be a transition")
+	}
+	if tracker.Changed("#foo", "a", "firing", now.Add(time.Minute)) {
+		t.Error("Expected a repeated firing alert not to be a transition")
+	}
+	if !tracker.Changed("#bar", "a", "firing", now.Add(time.Minute)) {
+		t.Error("Expected alerts to be tracked per channel")
+	}
+	if !tracker.Changed("#foo", "a", "resolved", now.Add(2*time.Minute)) {
+		t.Error("Expected a resolved alert to be a transition")
+	}
+	if tracker.Changed("#foo", "a", "resolved", now.Add(3*time.Minute)) {
+		t.Error("Expected a repeated resolved alert not to be a transition")
+	}
+	if !tracker.Changed("#foo", "a", "resolved", now.Add(2*time.Hour)) {
+		t.Error("Expected an alert last seen longer than the TTL ago to be forgotten")
+	}
+}
+
+func TestTransitionTrackerBounded(t *testing.T) {
+	now := time.Date(2017, 5, 15, 23, 0, 0, 0, time.UTC)
+	tracker := NewTransitionTracker(time.Hour, 2)
+
+	tracker.Changed("#foo", "a", "firing", now)
+	tracker.Changed("#foo", "b", "firing", now.Add(time.Second))
+	tracker.Changed("#foo", "c", "firing", now.Add(2*time.Second))
+
+	if tracker.Size() != 2 {
+		t.Errorf("Expected 2 alerts, got %d", tracker.Size())
+	}
+	if !tracker.Changed("#foo", "a", "firing", now.Add(3*time.Second)) {
+		t.Error("Expected the least recently seen alert to be evicted")
+	}
+	if tracker.Changed("#foo", "c", "firing", now.Add(3*time.Second)) {
+		t.Error("Expected the most recently seen alert to be kept")
+	}
+}
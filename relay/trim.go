@@ -0,0 +1,54 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"strings"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// labelPrefixTrimmer strips configured prefixes from label values, by label
+// name, for display only.
+type labelPrefixTrimmer map[string][]string
+
+// trim returns a copy of labels with the first matching prefix of each
+// value stripped, unless nothing would be left.
+func (t labelPrefixTrimmer) trim(labels promtmpl.KV) promtmpl.KV {
+	if labels == nil {
+		return nil
+	}
+	trimmed := make(promtmpl.KV, len(labels))
+	for name, value := range labels {
+		for _, prefix := range t[name] {
+			if len(value) > len(prefix) && strings.HasPrefix(value, prefix) {
+				value = value[len(prefix):]
+				break
+			}
+		}
+		trimmed[name] = value
+	}
+	return trimmed
+}
+
+// apply returns a copy of alertMsg with the label values of its alert and
+// group trimmed, leaving those of alertMsg, still used to match alerts,
+// untouched.
+func (t labelPrefixTrimmer) apply(alertMsg *AlertMsg) *AlertMsg {
+	if len(t) == 0 {
+		return alertMsg
+	}
+	return mapMsgLabels(alertMsg, t.trim)
+}
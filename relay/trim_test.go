@@ -0,0 +1,47 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"reflect"
+	"testing"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestLabelValueTrimPrefixes(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "{{ range .Alerts }}{{ .Labels.instance }} {{ .Labels.job }} {{ end }}",
+		LabelValueTrimPrefixes: map[string][]string{
+			"instance": {"prod-use1-", "prod-euw1-"},
+		},
+	})
+	group := &WebhookData{}
+	group.Alerts = promtmpl.Alerts{
+		{Labels: promtmpl.KV{"instance": "prod-euw1-web-01", "job": "prod-euw1-web"}},
+		// Values are not trimmed to nothing.
+		{Labels: promtmpl.KV{"instance": "prod-euw1-", "job": "web"}},
+	}
+	alertMsg := &AlertMsg{Channel: "#foo", GroupData: group}
+
+	if msg := formatter.RenderMsg(alertMsg); msg != "web-01 prod-euw1-web prod-euw1- web " {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+	expected := promtmpl.KV{"instance": "prod-euw1-web-01", "job": "prod-euw1-web"}
+	if !reflect.DeepEqual(expected, alertMsg.GroupData.Alerts[0].Labels) {
+		t.Errorf("Expected the labels of the alert untouched, got %v",
+			alertMsg.GroupData.Alerts[0].Labels)
+	}
+}
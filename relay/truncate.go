@@ -0,0 +1,63 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"strings"
+	"unicode"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+const ellipsis = "…"
+
+// maxLen returns s cut to at most max characters, ending with an ellipsis,
+// at the last word boundary when there is one in its second half. The
+// argument order lets templates use it in pipelines, as in
+// {{ .Annotations.description | maxlen 200 }}.
+func maxLen(max int, s string) string {
+	runes := []rune(s)
+	if max <= 0 || len(runes) <= max {
+		return s
+	}
+	if max <= 1 {
+		return ellipsis
+	}
+	cut := runes[:max-1]
+	for i := len(cut); i > len(cut)/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			cut = runes[:i]
+			break
+		}
+	}
+	return strings.TrimRightFunc(string(cut), unicode.IsSpace) + ellipsis
+}
+
+// truncateAnnotations returns a copy of alertMsg with its annotation values
+// cut to max characters, leaving those of alertMsg untouched.
+func truncateAnnotations(alertMsg *AlertMsg, max int) *AlertMsg {
+	truncate := func(kv promtmpl.KV) promtmpl.KV {
+		if kv == nil {
+			return nil
+		}
+		truncated := make(promtmpl.KV, len(kv))
+		for name, value := range kv {
+			truncated[name] = maxLen(max, value)
+		}
+		return truncated
+	}
+	return mapMsgKVs(alertMsg, func(kv promtmpl.KV) promtmpl.KV { return kv },
+		truncate)
+}
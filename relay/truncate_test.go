@@ -0,0 +1,91 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	promtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestMaxLen(t *testing.T) {
+	for _, test := range []struct {
+		max      int
+		input    string
+		expected string
+	}{
+		{10, "short", "short"},
+		{10, "exactly 10", "exactly 10"},
+		{12, "instance is down for good", "instance is…"},
+		{8, "unbreakableword", "unbreak…"},
+		{6, "héllo wörld", "héllo…"},
+		{1, "anything", "…"},
+		{0, "unlimited", "unlimited"},
+	} {
+		if output := maxLen(test.max, test.input); output != test.expected {
+			t.Errorf("Unexpected output for %d %q: %q", test.max, test.input, output)
+		}
+	}
+}
+
+func TestMaxAnnotationLength(t *testing.T) {
+	words := strings.Repeat("paragraph ", 200)
+	description := words[:2000]
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate:         "{{ .Annotations.description }}",
+		MaxAnnotationLength: 100,
+	})
+	alert := promtmpl.Alert{
+		Annotations: promtmpl.KV{"description": description},
+	}
+	alertMsg := &AlertMsg{Channel: "#foo", AlertData: &alert}
+
+	msg := formatter.RenderMsg(alertMsg)
+	if utf8.RuneCountInString(msg) > 100 || !strings.HasSuffix(msg, "paragraph…") {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+	if alert.Annotations["description"] != description {
+		t.Error("Expected the annotations of the alert untouched")
+	}
+	lines := formatter.RenderMsgLines(alertMsg)
+	if len(lines) != 1 {
+		t.Errorf("Expected a single line, got %d", len(lines))
+	}
+
+	// The raw alerts sent when the template fails are cut too.
+	formatter = makeTestFormatter(t, &Config{
+		MsgTemplate:         "{{ .Annotations.description.missing }}",
+		MaxAnnotationLength: 100,
+	})
+	if msg := formatter.RenderMsg(alertMsg); strings.Contains(msg, description) ||
+		!strings.Contains(msg, "paragraph…") {
+		t.Errorf("Expected the raw alert with the description cut, got %q", msg)
+	}
+}
+
+func TestMaxLenTemplateFunc(t *testing.T) {
+	formatter := makeTestFormatter(t, &Config{
+		MsgTemplate: "{{ .Annotations.summary | maxlen 13 }}",
+	})
+	alert := promtmpl.Alert{
+		Annotations: promtmpl.KV{"summary": "disk full on all the hosts"},
+	}
+	msg := formatter.RenderMsg(&AlertMsg{Channel: "#foo", AlertData: &alert})
+	if msg != "disk full on…" {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+}